@@ -48,6 +48,8 @@ func DoTask() {
 	task.DoResetIPLotteryNumsTask()
 	task.DoResetUserLotteryNumsTask()
 	task.DoPrizePlanTask()
+	task.DoCouponExpireTask()
+	task.DoCouponReconcileTask()
 }
 
 func main() {
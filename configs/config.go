@@ -1,8 +1,12 @@
 package configs
 
 import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
+	"log"
 	"sync"
+	"sync/atomic"
 )
 
 const defaultTimeFormat = "2006-01-02 15:04:05"
@@ -42,17 +46,62 @@ type RedisConf struct {
 	PoolSize int    `yaml:"poolsize" mapstructure:"poolsize"`
 }
 
+// LotteryConf 抽奖业务配置，修改配置文件之后自动生效，活动中单独设置的限制优先
+type LotteryConf struct {
+	UserPrizeMax     int      `yaml:"user_prize_max" mapstructure:"user_prize_max"`         // 用户每天最多抽奖次数
+	IpLimitMax       int      `yaml:"ip_limit_max" mapstructure:"ip_limit_max"`             // 同一个IP每天最多抽奖次数
+	IpFrameSize      int      `yaml:"ip_frame_size" mapstructure:"ip_frame_size"`           // IP抽奖次数缓存的分段数，修改之后需要重启
+	UserFrameSize    int      `yaml:"user_frame_size" mapstructure:"user_frame_size"`       // 用户抽奖次数缓存的分段数，修改之后需要重启
+	DefaultBlackTime int      `yaml:"default_black_time" mapstructure:"default_black_time"` // 中实物大奖之后拉黑的时间，单位秒
+	PrizeCodeMax     int      `yaml:"prize_code_max" mapstructure:"prize_code_max"`         // 旧版中奖编码的范围
+	SignSecret       string   `yaml:"sign_secret" mapstructure:"sign_secret"`               // 抽奖请求签名的密钥，活动配置了签名规则时使用
+	TrustedProxies   []string `yaml:"trusted_proxies" mapstructure:"trusted_proxies"`       // 可信代理的IP或者CIDR网段，只有来自这些地址的 X-Forwarded-For 和 X-Real-IP 才会采用
+	IpMismatchReject bool     `yaml:"ip_mismatch_reject" mapstructure:"ip_mismatch_reject"` // 请求中填写的IP和服务端获取的IP不一致时拒绝抽奖，否则只记录到抽奖审计
+}
+
+// defaultLotteryConf 配置文件中没有配置的项使用的默认值
+var defaultLotteryConf = LotteryConf{
+	UserPrizeMax:     3000,
+	IpLimitMax:       300000,
+	IpFrameSize:      2,
+	UserFrameSize:    2,
+	DefaultBlackTime: 7 * 86400,
+	PrizeCodeMax:     10000,
+}
+
+// JwtConf 登录token配置，修改之后自动生效
+type JwtConf struct {
+	Issuer          string            `yaml:"issuer" mapstructure:"issuer"`                       // 签发者
+	AccessTokenTTL  int               `yaml:"access_token_ttl" mapstructure:"access_token_ttl"`   // 访问token有效期，单位秒
+	RefreshTokenTTL int               `yaml:"refresh_token_ttl" mapstructure:"refresh_token_ttl"` // 刷新token有效期，单位秒
+	CurrentKid      string            `yaml:"current_kid" mapstructure:"current_kid"`             // 签发新token使用的密钥ID
+	Keys            map[string]string `yaml:"keys" mapstructure:"keys"`                           // 密钥ID -> 密钥，轮换之后旧密钥保留到签发的token全部过期
+}
+
+// defaultJwtConf 配置文件中没有配置的项使用的默认值
+var defaultJwtConf = JwtConf{
+	Issuer:          "lottery",
+	AccessTokenTTL:  900,
+	RefreshTokenTTL: 7 * 86400,
+	CurrentKid:      "default",
+	Keys:            map[string]string{"default": "lottery-single"},
+}
+
 // GlobalConfig 业务配置结构体
 type GlobalConfig struct {
-	AppConfig   AppConf   `yaml:"app" mapstructure:"app"`
-	DbConfig    DbConf    `yaml:"db" mapstructure:"db"`       // db配置
-	RedisConfig RedisConf `yaml:"redis" mapstructure:"redis"` // redis配置
-	LogConfig   LogConf   `yaml:"log" mapstructure:"log"`     //
+	AppConfig     AppConf     `yaml:"app" mapstructure:"app"`
+	DbConfig      DbConf      `yaml:"db" mapstructure:"db"`           // db配置
+	RedisConfig   RedisConf   `yaml:"redis" mapstructure:"redis"`     // redis配置
+	LogConfig     LogConf     `yaml:"log" mapstructure:"log"`         //
+	LotteryConfig LotteryConf `yaml:"lottery" mapstructure:"lottery"` // 抽奖业务配置，使用GetLotteryConfig获取最新的配置
+	JwtConfig     JwtConf     `yaml:"jwt" mapstructure:"jwt"`         // 登录token配置，使用GetJwtConfig获取最新的配置
 }
 
 var (
-	config GlobalConfig // 全局业务配置文件
-	once   sync.Once
+	config        GlobalConfig // 全局业务配置文件
+	once          sync.Once
+	lotteryConfig atomic.Pointer[LotteryConf] // 抽奖业务配置，配置文件修改之后整体替换
+	jwtConfig     atomic.Pointer[JwtConf]     // 登录token配置，配置文件修改之后整体替换
 )
 
 // GetGlobalConfig 获取全局配置文件
@@ -76,6 +125,99 @@ func readConf() {
 	if err != nil {
 		panic("config file unmarshal err:" + err.Error())
 	}
+	setLotteryConf(config.LotteryConfig, nil)
+	config.LotteryConfig = *lotteryConfig.Load()
+	if err = setJwtConf(config.JwtConfig); err != nil {
+		panic("config file jwt err:" + err.Error())
+	}
+	config.JwtConfig = *jwtConfig.Load()
+
+	// 监听配置文件，只热更新抽奖业务配置和token配置，其他配置修改之后需要重启
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		var conf LotteryConf
+		if err := viper.UnmarshalKey("lottery", &conf); err != nil {
+			log.Printf("reload lottery config err:%v", err)
+			return
+		}
+		setLotteryConf(conf, lotteryConfig.Load())
+		log.Printf("lottery config reloaded: %+v", *lotteryConfig.Load())
+
+		var jwtConf JwtConf
+		if err := viper.UnmarshalKey("jwt", &jwtConf); err != nil {
+			log.Printf("reload jwt config err:%v", err)
+			return
+		}
+		// 配置有误时沿用之前的配置，避免已经签发的token全部失效
+		if err := setJwtConf(jwtConf); err != nil {
+			log.Printf("reload jwt config err:%v", err)
+			return
+		}
+		log.Printf("jwt config reloaded, current_kid=%s", jwtConfig.Load().CurrentKid)
+	})
+	viper.WatchConfig()
+}
+
+// setLotteryConf 没有配置的项使用默认值，缓存分段数不能热更新，沿用之前的配置
+func setLotteryConf(conf LotteryConf, old *LotteryConf) {
+	if conf.UserPrizeMax <= 0 {
+		conf.UserPrizeMax = defaultLotteryConf.UserPrizeMax
+	}
+	if conf.IpLimitMax <= 0 {
+		conf.IpLimitMax = defaultLotteryConf.IpLimitMax
+	}
+	if conf.IpFrameSize <= 0 {
+		conf.IpFrameSize = defaultLotteryConf.IpFrameSize
+	}
+	if conf.UserFrameSize <= 0 {
+		conf.UserFrameSize = defaultLotteryConf.UserFrameSize
+	}
+	if conf.DefaultBlackTime <= 0 {
+		conf.DefaultBlackTime = defaultLotteryConf.DefaultBlackTime
+	}
+	if conf.PrizeCodeMax <= 0 {
+		conf.PrizeCodeMax = defaultLotteryConf.PrizeCodeMax
+	}
+	if old != nil {
+		conf.IpFrameSize = old.IpFrameSize
+		conf.UserFrameSize = old.UserFrameSize
+	}
+	lotteryConfig.Store(&conf)
+}
+
+// GetLotteryConfig 获取最新的抽奖业务配置，返回的配置不能修改
+func GetLotteryConfig() *LotteryConf {
+	once.Do(readConf)
+	return lotteryConfig.Load()
+}
+
+// setJwtConf 没有配置的项使用默认值，签发密钥必须在密钥列表中
+func setJwtConf(conf JwtConf) error {
+	if conf.Issuer == "" {
+		conf.Issuer = defaultJwtConf.Issuer
+	}
+	if conf.AccessTokenTTL <= 0 {
+		conf.AccessTokenTTL = defaultJwtConf.AccessTokenTTL
+	}
+	if conf.RefreshTokenTTL <= 0 {
+		conf.RefreshTokenTTL = defaultJwtConf.RefreshTokenTTL
+	}
+	if len(conf.Keys) == 0 {
+		conf.Keys = defaultJwtConf.Keys
+		if conf.CurrentKid == "" {
+			conf.CurrentKid = defaultJwtConf.CurrentKid
+		}
+	}
+	if secret, ok := conf.Keys[conf.CurrentKid]; !ok || secret == "" {
+		return fmt.Errorf("current_kid %q not found in keys", conf.CurrentKid)
+	}
+	jwtConfig.Store(&conf)
+	return nil
+}
+
+// GetJwtConfig 获取最新的登录token配置，返回的配置不能修改
+func GetJwtConfig() *JwtConf {
+	once.Do(readConf)
+	return jwtConfig.Load()
 }
 
 // InitConfig 配置初始化
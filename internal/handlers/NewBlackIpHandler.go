@@ -1,31 +1,128 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
-	"lottery_single/internal/model"
+	"lottery_single/internal/handlers/params"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/sheet"
 	"lottery_single/internal/service"
 	"net/http"
 	"strconv"
 )
 
-// 添加IP到黑名单
+// 添加IP到黑名单，ip可以是单个IPv4、IPv6地址或者CIDR网段，已经在黑名单中时更新到期时间和原因
 func AddBlackIP(c *gin.Context) {
-	var blackIP model.BlackIp
-	if err := c.ShouldBindJSON(&blackIP); err != nil {
+	claims, ok := loginClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": constant.GetErrMsg(constant.ErrUnauthorized)})
+		return
+	}
+	var ban service.BlackIpBan
+	if err := c.ShouldBindJSON(&ban); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ban.Operator = claims.UserName
+
+	blackIP, created, err := service.GetAdminService().AddBlackIP(c, &ban)
+	if errors.Is(err, service.ErrInvalidBlackIP) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	message := "IP added to blacklist successfully"
+	if !created {
+		message = "IP blacklist updated successfully"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "black_ip": blackIP, "created": created})
+}
+
+// ImportBlackIP 批量导入IP黑名单，json请求传ips，multipart请求上传csv或者xlsx文件
+func ImportBlackIP(c *gin.Context) {
+	claims, ok := loginClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": constant.GetErrMsg(constant.ErrUnauthorized)})
+		return
+	}
+	var req params.BlackIpImportReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ban := &service.BlackIpBan{
+		Preset:   req.Preset,
+		Seconds:  req.Seconds,
+		Reason:   req.Reason,
+		Operator: claims.UserName,
+	}
+	if req.BlackTime != "" {
+		t, err := parseQueryTime(req.BlackTime)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid black_time"})
+			return
+		}
+		ban.BlackTime = &t
+	}
+	rows, err := blackIpImportRows(&req)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	err := service.GetAdminService().AddBlackIP(c, &blackIP)
+	result, err := service.GetAdminService().ImportBlackIPRows(c, rows, ban)
+	if errors.Is(err, service.ErrInvalidBlackIP) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, result)
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "IP added to blacklist successfully"})
+// blackIpImportRows 读取上传的文件或者请求中的ips，ips按照顺序从第1行开始编号
+func blackIpImportRows(req *params.BlackIpImportReq) ([]sheet.Row, error) {
+	if req.File == nil {
+		if len(req.IPs) == 0 {
+			return nil, errors.New("ips or file is required")
+		}
+		if len(req.IPs) > constant.BlackIpImportMaxRows {
+			return nil, errors.New("too many ips")
+		}
+		rows := make([]sheet.Row, 0, len(req.IPs))
+		for i, ip := range req.IPs {
+			rows = append(rows, sheet.Row{Line: i + 1, Cells: []string{ip}})
+		}
+		return rows, nil
+	}
+	if req.File.Size > constant.BlackIpImportMaxFileSize {
+		return nil, errors.New("file is too large")
+	}
+	if !sheet.Supported(req.File.Filename) {
+		return nil, errors.New("file format is not supported")
+	}
+	f, err := req.File.Open()
+	if err != nil {
+		log.Errorf("blackIpImportRows: error opening file: %v", err)
+		return nil, errors.New("failed to open file")
+	}
+	defer f.Close()
+	rows, err := sheet.Read(req.File.Filename, f, constant.BlackIpImportMaxRows)
+	if err != nil {
+		log.Errorf("blackIpImportRows filename=%s:%v", req.File.Filename, err)
+		return nil, err
+	}
+	return rows, nil
 }
 
-// 删除黑名单中的IP
+// 删除黑名单中的IP，同时删除缓存
 func DeleteBlackIP(c *gin.Context) {
 	id := c.Param("id")
 	blackIpID, err := strconv.ParseUint(id, 10, 64)
@@ -34,11 +131,15 @@ func DeleteBlackIP(c *gin.Context) {
 		return
 	}
 
-	err = service.GetAdminService().DeleteBlackIP(c, uint(blackIpID))
+	blackIP, err := service.GetAdminService().DeleteBlackIP(c, uint(blackIpID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if blackIP == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "black ip not found"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "IP removed from blacklist successfully"})
 }
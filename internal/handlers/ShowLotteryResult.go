@@ -1,18 +1,148 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
-	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/handlers/params"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/utils"
 	"lottery_single/internal/repo"
+	"lottery_single/internal/service"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// ShowLotteryResult 最新的正常状态的中奖记录，只支持翻页和按活动过滤
 func ShowLotteryResult(c *gin.Context) {
-	resultRepo := repo.NewResultRepo()
-	results, err := resultRepo.GetAll(gormcli.GetDB())
+	var req params.ResultListReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	normal := uint(constant.ResultStatusNormal)
+	query := &repo.ResultQuery{ActivityID: req.ActivityID, Status: &normal}
+	page, err := service.GetResultService().ListResults(c, query, req.Cursor, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve lottery results"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// ListResults 管理后台查询中奖记录，支持按用户、奖品、奖品类型、状态、IP和时间范围过滤
+func ListResults(c *gin.Context) {
+	var req params.ResultListReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query, err := newResultQuery(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := service.GetResultService().ListResults(c, query, req.Cursor, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve lottery results"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// MyResults 登录用户查询自己的中奖记录，需要在JWTAuth之后使用
+func MyResults(c *gin.Context) {
+	value, _ := c.Get(constant.JWTUserKey)
+	claims, ok := value.(*utils.JWTClaims)
+	if !ok || claims == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": constant.GetErrMsg(constant.ErrUnauthorized)})
+		return
+	}
+	var req params.ResultListReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query, err := newResultQuery(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 只能查询自己的记录，忽略用户ID和IP参数
+	query.UserID = claims.UserID
+	query.IP = ""
+	page, err := service.GetResultService().ListResults(c, query, req.Cursor, req.Limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve lottery results"})
 		return
 	}
-	c.JSON(http.StatusOK, results)
+	c.JSON(http.StatusOK, page)
+}
+
+// UpdateResultStatus 修改中奖记录状态，删除或者判定作弊
+func UpdateResultStatus(c *gin.Context) {
+	resultID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	var req params.UpdateResultStatusReq
+	if err = c.ShouldBindJSON(&req); err != nil || req.Status == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constant.GetErrMsg(constant.ErrInputInvalid)})
+		return
+	}
+
+	result, err := service.GetAdminService().UpdateResultStatus(c, uint(resultID), *req.Status, req.ReturnStock)
+	if errors.Is(err, service.ErrInvalidResultStatus) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Errorf("UpdateResultStatus: error updating result status: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update result status"})
+		return
+	}
+	if result == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "result not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "result status updated successfully", "result": result})
+}
+
+// newResultQuery 将请求参数转为查询条件
+func newResultQuery(req *params.ResultListReq) (*repo.ResultQuery, error) {
+	query := &repo.ResultQuery{
+		ActivityID: req.ActivityID,
+		UserID:     req.UserID,
+		PrizeID:    req.PrizeID,
+		PrizeType:  req.PrizeType,
+		Status:     req.Status,
+		IP:         req.IP,
+	}
+	if req.BeginTime != "" {
+		t, err := parseQueryTime(req.BeginTime)
+		if err != nil {
+			return nil, err
+		}
+		query.BeginTime = &t
+	}
+	if req.EndTime != "" {
+		t, err := parseQueryTime(req.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		query.EndTime = &t
+	}
+	return query, nil
+}
+
+// parseQueryTime 支持 2006-01-02 15:04:05 和 2006-01-02 两种格式
+func parseQueryTime(str string) (time.Time, error) {
+	if len(str) == len(constant.SysTimeFormatShort) {
+		location, _ := time.LoadLocation("Asia/Shanghai")
+		return time.ParseInLocation(constant.SysTimeFormatShort, str, location)
+	}
+	return utils.ParseTime(str)
 }
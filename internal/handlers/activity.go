@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/risk"
+	"lottery_single/internal/service"
+	"net/http"
+	"strconv"
+)
+
+// AddActivity 新增抽奖活动
+func AddActivity(c *gin.Context) {
+	var activity model.Activity
+	if err := c.ShouldBindJSON(&activity); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkActivity(&activity) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constant.GetErrMsg(constant.ErrInputInvalid)})
+		return
+	}
+	activity.Id = 0
+
+	err := service.GetActivityService().AddActivity(c, &activity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "activity added successfully", "id": activity.Id})
+}
+
+// UpdateActivity 修改抽奖活动
+func UpdateActivity(c *gin.Context) {
+	id := c.Param("id")
+	activityID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	var activity model.Activity
+	if err = c.ShouldBindJSON(&activity); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkActivity(&activity) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constant.GetErrMsg(constant.ErrInputInvalid)})
+		return
+	}
+	activity.Id = uint(activityID)
+
+	err = service.GetActivityService().UpdateActivity(c, &activity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "activity updated successfully"})
+}
+
+// ListActivity 查看所有抽奖活动
+func ListActivity(c *gin.Context) {
+	activities, err := service.GetActivityService().GetActivityList(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, activities)
+}
+
+// checkActivity 活动名称不能为空，结束时间要晚于开始时间
+func checkActivity(activity *model.Activity) bool {
+	if activity.Title == "" || !activity.EndTime.After(activity.BeginTime) {
+		return false
+	}
+	// 限制为0表示使用配置中的值
+	if activity.UserDayLimit < 0 || activity.IpDayLimit < 0 || activity.BlackTime < 0 ||
+		activity.DrawCost < 0 {
+		return false
+	}
+	// 保底规则的次数为0表示不启用
+	if activity.PityBoostAfter < 0 || activity.PityBoostStep < 0 || activity.PityGuaranteeAfter < 0 {
+		return false
+	}
+	if activity.BlackPolicy > constant.BlackPolicyNone || activity.BundleGuarantee > constant.BundleGuaranteeOn {
+		return false
+	}
+	if _, err := risk.ParseRules(activity.RiskRules); err != nil {
+		return false
+	}
+	if activity.SysStatus != 0 && activity.SysStatus != constant.ActivityStatusNormal &&
+		activity.SysStatus != constant.ActivityStatusClosed {
+		return false
+	}
+	return true
+}
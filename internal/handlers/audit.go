@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/service"
+	"net/http"
+	"strconv"
+)
+
+// ListDrawSeeds 查看最近的抽奖种子，公开之前只展示承诺值，公开之后可以用种子校验自己的抽奖
+func ListDrawSeeds(c *gin.Context) {
+	seeds, err := service.GetAuditService().ListSeeds(c, constant.DrawAuditListMax)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, seeds)
+}
+
+// RotateDrawSeed 公开正在使用的抽奖种子，并生成新的种子
+func RotateDrawSeed(c *gin.Context) {
+	seed, err := service.GetAuditService().RotateSeed(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, seed)
+}
+
+// ListDrawAudits 按照用户ID查询抽奖审计记录，可以指定请求ID
+func ListDrawAudits(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Query("user_id"), 10, 64)
+	if err != nil || userID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	audits, err := service.GetAuditService().ListDrawAudits(c, uint(userID), c.Query("request_id"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, audits)
+}
+
+// VerifyDraw 校验一次抽奖，使用种子重新计算抽奖编码并和记录对比
+func VerifyDraw(c *gin.Context) {
+	auditID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	result, err := service.GetAuditService().VerifyDraw(c, uint(auditID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if result == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "draw audit not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
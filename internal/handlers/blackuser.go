@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"lottery_single/internal/handlers/params"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/repo"
+	"lottery_single/internal/service"
+	"net/http"
+)
+
+// BanUser 拉黑用户，使用预设时长或者自定义秒数，已经在黑名单中时重新计算到期时间
+func BanUser(c *gin.Context) {
+	claims, ok := loginClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": constant.GetErrMsg(constant.ErrUnauthorized)})
+		return
+	}
+	var req service.BlackUserBan
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constant.GetErrMsg(constant.ErrInputInvalid)})
+		return
+	}
+	req.Operator = claims.UserName
+	blackUser, err := service.GetBlackUserService().Ban(c, &req)
+	if errors.Is(err, service.ErrInvalidBlackUser) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Errorf("BanUser: error banning user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to ban user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "user banned successfully", "black_user": blackUser})
+}
+
+// LiftUserBan 洗白用户，黑名单立即失效
+func LiftUserBan(c *gin.Context) {
+	claims, ok := loginClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": constant.GetErrMsg(constant.ErrUnauthorized)})
+		return
+	}
+	var req service.BlackUserLift
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constant.GetErrMsg(constant.ErrInputInvalid)})
+		return
+	}
+	req.Operator = claims.UserName
+	blackUser, err := service.GetBlackUserService().Lift(c, &req)
+	if errors.Is(err, service.ErrInvalidBlackUser) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Errorf("LiftUserBan: error lifting ban: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to lift ban"})
+		return
+	}
+	if blackUser == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user is not banned"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "ban lifted successfully", "black_user": blackUser})
+}
+
+// ListBlackUsers 分页查询用户黑名单，可以按用户和是否有效过滤
+func ListBlackUsers(c *gin.Context) {
+	var req params.BlackUserListReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query := &repo.BlackUserQuery{UserID: req.UserID}
+	switch req.Status {
+	case "":
+	case constant.BlackUserStatusActive, constant.BlackUserStatusExpired:
+		active := req.Status == constant.BlackUserStatusActive
+		query.Active = &active
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+	page, err := service.GetBlackUserService().ListBlackUsers(c, query, req.Cursor, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve black users"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// ListBlackUserLogs 分页查询用户被拉黑和洗白的操作记录
+func ListBlackUserLogs(c *gin.Context) {
+	var req params.BlackUserLogListReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := service.GetBlackUserService().ListLogs(c, req.UserID, req.Cursor, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve black user logs"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
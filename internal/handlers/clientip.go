@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"lottery_single/configs"
+	"lottery_single/internal/handlers/params"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/utils"
+	"net/netip"
+	"sync/atomic"
+)
+
+// trustedProxies 解析之后的可信代理网段，配置文件修改之后重新解析
+type trustedProxies struct {
+	conf     *configs.LotteryConf
+	prefixes []netip.Prefix
+}
+
+var trustedProxiesCache atomic.Pointer[trustedProxies]
+
+// getTrustedProxies 获取可信代理网段，配置有误时不信任任何代理，只使用连接的地址
+func getTrustedProxies() []netip.Prefix {
+	conf := configs.GetLotteryConfig()
+	if cached := trustedProxiesCache.Load(); cached != nil && cached.conf == conf {
+		return cached.prefixes
+	}
+	prefixes, err := utils.ParseIPRanges(conf.TrustedProxies)
+	if err != nil {
+		log.Errorf("getTrustedProxies|invalid trusted_proxies %v:%v", conf.TrustedProxies, err)
+		prefixes = nil
+	}
+	trustedProxiesCache.Store(&trustedProxies{conf: conf, prefixes: prefixes})
+	return prefixes
+}
+
+// bindClientIP 抽奖使用服务端获取的客户端IP，请求中填写的IP不一致时按照配置拒绝，或者保留下来记录到抽奖审计
+func bindClientIP(c *gin.Context, req *params.LotteryReq) constant.ErrCode {
+	if req == nil {
+		return constant.Success
+	}
+	clientIP := utils.ClientIP(c.Request.RemoteAddr, c.Request.Header, getTrustedProxies())
+	bodyIP := req.IP
+	req.IP, req.BodyIP = clientIP, ""
+	if bodyIP == "" || utils.NormalizeIP(bodyIP) == clientIP {
+		return constant.Success
+	}
+	log.Infof("bindClientIP|body ip=%s client ip=%s user_id=%d", bodyIP, clientIP, req.UserID)
+	if configs.GetLotteryConfig().IpMismatchReject {
+		return constant.ErrIPMismatch
+	}
+	req.BodyIP = bodyIP
+	return constant.Success
+}
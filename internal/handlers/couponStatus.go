@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"lottery_single/internal/handlers/params"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/service"
+	"net/http"
+	"strings"
+)
+
+// VoidCoupons 作废优惠券，按编码或者按奖品的导入批次
+func VoidCoupons(c *gin.Context) {
+	updateCouponStatus(c, "VoidCoupons", service.GetAdminService().VoidCoupons)
+}
+
+// RestoreCoupons 恢复作废的优惠券，按编码或者按奖品的导入批次
+func RestoreCoupons(c *gin.Context) {
+	updateCouponStatus(c, "RestoreCoupons", service.GetAdminService().RestoreCoupons)
+}
+
+func updateCouponStatus(c *gin.Context, name string,
+	update func(ctx context.Context, filter *service.CouponFilter) (*service.CouponStatusResult, error)) {
+	var req params.CouponStatusReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constant.GetErrMsg(constant.ErrInputInvalid)})
+		return
+	}
+	filter := &service.CouponFilter{PrizeID: req.PrizeID, BatchNo: strings.TrimSpace(req.BatchNo)}
+	for _, code := range req.Codes {
+		if code = strings.TrimSpace(code); code != "" {
+			filter.Codes = append(filter.Codes, code)
+		}
+	}
+
+	result, err := update(c, filter)
+	if errors.Is(err, service.ErrInvalidCouponFilter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Errorf("%s: error updating coupon status: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update coupon status"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "coupon status updated successfully", "result": result})
+}
+
+// RecacheCoupons 优惠券缓存对账，修正缓存和数据库的差异
+func RecacheCoupons(c *gin.Context) {
+	var req params.CouponRecacheReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constant.GetErrMsg(constant.ErrInputInvalid)})
+		return
+	}
+	adminService := service.GetAdminService()
+	if req.PrizeID == 0 {
+		list, err := adminService.ReCacheAllCoupons(c, constant.CouponReconcileSourceAdmin, req.DryRun)
+		if err != nil {
+			log.Errorf("RecacheCoupons: error reconciling coupons: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reconcile coupon cache"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"reconciles": list})
+		return
+	}
+	reconcile, err := adminService.ReCacheCoupon(c, req.PrizeID, constant.CouponReconcileSourceAdmin, req.DryRun)
+	if errors.Is(err, service.ErrInvalidCouponPrize) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Errorf("RecacheCoupons: error reconciling prize %d: %v", req.PrizeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reconcile coupon cache", "reconcile": reconcile})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reconciles": []*model.CouponReconcile{reconcile}})
+}
+
+// ListCouponReconciles 查询优惠券缓存对账记录
+func ListCouponReconciles(c *gin.Context) {
+	var req params.CouponReconcileListReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constant.GetErrMsg(constant.ErrInputInvalid)})
+		return
+	}
+	list, err := service.GetAdminService().ListCouponReconciles(c, req.PrizeID, req.Limit)
+	if err != nil {
+		log.Errorf("ListCouponReconciles: error listing reconciles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list coupon reconciles"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reconciles": list})
+}
@@ -2,12 +2,18 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"lottery_single/internal/handlers/params"
 	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/sheet"
 	"lottery_single/internal/service"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type CouponImportHandler struct {
@@ -17,8 +23,12 @@ type CouponImportHandler struct {
 	service service.AdminService
 }
 
-// CouponImport 导入优惠卷
+// CouponImport 导入优惠卷，multipart请求上传csv或者xlsx文件，json请求的code为换行分隔的编码
 func CouponImport(c *gin.Context) {
+	if c.ContentType() == binding.MIMEMultipartPOSTForm {
+		couponImportFile(c)
+		return
+	}
 	// todo: 参数获取，校验
 	h := CouponImportHandler{
 		service: service.GetAdminService(),
@@ -60,13 +70,147 @@ func (h *CouponImportHandler) CheckInput(ctx context.Context) error {
 
 func (h *CouponImportHandler) Process(ctx context.Context) {
 	log.Infof("CouponImportHandler req ==== %+v\n", h.req)
-	successNum, failNum, err := h.service.ImportCoupon(ctx, h.req.PrizeId, h.req.Code)
+	// 每行一个编码，和上传文件走相同的校验
+	var rows []sheet.Row
+	for i, code := range strings.Split(h.req.Code, "\n") {
+		rows = append(rows, sheet.Row{Line: i + 1, Cells: []string{code}})
+	}
+	result, err := h.service.ImportCouponRows(ctx, h.req.PrizeId, rows, nil)
+	if errors.Is(err, service.ErrInvalidCouponPrize) {
+		h.resp.Code = constant.ErrInputInvalid
+		return
+	}
+	if err != nil {
+		h.resp.Code = constant.ErrInternalServer
+		return
+	}
+	log.Infof("CouponImportHandler|successNum=%d|failNum=%d\n", result.SuccessNum, result.FailNum)
+	h.resp.Data = result
+}
+
+type CouponImportFileHandler struct {
+	req     *params.CouponImportFileReq
+	opt     *service.CouponImportOption
+	resp    HttpResponse
+	service service.AdminService
+}
+
+// couponImportFile 上传文件导入优惠券
+func couponImportFile(c *gin.Context) {
+	h := CouponImportFileHandler{
+		req:     &params.CouponImportFileReq{},
+		service: service.GetAdminService(),
+	}
+	defer func() {
+		h.resp.Msg = constant.GetErrMsg(h.resp.Code)
+		c.JSON(http.StatusOK, h.resp)
+	}()
+	if err := c.ShouldBind(h.req); err != nil {
+		log.Errorf("ShouldBind coupon import file req:err+%v\n", err)
+		h.resp.Code = constant.ErrShouldBind
+		return
+	}
+	Run(&h)
+}
+
+func (h *CouponImportFileHandler) CheckInput(ctx context.Context) error {
+	h.resp.Code = constant.ErrInputInvalid
+	r := h.req
+	if r.PrizeID <= 0 {
+		log.ErrorContextf(ctx, "coupon import prize_id is invalid")
+		return fmt.Errorf("coupon import prize_id is invalid")
+	}
+	if r.File == nil {
+		log.ErrorContextf(ctx, "coupon import file is missing")
+		return fmt.Errorf("coupon import file is missing")
+	}
+	if r.File.Size > constant.CouponImportMaxFileSize {
+		log.ErrorContextf(ctx, "coupon import file is too large, size=%d", r.File.Size)
+		return fmt.Errorf("coupon import file is too large, size=%d", r.File.Size)
+	}
+	if !sheet.Supported(r.File.Filename) {
+		log.ErrorContextf(ctx, "coupon import file format is not supported, filename=%s", r.File.Filename)
+		return fmt.Errorf("coupon import file format is not supported, filename=%s", r.File.Filename)
+	}
+	opt, err := newCouponImportOption(r)
+	if err != nil {
+		log.ErrorContextf(ctx, "coupon import option is invalid:%v", err)
+		return err
+	}
+	h.opt = opt
+	h.resp.Code = constant.Success
+	return nil
+}
+
+func (h *CouponImportFileHandler) Process(ctx context.Context) {
+	f, err := h.req.File.Open()
 	if err != nil {
-		// TODO:
+		log.ErrorContextf(ctx, "CouponImportFileHandler|Process:%v", err)
 		h.resp.Code = constant.ErrInternalServer
 		return
 	}
-	log.Infof("CouponImportHandler|successNum=%d|failNum=%d\n", successNum, failNum)
-	// 继续处理
-	return
+	defer f.Close()
+	rows, err := sheet.Read(h.req.File.Filename, f, constant.CouponImportMaxRows)
+	if err != nil {
+		log.ErrorContextf(ctx, "CouponImportFileHandler|Process filename=%s:%v", h.req.File.Filename, err)
+		h.resp.Code = constant.ErrInputInvalid
+		return
+	}
+	result, err := h.service.ImportCouponRows(ctx, h.req.PrizeID, rows, h.opt)
+	if errors.Is(err, service.ErrInvalidCouponPrize) {
+		log.ErrorContextf(ctx, "CouponImportFileHandler|Process:%v", err)
+		h.resp.Code = constant.ErrInputInvalid
+		return
+	}
+	if err != nil {
+		h.resp.Code = constant.ErrInternalServer
+		return
+	}
+	log.InfoContextf(ctx, "CouponImportFileHandler|prize_id=%d|filename=%s|total=%d|successNum=%d|failNum=%d",
+		h.req.PrizeID, h.req.File.Filename, result.Total, result.SuccessNum, result.FailNum)
+	h.resp.Data = result
+}
+
+// newCouponImportOption 校验批次号和有效期
+func newCouponImportOption(r *params.CouponImportFileReq) (*service.CouponImportOption, error) {
+	opt := &service.CouponImportOption{BatchNo: strings.TrimSpace(r.BatchNo)}
+	if len(opt.BatchNo) > constant.CouponBatchNoMaxLen {
+		return nil, fmt.Errorf("batch_no is too long")
+	}
+	if r.ValidFrom != "" {
+		t, err := parseQueryTime(r.ValidFrom)
+		if err != nil {
+			return nil, fmt.Errorf("valid_from is invalid:%v", err)
+		}
+		opt.ValidFrom = &t
+	}
+	if r.ValidTo != "" {
+		t, err := parseQueryTime(r.ValidTo)
+		if err != nil {
+			return nil, fmt.Errorf("valid_to is invalid:%v", err)
+		}
+		if !t.After(time.Now()) || (opt.ValidFrom != nil && !t.After(*opt.ValidFrom)) {
+			return nil, fmt.Errorf("valid_to must be after now and valid_from")
+		}
+		opt.ValidTo = &t
+	}
+	return opt, nil
+}
+
+// GetCouponImportReport 下载优惠券导入的错误报告
+func GetCouponImportReport(c *gin.Context) {
+	reportID := c.Param("id")
+	report, ok, err := service.GetAdminService().GetCouponImportReport(c, reportID)
+	if err != nil {
+		log.Errorf("GetCouponImportReport: error getting report %s: %v", reportID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get import report"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "import report not found or expired"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="coupon_import_%s.csv"`, reportID))
+	// 加上BOM，Excel打开时不会乱码
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", []byte("\xEF\xBB\xBF"+report))
 }
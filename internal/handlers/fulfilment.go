@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"lottery_single/internal/handlers/params"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/utils"
+	"lottery_single/internal/repo"
+	"lottery_single/internal/service"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loginClaims 获取JWTAuth保存的登录用户信息
+func loginClaims(c *gin.Context) (*utils.JWTClaims, bool) {
+	value, _ := c.Get(constant.JWTUserKey)
+	claims, ok := value.(*utils.JWTClaims)
+	if !ok || claims == nil {
+		return nil, false
+	}
+	return claims, true
+}
+
+// SubmitFulfilmentAddress 中奖用户提交实物奖品的收货地址，还没有发货时可以重复提交修改地址
+func SubmitFulfilmentAddress(c *gin.Context) {
+	claims, ok := loginClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": constant.GetErrMsg(constant.ErrUnauthorized)})
+		return
+	}
+	var req params.FulfilmentAddressReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.ResultID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constant.GetErrMsg(constant.ErrInputInvalid)})
+		return
+	}
+	order, err := service.GetFulfilmentService().SubmitAddress(c, claims.UserID, req.ResultID, &req.FulfilmentAddress)
+	if errors.Is(err, service.ErrInvalidFulfilment) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Errorf("SubmitFulfilmentAddress: error submitting address: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to submit address"})
+		return
+	}
+	if order == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "result not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "address submitted successfully", "order": order})
+}
+
+// MyFulfilments 登录用户查询自己的发货单，需要在JWTAuth之后使用
+func MyFulfilments(c *gin.Context) {
+	claims, ok := loginClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": constant.GetErrMsg(constant.ErrUnauthorized)})
+		return
+	}
+	var req params.FulfilmentListReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query := &repo.FulfilmentQuery{UserID: claims.UserID, Status: req.Status}
+	page, err := service.GetFulfilmentService().ListOrders(c, query, req.Cursor, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve fulfilment orders"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// ListFulfilments 管理后台查询发货单，支持按活动、用户、奖品、状态和时间范围过滤
+func ListFulfilments(c *gin.Context) {
+	var req params.FulfilmentListReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query, err := newFulfilmentQuery(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := service.GetFulfilmentService().ListOrders(c, query, req.Cursor, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve fulfilment orders"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// UpdateFulfilmentStatus 修改发货单状态，发货时填写快递单号
+func UpdateFulfilmentStatus(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	var req service.FulfilmentStatusUpdate
+	if err = c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constant.GetErrMsg(constant.ErrInputInvalid)})
+		return
+	}
+	order, err := service.GetFulfilmentService().UpdateStatus(c, uint(id), &req)
+	if errors.Is(err, service.ErrInvalidFulfilmentStatus) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Errorf("UpdateFulfilmentStatus: error updating order status: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update fulfilment status"})
+		return
+	}
+	if order == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "fulfilment order not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "fulfilment status updated successfully", "order": order})
+}
+
+// ExportFulfilments 导出发货单给仓库，不传状态时导出待发货的发货单
+func ExportFulfilments(c *gin.Context) {
+	var req params.FulfilmentListReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query, err := newFulfilmentQuery(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if query.Status == nil {
+		pending := uint(constant.FulfilmentStatusPending)
+		query.Status = &pending
+	}
+	data, num, err := service.GetFulfilmentService().ExportOrders(c, query)
+	if err != nil {
+		log.Errorf("ExportFulfilments: error exporting orders: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export fulfilment orders"})
+		return
+	}
+	log.Infof("ExportFulfilments: exported %d orders, query=%+v", num, query)
+	filename := fmt.Sprintf("fulfilment_%s.csv", time.Now().Format("20060102150405"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	// 加上BOM，Excel打开时不会乱码
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", []byte("\xEF\xBB\xBF"+data))
+}
+
+// newFulfilmentQuery 将请求参数转为查询条件
+func newFulfilmentQuery(req *params.FulfilmentListReq) (*repo.FulfilmentQuery, error) {
+	query := &repo.FulfilmentQuery{
+		ActivityID: req.ActivityID,
+		UserID:     req.UserID,
+		PrizeID:    req.PrizeID,
+		Status:     req.Status,
+	}
+	for _, str := range strings.Split(req.IDs, ",") {
+		if str = strings.TrimSpace(str); str == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(str, 10, 64)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid id %q in ids", str)
+		}
+		query.IDs = append(query.IDs, uint(id))
+	}
+	if len(query.IDs) > constant.FulfilmentExportMaxRows {
+		return nil, fmt.Errorf("too many ids %d", len(query.IDs))
+	}
+	if req.BeginTime != "" {
+		t, err := parseQueryTime(req.BeginTime)
+		if err != nil {
+			return nil, err
+		}
+		query.BeginTime = &t
+	}
+	if req.EndTime != "" {
+		t, err := parseQueryTime(req.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		query.EndTime = &t
+	}
+	return query, nil
+}
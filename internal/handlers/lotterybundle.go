@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"lottery_single/internal/handlers/params"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/lock"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/service"
+	"net/http"
+)
+
+// LotteryBundleHandler 连抽，在同一个用户抽奖锁中连续完成多次V3抽奖
+type LotteryBundleHandler struct {
+	LotteryHandlerV3
+	bundleReq *params.LotteryBundleReq
+}
+
+// LotteryBundleOutcome 连抽中一次抽奖的结果
+type LotteryBundleOutcome struct {
+	RequestID string                `json:"request_id"`
+	Code      constant.ErrCode      `json:"code"`
+	Msg       string                `json:"msg"`
+	Prize     *service.LotteryPrize `json:"prize,omitempty"`
+}
+
+func LotteryBundle(c *gin.Context) {
+	h := LotteryBundleHandler{
+		LotteryHandlerV3: LotteryHandlerV3{
+			activityService: service.GetActivityService(),
+			limitService:    service.GetLimitService(),
+			lotteryService:  service.GetLotteryService(),
+			resultService:   service.GetResultService(),
+			auditService:    service.GetAuditService(),
+			walletService:   service.GetWalletService(),
+			pityService:     service.GetPityService(),
+			winCapService:   service.GetWinCapService(),
+			riskService:     service.GetRiskService(),
+		},
+	}
+	defer func() {
+		h.resp.Msg = constant.GetErrMsg(h.resp.Code)
+		c.JSON(http.StatusOK, h.resp)
+	}()
+	if err := c.ShouldBind(&h.bundleReq); err != nil {
+		log.Errorf("LotteryBundle|Error binding:%v", err)
+		h.resp.Code = constant.ErrShouldBind
+		return
+	}
+	if h.bundleReq != nil {
+		h.req = &h.bundleReq.LotteryReq
+	}
+	if h.resp.Code = bindClientIP(c, h.req); h.resp.Code != constant.Success {
+		return
+	}
+	Run(&h)
+}
+
+func (l *LotteryBundleHandler) CheckInput(ctx context.Context) error {
+	if err := l.LotteryHandlerV3.CheckInput(ctx); err != nil {
+		return err
+	}
+	if l.bundleReq.Count == 0 {
+		l.bundleReq.Count = constant.LotteryBundleSize
+	}
+	if l.bundleReq.Count < 0 || l.bundleReq.Count > constant.LotteryBundleSize {
+		l.resp.Code = constant.ErrInputInvalid
+		log.Errorf("lottery bundle params invalid, count=%d\n", l.bundleReq.Count)
+		return fmt.Errorf(constant.GetErrMsg(constant.ErrInputInvalid))
+	}
+	// 每次抽奖的请求ID为 {请求ID}_{序号}，需要留出序号的长度
+	if len(bundleRequestID(l.req.RequestID, constant.LotteryBundleSize-1)) > constant.LotteryRequestIDMaxLen {
+		l.resp.Code = constant.ErrInputInvalid
+		log.Errorf("lottery bundle params invalid, request_id=%s\n", l.req.RequestID)
+		return fmt.Errorf(constant.GetErrMsg(constant.ErrInputInvalid))
+	}
+	return nil
+}
+
+// Process 连抽，抽奖之前一次检查剩余的抽奖次数和余额是否够全部抽完
+// 每一次抽奖仍然逐次检查次数、黑名单和奖品库存，被拉黑或者次数用完时不再继续，返回已经完成的每一次抽奖结果
+func (l *LotteryBundleHandler) Process(ctx context.Context) {
+	jwtClaims, err := service.GetUserService().ParseToken(ctx, l.req.Token)
+	if err != nil || jwtClaims == nil {
+		l.resp.Code = constant.ErrJwtParse
+		log.Errorf("jwt parse err, token=%s,user_id=%s\n", l.req.Token, l.req.UserID)
+		return
+	}
+	userID := jwtClaims.UserID
+
+	// 开始抽奖之前被拒绝的请求按照连抽的请求ID记录一次审计信息，开始抽奖之后每一次抽奖单独记录
+	trace := newDrawTrace(ctx, "v3_bundle", l.req, userID)
+	defer func() {
+		if trace != nil {
+			l.auditService.RecordDraw(ctx, trace, l.resp.Code)
+		}
+	}()
+
+	ok, activity, err := l.activityService.CheckActivity(ctx, l.req.ActivityID)
+	if err != nil {
+		l.resp.Code = constant.ErrInternalServer
+		log.ErrorContextf(ctx, "LotteryBundle|CheckActivity:%v", err)
+		return
+	}
+	if !ok {
+		l.resp.Code = constant.ErrActivityInvalid
+		log.InfoContextf(ctx, "LotteryBundle|CheckActivity activity_id=%d is invalid", l.req.ActivityID)
+		return
+	}
+
+	lock1 := lock.NewRedisLock(getLotteryLockKey(userID), lock.WithExpireSeconds(5), lock.WithWatchDogMode())
+	if err := lock1.Lock(ctx); err != nil {
+		l.resp.Code = constant.ErrInternalServer
+		log.ErrorContextf(ctx, "LotteryBundle|Process:%v", err)
+		return
+	}
+	defer lock1.Unlock(ctx)
+
+	// 重试请求在第一次处理时已经检查过，不再检查次数和余额
+	count := l.bundleReq.Count
+	record, err := l.resultService.GetLotteryRequest(ctx, userID, bundleRequestID(l.req.RequestID, 0))
+	if err != nil {
+		l.resp.Code = constant.ErrInternalServer
+		log.ErrorContextf(ctx, "LotteryBundle|GetLotteryRequest:%v", err)
+		return
+	}
+	if record == nil {
+		if l.resp.Code = l.checkBundle(ctx, activity, userID, count); l.resp.Code != constant.Success {
+			return
+		}
+	}
+
+	// 完整的连抽才有保底，前面都没有中奖时最后一次保底
+	guarantee := activity.BundleGuarantee == constant.BundleGuaranteeOn && count == constant.LotteryBundleSize
+	won := false
+	trace = nil
+	outcomes := make([]*LotteryBundleOutcome, 0, count)
+	for i := 0; i < count; i++ {
+		requestID := bundleRequestID(l.req.RequestID, i)
+		req := *l.req
+		req.RequestID = requestID
+		drawTrace := newDrawTrace(ctx, "v3_bundle", &req, userID)
+		code, prize, replayed := l.draw(ctx, activity, jwtClaims, requestID, drawTrace,
+			guarantee && !won && i == count-1)
+		if !replayed {
+			l.auditService.RecordDraw(ctx, drawTrace, code)
+		}
+		won = won || code == constant.Success
+		outcomes = append(outcomes, &LotteryBundleOutcome{
+			RequestID: requestID,
+			Code:      code,
+			Msg:       constant.GetErrMsg(code),
+			Prize:     prize,
+		})
+		if !bundleContinue(code) {
+			break
+		}
+	}
+	// 内部错误时客户端可以用相同的请求ID重试，已经完成的抽奖直接返回之前的结果
+	l.resp.Code = constant.Success
+	if last := outcomes[len(outcomes)-1]; last.Code == constant.ErrInternalServer {
+		l.resp.Code = constant.ErrInternalServer
+	}
+	l.resp.Data = outcomes
+}
+
+// checkBundle 检查用户和IP剩余的抽奖次数，付费活动检查余额，都要够count次抽奖
+func (l *LotteryBundleHandler) checkBundle(ctx context.Context, activity *model.Activity, userID uint,
+	count int) constant.ErrCode {
+	code, err := l.limitService.CheckDrawTimesLeft(ctx, activity, userID, l.req.IP, count)
+	if err != nil {
+		log.ErrorContextf(ctx, "LotteryBundle|CheckDrawTimesLeft:%v", err)
+		return constant.ErrInternalServer
+	}
+	if code != constant.Success {
+		log.InfoContextf(ctx, "LotteryBundle|CheckDrawTimesLeft code=%d count=%d", code, count)
+		return code
+	}
+	if activity.DrawCost <= 0 {
+		return constant.Success
+	}
+	balance, err := l.walletService.GetBalance(ctx, userID)
+	if err != nil {
+		log.ErrorContextf(ctx, "LotteryBundle|GetBalance:%v", err)
+		return constant.ErrInternalServer
+	}
+	if balance < activity.DrawCost*int64(count) {
+		log.InfoContextf(ctx, "LotteryBundle|GetBalance balance=%d draw_cost=%d count=%d", balance,
+			activity.DrawCost, count)
+		return constant.ErrBalanceNotEnough
+	}
+	return constant.Success
+}
+
+// bundleRequestID 连抽中每一次抽奖的请求ID
+func bundleRequestID(requestID string, i int) string {
+	return fmt.Sprintf("%s_%d", requestID, i)
+}
+
+// bundleContinue 中奖、没有中奖和奖品不足时继续抽奖，其他结果之后的抽奖也不会成功
+func bundleContinue(code constant.ErrCode) bool {
+	switch code {
+	case constant.Success, constant.ErrNotWon, constant.ErrPrizeNotEnough:
+		return true
+	}
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"lottery_single/internal/handlers/params"
+	"lottery_single/internal/model"
 	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/pkg/middlewares/lock"
 	"lottery_single/internal/pkg/middlewares/log"
@@ -18,16 +19,22 @@ type LotteryHandlerV2 struct {
 	resp HttpResponse
 
 	// 需要什么Service，就在这里声明
-	limitService   service.LimitService
-	lotteryService service.LotteryService
-	resultService  service.ResultService
+	activityService service.ActivityService
+	limitService    service.LimitService
+	lotteryService  service.LotteryService
+	resultService   service.ResultService
+	auditService    service.AuditService
+	winCapService   service.WinCapService
 }
 
 func LotteryV2(c *gin.Context) {
 	h := LotteryHandlerV2{
-		limitService:   service.GetLimitService(),
-		lotteryService: service.GetLotteryService(),
-		resultService:  service.GetResultService(),
+		activityService: service.GetActivityService(),
+		limitService:    service.GetLimitService(),
+		lotteryService:  service.GetLotteryService(),
+		resultService:   service.GetResultService(),
+		auditService:    service.GetAuditService(),
+		winCapService:   service.GetWinCapService(),
 	}
 	// HTTP响应
 	defer func() {
@@ -40,6 +47,9 @@ func LotteryV2(c *gin.Context) {
 		h.resp.Code = constant.ErrShouldBind
 		return
 	}
+	if h.resp.Code = bindClientIP(c, h.req); h.resp.Code != constant.Success {
+		return
+	}
 	Run(&h)
 }
 
@@ -54,6 +64,12 @@ func (l *LotteryHandlerV2) CheckInput(ctx context.Context) error {
 		log.Errorf("lottery params invalid, token=%s,user_id=%s\n", l.req.Token, l.req.UserID)
 		return fmt.Errorf(constant.GetErrMsg(constant.ErrInputInvalid))
 	}
+	// IP统一为规范的格式，黑名单和次数限制使用相同的写法
+	l.req.IP = utils.NormalizeIP(l.req.IP)
+	// 没有指定活动的请求参与默认活动
+	if l.req.ActivityID == 0 {
+		l.req.ActivityID = constant.DefaultActivityID
+	}
 	return nil
 }
 
@@ -64,7 +80,7 @@ func (l *LotteryHandlerV2) Process(ctx context.Context) {
 		err error
 	)
 	// 1. 根据token解析出用户信息
-	jwtClaims, err := utils.ParseJwtToken(l.req.Token, constant.SecretKey)
+	jwtClaims, err := service.GetUserService().ParseToken(ctx, l.req.Token)
 	if err != nil || jwtClaims == nil {
 		l.resp.Code = constant.ErrJwtParse
 		log.Errorf("jwt parse err, token=%s,user_id=%s\n", l.req.Token, l.req.UserID)
@@ -72,6 +88,49 @@ func (l *LotteryHandlerV2) Process(ctx context.Context) {
 	}
 	userID := jwtClaims.UserID
 
+	// 每一次抽奖请求都记录审计信息，包括没有中奖和被拒绝的请求
+	trace := newDrawTrace(ctx, "v2", l.req, userID)
+	defer func() {
+		l.auditService.RecordDraw(ctx, trace, l.resp.Code)
+	}()
+
+	// 验证活动是否存在，并且在活动时间内
+	ok, activity, err := l.activityService.CheckActivity(ctx, l.req.ActivityID)
+	if err != nil {
+		l.resp.Code = constant.ErrInternalServer
+		log.ErrorContextf(ctx, "LotteryHandler|CheckActivity:%v", err)
+		return
+	}
+	if !ok {
+		l.resp.Code = constant.ErrActivityInvalid
+		log.InfoContextf(ctx, "LotteryHandler|CheckActivity activity_id=%d is invalid", l.req.ActivityID)
+		return
+	}
+	// 付费抽奖需要扣费和发奖在同一个事务中，只有V3版本支持
+	if activity.DrawCost > 0 {
+		l.resp.Code = constant.ErrPaidDrawInvalid
+		log.InfoContextf(ctx, "LotteryHandler|CheckActivity activity_id=%d is paid", l.req.ActivityID)
+		return
+	}
+	// 风控规则需要设备ID和请求签名，只有V3版本支持
+	if activity.RiskRules != "" {
+		l.resp.Code = constant.ErrRiskDrawInvalid
+		log.InfoContextf(ctx, "LotteryHandler|CheckActivity activity_id=%d has risk rules", l.req.ActivityID)
+		return
+	}
+	// 中奖次数上限需要在发奖之前占用次数，只有V3版本支持
+	hasCaps, err := l.winCapService.HasCaps(ctx, activity.Id)
+	if err != nil {
+		l.resp.Code = constant.ErrInternalServer
+		log.ErrorContextf(ctx, "LotteryHandler|HasCaps:%v", err)
+		return
+	}
+	if hasCaps {
+		l.resp.Code = constant.ErrWinCapDrawInvalid
+		log.InfoContextf(ctx, "LotteryHandler|CheckActivity activity_id=%d has win caps", l.req.ActivityID)
+		return
+	}
+
 	lockKey := getLotteryLockKey(userID)
 	lock1 := lock.NewRedisLock(lockKey, lock.WithExpireSeconds(5), lock.WithWatchDogMode())
 
@@ -84,7 +143,7 @@ func (l *LotteryHandlerV2) Process(ctx context.Context) {
 	defer lock1.Unlock(ctx)
 
 	// 2. 验证用户今日抽奖次数
-	ok, err = l.limitService.CheckUserDayLotteryTimesWithCache(ctx, userID)
+	ok, err = l.limitService.CheckUserDayLotteryTimesWithCache(ctx, activity, userID)
 	if err != nil {
 		l.resp.Code = constant.ErrInternalServer
 		log.ErrorContextf(ctx, "LotteryHandler|CheckUserDayLotteryTimes:%v", err)
@@ -97,50 +156,56 @@ func (l *LotteryHandlerV2) Process(ctx context.Context) {
 	}
 
 	// 3. 验证当天IP参与的抽奖次数
-	ipDayLotteryTimes := l.limitService.CheckIPLimit(ctx, l.req.IP)
-	if ipDayLotteryTimes > constant.IpLimitMax {
+	ipDayLotteryTimes := l.limitService.CheckIPLimit(ctx, activity.Id, l.req.IP)
+	if ipDayLotteryTimes > int64(activity.IpDayLimit) {
 		l.resp.Code = constant.ErrIPLimitInvalid
 		log.InfoContextf(ctx, "LotteryHandler|CheckUserDayLotteryTimes:%v", err)
 		return
 	}
 
-	// 4. 验证IP是否在ip黑名单
-	ok, blackIpInfo, err := l.limitService.CheckBlackIPWithCache(ctx, l.req.IP)
-	if err != nil {
-		l.resp.Code = constant.ErrInternalServer
-		log.ErrorContextf(ctx, "LotteryHandler|CheckBlackIP:%v", err)
-		return
-	}
-	// ip黑明单生效
-	if !ok {
-		l.resp.Code = constant.ErrBlackedIP
-		log.InfoContextf(ctx, "LotteryHandler|CheckBlackIP blackIpInfo is %v\n", blackIpInfo)
-		return
-	}
+	var (
+		blackIpInfo   *model.BlackIp
+		blackUserInfo *model.BlackUser
+	)
+	// 活动的黑名单策略为不校验时，跳过黑名单验证
+	if activity.BlackPolicy != constant.BlackPolicyNone {
+		// 4. 验证IP是否在ip黑名单
+		ok, blackIpInfo, err = l.limitService.CheckBlackIPWithCache(ctx, l.req.IP)
+		if err != nil {
+			l.resp.Code = constant.ErrInternalServer
+			log.ErrorContextf(ctx, "LotteryHandler|CheckBlackIP:%v", err)
+			return
+		}
+		// ip黑明单生效
+		if !ok {
+			l.resp.Code = constant.ErrBlackedIP
+			log.InfoContextf(ctx, "LotteryHandler|CheckBlackIP blackIpInfo is %v\n", blackIpInfo)
+			return
+		}
 
-	// 5. 验证用户是否在黑明单中
-	ok, blackUserInfo, err := l.limitService.CheckBlackUserWithCache(ctx, userID)
-	if err != nil {
-		l.resp.Code = constant.ErrInternalServer
-		log.ErrorContextf(ctx, "LotteryHandler|CheckBlackUser:%v", err)
-		return
-	}
-	// 用户黑明单生效
-	if !ok {
-		l.resp.Code = constant.ErrBlackedUser
-		log.ErrorContextf(ctx, "LotteryHandler|CheckBlackUser blackUserInfo is %v\n", blackUserInfo)
-		return
+		// 5. 验证用户是否在黑明单中
+		ok, blackUserInfo, err = l.limitService.CheckBlackUserWithCache(ctx, userID)
+		if err != nil {
+			l.resp.Code = constant.ErrInternalServer
+			log.ErrorContextf(ctx, "LotteryHandler|CheckBlackUser:%v", err)
+			return
+		}
+		// 用户黑明单生效
+		if !ok {
+			l.resp.Code = constant.ErrBlackedUser
+			log.ErrorContextf(ctx, "LotteryHandler|CheckBlackUser blackUserInfo is %v\n", blackUserInfo)
+			return
+		}
 	}
 
 	// 6. 抽奖逻辑实现
-	prizeCode := utils.Random(constant.PrizeCodeMax)
-	log.InfoContextf(ctx, "LotteryHandler|prizeCode=%d", prizeCode)
-	prize, err := l.lotteryService.GetPrizeWithCache(ctx, prizeCode)
+	prize, prizeCode, err := l.lotteryService.GetPrizeWithCache(ctx, activity.Id, trace)
 	if err != nil {
 		l.resp.Code = constant.ErrInternalServer
 		log.ErrorContextf(ctx, "LotteryHandler|GetPrizeWithCache:%v", err)
 		return
 	}
+	log.InfoContextf(ctx, "LotteryHandler|prizeCode=%d", prizeCode)
 	if prize == nil || prize.PrizeNum < 0 || (prize.PrizeNum > 0 && prize.LeftNum <= 0) {
 		l.resp.Code = constant.ErrNotWon
 		return
@@ -148,7 +213,7 @@ func (l *LotteryHandlerV2) Process(ctx context.Context) {
 
 	// 7. 有剩余奖品发放
 	if prize.PrizeNum > 0 {
-		ok, err = l.lotteryService.GiveOutPrizeWithCache(ctx, int(prize.Id))
+		ok, err = l.lotteryService.GiveOutPrizeWithCache(ctx, activity.Id, int(prize.Id))
 		if err != nil {
 			l.resp.Code = constant.ErrInternalServer
 			log.ErrorContextf(ctx, "LotteryHandler|GiveOutPrize:%v", err)
@@ -187,14 +252,15 @@ func (l *LotteryHandlerV2) Process(ctx context.Context) {
 		return
 	}
 
-	// 10. 如果中了实物大奖，需要把ip和用户置于黑明单中一段时间，防止同一个用户频繁中大奖
-	if prize.PrizeType == constant.PrizeTypeEntityLarge {
+	// 10. 如果中了实物大奖，并且活动的黑名单策略需要拉黑，需要把ip和用户置于黑明单中一段时间，防止同一个用户频繁中大奖
+	if prize.PrizeType == constant.PrizeTypeEntityLarge && activity.BlackPolicy == constant.BlackPolicyCheckAndBan {
 		lotteryUserInfo := service.LotteryUserInfo{
 			UserID:   userID,
 			UserName: jwtClaims.UserName,
 			IP:       l.req.IP,
 		}
-		if err := l.lotteryService.PrizeLargeBlackLimit(ctx, blackUserInfo, blackIpInfo, &lotteryUserInfo); err != nil {
+		if err := l.lotteryService.PrizeLargeBlackLimit(ctx, &lotteryUserInfo,
+			activity.BlackTime); err != nil {
 			l.resp.Code = constant.ErrInternalServer
 			log.InfoContextf(ctx, "LotteryHandler|PrizeCouponDiff:%v", err)
 			return
@@ -2,12 +2,15 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"lottery_single/internal/handlers/params"
+	"lottery_single/internal/model"
 	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/pkg/middlewares/lock"
 	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/risk"
 	"lottery_single/internal/pkg/utils"
 	"lottery_single/internal/service"
 	"net/http"
@@ -18,16 +21,28 @@ type LotteryHandlerV3 struct {
 	resp HttpResponse
 
 	// 需要什么Service，就在这里声明
-	limitService   service.LimitService
-	lotteryService service.LotteryService
-	resultService  service.ResultService
+	activityService service.ActivityService
+	limitService    service.LimitService
+	lotteryService  service.LotteryService
+	resultService   service.ResultService
+	auditService    service.AuditService
+	walletService   service.WalletService
+	pityService     service.PityService
+	winCapService   service.WinCapService
+	riskService     service.RiskService
 }
 
 func LotteryV3(c *gin.Context) {
 	h := LotteryHandlerV3{
-		limitService:   service.GetLimitService(),
-		lotteryService: service.GetLotteryService(),
-		resultService:  service.GetResultService(),
+		activityService: service.GetActivityService(),
+		limitService:    service.GetLimitService(),
+		lotteryService:  service.GetLotteryService(),
+		resultService:   service.GetResultService(),
+		auditService:    service.GetAuditService(),
+		walletService:   service.GetWalletService(),
+		pityService:     service.GetPityService(),
+		winCapService:   service.GetWinCapService(),
+		riskService:     service.GetRiskService(),
 	}
 	// HTTP响应
 	defer func() {
@@ -40,6 +55,9 @@ func LotteryV3(c *gin.Context) {
 		h.resp.Code = constant.ErrShouldBind
 		return
 	}
+	if h.resp.Code = bindClientIP(c, h.req); h.resp.Code != constant.Success {
+		return
+	}
 	Run(&h)
 }
 
@@ -54,6 +72,18 @@ func (l *LotteryHandlerV3) CheckInput(ctx context.Context) error {
 		log.Errorf("lottery params invalid, token=%s,user_id=%s\n", l.req.Token, l.req.UserID)
 		return fmt.Errorf(constant.GetErrMsg(constant.ErrInputInvalid))
 	}
+	// 请求ID用于客户端重试时的幂等，同一个请求ID只会处理一次
+	if l.req.RequestID == "" || len(l.req.RequestID) > constant.LotteryRequestIDMaxLen {
+		l.resp.Code = constant.ErrInputInvalid
+		log.Errorf("lottery params invalid, request_id=%s\n", l.req.RequestID)
+		return fmt.Errorf(constant.GetErrMsg(constant.ErrInputInvalid))
+	}
+	// IP统一为规范的格式，黑名单和次数限制使用相同的写法
+	l.req.IP = utils.NormalizeIP(l.req.IP)
+	// 没有指定活动的请求参与默认活动
+	if l.req.ActivityID == 0 {
+		l.req.ActivityID = constant.DefaultActivityID
+	}
 	return nil
 }
 
@@ -64,7 +94,7 @@ func (l *LotteryHandlerV3) Process(ctx context.Context) {
 		err error
 	)
 	// 1. 根据token解析出用户信息
-	jwtClaims, err := utils.ParseJwtToken(l.req.Token, constant.SecretKey)
+	jwtClaims, err := service.GetUserService().ParseToken(ctx, l.req.Token)
 	if err != nil || jwtClaims == nil {
 		l.resp.Code = constant.ErrJwtParse
 		log.Errorf("jwt parse err, token=%s,user_id=%s\n", l.req.Token, l.req.UserID)
@@ -72,6 +102,27 @@ func (l *LotteryHandlerV3) Process(ctx context.Context) {
 	}
 	userID := jwtClaims.UserID
 
+	// 每一次抽奖请求都记录审计信息，包括没有中奖和被拒绝的请求，重试请求直接返回之前的结果，不再记录
+	trace := newDrawTrace(ctx, "v3", l.req, userID)
+	defer func() {
+		if trace != nil {
+			l.auditService.RecordDraw(ctx, trace, l.resp.Code)
+		}
+	}()
+
+	// 验证活动是否存在，并且在活动时间内
+	ok, activity, err := l.activityService.CheckActivity(ctx, l.req.ActivityID)
+	if err != nil {
+		l.resp.Code = constant.ErrInternalServer
+		log.ErrorContextf(ctx, "LotteryHandler|CheckActivity:%v", err)
+		return
+	}
+	if !ok {
+		l.resp.Code = constant.ErrActivityInvalid
+		log.InfoContextf(ctx, "LotteryHandler|CheckActivity activity_id=%d is invalid", l.req.ActivityID)
+		return
+	}
+
 	lockKey := getLotteryLockKey(userID)
 	lock1 := lock.NewRedisLock(lockKey, lock.WithExpireSeconds(5), lock.WithWatchDogMode())
 
@@ -83,132 +134,205 @@ func (l *LotteryHandlerV3) Process(ctx context.Context) {
 	}
 	defer lock1.Unlock(ctx)
 
-	// 2. 验证用户今日抽奖次数
-	ok, err = l.limitService.CheckUserDayLotteryTimesWithCache(ctx, userID)
-	if err != nil {
-		l.resp.Code = constant.ErrInternalServer
-		log.ErrorContextf(ctx, "LotteryHandler|CheckUserDayLotteryTimes:%v", err)
-		return
+	var (
+		prize    *service.LotteryPrize
+		replayed bool
+	)
+	l.resp.Code, prize, replayed = l.draw(ctx, activity, jwtClaims, l.req.RequestID, trace, false)
+	if replayed {
+		trace = nil
 	}
-	if !ok {
-		l.resp.Code = constant.ErrUserLimitInvalid
-		log.InfoContextf(ctx, "LotteryHandler|CheckUserDayLotteryTimes:%v", err)
-		return
+	if prize != nil {
+		l.resp.Data = prize
 	}
+}
 
-	// 3. 验证当天IP参与的抽奖次数
-	ipDayLotteryTimes := l.limitService.CheckIPLimit(ctx, l.req.IP)
-	if ipDayLotteryTimes > constant.IpLimitMax {
-		l.resp.Code = constant.ErrIPLimitInvalid
-		log.InfoContextf(ctx, "LotteryHandler|CheckUserDayLotteryTimes:%v", err)
-		return
+// draw 在持有用户抽奖锁时完成一次抽奖，单抽和连抽共用
+// 相同请求ID的重试请求直接返回第一次的处理结果，replayed为true，不需要再记录审计信息
+// 付费活动只有处理完成才扣费，中奖时扣费和发奖在同一个事务中，内部错误不扣费
+// guaranteed 为true时是连抽保底，只从有库存的奖品中抽取，否则按照活动的保底规则调整
+// 风控规则链拒绝时不抽奖，降级时照常抽奖和计数，但是按照没有中奖处理
+func (l *LotteryHandlerV3) draw(ctx context.Context, activity *model.Activity, jwtClaims *utils.JWTClaims,
+	requestID string, trace *service.DrawTrace, guaranteed bool) (code constant.ErrCode, prize *service.LotteryPrize,
+	replayed bool) {
+	userID := jwtClaims.UserID
+	record, err := l.resultService.GetLotteryRequest(ctx, userID, requestID)
+	if err != nil {
+		log.ErrorContextf(ctx, "LotteryHandler|GetLotteryRequest:%v", err)
+		return constant.ErrInternalServer, nil, false
+	}
+	if record != nil {
+		log.InfoContextf(ctx, "LotteryHandler|GetLotteryRequest retry request_id=%s", requestID)
+		return record.Code, record.Prize, true
 	}
+	// 内部错误允许客户端重试，其他的处理结果都记录下来，同时更新连续没有中奖的次数，在释放锁之前执行
+	var pity *service.PityDraw
+	defer func() {
+		if code == constant.ErrInternalServer {
+			return
+		}
+		record := &service.LotteryRequestRecord{Code: code, Prize: prize}
+		if err := l.resultService.SetLotteryRequest(ctx, userID, requestID, record); err != nil {
+			log.ErrorContextf(ctx, "LotteryHandler|SetLotteryRequest:%v", err)
+		}
+		l.pityService.RecordOutcome(ctx, activity, userID, pity, code)
+	}()
 
-	// 4. 验证IP是否在ip黑名单
-	ok, blackIpInfo, err := l.limitService.CheckBlackIPWithCache(ctx, l.req.IP)
+	// 风控规则链，签名使用客户端提供的请求ID，连抽只签名一次
+	decision, err := l.riskService.Evaluate(ctx, activity, &risk.Input{
+		ActivityID: activity.Id,
+		UserID:     userID,
+		IP:         l.req.IP,
+		DeviceID:   l.req.DeviceID,
+		RequestID:  l.req.RequestID,
+		Sign:       l.req.Sign,
+	})
 	if err != nil {
-		l.resp.Code = constant.ErrInternalServer
-		log.ErrorContextf(ctx, "LotteryHandler|CheckBlackIP:%v", err)
-		return
+		log.ErrorContextf(ctx, "LotteryHandler|Evaluate:%v", err)
+		return constant.ErrInternalServer, nil, false
 	}
-	// ip黑明单生效
-	if !ok {
-		l.resp.Code = constant.ErrBlackedIP
-		log.InfoContextf(ctx, "LotteryHandler|CheckBlackIP blackIpInfo is %v\n", blackIpInfo)
-		return
+	if activity.RiskRules != "" {
+		trace.RiskVerdict, trace.RiskRule = string(decision.Verdict), decision.Rule
+	}
+	if decision.Verdict == risk.VerdictDeny {
+		return constant.ErrRiskDenied, nil, false
 	}
 
-	// 5. 验证用户是否在黑明单中
-	ok, blackUserInfo, err := l.limitService.CheckBlackUserWithCache(ctx, userID)
+	// 付费活动余额不足时不能抽奖
+	if activity.DrawCost > 0 {
+		balance, err := l.walletService.GetBalance(ctx, userID)
+		if err != nil {
+			log.ErrorContextf(ctx, "LotteryHandler|GetBalance:%v", err)
+			return constant.ErrInternalServer, nil, false
+		}
+		if balance < activity.DrawCost {
+			log.InfoContextf(ctx, "LotteryHandler|GetBalance balance=%d draw_cost=%d", balance, activity.DrawCost)
+			return constant.ErrBalanceNotEnough, nil, false
+		}
+	}
+
+	// 2. 抽奖，先抽出奖品，这样奖品池的扣减可以和次数限制、黑名单检查一起完成
+	// 连续没有中奖时按照保底规则提高中奖权重或者必中
+	pity, err = l.pityService.GetPityDraw(ctx, activity, userID)
 	if err != nil {
-		l.resp.Code = constant.ErrInternalServer
-		log.ErrorContextf(ctx, "LotteryHandler|CheckBlackUser:%v", err)
-		return
+		log.ErrorContextf(ctx, "LotteryHandler|GetPityDraw:%v", err)
+		return constant.ErrInternalServer, nil, false
 	}
-	// 用户黑明单生效
-	if !ok {
-		l.resp.Code = constant.ErrBlackedUser
-		log.ErrorContextf(ctx, "LotteryHandler|CheckBlackUser blackUserInfo is %v\n", blackUserInfo)
-		return
+	if guaranteed {
+		pity.Mode, pity.PrizeID = constant.PityModeBundle, 0
+	}
+	prize, prizeCode, err := l.lotteryService.GetPityPrizeWithCache(ctx, activity.Id, trace, pity)
+	if err != nil {
+		log.ErrorContextf(ctx, "LotteryHandler|GetPrizeWithCache:%v", err)
+		return constant.ErrInternalServer, nil, false
+	}
+	won := prize != nil && prize.PrizeNum >= 0 && (prize.PrizeNum == 0 || prize.LeftNum > 0)
+	if won && decision.Verdict == risk.VerdictDegrade {
+		log.InfoContextf(ctx, "LotteryHandler|Evaluate degrade prize_id=%d rule=%s", prize.Id, decision.Rule)
+		won = false
 	}
 
-	// 6. 抽奖逻辑实现
-	prizeCode := utils.Random(constant.PrizeCodeMax)
-	prize, err := l.lotteryService.GetPrizeWithCache(ctx, prizeCode)
+	// 中奖次数超过用户或者IP的上限时按照没有中奖处理，没有发奖时归还占用的次数
+	var (
+		reservation *service.WinCapReservation
+		awarded     bool
+	)
+	defer func() {
+		if !awarded {
+			l.winCapService.Release(ctx, reservation)
+		}
+	}()
+	if won {
+		reservation, won, err = l.winCapService.Reserve(ctx, activity, userID, l.req.IP, prize)
+		if err != nil {
+			log.ErrorContextf(ctx, "LotteryHandler|Reserve:%v", err)
+			return constant.ErrInternalServer, nil, false
+		}
+	}
+	var poolPrize *service.LotteryPrize
+	if won {
+		poolPrize = prize
+	}
+
+	// 3. 用户和IP的抽奖次数、IP和用户黑名单验证，中奖时同时扣减奖品池
+	check, err := l.limitService.CheckBeforeDraw(ctx, activity, userID, l.req.IP, poolPrize)
 	if err != nil {
-		l.resp.Code = constant.ErrInternalServer
-		log.ErrorContextf(ctx, "LotteryHandler|CheckBlackUser:%v", err)
-		return
+		log.ErrorContextf(ctx, "LotteryHandler|CheckBeforeDraw:%v", err)
+		return constant.ErrInternalServer, nil, false
 	}
-	if prize == nil || prize.PrizeNum < 0 || (prize.PrizeNum > 0 && prize.LeftNum <= 0) {
-		l.resp.Code = constant.ErrNotWon
-		return
+	// 脚本发现奖品池已经没有这个奖品时，次数已经计入，和没有中奖一样扣费
+	if check.Code == constant.ErrNotWon {
+		log.InfoContextf(ctx, "LotteryHandler|CheckBeforeDraw prize pool is empty")
+		return l.chargeNotWon(ctx, activity, userID, requestID), nil, false
+	}
+	if check.Code != constant.Success {
+		log.InfoContextf(ctx, "LotteryHandler|CheckBeforeDraw code=%d, black ip=%v, black user=%v",
+			check.Code, check.BlackIp, check.BlackUser)
+		return check.Code, nil, false
+	}
+	if !won {
+		return l.chargeNotWon(ctx, activity, userID, requestID), nil, false
 	}
 
-	// 7. 有剩余奖品发放
-	if prize.PrizeNum > 0 {
-		num, err := l.lotteryService.GetPrizeNumWithPool(ctx, prize.Id)
+	// 4. 奖品池没有扣减时，奖品池中没有剩余奖品不能发奖
+	if prize.PrizeNum > 0 && !check.PoolDecreased {
+		num, err := l.lotteryService.GetPrizeNumWithPool(ctx, activity.Id, prize.Id)
 		if err != nil {
-			l.resp.Code = constant.ErrInternalServer
 			log.ErrorContextf(ctx, "LotteryHandler|GiveOutPrize:%v", err)
-			return
+			return constant.ErrInternalServer, nil, false
 		}
 		// 奖品池奖品不够，不能发奖
 		if num <= 0 {
-			l.resp.Code = constant.ErrNotWon
 			log.InfoContextf(ctx, "LotteryHandler|GiveOutPrize|prize num not enough")
-			return
-		}
-		// 奖品池奖品足够，可以发奖
-		ok, err = l.lotteryService.GiveOutPrizeWithPool(ctx, int(prize.Id))
-		if err != nil {
-			l.resp.Code = constant.ErrInternalServer
-			log.ErrorContextf(ctx, "LotteryHandler|GiveOutPrize:%v", err)
-			return
-		}
-		// 奖品不足，发放失败
-		if !ok {
-			l.resp.Code = constant.ErrPrizeNotEnough
-			log.InfoContextf(ctx, "LotteryHandler|GiveOutPrize:%v", err)
-			return
+			return l.chargeNotWon(ctx, activity, userID, requestID), nil, false
 		}
 	}
 
-	/***如果中奖记录重要的的话，可以考虑用事务将下面逻辑包裹*****/
-	// 8. 发优惠券
-	if prize.PrizeType == constant.PrizeTypeCouponDiff {
-		code, err := l.lotteryService.PrizeCouponDiffWithCache(ctx, int(prize.Id))
+	// 5. 发奖，奖品池、库存、优惠券、扣费和中奖纪录要么全部成功，要么全部回滚
+	lotteryUserInfo := service.LotteryUserInfo{
+		UserID:   userID,
+		UserName: jwtClaims.UserName,
+		IP:       l.req.IP,
+		DrawCost: activity.DrawCost,
+	}
+	ok, err := l.lotteryService.AwardPrizeWithPool(ctx, prize, &lotteryUserInfo, prizeCode, requestID,
+		check.PoolDecreased)
+	if errors.Is(err, service.ErrInsufficientBalance) {
+		log.InfoContextf(ctx, "LotteryHandler|AwardPrizeWithPool balance not enough with prize_id=%d", prize.Id)
+		return constant.ErrBalanceNotEnough, nil, false
+	}
+	if err != nil {
+		log.ErrorContextf(ctx, "LotteryHandler|AwardPrizeWithPool:%v", err)
+		return constant.ErrInternalServer, nil, false
+	}
+	// 奖品不足，发放失败，不扣费
+	if !ok {
+		log.InfoContextf(ctx, "LotteryHandler|AwardPrizeWithPool prize not enough with prize_id=%d", prize.Id)
+		return constant.ErrPrizeNotEnough, nil, false
+	}
+	awarded = true
+
+	// 6. 如果中了实物大奖，并且活动的黑名单策略需要拉黑，需要把ip和用户置于黑明单中一段时间，防止同一个用户频繁中大奖
+	// 发奖已经提交，拉黑失败只记录日志，不能让客户端把已经中奖的请求当作内部错误重试
+	if prize.PrizeType == constant.PrizeTypeEntityLarge && activity.BlackPolicy == constant.BlackPolicyCheckAndBan {
+		err := l.lotteryService.PrizeLargeBlackLimit(ctx, &lotteryUserInfo, activity.BlackTime)
 		if err != nil {
-			l.resp.Code = constant.ErrInternalServer
-			log.InfoContextf(ctx, "LotteryHandler|PrizeCouponDiff:%v", err)
-			return
-		}
-		if code == "" {
-			l.resp.Code = constant.ErrNotWon
-			log.InfoContext(ctx, "LotteryHandler|PrizeCouponDiff coupon left is nil")
-			return
+			log.ErrorContextf(ctx, "LotteryHandler|PrizeLargeBlackLimit user_id=%d ip=%s:%v", userID, l.req.IP, err)
 		}
 	}
-	l.resp.Data = prize
+	return constant.Success, prize, false
+}
 
-	// 9 记录中奖纪录
-	if err := l.resultService.LotteryResult(ctx, prize, userID, jwtClaims.UserName, l.req.IP, prizeCode); err != nil {
-		l.resp.Code = constant.ErrInternalServer
-		log.InfoContextf(ctx, "LotteryHandler|PrizeCouponDiff:%v", err)
-		return
+// chargeNotWon 没有中奖时扣除付费抽奖的费用
+func (l *LotteryHandlerV3) chargeNotWon(ctx context.Context, activity *model.Activity, userID uint,
+	requestID string) constant.ErrCode {
+	err := l.walletService.ChargeDraw(ctx, userID, activity.Id, requestID, activity.DrawCost)
+	if errors.Is(err, service.ErrInsufficientBalance) {
+		return constant.ErrBalanceNotEnough
 	}
-
-	// 10. 如果中了实物大奖，需要把ip和用户置于黑明单中一段时间，防止同一个用户频繁中大奖
-	if prize.PrizeType == constant.PrizeTypeEntityLarge {
-		lotteryUserInfo := service.LotteryUserInfo{
-			UserID:   userID,
-			UserName: jwtClaims.UserName,
-			IP:       l.req.IP,
-		}
-		if err := l.lotteryService.PrizeLargeBlackLimit(ctx, blackUserInfo, blackIpInfo, &lotteryUserInfo); err != nil {
-			l.resp.Code = constant.ErrInternalServer
-			log.InfoContextf(ctx, "LotteryHandler|PrizeCouponDiff:%v", err)
-			return
-		}
+	if err != nil {
+		log.ErrorContextf(ctx, "LotteryHandler|ChargeDraw:%v", err)
+		return constant.ErrInternalServer
 	}
+	return constant.ErrNotWon
 }
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/handlers/params"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/risk"
+	"lottery_single/internal/pkg/utils"
+	"lottery_single/internal/service"
+)
+
+func TestMain(m *testing.M) {
+	dir, _ := os.MkdirTemp("", "lottery_handlers_test")
+	log.Init(log.WithLogPath(dir))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// 下面的service只实现抽奖用到的方法，其他方法调用时会panic
+
+type fakeResultService struct {
+	service.ResultService
+	records map[string]*service.LotteryRequestRecord
+}
+
+func (f *fakeResultService) GetLotteryRequest(ctx context.Context, uid uint, requestID string) (
+	*service.LotteryRequestRecord, error) {
+	return f.records[requestID], nil
+}
+
+func (f *fakeResultService) SetLotteryRequest(ctx context.Context, uid uint, requestID string,
+	record *service.LotteryRequestRecord) error {
+	f.records[requestID] = record
+	return nil
+}
+
+type fakePityService struct {
+	service.PityService
+	outcomes []constant.ErrCode
+}
+
+func (f *fakePityService) GetPityDraw(ctx context.Context, activity *model.Activity, uid uint) (*service.PityDraw,
+	error) {
+	return &service.PityDraw{Mode: constant.PityModeNone}, nil
+}
+
+func (f *fakePityService) RecordOutcome(ctx context.Context, activity *model.Activity, uid uint,
+	pity *service.PityDraw, code constant.ErrCode) {
+	f.outcomes = append(f.outcomes, code)
+}
+
+type fakeRiskService struct {
+	service.RiskService
+}
+
+func (f *fakeRiskService) Evaluate(ctx context.Context, activity *model.Activity, in *risk.Input) (risk.Decision,
+	error) {
+	return risk.Decision{Verdict: risk.VerdictAllow}, nil
+}
+
+type fakeWalletService struct {
+	service.WalletService
+	balance int64
+	charged []string
+}
+
+func (f *fakeWalletService) GetBalance(ctx context.Context, uid uint) (int64, error) {
+	return f.balance, nil
+}
+
+func (f *fakeWalletService) ChargeDraw(ctx context.Context, uid uint, activityID uint, requestID string,
+	cost int64) error {
+	f.charged = append(f.charged, requestID)
+	return nil
+}
+
+type fakeLotteryService struct {
+	service.LotteryService
+	prize    *service.LotteryPrize
+	awarded  int
+	banError error
+}
+
+func (f *fakeLotteryService) GetPityPrizeWithCache(ctx context.Context, activityID uint, trace *service.DrawTrace,
+	pity *service.PityDraw) (*service.LotteryPrize, int64, error) {
+	return f.prize, 1, nil
+}
+
+func (f *fakeLotteryService) AwardPrizeWithPool(ctx context.Context, prize *service.LotteryPrize,
+	info *service.LotteryUserInfo, prizeCode int64, requestID string, poolDecreased bool) (bool, error) {
+	f.awarded++
+	return true, nil
+}
+
+func (f *fakeLotteryService) PrizeLargeBlackLimit(ctx context.Context, info *service.LotteryUserInfo,
+	blackTime int) error {
+	return f.banError
+}
+
+type fakeWinCapService struct {
+	service.WinCapService
+	released int
+}
+
+func (f *fakeWinCapService) Reserve(ctx context.Context, activity *model.Activity, uid uint, ip string,
+	prize *service.LotteryPrize) (*service.WinCapReservation, bool, error) {
+	return &service.WinCapReservation{}, true, nil
+}
+
+func (f *fakeWinCapService) Release(ctx context.Context, reservation *service.WinCapReservation) {
+	if reservation != nil {
+		f.released++
+	}
+}
+
+type fakeLimitService struct {
+	service.LimitService
+	check *service.PreDrawCheck
+	err   error
+}
+
+func (f *fakeLimitService) CheckBeforeDraw(ctx context.Context, activity *model.Activity, uid uint, ip string,
+	prize *service.LotteryPrize) (*service.PreDrawCheck, error) {
+	return f.check, f.err
+}
+
+func (f *fakeLimitService) CheckBlackIPWithCache(ctx context.Context, ip string) (bool, *model.BlackIp, error) {
+	return true, nil, nil
+}
+
+func (f *fakeLimitService) CheckBlackUserWithCache(ctx context.Context, uid uint) (bool, *model.BlackUser, error) {
+	return true, nil, nil
+}
+
+type drawFakes struct {
+	result  *fakeResultService
+	pity    *fakePityService
+	wallet  *fakeWalletService
+	lottery *fakeLotteryService
+	winCap  *fakeWinCapService
+	limit   *fakeLimitService
+}
+
+func newDrawHandler(prize *service.LotteryPrize, check *service.PreDrawCheck) (*LotteryHandlerV3, *drawFakes) {
+	f := &drawFakes{
+		result:  &fakeResultService{records: make(map[string]*service.LotteryRequestRecord)},
+		pity:    &fakePityService{},
+		wallet:  &fakeWalletService{balance: 100},
+		lottery: &fakeLotteryService{prize: prize},
+		winCap:  &fakeWinCapService{},
+		limit:   &fakeLimitService{check: check},
+	}
+	h := &LotteryHandlerV3{
+		req:            &params.LotteryReq{IP: "10.0.0.1"},
+		limitService:   f.limit,
+		lotteryService: f.lottery,
+		resultService:  f.result,
+		walletService:  f.wallet,
+		pityService:    f.pity,
+		winCapService:  f.winCap,
+		riskService:    &fakeRiskService{},
+	}
+	return h, f
+}
+
+func TestDrawPoolEmptyIsCharged(t *testing.T) {
+	prize := &service.LotteryPrize{Id: 1, PrizeNum: 10, LeftNum: 5}
+	h, f := newDrawHandler(prize, &service.PreDrawCheck{Code: constant.ErrNotWon})
+	activity := &model.Activity{Id: 1, DrawCost: 10}
+	claims := &utils.JWTClaims{UserID: 1}
+
+	code, got, replayed := h.draw(context.Background(), activity, claims, "req-1", &service.DrawTrace{}, false)
+	assert.Equal(t, constant.ErrNotWon, code)
+	assert.Nil(t, got)
+	assert.False(t, replayed)
+	// 奖品池为空和其他没有中奖的情况一样扣费，并且记录结果，占用的中奖次数归还
+	assert.Equal(t, []string{"req-1"}, f.wallet.charged)
+	assert.Equal(t, []constant.ErrCode{constant.ErrNotWon}, f.pity.outcomes)
+	assert.Equal(t, constant.ErrNotWon, f.result.records["req-1"].Code)
+	assert.Equal(t, 1, f.winCap.released)
+	assert.Equal(t, 0, f.lottery.awarded)
+}
+
+func TestDrawBanFailureKeepsAward(t *testing.T) {
+	prize := &service.LotteryPrize{Id: 1, PrizeNum: 10, LeftNum: 5, PrizeType: constant.PrizeTypeEntityLarge}
+	h, f := newDrawHandler(prize, &service.PreDrawCheck{Code: constant.Success, PoolDecreased: true})
+	f.lottery.banError = errors.New("db down")
+	activity := &model.Activity{Id: 1, BlackPolicy: constant.BlackPolicyCheckAndBan}
+	claims := &utils.JWTClaims{UserID: 1}
+
+	code, got, _ := h.draw(context.Background(), activity, claims, "req-2", &service.DrawTrace{}, false)
+	assert.Equal(t, constant.Success, code)
+	assert.Equal(t, prize, got)
+	assert.Equal(t, 1, f.lottery.awarded)
+	assert.Equal(t, 0, f.winCap.released)
+	assert.Equal(t, constant.Success, f.result.records["req-2"].Code)
+}
+
+func TestDrawPreCheckErrorIsInternal(t *testing.T) {
+	prize := &service.LotteryPrize{Id: 1, PrizeNum: 10, LeftNum: 5}
+	h, f := newDrawHandler(prize, nil)
+	f.limit.err = errors.New("i/o timeout")
+	activity := &model.Activity{Id: 1, DrawCost: 10}
+	claims := &utils.JWTClaims{UserID: 1}
+
+	code, got, _ := h.draw(context.Background(), activity, claims, "req-3", &service.DrawTrace{}, false)
+	assert.Equal(t, constant.ErrInternalServer, code)
+	assert.Nil(t, got)
+	// 前置检查出错时不扣费，占用的中奖次数归还
+	assert.Empty(t, f.wallet.charged)
+	assert.Equal(t, 1, f.winCap.released)
+	assert.Equal(t, 0, f.lottery.awarded)
+}
@@ -2,11 +2,13 @@ package params
 
 import (
 	"lottery_single/internal/service"
+	"mime/multipart"
 	"time"
 )
 
 // PrizeListRequest 处理请求和响应的实体
 type PrizeListRequest struct {
+	ActivityID uint `form:"activity_id" json:"activity_id"` // 活动ID，不传获取所有活动的奖品
 }
 
 type PrizeListResponse struct {
@@ -22,6 +24,16 @@ type LoginRsp struct {
 	Token  string
 }
 
+// RefreshTokenReq 刷新token的请求参数
+type RefreshTokenReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LogoutReq 退出登录的请求参数，刷新token可以不传
+type LogoutReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 // RegisterReq 定义注册请求所需的参数
 type RegisterReq struct {
 	UserName string `form:"user_name" json:"user_name" binding:"required"`
@@ -32,10 +44,73 @@ type RegisterReq struct {
 	Age      int    `form:"age" json:"age" binding:"required,min=0"`
 	Gender   string `form:"gender" json:"gender" binding:"required,oneof=male female"`
 }
+
+// SetUserRolesReq 设置用户角色的请求参数
+type SetUserRolesReq struct {
+	Roles []string `json:"roles"`
+}
+
 type LotteryReq struct {
-	UserID uint   `json:"user_id"`
-	Token  string `json:"token"`
-	IP     string `json:"ip"`
+	UserID     uint   `json:"user_id"`
+	Token      string `json:"token"`
+	IP         string `json:"ip"`          // 服务端根据连接和可信代理获取，请求中填写的值只用于比对
+	ActivityID uint   `json:"activity_id"` // 活动ID，不传参与默认活动
+	RequestID  string `json:"request_id"`  // 请求ID，客户端重试时保持不变，V3版本必传
+	DeviceID   string `json:"device_id"`   // 设备ID，风控规则按照设备统计抽奖次数
+	Sign       string `json:"sign"`        // 请求签名，活动配置了签名规则时必传，见 risk.Sign
+	BodyIP     string `json:"-" form:"-"`  // 请求中填写的和服务端获取的不一致的IP，记录到抽奖审计
+}
+
+// LotteryBundleReq 连抽请求参数
+type LotteryBundleReq struct {
+	LotteryReq
+	Count int `json:"count"` // 抽奖次数，不传为 constant.LotteryBundleSize
+}
+
+// ResultListReq 中奖记录查询参数，时间格式为 2006-01-02 15:04:05 或者 2006-01-02
+type ResultListReq struct {
+	Cursor     uint   `form:"cursor"` // 上一页返回的next_cursor，不传表示第一页
+	Limit      int    `form:"limit"`
+	ActivityID uint   `form:"activity_id"`
+	UserID     uint   `form:"user_id"`
+	PrizeID    uint   `form:"prize_id"`
+	PrizeType  *uint  `form:"prize_type"`
+	Status     *uint  `form:"status"`
+	IP         string `form:"ip"`
+	BeginTime  string `form:"begin_time"` // 包含
+	EndTime    string `form:"end_time"`   // 不包含
+}
+
+// UpdateResultStatusReq 修改中奖记录状态的请求参数，收回奖品只在判定作弊时有效
+type UpdateResultStatusReq struct {
+	Status      *uint `json:"status"`       // 0 正常，1 删除，2 作弊
+	ReturnStock bool  `json:"return_stock"` // 是否收回奖品，库存和奖品池加回，优惠券作废
+}
+
+// FulfilmentAddressReq 中奖用户提交实物奖品的收货地址
+type FulfilmentAddressReq struct {
+	ResultID uint `json:"result_id"`
+	service.FulfilmentAddress
+}
+
+// FulfilmentListReq 发货单查询参数，导出时cursor和limit无效，ids为逗号分隔的发货单ID
+type FulfilmentListReq struct {
+	Cursor     uint   `form:"cursor"`
+	Limit      int    `form:"limit"`
+	ActivityID uint   `form:"activity_id"`
+	UserID     uint   `form:"user_id"`
+	PrizeID    uint   `form:"prize_id"`
+	Status     *uint  `form:"status"`
+	IDs        string `form:"ids"`
+	BeginTime  string `form:"begin_time"` // 包含
+	EndTime    string `form:"end_time"`   // 不包含
+}
+
+// WalletLedgerReq 查询虚拟币余额变动记录，用户查询自己的记录时忽略user_id
+type WalletLedgerReq struct {
+	UserID uint `form:"user_id"`
+	Cursor uint `form:"cursor"`
+	Limit  int  `form:"limit"`
 }
 
 type PrizeAddRequest struct {
@@ -55,9 +130,63 @@ type CouponListRequest struct {
 	PrizeID uint `json:"prize_id"`
 }
 
+// CouponImportFileReq 上传文件导入优惠券，支持csv和xlsx，读取code列，没有表头时读取第一列
+type CouponImportFileReq struct {
+	PrizeID   uint                  `form:"prize_id"`
+	File      *multipart.FileHeader `form:"file"`
+	BatchNo   string                `form:"batch_no"`   // 导入批次号，不传时自动生成
+	ValidFrom string                `form:"valid_from"` // 有效期开始时间，不传表示不限
+	ValidTo   string                `form:"valid_to"`   // 有效期结束时间，不传表示不限
+}
+
+// CouponStatusReq 批量作废或者恢复优惠券，传codes时按编码处理，否则按奖品的导入批次处理
+type CouponStatusReq struct {
+	PrizeID uint     `json:"prize_id"`
+	BatchNo string   `json:"batch_no"`
+	Codes   []string `json:"codes"`
+}
+
+// CouponRecacheReq 优惠券缓存对账，prize_id为0时对所有奖品对账，dry_run只对比不修正
+type CouponRecacheReq struct {
+	PrizeID uint `json:"prize_id"`
+	DryRun  bool `json:"dry_run"`
+}
+
+// CouponReconcileListReq 查询优惠券缓存对账记录
+type CouponReconcileListReq struct {
+	PrizeID uint `form:"prize_id"`
+	Limit   int  `form:"limit"`
+}
+
 // CouponListResponse 优惠券列表的响应
 type CouponListResponse struct {
 	Coupons        []*service.ViewCouponInfo `json:"coupons"`
 	DBCouponNum    int64                     `json:"db_coupon_num"`
 	CacheCouponNum int64                     `json:"cache_coupon_num"`
 }
+
+// BlackUserListReq 查询用户黑名单，status为active只查询有效的，expired只查询已经过期或者洗白的，不传表示全部
+type BlackUserListReq struct {
+	Status string `form:"status"`
+	UserID uint   `form:"user_id"`
+	Cursor uint   `form:"cursor"`
+	Limit  int    `form:"limit"`
+}
+
+// BlackUserLogListReq 查询用户被拉黑和洗白的操作记录
+type BlackUserLogListReq struct {
+	UserID uint `form:"user_id" binding:"required"`
+	Cursor uint `form:"cursor"`
+	Limit  int  `form:"limit"`
+}
+
+// BlackIpImportReq 批量导入IP黑名单，json请求传ips，multipart请求上传csv或者xlsx文件，读取ip列，没有表头时读取第一列
+// preset、seconds和black_time三选一，所有IP使用相同的到期时间和原因
+type BlackIpImportReq struct {
+	IPs       []string              `json:"ips" form:"-"`
+	File      *multipart.FileHeader `json:"-" form:"file"`
+	Preset    string                `json:"preset" form:"preset"`
+	Seconds   int64                 `json:"seconds" form:"seconds"`
+	BlackTime string                `json:"black_time" form:"black_time"`
+	Reason    string                `json:"reason" form:"reason"`
+}
@@ -31,7 +31,7 @@ func GetPrizeList(c *gin.Context) {
 		c.JSON(http.StatusOK, h.resp)
 	}()
 	// 获取请求数据
-	c.ShouldBind(h.req)
+	c.ShouldBind(&h.req)
 	Run(&h)
 }
 
@@ -41,7 +41,7 @@ func (h *PrizeListHandler) CheckInput(ctx context.Context) error {
 }
 
 func (h *PrizeListHandler) Process(ctx context.Context) {
-	v, err := h.service.GetPrizeList(ctx)
+	v, err := h.service.GetPrizeList(ctx, h.req.ActivityID)
 	if err != nil {
 		// TODO:
 		h.resp.Code = constant.PrizeStatusDelete
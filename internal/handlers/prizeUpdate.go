@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"lottery_single/internal/pkg/draw"
 	"lottery_single/internal/service"
 	"time"
 
@@ -10,10 +12,12 @@ import (
 
 type ViewPrize struct {
 	Id           uint      `json:"id"`
+	ActivityId   uint      `json:"activity_id"`
 	Title        string    `json:"title"`
 	Img          string    `json:"img"`
 	PrizeNum     int       `json:"prize_num"`
 	PrizeCode    string    `json:"prize_code"`
+	Probability  float64   `json:"probability"`
 	PrizeTime    uint      `json:"prize_time"`
 	LeftNum      int       `json:"left_num"`
 	PrizeType    uint      `json:"prize_type"`
@@ -22,6 +26,9 @@ type ViewPrize struct {
 	EndTime      time.Time `json:"end_time"`
 	DisplayOrder uint      `json:"display_order"`
 	SysStatus    uint      `json:"sys_status"`
+	PrizeProfile string    `json:"prize_profile"`
+	// 相同编码优惠券的信息，不传时从PrizeProfile解析
+	CouponProfile *service.CouponProfile `json:"coupon_profile,omitempty"`
 }
 
 func UpdatePrize(c *gin.Context) {
@@ -32,7 +39,11 @@ func UpdatePrize(c *gin.Context) {
 	}
 
 	err := service.GetAdminService().UpdatePrize(c, (*service.ViewPrize)(&viewPrize))
-
+	if errors.Is(err, draw.ErrWeightInvalid) || errors.Is(err, draw.ErrWeightOverflow) ||
+		errors.Is(err, service.ErrInvalidPrizeProfile) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/draw"
 	"lottery_single/internal/pkg/middlewares/log"
 	"lottery_single/internal/service"
 	"net/http"
@@ -59,7 +61,12 @@ func (h *PrizeAddHandler) CheckInput(ctx context.Context) error {
 		log.ErrorContextf(ctx, "prize prize_num is invalid")
 		return fmt.Errorf("prize prize_num is invalid")
 	}
-	if r.PrizeCode == "" {
+	// 中奖概率和旧版的中奖编码至少设置一个
+	if r.Probability < 0 || r.Probability > 1 {
+		log.ErrorContextf(ctx, "prize probability is invalid")
+		return fmt.Errorf("prize probability is invalid")
+	}
+	if r.PrizeCode == "" && r.Probability == 0 {
 		log.ErrorContextf(ctx, "prize prize_code is invalid")
 		return fmt.Errorf("prize prize_code is invalid")
 	}
@@ -78,6 +85,15 @@ func (h *PrizeAddHandler) CheckInput(ctx context.Context) error {
 func (h *PrizeAddHandler) Process(ctx context.Context) {
 	log.Infof("PrizeAddHandler req ==== %+v\n", h.req)
 	err := h.service.AddPrize(ctx, h.req)
+	if errors.Is(err, draw.ErrWeightInvalid) || errors.Is(err, draw.ErrWeightOverflow) {
+		h.resp.Code = constant.ErrPrizeProbability
+		return
+	}
+	if errors.Is(err, service.ErrInvalidPrizeProfile) {
+		log.ErrorContextf(ctx, "PrizeAddHandler|Process:%v", err)
+		h.resp.Code = constant.ErrInputInvalid
+		return
+	}
 	if err != nil {
 		// TODO:
 		h.resp.Code = constant.ErrInternalServer
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"lottery_single/internal/handlers/params"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/utils"
+	"lottery_single/internal/service"
+	"net/http"
+)
+
+type RefreshTokenHandler struct {
+	req  *params.RefreshTokenReq
+	resp *HttpResponse
+
+	// 需要什么Service，就在这里声明
+	service service.UserService
+}
+
+// RefreshToken 使用刷新token换取新的token
+func RefreshToken(c *gin.Context) {
+	h := RefreshTokenHandler{
+		req:     &params.RefreshTokenReq{},
+		resp:    &HttpResponse{},
+		service: service.GetUserService(),
+	}
+	// HTTP响应
+	defer func() {
+		// 通过对应的Code，获取Msg
+		h.resp.Msg = constant.GetErrMsg(h.resp.Code)
+		c.JSON(http.StatusOK, h.resp)
+	}()
+	if err := c.ShouldBind(h.req); err != nil {
+		log.Errorf("ShouldBind refresh token req:err+%v\n", err)
+		h.resp.Code = constant.ErrShouldBind
+		return
+	}
+	Run(&h)
+}
+
+func (r *RefreshTokenHandler) CheckInput(ctx context.Context) error {
+	if r.req.RefreshToken == "" {
+		r.resp.Code = constant.ErrInputInvalid
+		return fmt.Errorf(constant.GetErrMsg(constant.ErrInputInvalid))
+	}
+	return nil
+}
+
+func (r *RefreshTokenHandler) Process(ctx context.Context) {
+	v, err := r.service.RefreshToken(ctx, r.req.RefreshToken)
+	switch {
+	case errors.Is(err, service.ErrTokenExpired):
+		r.resp.Code = constant.ErrTokenExpired
+		return
+	case errors.Is(err, service.ErrTokenInvalid):
+		log.InfoContextf(ctx, "RefreshTokenHandler|Process:%v", err)
+		r.resp.Code = constant.ErrUnauthorized
+		return
+	case err != nil:
+		log.ErrorContextf(ctx, "RefreshTokenHandler|Process:%v", err)
+		r.resp.Code = constant.ErrInternalServer
+		return
+	}
+	r.resp.Data = v
+}
+
+// Logout 退出登录，注销当前的访问token和刷新token，需要在JWTAuth之后使用
+func Logout(c *gin.Context) {
+	resp := HttpResponse{}
+	defer func() {
+		resp.Msg = constant.GetErrMsg(resp.Code)
+		c.JSON(http.StatusOK, resp)
+	}()
+	value, _ := c.Get(constant.JWTUserKey)
+	claims, ok := value.(*utils.JWTClaims)
+	if !ok || claims == nil {
+		resp.Code = constant.ErrUnauthorized
+		return
+	}
+	// 没有传请求体的时候只注销访问token
+	var req params.LogoutReq
+	_ = c.ShouldBindJSON(&req)
+	err := service.GetUserService().Logout(c.Request.Context(), claims, req.RefreshToken)
+	switch {
+	case errors.Is(err, service.ErrTokenInvalid):
+		resp.Code = constant.ErrInputInvalid
+	case err != nil:
+		log.Errorf("Logout|user_id=%d:%v", claims.UserID, err)
+		resp.Code = constant.ErrInternalServer
+	}
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"lottery_single/internal/handlers/params"
 	"lottery_single/internal/model"
 	"lottery_single/internal/pkg/middlewares/log"
 	"lottery_single/internal/service"
@@ -74,3 +76,52 @@ func GetAllUsers(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"users": users})
 }
+
+// GetUserRoles 查看用户的管理后台角色
+func GetUserRoles(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("userID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid userID"})
+		return
+	}
+
+	roles, err := service.GetUserService().GetUserRoles(c.Request.Context(), uint(userID))
+	if err != nil {
+		log.Errorf("GetUserRoles: error getting user roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve user roles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "roles": roles})
+}
+
+// SetUserRoles 设置用户的管理后台角色，传空列表表示取消所有角色
+func SetUserRoles(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("userID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid userID"})
+		return
+	}
+
+	var req params.SetUserRolesReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	err = service.GetUserService().SetUserRoles(c.Request.Context(), uint(userID), req.Roles)
+	if errors.Is(err, service.ErrInvalidRole) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		log.Errorf("SetUserRoles: error setting user roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set user roles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "user roles updated successfully"})
+}
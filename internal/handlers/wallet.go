@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"lottery_single/internal/handlers/params"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/service"
+	"net/http"
+)
+
+// MyWallet 登录用户查询自己的虚拟币余额和余额变动记录
+func MyWallet(c *gin.Context) {
+	claims, ok := loginClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": constant.GetErrMsg(constant.ErrUnauthorized)})
+		return
+	}
+	var req params.WalletLedgerReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := service.GetWalletService().ListLedgers(c, claims.UserID, req.Cursor, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve wallet"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// ListWalletLedgers 管理后台查询用户的虚拟币余额和余额变动记录
+func ListWalletLedgers(c *gin.Context) {
+	var req params.WalletLedgerReq
+	if err := c.ShouldBindQuery(&req); err != nil || req.UserID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+		return
+	}
+	page, err := service.GetWalletService().ListLedgers(c, req.UserID, req.Cursor, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve wallet"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// AdjustWallet 后台调整用户的虚拟币余额，必须填写原因，相同request_id只调整一次
+func AdjustWallet(c *gin.Context) {
+	claims, ok := loginClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": constant.GetErrMsg(constant.ErrUnauthorized)})
+		return
+	}
+	var req service.WalletAdjust
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constant.GetErrMsg(constant.ErrInputInvalid)})
+		return
+	}
+	req.Operator = claims.UserName
+	ledger, err := service.GetWalletService().Adjust(c, &req)
+	if errors.Is(err, service.ErrInvalidWalletAdjust) || errors.Is(err, service.ErrInsufficientBalance) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Errorf("AdjustWallet: error adjusting wallet of user %d: %v", req.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to adjust wallet"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "wallet adjusted successfully", "ledger": ledger})
+}
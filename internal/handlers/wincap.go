@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/service"
+	"net/http"
+	"strconv"
+)
+
+// ListWinCaps 查看活动的中奖次数上限规则
+func ListWinCaps(c *gin.Context) {
+	activityID, err := strconv.ParseUint(c.Query("activity_id"), 10, 64)
+	if err != nil || activityID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid activity_id"})
+		return
+	}
+	list, err := service.GetWinCapService().ListCaps(c, uint(activityID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve win caps"})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// AddWinCap 新增中奖次数上限规则
+func AddWinCap(c *gin.Context) {
+	var winCap model.WinCap
+	if err := c.ShouldBindJSON(&winCap); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": constant.GetErrMsg(constant.ErrInputInvalid)})
+		return
+	}
+	winCap.Id = 0
+	err := service.GetWinCapService().AddCap(c, &winCap)
+	if errors.Is(err, service.ErrInvalidWinCap) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Errorf("AddWinCap: error adding win cap: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add win cap"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "win cap added successfully", "id": winCap.Id})
+}
+
+// DeleteWinCap 删除中奖次数上限规则，已经统计的中奖次数在周期结束之后过期
+func DeleteWinCap(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	ok, err := service.GetWinCapService().DeleteCap(c, uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete win cap"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "win cap not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "win cap deleted successfully"})
+}
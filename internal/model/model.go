@@ -5,15 +5,17 @@ import "time"
 // Prize 奖品表
 type Prize struct {
 	Id           uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	ActivityId   uint       `gorm:"column:activity_id;type:int(10) unsigned;default:1;comment:所属活动ID，关联t_activity表;NOT NULL" json:"activity_id"`
 	Title        string     `gorm:"column:title;type:varchar(255);comment:奖品名称;NOT NULL" json:"title"`
 	PrizeNum     int        `gorm:"column:prize_num;type:int(11);default:-1;comment:奖品数量，0 无限量，>0限量，<0无奖品;NOT NULL" json:"prize_num"`
 	LeftNum      int        `gorm:"column:left_num;type:int(11);default:0;comment:剩余数量;NOT NULL" json:"left_num"`
-	PrizeCode    string     `gorm:"column:prize_code;type:varchar(50);comment:0-9999表示100%，0-0表示万分之一的中奖概率;NOT NULL" json:"prize_code"`
+	PrizeCode    string     `gorm:"column:prize_code;type:varchar(50);comment:0-9999表示100%，0-0表示万分之一的中奖概率，旧版字段，未设置中奖概率时自动折算;NOT NULL" json:"prize_code"`
+	Probability  float64    `gorm:"column:probability;type:decimal(10,7);default:0;comment:中奖概率，1表示100%，最小精度0.0000001;NOT NULL" json:"probability"`
 	PrizeTime    uint       `gorm:"column:prize_time;type:int(10) unsigned;default:0;comment:发奖周期，多少天，以天为单位;NOT NULL" json:"prize_time"`
 	Img          string     `gorm:"column:img;type:varchar(255);comment:奖品图片;NOT NULL" json:"img"`
 	DisplayOrder uint       `gorm:"column:display_order;type:int(10) unsigned;default:0;comment:位置序号，小的排在前面;NOT NULL" json:"display_order"`
 	PrizeType    uint       `gorm:"column:prize_type;type:int(10) unsigned;default:0;comment:奖品类型，0 虚拟币，1 虚拟券，2 实物-小奖，3 实物-大奖;NOT NULL" json:"prize_type"`
-	PrizeProfile string     `gorm:"column:prize_profile;type:varchar(255);comment:奖品扩展数据，如：虚拟币数量;NOT NULL" json:"prize_profile"`
+	PrizeProfile string     `gorm:"column:prize_profile;type:varchar(1024);comment:奖品扩展数据，如：虚拟币数量，相同编码优惠券的信息;NOT NULL" json:"prize_profile"`
 	BeginTime    time.Time  `gorm:"column:begin_time;type:datetime;default:1000-01-01 00:00:00;comment:奖品有效周期：开始时间;NOT NULL" json:"begin_time"`
 	EndTime      time.Time  `gorm:"column:end_time;type:datetime;default:1000-01-01 00:00:00;comment:奖品有效周期：结束时间;NOT NULL" json:"end_time"`
 	PrizePlan    string     `gorm:"column:prize_plan;type:mediumtext;comment:发奖计划，[[时间1,数量1],[时间2,数量2]]" json:"prize_plan"`
@@ -46,29 +48,45 @@ func (p *Prize) TableName() string {
 
 // User 用户表
 type User struct {
-	Id        uint   `gorm:"column:id;type:int(10) unsigned;AUTO_INCREMENT;NOT NULL" json:"id"`
-	UserName  string `gorm:"column:user_name;type:varchar(255);comment:用户名称;NOT NULL" json:"user_name"`
-	Password  string `gorm:"column:pass_word;type:varchar(255);comment:用户密码;NOT NULL" json:"pass_word"`
-	Signature string `gorm:"column:signature;type:varchar(1024);comment:签名" json:"signature"`
-	Email     string `json:"email"`
-	Mobile    string `json:"mobile"`
-	RealName  string `json:"real_name"`
-	Age       int    `json:"age"`
-	Gender    string `json:"gender"`
+	Id        uint       `gorm:"column:id;type:int(10) unsigned;AUTO_INCREMENT;NOT NULL" json:"id"`
+	UserName  string     `gorm:"column:user_name;type:varchar(255);comment:用户名称;NOT NULL" json:"user_name"`
+	Password  string     `gorm:"column:pass_word;type:varchar(255);comment:用户密码;NOT NULL" json:"pass_word"`
+	Signature string     `gorm:"column:signature;type:varchar(1024);comment:签名" json:"signature"`
+	Email     string     `json:"email"`
+	Mobile    string     `json:"mobile"`
+	RealName  string     `json:"real_name"`
+	Age       int        `json:"age"`
+	Gender    string     `json:"gender"`
+	CreatedAt *time.Time `gorm:"column:created_at;type:datetime;comment:注册时间" json:"created_at"`
 }
 
 func (u *User) TableName() string {
 	return "t_user"
 }
 
+// UserRole 管理后台用户角色表，一个用户可以有多个角色
+type UserRole struct {
+	Id         uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	UserId     uint       `gorm:"column:user_id;type:int(10) unsigned;default:0;comment:用户ID，关联t_user表;NOT NULL" json:"user_id"`
+	Role       string     `gorm:"column:role;type:varchar(50);comment:角色，super_admin 超级管理员，operator 运营，auditor 审计;NOT NULL" json:"role"`
+	SysCreated *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
+}
+
+func (u *UserRole) TableName() string {
+	return "t_user_role"
+}
+
 // Coupon 优惠券表
 type Coupon struct {
 	Id         uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
 	PrizeId    uint       `gorm:"column:prize_id;type:int(10) unsigned;default:0;comment:奖品ID，关联lt_prize表;NOT NULL" json:"prize_id"`
 	Code       string     `gorm:"column:code;type:varchar(255);comment:虚拟券编码;NOT NULL" json:"code"`
+	BatchNo    string     `gorm:"column:batch_no;type:varchar(64);comment:导入批次号;NOT NULL" json:"batch_no"`
+	ValidFrom  *time.Time `gorm:"column:valid_from;type:datetime;default null;comment:有效期开始时间，为空表示不限" json:"valid_from"`
+	ValidTo    *time.Time `gorm:"column:valid_to;type:datetime;default null;comment:有效期结束时间，为空表示不限" json:"valid_to"`
 	SysCreated *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
 	SysUpdated *time.Time `gorm:"autoUpdateTime;column:sys_updated;type:datetime;default null;comment:更新时间;NOT NULL" json:"sys_updated"`
-	SysStatus  uint       `gorm:"column:sys_status;type:smallint(5) unsigned;default:0;comment:状态，1正常，2作废，2已发放;NOT NULL" json:"sys_status"`
+	SysStatus  uint       `gorm:"column:sys_status;type:smallint(5) unsigned;default:0;comment:状态，1正常，2已发放，3已作废，4已过期，5已收回;NOT NULL" json:"sys_status"`
 }
 
 func (c *Coupon) TableName() string {
@@ -78,13 +96,15 @@ func (c *Coupon) TableName() string {
 // Result 抽奖记录表
 type Result struct {
 	Id         uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	ActivityId uint       `gorm:"column:activity_id;type:int(10) unsigned;default:1;comment:活动ID，关联t_activity表;NOT NULL" json:"activity_id"`
 	PrizeId    uint       `gorm:"column:prize_id;type:int(10) unsigned;default:0;comment:奖品ID，关联lt_prize表;NOT NULL" json:"prize_id"`
 	PrizeName  string     `gorm:"column:prize_name;type:varchar(255);comment:奖品名称;NOT NULL" json:"prize_name"`
 	PrizeType  uint       `gorm:"column:prize_type;type:int(10) unsigned;default:0;comment:奖品类型，同lt_prize. gtype;NOT NULL" json:"prize_type"`
 	UserId     uint       `gorm:"column:user_id;type:int(10) unsigned;default:0;comment:用户ID;NOT NULL" json:"user_id"`
 	UserName   string     `gorm:"column:user_name;type:varchar(50);comment:用户名;NOT NULL" json:"user_name"`
-	PrizeCode  uint       `gorm:"column:prize_code;type:int(10) unsigned;default:0;comment:抽奖编号（4位的随机数）;NOT NULL" json:"prize_code"`
-	PrizeData  string     `gorm:"column:prize_data;type:varchar(255);comment:获奖信息;NOT NULL" json:"prize_data"`
+	PrizeCode  uint64     `gorm:"column:prize_code;type:bigint(20) unsigned;default:0;comment:抽奖编号，抽奖引擎编码空间内的随机数;NOT NULL" json:"prize_code"`
+	PrizeData  string     `gorm:"column:prize_data;type:varchar(1024);comment:获奖信息，不同编码优惠券为发放的编码，相同编码优惠券为优惠券信息json;NOT NULL" json:"prize_data"`
+	RequestId  string     `gorm:"column:request_id;type:varchar(64);comment:抽奖请求ID，客户端重试时保持不变，用于防止重复发奖;NOT NULL" json:"request_id"`
 	SysCreated *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
 	SysIp      string     `gorm:"column:sys_ip;type:varchar(50);comment:用户抽奖的IP;NOT NULL" json:"sys_ip"`
 	SysStatus  uint       `gorm:"column:sys_status;type:smallint(5) unsigned;default:0;comment:状态，0 正常，1删除，2作弊;NOT NULL" json:"sys_status"`
@@ -94,6 +114,160 @@ func (r *Result) TableName() string {
 	return "t_result"
 }
 
+// FulfilmentOrder 实物奖品的发货单，每条中奖记录最多一个发货单
+type FulfilmentOrder struct {
+	Id              uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	ResultId        uint       `gorm:"column:result_id;type:int(10) unsigned;default:0;comment:中奖记录ID，关联t_result表;NOT NULL" json:"result_id"`
+	ActivityId      uint       `gorm:"column:activity_id;type:int(10) unsigned;default:0;comment:活动ID;NOT NULL" json:"activity_id"`
+	PrizeId         uint       `gorm:"column:prize_id;type:int(10) unsigned;default:0;comment:奖品ID;NOT NULL" json:"prize_id"`
+	PrizeName       string     `gorm:"column:prize_name;type:varchar(255);comment:奖品名称;NOT NULL" json:"prize_name"`
+	PrizeType       uint       `gorm:"column:prize_type;type:int(10) unsigned;default:0;comment:奖品类型;NOT NULL" json:"prize_type"`
+	UserId          uint       `gorm:"column:user_id;type:int(10) unsigned;default:0;comment:用户ID;NOT NULL" json:"user_id"`
+	UserName        string     `gorm:"column:user_name;type:varchar(50);comment:用户名;NOT NULL" json:"user_name"`
+	Receiver        string     `gorm:"column:receiver;type:varchar(50);comment:收件人;NOT NULL" json:"receiver"`
+	Mobile          string     `gorm:"column:mobile;type:varchar(20);comment:收件人电话;NOT NULL" json:"mobile"`
+	Province        string     `gorm:"column:province;type:varchar(50);comment:省;NOT NULL" json:"province"`
+	City            string     `gorm:"column:city;type:varchar(50);comment:市;NOT NULL" json:"city"`
+	District        string     `gorm:"column:district;type:varchar(50);comment:区县;NOT NULL" json:"district"`
+	Address         string     `gorm:"column:address;type:varchar(255);comment:详细地址;NOT NULL" json:"address"`
+	TrackingCompany string     `gorm:"column:tracking_company;type:varchar(50);comment:快递公司;NOT NULL" json:"tracking_company"`
+	TrackingNo      string     `gorm:"column:tracking_no;type:varchar(64);comment:快递单号;NOT NULL" json:"tracking_no"`
+	Remark          string     `gorm:"column:remark;type:varchar(255);comment:备注，取消时为取消原因;NOT NULL" json:"remark"`
+	ShippedTime     *time.Time `gorm:"column:shipped_time;type:datetime;comment:发货时间" json:"shipped_time"`
+	DeliveredTime   *time.Time `gorm:"column:delivered_time;type:datetime;comment:签收时间" json:"delivered_time"`
+	SysStatus       uint       `gorm:"column:sys_status;type:smallint(5) unsigned;default:1;comment:状态，1待发货，2已发货，3已签收，4已取消;NOT NULL" json:"sys_status"`
+	SysCreated      *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
+	SysUpdated      *time.Time `gorm:"autoUpdateTime;column:sys_updated;type:datetime;default null;comment:修改时间;NOT NULL" json:"sys_updated"`
+}
+
+func (f *FulfilmentOrder) TableName() string {
+	return "t_fulfilment_order"
+}
+
+// Wallet 用户的虚拟币钱包，余额只能通过账本记账修改
+type Wallet struct {
+	Id         uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	UserId     uint       `gorm:"column:user_id;type:int(10) unsigned;default:0;comment:用户ID;NOT NULL" json:"user_id"`
+	Balance    int64      `gorm:"column:balance;type:bigint(20);default:0;comment:虚拟币余额;NOT NULL" json:"balance"`
+	SysCreated *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
+	SysUpdated *time.Time `gorm:"autoUpdateTime;column:sys_updated;type:datetime;default null;comment:修改时间;NOT NULL" json:"sys_updated"`
+}
+
+func (w *Wallet) TableName() string {
+	return "t_wallet"
+}
+
+// WinCap 中奖次数上限规则，超过上限时按照没有中奖处理
+type WinCap struct {
+	Id         uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	ActivityId uint       `gorm:"column:activity_id;type:int(10) unsigned;default:0;comment:活动ID;NOT NULL" json:"activity_id"`
+	Scope      uint       `gorm:"column:scope;type:smallint(5) unsigned;default:1;comment:计数对象，1 用户，2 IP;NOT NULL" json:"scope"`
+	PrizeId    uint       `gorm:"column:prize_id;type:int(10) unsigned;default:0;comment:限制的奖品ID，0 不限奖品;NOT NULL" json:"prize_id"`
+	PrizeKind  uint       `gorm:"column:prize_kind;type:smallint(5) unsigned;default:0;comment:限制的奖品种类，0 全部，1 实物奖品，2 虚拟奖品;NOT NULL" json:"prize_kind"`
+	Period     uint       `gorm:"column:period;type:smallint(5) unsigned;default:1;comment:计数周期，1 每天，2 活动期间;NOT NULL" json:"period"`
+	MaxWins    int        `gorm:"column:max_wins;type:int(11);default:0;comment:周期内最多中奖次数;NOT NULL" json:"max_wins"`
+	SysCreated *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
+	SysUpdated *time.Time `gorm:"autoUpdateTime;column:sys_updated;type:datetime;default null;comment:修改时间;NOT NULL" json:"sys_updated"`
+}
+
+func (w *WinCap) TableName() string {
+	return "t_win_cap"
+}
+
+// PityCounter 用户在活动中连续没有中奖的次数，redis中的计数丢失时从这里恢复
+type PityCounter struct {
+	Id         uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	ActivityId uint       `gorm:"column:activity_id;type:int(10) unsigned;default:0;comment:活动ID;NOT NULL" json:"activity_id"`
+	UserId     uint       `gorm:"column:user_id;type:int(10) unsigned;default:0;comment:用户ID;NOT NULL" json:"user_id"`
+	Losses     int64      `gorm:"column:losses;type:bigint(20);default:0;comment:连续没有中奖的次数;NOT NULL" json:"losses"`
+	SysCreated *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
+	SysUpdated *time.Time `gorm:"autoUpdateTime;column:sys_updated;type:datetime;default null;comment:修改时间;NOT NULL" json:"sys_updated"`
+}
+
+func (p *PityCounter) TableName() string {
+	return "t_pity_counter"
+}
+
+// WalletLedger 虚拟币账本，复式记账，每笔交易一条用户账户分录和一条系统账户分录，金额相加为0
+type WalletLedger struct {
+	Id           uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	TxNo         string     `gorm:"column:tx_no;type:varchar(100);comment:交易号，同一笔交易的分录相同，用于幂等;NOT NULL" json:"tx_no"`
+	Account      string     `gorm:"column:account;type:varchar(32);comment:账户，user用户钱包，system_prize中奖发放，system_adjust后台调整;NOT NULL" json:"account"`
+	UserId       uint       `gorm:"column:user_id;type:int(10) unsigned;default:0;comment:用户ID;NOT NULL" json:"user_id"`
+	Amount       int64      `gorm:"column:amount;type:bigint(20);default:0;comment:金额，入账为正，出账为负;NOT NULL" json:"amount"`
+	BalanceAfter int64      `gorm:"column:balance_after;type:bigint(20);default:0;comment:记账之后的用户余额，系统账户为0;NOT NULL" json:"balance_after"`
+	BizType      string     `gorm:"column:biz_type;type:varchar(32);comment:业务类型，prize中奖，prize_revoke作弊收回，adjust后台调整;NOT NULL" json:"biz_type"`
+	ResultId     uint       `gorm:"column:result_id;type:int(10) unsigned;default:0;comment:关联的中奖记录ID;NOT NULL" json:"result_id"`
+	Reason       string     `gorm:"column:reason;type:varchar(255);comment:原因;NOT NULL" json:"reason"`
+	Operator     string     `gorm:"column:operator;type:varchar(50);comment:操作人，后台调整时为管理员;NOT NULL" json:"operator"`
+	SysCreated   *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
+}
+
+func (w *WalletLedger) TableName() string {
+	return "t_wallet_ledger"
+}
+
+// DrawSeed 抽奖种子表，种子在公开之前只对外公布承诺值
+type DrawSeed struct {
+	Id         uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	Commitment string     `gorm:"column:commitment;type:char(64);comment:种子的sha256，生成种子时公布;NOT NULL" json:"commitment"`
+	Seed       string     `gorm:"column:seed;type:char(64);comment:种子，轮换之后公开;NOT NULL" json:"-"`
+	SysStatus  uint       `gorm:"column:sys_status;type:smallint(5) unsigned;default:1;comment:状态，1 使用中，2 已公开;NOT NULL" json:"sys_status"`
+	SysCreated *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
+	SysUpdated *time.Time `gorm:"autoUpdateTime;column:sys_updated;type:datetime;default null;comment:更新时间，公开之后为公开时间;NOT NULL" json:"sys_updated"`
+}
+
+func (d *DrawSeed) TableName() string {
+	return "t_draw_seed"
+}
+
+// DrawAudit 抽奖审计表，每一次抽奖请求都会记录，包括没有中奖和被拒绝的请求
+type DrawAudit struct {
+	Id            uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	ActivityId    uint       `gorm:"column:activity_id;type:int(10) unsigned;default:0;comment:活动ID;NOT NULL" json:"activity_id"`
+	UserId        uint       `gorm:"column:user_id;type:int(10) unsigned;default:0;comment:用户ID;NOT NULL" json:"user_id"`
+	RequestId     string     `gorm:"column:request_id;type:varchar(64);comment:抽奖请求ID，参与抽奖编码的计算;NOT NULL" json:"request_id"`
+	Api           string     `gorm:"column:api;type:varchar(10);comment:抽奖接口版本;NOT NULL" json:"api"`
+	SeedId        uint       `gorm:"column:seed_id;type:int(10) unsigned;default:0;comment:抽奖种子ID，0表示在抽奖之前被拒绝;NOT NULL" json:"seed_id"`
+	Nonce         int64      `gorm:"column:nonce;type:bigint(20);default:0;comment:种子下的抽奖序号;NOT NULL" json:"nonce"`
+	CodeSpace     int64      `gorm:"column:code_space;type:bigint(20);default:0;comment:抽奖编码空间;NOT NULL" json:"code_space"`
+	PrizeCode     int64      `gorm:"column:prize_code;type:bigint(20);default:0;comment:抽奖编码;NOT NULL" json:"prize_code"`
+	EngineVersion string     `gorm:"column:engine_version;type:varchar(32);comment:抽奖时奖品列表的版本;NOT NULL" json:"engine_version"`
+	PrizeId       uint       `gorm:"column:prize_id;type:int(10) unsigned;default:0;comment:抽中的奖品ID，0表示没有抽中;NOT NULL" json:"prize_id"`
+	ResultCode    int        `gorm:"column:result_code;type:int(10);default:0;comment:抽奖接口的返回码;NOT NULL" json:"result_code"`
+	PityLosses    int64      `gorm:"column:pity_losses;type:bigint(20);default:0;comment:抽奖之前连续没有中奖的次数;NOT NULL" json:"pity_losses"`
+	PityMode      string     `gorm:"column:pity_mode;type:varchar(16);comment:保底规则对本次抽奖的调整，空表示没有调整;NOT NULL" json:"pity_mode"`
+	RiskVerdict   string     `gorm:"column:risk_verdict;type:varchar(16);comment:风控规则链的处理结果，空表示没有配置规则;NOT NULL" json:"risk_verdict"`
+	RiskRule      string     `gorm:"column:risk_rule;type:varchar(64);comment:命中的风控规则;NOT NULL" json:"risk_rule"`
+	SysIp         string     `gorm:"column:sys_ip;type:varchar(50);comment:用户抽奖的IP;NOT NULL" json:"sys_ip"`
+	BodyIp        string     `gorm:"column:body_ip;type:varchar(50);comment:请求中填写的和服务端获取的不一致的IP，空表示一致或者没有填写;NOT NULL" json:"body_ip"`
+	SysCreated    *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
+}
+
+func (d *DrawAudit) TableName() string {
+	return "t_draw_audit"
+}
+
+// CouponReconcile 优惠券缓存对账记录，记录每次对账发现的差异和修正
+type CouponReconcile struct {
+	Id           uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	PrizeId      uint       `gorm:"column:prize_id;type:int(10) unsigned;default:0;comment:奖品ID;NOT NULL" json:"prize_id"`
+	Source       string     `gorm:"column:source;type:varchar(32);comment:触发方式，task定时任务，admin后台;NOT NULL" json:"source"`
+	DryRun       bool       `gorm:"column:dry_run;type:tinyint(1);default:0;comment:是否只对比不修正;NOT NULL" json:"dry_run"`
+	DbNum        int64      `gorm:"column:db_num;type:int(10);default:0;comment:数据库中可以发放的优惠券数量;NOT NULL" json:"db_num"`
+	CacheNum     int64      `gorm:"column:cache_num;type:int(10);default:0;comment:对账前缓存中的优惠券数量;NOT NULL" json:"cache_num"`
+	AddedNum     int64      `gorm:"column:added_num;type:int(10);default:0;comment:缓存中缺少的数量;NOT NULL" json:"added_num"`
+	RemovedNum   int64      `gorm:"column:removed_num;type:int(10);default:0;comment:缓存中多出的数量;NOT NULL" json:"removed_num"`
+	AddedCodes   string     `gorm:"column:added_codes;type:text;comment:加入缓存的编码，最多保存100个，json数组" json:"added_codes"`
+	RemovedCodes string     `gorm:"column:removed_codes;type:text;comment:移出缓存的编码，最多保存100个，json数组" json:"removed_codes"`
+	ErrMsg       string     `gorm:"column:err_msg;type:varchar(255);comment:修正失败的原因;NOT NULL" json:"err_msg"`
+	SysCreated   *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
+}
+
+func (c *CouponReconcile) TableName() string {
+	return "t_coupon_reconcile"
+}
+
 // BlackUser 用户黑明单表
 type BlackUser struct {
 	Id         uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
@@ -106,19 +280,38 @@ type BlackUser struct {
 	SysCreated *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
 	SysUpdated *time.Time `gorm:"autoUpdateTime;column:sys_updated;type:datetime;default null;comment:修改时间;NOT NULL" json:"sys_updated"`
 	SysIp      string     `gorm:"column:sys_ip;type:varchar(50);comment:IP地址;NOT NULL" json:"sys_ip"`
+	Reason     string     `gorm:"column:reason;type:varchar(255);comment:最近一次拉黑或者洗白的原因;NOT NULL" json:"reason"`
+	Operator   string     `gorm:"column:operator;type:varchar(50);comment:最近一次拉黑或者洗白的管理员，空表示系统自动拉黑;NOT NULL" json:"operator"`
 }
 
 func (m *BlackUser) TableName() string {
 	return "t_black_user"
 }
 
+// BlackUserLog 后台拉黑和洗白用户的操作记录
+type BlackUserLog struct {
+	Id         uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	UserId     uint       `gorm:"column:user_id;type:int(10) unsigned;default:0;comment:被操作的用户ID;NOT NULL" json:"user_id"`
+	Action     string     `gorm:"column:action;type:varchar(16);comment:操作，ban拉黑，lift洗白;NOT NULL" json:"action"`
+	BlackTime  time.Time  `gorm:"column:black_time;type:datetime;default:1000-01-01 00:00:00;comment:操作之后的黑名单到期时间;NOT NULL" json:"black_time"`
+	Reason     string     `gorm:"column:reason;type:varchar(255);comment:原因;NOT NULL" json:"reason"`
+	Operator   string     `gorm:"column:operator;type:varchar(50);comment:操作的管理员;NOT NULL" json:"operator"`
+	SysCreated *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
+}
+
+func (m *BlackUserLog) TableName() string {
+	return "t_black_user_log"
+}
+
 // BlackIp ip黑明单表
 type BlackIp struct {
 	Id         uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
-	Ip         string     `gorm:"column:ip;type:varchar(50);comment:IP地址;NOT NULL" json:"ip"`
+	Ip         string     `gorm:"column:ip;type:varchar(50);comment:IP地址或者CIDR网段，支持IPv4和IPv6;NOT NULL" json:"ip"`
 	BlackTime  time.Time  `gorm:"column:black_time;type:datetime;default:1000-01-01 00:00:00;comment:黑名单限制到期时间;NOT NULL" json:"black_time"`
 	SysCreated *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
 	SysUpdated *time.Time `gorm:"autoUpdateTime;column:sys_updated;type:datetime;default null;comment:修改时间;NOT NULL" json:"sys_updated"`
+	Reason     string     `gorm:"column:reason;type:varchar(255);comment:最近一次拉黑的原因;NOT NULL" json:"reason"`
+	Operator   string     `gorm:"column:operator;type:varchar(50);comment:最近一次拉黑的管理员，空表示系统自动拉黑;NOT NULL" json:"operator"`
 }
 
 func (m *BlackIp) TableName() string {
@@ -128,6 +321,7 @@ func (m *BlackIp) TableName() string {
 // LotteryTimes 用户每日抽奖次数表
 type LotteryTimes struct {
 	Id         uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	ActivityId uint       `gorm:"column:activity_id;type:int(10) unsigned;default:1;comment:活动ID，关联t_activity表;NOT NULL" json:"activity_id"`
 	UserId     uint       `gorm:"column:user_id;type:int(10) unsigned;default:0;comment:用户ID;NOT NULL" json:"user_id"`
 	Day        uint       `gorm:"column:day;type:int(10) unsigned;default:0;comment:日期，如：20220625;NOT NULL" json:"day"`
 	Num        uint       `gorm:"column:num;type:int(10) unsigned;default:0;comment:次数;NOT NULL" json:"num"`
@@ -139,6 +333,33 @@ func (l *LotteryTimes) TableName() string {
 	return "t_lottery_times"
 }
 
+// Activity 抽奖活动表，奖品、抽奖次数限制、黑名单策略都按照活动区分
+type Activity struct {
+	Id                 uint       `gorm:"column:id;type:int(10) unsigned;primary_key;AUTO_INCREMENT" json:"id"`
+	Title              string     `gorm:"column:title;type:varchar(255);comment:活动名称;NOT NULL" json:"title"`
+	Description        string     `gorm:"column:description;type:varchar(1024);comment:活动描述;NOT NULL" json:"description"`
+	BeginTime          time.Time  `gorm:"column:begin_time;type:datetime;default:1000-01-01 00:00:00;comment:活动开始时间;NOT NULL" json:"begin_time"`
+	EndTime            time.Time  `gorm:"column:end_time;type:datetime;default:1000-01-01 00:00:00;comment:活动结束时间;NOT NULL" json:"end_time"`
+	UserDayLimit       int        `gorm:"column:user_day_limit;type:int(11);default:0;comment:每个用户每天最多抽奖次数，0 使用配置;NOT NULL" json:"user_day_limit"`
+	IpDayLimit         int        `gorm:"column:ip_day_limit;type:int(11);default:0;comment:同一个IP每天最多抽奖次数，0 使用配置;NOT NULL" json:"ip_day_limit"`
+	BlackTime          int        `gorm:"column:black_time;type:int(11);default:0;comment:中实物大奖之后拉黑的时间，单位秒，0 使用配置;NOT NULL" json:"black_time"`
+	BlackPolicy        uint       `gorm:"column:black_policy;type:smallint(5) unsigned;default:0;comment:黑名单策略，0 校验并且中大奖拉黑，1 只校验，2 不校验;NOT NULL" json:"black_policy"`
+	DrawCost           int64      `gorm:"column:draw_cost;type:bigint(20);default:0;comment:每次抽奖扣除的虚拟币，0 免费;NOT NULL" json:"draw_cost"`
+	BundleGuarantee    uint       `gorm:"column:bundle_guarantee;type:smallint(5) unsigned;default:0;comment:连抽保底，0 不保底，1 十连抽至少中奖一次;NOT NULL" json:"bundle_guarantee"`
+	PityBoostAfter     int        `gorm:"column:pity_boost_after;type:int(11);default:0;comment:连续没有中奖的次数达到之后开始提高中奖权重，0 不提高;NOT NULL" json:"pity_boost_after"`
+	PityBoostStep      int        `gorm:"column:pity_boost_step;type:int(11);default:0;comment:超过之后每多一次没有中奖，中奖权重提高的百分比;NOT NULL" json:"pity_boost_step"`
+	PityGuaranteeAfter int        `gorm:"column:pity_guarantee_after;type:int(11);default:0;comment:连续没有中奖的次数达到之后下一次必中，0 不保底;NOT NULL" json:"pity_guarantee_after"`
+	PityPrizeId        uint       `gorm:"column:pity_prize_id;type:int(10) unsigned;default:0;comment:保底的奖品ID，0 有库存的任意奖品;NOT NULL" json:"pity_prize_id"`
+	RiskRules          string     `gorm:"column:risk_rules;type:varchar(2048);comment:风控规则链，json数组，空表示不启用;NOT NULL" json:"risk_rules"`
+	SysStatus          uint       `gorm:"column:sys_status;type:smallint(5) unsigned;default:1;comment:状态，1 正常，2 关闭;NOT NULL" json:"sys_status"`
+	SysCreated         *time.Time `gorm:"autoCreateTime;column:sys_created;type:datetime;default null;comment:创建时间;NOT NULL" json:"sys_created"`
+	SysUpdated         *time.Time `gorm:"autoUpdateTime;column:sys_updated;type:datetime;default null;comment:修改时间;NOT NULL" json:"sys_updated"`
+}
+
+func (a *Activity) TableName() string {
+	return "t_activity"
+}
+
 type Teacher struct {
 	Id          int        `gorm:"primaryKey;autoIncrement;comment:主键id"` //所谓蛇形复数
 	Tno         int        `gorm:"default:0"`
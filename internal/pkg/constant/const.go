@@ -1,7 +1,5 @@
 package constant
 
-import "time"
-
 const ReqID = "req_id"
 
 // 时间标准化
@@ -16,11 +14,70 @@ const (
 	PrizeStatusDelete = 2 // 删除
 )
 
+// 优惠券状态
+const (
+	CouponStatusNormal  = 1 // 正常，可以发放
+	CouponStatusIssued  = 2 // 已发放
+	CouponStatusVoid    = 3 // 已作废，后台作废，可以恢复
+	CouponStatusExpired = 4 // 已过期，超过有效期还没有发放
+	CouponStatusRevoked = 5 // 已收回，中奖记录被判定为作弊时收回，编码已经泄露，不能恢复
+)
+
+// 中奖记录状态
+const (
+	ResultStatusNormal = 0 // 正常
+	ResultStatusDelete = 1 // 删除
+	ResultStatusCheat  = 2 // 作弊，判定之后不能再修改
+)
+
+// 发货单状态，待发货可以发货或者取消，已发货可以签收或者取消
+const (
+	FulfilmentStatusPending   = 1 // 待发货，用户可以修改收货地址
+	FulfilmentStatusShipped   = 2 // 已发货
+	FulfilmentStatusDelivered = 3 // 已签收
+	FulfilmentStatusCancelled = 4 // 已取消
+)
+
+// 虚拟币账本的账户，用户账户之外的都是系统账户
+const (
+	WalletAccountUser         = "user"
+	WalletAccountSystemPrize  = "system_prize"  // 中奖发放和作弊收回
+	WalletAccountSystemAdjust = "system_adjust" // 后台调整
+	WalletAccountSystemDraw   = "system_draw"   // 付费抽奖扣费
+)
+
+// 虚拟币账本的业务类型
+const (
+	WalletBizPrize       = "prize"        // 中奖发放，交易号 prize_{中奖记录ID}
+	WalletBizPrizeRevoke = "prize_revoke" // 作弊收回，交易号 prize_revoke_{中奖记录ID}
+	WalletBizAdjust      = "adjust"       // 后台调整，交易号 adjust_{请求ID}
+	WalletBizDraw        = "draw"         // 付费抽奖扣费，交易号 draw_{用户ID}_{抽奖请求ID}
+)
+
+// 抽奖种子状态
+const (
+	DrawSeedStatusActive   = 1 // 使用中，只公布承诺值
+	DrawSeedStatusRevealed = 2 // 已公开
+)
+
+// 活动状态
+const (
+	ActivityStatusNormal = 1 // 正常
+	ActivityStatusClosed = 2 // 关闭
+)
+
+const (
+	Issuer  = "lottery"
+	Expires = 3600
+)
+
+// 登录token，签名密钥和有效期在配置文件的jwt中配置
 const (
-	Issuer              = "lottery"
-	Expires             = 3600
-	SecretKey           = "lottery-single"
-	TokenExpireDuration = time.Hour * 2
+	TokenTypeAccess     = "access"       // 访问token，用于接口鉴权
+	TokenTypeRefresh    = "refresh"      // 刷新token，只能用于换取新的token
+	JWTUserKey          = "jwtUser"      // 鉴权通过之后，token中的用户信息保存在gin.Context中的key
+	JwtRevokedKeyPrefix = "jwt_revoked_" // jwt_revoked_{jti}，已经注销的token，保存到token过期
+	JwtRefreshKeyPrefix = "jwt_refresh_" // jwt_refresh_{jti}，有效的刷新token，使用一次之后删除
 )
 
 const (
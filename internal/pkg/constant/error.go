@@ -12,34 +12,56 @@ var (
 type ErrCode int // 错误码
 
 const (
-	Success             ErrCode = 0
-	ErrInternalServer   ErrCode = 500
-	ErrInputInvalid     ErrCode = 8020
-	ErrShouldBind       ErrCode = 8021
-	ErrJsonMarshal      ErrCode = 8022
-	ErrJwtParse         ErrCode = 8023
-	ErrRegister         ErrCode = 1001
-	ErrLogin            ErrCode = 10000
-	ErrIPLimitInvalid   ErrCode = 10001
-	ErrUserLimitInvalid ErrCode = 10002
-	ErrBlackedIP        ErrCode = 10003
-	ErrBlackedUser      ErrCode = 10004
-	ErrPrizeNotEnough   ErrCode = 10005
-	ErrNotWon           ErrCode = 100010
+	Success              ErrCode = 0
+	ErrInternalServer    ErrCode = 500
+	ErrInputInvalid      ErrCode = 8020
+	ErrShouldBind        ErrCode = 8021
+	ErrJsonMarshal       ErrCode = 8022
+	ErrJwtParse          ErrCode = 8023
+	ErrUnauthorized      ErrCode = 8024
+	ErrTokenExpired      ErrCode = 8025
+	ErrForbidden         ErrCode = 8026
+	ErrRegister          ErrCode = 1001
+	ErrLogin             ErrCode = 10000
+	ErrIPLimitInvalid    ErrCode = 10001
+	ErrUserLimitInvalid  ErrCode = 10002
+	ErrBlackedIP         ErrCode = 10003
+	ErrBlackedUser       ErrCode = 10004
+	ErrPrizeNotEnough    ErrCode = 10005
+	ErrPrizeProbability  ErrCode = 10006
+	ErrActivityInvalid   ErrCode = 10007
+	ErrBalanceNotEnough  ErrCode = 10008
+	ErrPaidDrawInvalid   ErrCode = 10009
+	ErrRiskDenied        ErrCode = 10010
+	ErrRiskDrawInvalid   ErrCode = 10011
+	ErrIPMismatch        ErrCode = 10012
+	ErrWinCapDrawInvalid ErrCode = 10013
+	ErrNotWon            ErrCode = 100010
 )
 
 var errMsgDic = map[ErrCode]string{
-	Success:             "ok",
-	ErrInternalServer:   "internal server error",
-	ErrInputInvalid:     "input invalid",
-	ErrShouldBind:       "should bind failed",
-	ErrJwtParse:         "json marshal failed",
-	ErrLogin:            "login fail",
-	ErrIPLimitInvalid:   "ip day num limited",
-	ErrUserLimitInvalid: "user day num limited",
-	ErrBlackedIP:        "blacked ip",
-	ErrBlackedUser:      "blacked user",
-	ErrPrizeNotEnough:   "prize not enough",
+	Success:              "ok",
+	ErrInternalServer:    "internal server error",
+	ErrInputInvalid:      "input invalid",
+	ErrShouldBind:        "should bind failed",
+	ErrJwtParse:          "json marshal failed",
+	ErrUnauthorized:      "token missing or invalid",
+	ErrTokenExpired:      "token expired",
+	ErrForbidden:         "permission denied",
+	ErrLogin:             "login fail",
+	ErrIPLimitInvalid:    "ip day num limited",
+	ErrUserLimitInvalid:  "user day num limited",
+	ErrBlackedIP:         "blacked ip",
+	ErrBlackedUser:       "blacked user",
+	ErrPrizeNotEnough:    "prize not enough",
+	ErrPrizeProbability:  "prize probability invalid or total exceeds 100%",
+	ErrActivityInvalid:   "activity not exists or not in progress",
+	ErrBalanceNotEnough:  "balance not enough to draw",
+	ErrPaidDrawInvalid:   "paid activity only supports v3 draw",
+	ErrRiskDenied:        "denied by risk control",
+	ErrRiskDrawInvalid:   "activity with risk rules only supports v3 draw",
+	ErrIPMismatch:        "request ip mismatch",
+	ErrWinCapDrawInvalid: "activity with win caps only supports v3 draw",
 	//ErrNotWon:           "not won,please try again!",
 	ErrNotWon: "sorry you didn't win the prize",
 }
@@ -1,20 +1,5 @@
 package constant
 
-const (
-	UserPrizeMax = 3000   // 用户每天最多抽奖次数
-	IpPrizeMax   = 30000  // 同一个IP每天最多抽奖次数
-	IpLimitMax   = 300000 // 同一个IP每天最多抽奖次数
-)
-
-const (
-	IpFrameSize   = 2
-	UserFrameSize = 2
-)
-
-const (
-	PrizeCodeMax = 10000
-)
-
 const (
 	PrizeTypeVirtualCoin  = 0 // 虚拟币
 	PrizeTypeCouponSame   = 1 // 虚拟券，相同的码
@@ -25,16 +10,158 @@ const (
 )
 
 const (
-	DefaultBlackTime    = 7 * 86400  // 默认1周
 	AllPrizeCacheTime   = 30 * 86400 // 默认1周
 	CouponDiffLockLimit = 10000000
 )
 
+// 以下缓存key除了黑名单和优惠券之外，都按照活动ID区分，格式为 前缀+活动ID
 const (
-	AllPrizeCacheKey        = "all_prize"
+	AllPrizeCacheKeyPrefix  = "all_prize_"
 	UserCacheKeyPrefix      = "black_user_info_"
 	IpCacheKeyPrefix        = "black_ip_info_"
-	UserLotteryDayNumPrefix = "user_lottery_day_num_"
-	PrizePoolCacheKey       = "prize_pool"
+	UserLotteryDayNumPrefix = "user_lottery_day_num_" // user_lottery_day_num_{活动ID}_{分段}
+	IpLotteryDayNumPrefix   = "day_ip_num_"           // day_ip_num_{活动ID}_{分段}
+	PrizePoolCacheKeyPrefix = "prize_pool_"
 	PrizeCouponCacheKey     = "prize_coupon_"
+	ActivityCacheKeyPrefix  = "activity_info_"
+)
+
+const (
+	LotteryRequestKeyPrefix = "lottery_request_" // lottery_request_{用户ID}_{请求ID}，记录抽奖请求的处理结果
+	LotteryRequestCacheTime = 86400              // 抽奖请求处理结果的保存时间
+	LotteryRequestIDMaxLen  = 64
+	LotteryBundleSize       = 10 // 连抽接口一次最多抽奖的次数，抽满时才有保底
+	CodeRangeCacheMax       = 64 // 旧版中奖编码折算权重的缓存最多保存的版本数
+)
+
+const (
+	BlackIpRangeRefreshTime = 30 // 内存中的IP黑名单网段刷新间隔，单位秒
+)
+
+const (
+	PityLossKeyPrefix = "pity_loss_" // pity_loss_{活动ID}，hash中保存每个用户连续没有中奖的次数
+)
+
+const (
+	WinCapCacheKeyPrefix = "win_cap_"       // win_cap_{活动ID}，活动的中奖次数上限规则
+	WinCapCountKeyPrefix = "win_cap_count_" // win_cap_count_{规则ID}_{周期}，hash中保存每个用户或者IP的中奖次数
+	WinCapCacheTime      = 86400            // 规则的缓存时间，修改规则时清空
+	WinCapMaxRules       = 20               // 每个活动最多的规则数量
+)
+
+const (
+	CouponCodeMaxLen            = 255                     // 优惠券编码最大长度，和t_coupon.code一致
+	PrizeProfileMaxLen          = 1024                    // 奖品扩展数据最大长度，和t_prize.prize_profile一致
+	CouponRulesMaxLen           = 500                     // 相同编码优惠券使用规则说明的最大长度
+	CouponImportMaxFileSize     = 10 << 20                // 导入文件最大10M
+	CouponImportMaxRows         = 100000                  // 导入文件最多行数
+	CouponImportBatchSize       = 500                     // 每批写入数据库的优惠券数量
+	CouponImportErrorPreview    = 100                     // 导入结果中直接返回的错误行数，完整的错误在报告中
+	CouponImportReportKeyPrefix = "coupon_import_report_" // coupon_import_report_{报告ID}，导入错误报告
+	CouponImportReportCacheTime = 86400                   // 导入错误报告的保存时间
+	CouponBatchNoMaxLen         = 64                      // 导入批次号最大长度
+	CouponStatusMaxCodes        = 1000                    // 按编码作废或者恢复时，一次最多的编码数量
+	CouponExpireBatchSize       = 1000                    // 过期任务每批处理的优惠券数量
+	CouponCacheBatchSize        = 1000                    // 每批加入或者移出缓存的优惠券数量
+	CouponReconcileSampleSize   = 100                     // 对账记录中最多保存的编码数量
+	CouponReconcileListMax      = 100                     // 对账记录每页最多条数
+)
+
+// 优惠券缓存对账的触发方式
+const (
+	CouponReconcileSourceTask  = "task"
+	CouponReconcileSourceAdmin = "admin"
+)
+
+const (
+	ResultListDefaultLimit = 20  // 中奖记录默认每页条数
+	ResultListMaxLimit     = 100 // 中奖记录每页最多条数
+)
+
+const (
+	FulfilmentListDefaultLimit = 20    // 发货单默认每页条数
+	FulfilmentListMaxLimit     = 100   // 发货单每页最多条数
+	FulfilmentExportMaxRows    = 10000 // 一次最多导出的发货单数量
+)
+
+const (
+	WalletLedgerDefaultLimit = 20  // 账本默认每页条数
+	WalletLedgerMaxLimit     = 100 // 账本每页最多条数
+	WalletReasonMaxLen       = 255 // 后台调整原因的最大长度
+)
+
+// 后台拉黑用户和IP的预设时长
+const (
+	BlackPresetWeek  = "week"
+	BlackPresetMonth = "month"
+	BlackPresetYear  = "year"
+)
+
+const (
+	BlackUserMaxSeconds       = 10 * 365 * 86400 // 自定义拉黑时长最长10年
+	BlackUserReasonMaxLen     = 255              // 拉黑和洗白原因的最大长度
+	BlackUserListDefaultLimit = 20               // 用户黑名单默认每页条数
+	BlackUserListMaxLimit     = 100              // 用户黑名单每页最多条数
+	BlackUserActionBan        = "ban"            // 操作记录：拉黑
+	BlackUserActionLift       = "lift"           // 操作记录：洗白
+	BlackUserStatusActive     = "active"         // 黑名单查询：有效期内
+	BlackUserStatusExpired    = "expired"        // 黑名单查询：已经过期或者洗白
+)
+
+const (
+	BlackIpMaxSeconds         = 10 * 365 * 86400 // IP自定义拉黑时长最长10年
+	BlackIpReasonMaxLen       = 255              // IP拉黑原因的最大长度
+	BlackIpImportMaxFileSize  = 2 << 20          // 导入IP黑名单文件最大2M
+	BlackIpImportMaxRows      = 10000            // 导入IP黑名单最多行数
+	BlackIpImportErrorPreview = 100              // 导入结果中返回的错误行数
+)
+
+const (
+	DrawNonceKeyPrefix = "draw_nonce_" // draw_nonce_{种子ID}，种子下的抽奖序号
+	DrawAuditListMax   = 100           // 审计记录每页最多条数
+)
+
+const (
+	DefaultActivityID = 1          // 默认活动，兼容没有传活动ID的请求
+	ActivityCacheTime = 30 * 86400 // 活动信息缓存时间
+)
+
+// 活动的黑名单策略
+const (
+	BlackPolicyCheckAndBan = 0 // 校验黑名单，中实物大奖之后拉黑用户和IP
+	BlackPolicyCheckOnly   = 1 // 只校验黑名单
+	BlackPolicyNone        = 2 // 不校验黑名单
+)
+
+// 活动的连抽保底
+const (
+	BundleGuaranteeOff = 0 // 不保底
+	BundleGuaranteeOn  = 1 // 完整的连抽中没有中奖时，最后一次只从有库存的奖品中抽取
+)
+
+// 中奖次数上限规则的计数对象
+const (
+	WinCapScopeUser = 1
+	WinCapScopeIp   = 2
+)
+
+// 中奖次数上限规则限制的奖品种类
+const (
+	WinCapKindAll     = 0
+	WinCapKindEntity  = 1 // 实物奖品
+	WinCapKindVirtual = 2 // 虚拟币和优惠券
+)
+
+// 中奖次数上限规则的计数周期
+const (
+	WinCapPeriodDay      = 1
+	WinCapPeriodActivity = 2
+)
+
+// 保底规则对一次抽奖的调整，记录在抽奖审计中
+const (
+	PityModeNone      = ""          // 按照正常的概率抽奖
+	PityModeBoost     = "boost"     // 连续没有中奖，提高有库存奖品的中奖权重
+	PityModeGuarantee = "guarantee" // 连续没有中奖，本次必中
+	PityModeBundle    = "bundle"    // 连抽保底，本次必中
 )
@@ -0,0 +1,65 @@
+package constant
+
+// 管理后台角色
+const (
+	RoleSuperAdmin = "super_admin" // 超级管理员，拥有所有权限
+	RoleOperator   = "operator"    // 运营，可以管理奖品、优惠券、活动和黑名单
+	RoleAuditor    = "auditor"     // 审计，只能查看
+)
+
+// 管理后台权限
+const (
+	PermPrizeView      = "prize:view"
+	PermPrizeEdit      = "prize:edit"
+	PermCouponView     = "coupon:view"
+	PermCouponEdit     = "coupon:edit"
+	PermActivityView   = "activity:view"
+	PermActivityEdit   = "activity:edit"
+	PermBlackListView  = "blacklist:view"
+	PermBlackListEdit  = "blacklist:edit"
+	PermUserManage     = "user:manage"
+	PermResultView     = "result:view"
+	PermResultEdit     = "result:edit"     // 删除中奖记录、判定作弊
+	PermDrawAuditView  = "draw_audit:view" // 查看和校验抽奖审计记录
+	PermDrawSeedEdit   = "draw_seed:edit"  // 轮换抽奖种子
+	PermFulfilmentView = "fulfilment:view" // 查看和导出发货单
+	PermFulfilmentEdit = "fulfilment:edit" // 发货、签收和取消发货单
+	PermWalletView     = "wallet:view"     // 查看用户的虚拟币余额和账本
+	PermWalletEdit     = "wallet:edit"     // 后台调整用户的虚拟币余额
+)
+
+// RolePermissions 每个角色拥有的权限
+var RolePermissions = map[string][]string{
+	RoleSuperAdmin: {
+		PermPrizeView, PermPrizeEdit, PermCouponView, PermCouponEdit, PermActivityView, PermActivityEdit,
+		PermBlackListView, PermBlackListEdit, PermUserManage, PermResultView, PermResultEdit, PermDrawAuditView, PermDrawSeedEdit,
+		PermFulfilmentView, PermFulfilmentEdit, PermWalletView, PermWalletEdit,
+	},
+	RoleOperator: {
+		PermPrizeView, PermPrizeEdit, PermCouponView, PermCouponEdit, PermActivityView, PermActivityEdit,
+		PermBlackListView, PermBlackListEdit, PermResultView, PermResultEdit, PermDrawAuditView,
+		PermFulfilmentView, PermFulfilmentEdit, PermWalletView,
+	},
+	RoleAuditor: {
+		PermPrizeView, PermCouponView, PermActivityView, PermBlackListView, PermResultView, PermDrawAuditView,
+		PermFulfilmentView, PermWalletView,
+	},
+}
+
+// HasPermission 判断角色列表中是否有角色拥有该权限
+func HasPermission(roles []string, perm string) bool {
+	for _, role := range roles {
+		for _, p := range RolePermissions[role] {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsValidRole 是否是已定义的角色
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
@@ -0,0 +1,19 @@
+package constant
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasPermission(t *testing.T) {
+	assert.True(t, HasPermission([]string{RoleSuperAdmin}, PermUserManage))
+	assert.False(t, HasPermission([]string{RoleOperator}, PermUserManage))
+	assert.True(t, HasPermission([]string{RoleOperator}, PermPrizeEdit))
+	assert.False(t, HasPermission([]string{RoleAuditor}, PermPrizeEdit))
+	assert.True(t, HasPermission([]string{RoleAuditor, RoleOperator}, PermCouponEdit))
+	assert.False(t, HasPermission(nil, PermPrizeView))
+	assert.False(t, HasPermission([]string{"unknown"}, PermPrizeView))
+	assert.True(t, HasPermission([]string{RoleOperator}, PermFulfilmentEdit))
+	assert.False(t, HasPermission([]string{RoleAuditor}, PermFulfilmentEdit))
+}
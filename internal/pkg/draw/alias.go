@@ -0,0 +1,89 @@
+package draw
+
+// aliasEngine 基于Vose别名算法的抽奖引擎
+// 每个奖品占一列，最后一列是"不中奖"，权重为 WeightTotal 减去所有奖品的权重。
+// 抽奖编码 code 在 [0, 列数*WeightTotal) 中取值，code/WeightTotal 为列号，
+// code%WeightTotal 与该列的阈值比较，小于阈值取本列，否则取该列的别名列。
+// 全部使用整数运算，同一个编码在同一个版本下总是得到同一个结果，方便审计复现。
+type aliasEngine struct {
+	ids       []uint  // 列号对应的奖品ID，最后一列不中奖
+	threshold []int64 // 每一列取本列的阈值
+	alias     []int   // 每一列的别名列
+	version   string
+}
+
+// NewAliasEngine 构建别名表抽奖引擎
+func NewAliasEngine(items []Item) (Engine, error) {
+	if err := Validate(items); err != nil {
+		return nil, err
+	}
+	n := len(items) + 1
+	ids := make([]uint, n)
+	scaled := make([]int64, n)
+	var total int64
+	for i, item := range items {
+		ids[i] = item.ID
+		scaled[i] = item.Weight * int64(n)
+		total += item.Weight
+	}
+	scaled[n-1] = (WeightTotal - total) * int64(n)
+
+	e := &aliasEngine{
+		ids:       ids,
+		threshold: make([]int64, n),
+		alias:     make([]int, n),
+		version:   Version(items),
+	}
+	small := make([]int, 0, n)
+	large := make([]int, 0, n)
+	for i, w := range scaled {
+		if w < WeightTotal {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+	for len(small) > 0 && len(large) > 0 {
+		s := small[len(small)-1]
+		small = small[:len(small)-1]
+		l := large[len(large)-1]
+		large = large[:len(large)-1]
+
+		e.threshold[s] = scaled[s]
+		e.alias[s] = l
+		scaled[l] = scaled[l] + scaled[s] - WeightTotal
+		if scaled[l] < WeightTotal {
+			small = append(small, l)
+		} else {
+			large = append(large, l)
+		}
+	}
+	// 整数运算下剩余的列权重恰好为 WeightTotal
+	for _, i := range append(small, large...) {
+		e.threshold[i] = WeightTotal
+		e.alias[i] = i
+	}
+	return e, nil
+}
+
+func (e *aliasEngine) Space() int64 {
+	return int64(len(e.ids)) * WeightTotal
+}
+
+func (e *aliasEngine) Pick(code int64) (uint, bool) {
+	if code < 0 || code >= e.Space() {
+		return 0, false
+	}
+	col := int(code / WeightTotal)
+	if code%WeightTotal >= e.threshold[col] {
+		col = e.alias[col]
+	}
+	if col == len(e.ids)-1 {
+		return 0, false
+	}
+	return e.ids[col], true
+}
+
+func (e *aliasEngine) Version() string {
+	return e.version
+}
@@ -0,0 +1,136 @@
+package draw
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countCodes 统计每个奖品在整个编码空间中占有的编码数量
+func countCodes(e *aliasEngine) map[uint]int64 {
+	counts := make(map[uint]int64)
+	last := len(e.ids) - 1
+	for col := range e.ids {
+		if col != last {
+			counts[e.ids[col]] += e.threshold[col]
+		}
+		if a := e.alias[col]; a != last {
+			counts[e.ids[a]] += WeightTotal - e.threshold[col]
+		}
+	}
+	return counts
+}
+
+func TestAliasEngineExact(t *testing.T) {
+	items := []Item{
+		{ID: 1, Weight: 1},
+		{ID: 2, Weight: 2500000},
+		{ID: 3, Weight: 333333},
+		{ID: 4, Weight: 0},
+	}
+	engine, err := NewAliasEngine(items)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(items)+1)*WeightTotal, engine.Space())
+
+	// 每个奖品占有的编码数量 = 权重 * 列数，中奖概率严格等于权重
+	counts := countCodes(engine.(*aliasEngine))
+	n := int64(len(items) + 1)
+	for _, item := range items {
+		assert.Equal(t, item.Weight*n, counts[item.ID], "item %d", item.ID)
+	}
+}
+
+func TestAliasEnginePick(t *testing.T) {
+	engine, err := NewAliasEngine([]Item{{ID: 7, Weight: WeightTotal}})
+	assert.NoError(t, err)
+	for _, code := range []int64{0, 1, WeightTotal - 1, WeightTotal, engine.Space() - 1} {
+		id, ok := engine.Pick(code)
+		assert.True(t, ok)
+		assert.Equal(t, uint(7), id)
+	}
+	_, ok := engine.Pick(engine.Space())
+	assert.False(t, ok)
+	_, ok = engine.Pick(-1)
+	assert.False(t, ok)
+
+	empty, err := NewAliasEngine(nil)
+	assert.NoError(t, err)
+	_, ok = empty.Pick(0)
+	assert.False(t, ok)
+}
+
+func TestValidate(t *testing.T) {
+	err := Validate([]Item{{ID: 1, Weight: WeightTotal / 2}, {ID: 2, Weight: WeightTotal/2 + 1}})
+	assert.True(t, errors.Is(err, ErrWeightOverflow))
+	err = Validate([]Item{{ID: 1, Weight: -1}})
+	assert.True(t, errors.Is(err, ErrWeightInvalid))
+	err = Validate([]Item{{ID: 1, Weight: 1}, {ID: 1, Weight: 1}})
+	assert.True(t, errors.Is(err, ErrDuplicateItem))
+}
+
+func TestProbToWeight(t *testing.T) {
+	w, err := ProbToWeight(0.0000001)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), w)
+	w, err = ProbToWeight(0.25)
+	assert.NoError(t, err)
+	assert.Equal(t, WeightTotal/4, w)
+	_, err = ProbToWeight(0.00000001)
+	assert.Error(t, err)
+	_, err = ProbToWeight(1.5)
+	assert.Error(t, err)
+}
+
+func TestMigrateCodeRanges(t *testing.T) {
+	// 1、2 两个区间重叠，重叠部分属于排在前面的奖品，9000-9999 没有覆盖表示不中奖
+	ranges := []CodeRange{
+		{ID: 1, Low: 0, High: 4999},
+		{ID: 2, Low: 4000, High: 8999},
+		{ID: 3, Low: 100, High: 200},
+	}
+	weights := MigrateCodeRanges(ranges, 10000)
+	assert.Equal(t, int64(5000000), weights[1])
+	assert.Equal(t, int64(4000000), weights[2])
+	assert.Equal(t, int64(0), weights[3])
+
+	// 和逐个编码线性查找的旧算法结果一致
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < 200; n++ {
+		codeMax := 1 + rnd.Intn(500)
+		ranges := make([]CodeRange, 1+rnd.Intn(6))
+		for i := range ranges {
+			low := rnd.Intn(codeMax)
+			ranges[i] = CodeRange{ID: uint(i + 1), Low: low, High: low + rnd.Intn(codeMax-low)}
+		}
+		assert.Equal(t, migrateByCode(ranges, codeMax), MigrateCodeRanges(ranges, codeMax), "%v", ranges)
+	}
+
+	low, high, ok := ParsePrizeCode("1-10", 10000)
+	assert.True(t, ok)
+	assert.Equal(t, 1, low)
+	assert.Equal(t, 10, high)
+	_, _, ok = ParsePrizeCode("10-1", 10000)
+	assert.False(t, ok)
+	_, _, ok = ParsePrizeCode("0-10000", 10000)
+	assert.False(t, ok)
+}
+
+// migrateByCode 旧算法，逐个编码查找第一个包含它的区间
+func migrateByCode(ranges []CodeRange, codeMax int) map[uint]int64 {
+	counts := make(map[uint]int64)
+	for code := 0; code < codeMax; code++ {
+		for _, r := range ranges {
+			if r.Low <= code && code <= r.High {
+				counts[r.ID]++
+				break
+			}
+		}
+	}
+	weights := make(map[uint]int64, len(counts))
+	for id, cnt := range counts {
+		weights[id] = cnt * WeightTotal / int64(codeMax)
+	}
+	return weights
+}
@@ -0,0 +1,177 @@
+package draw
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// WeightTotal 权重总和，代表100%的中奖概率，精度为千万分之一
+const WeightTotal int64 = 10000000
+
+var (
+	ErrWeightInvalid  = errors.New("draw: weight invalid")
+	ErrWeightOverflow = errors.New("draw: total weight exceeds 100%")
+	ErrDuplicateItem  = errors.New("draw: duplicate item id")
+)
+
+// Item 参与抽奖的奖品
+type Item struct {
+	ID     uint  // 奖品ID
+	Weight int64 // 权重，WeightTotal 表示100%
+}
+
+// Engine 抽奖引擎，同一个奖品列表版本只需要构建一次，构建好之后每次抽奖都是O(1)
+type Engine interface {
+	// Space 抽奖编码空间，抽奖编码的取值范围是 [0, Space)
+	Space() int64
+	// Pick 根据抽奖编码选出奖品，ok为false表示没有中奖
+	Pick(code int64) (id uint, ok bool)
+	// Version 构建引擎时奖品列表的版本
+	Version() string
+}
+
+// Builder 抽奖引擎的构造函数，不同的抽奖算法实现这个函数即可替换
+type Builder func(items []Item) (Engine, error)
+
+// Validate 校验奖品权重，权重不能为负数，总和不能超过100%
+func Validate(items []Item) error {
+	var total int64
+	ids := make(map[uint]struct{}, len(items))
+	for _, item := range items {
+		if item.Weight < 0 || item.Weight > WeightTotal {
+			return fmt.Errorf("%w: id=%d weight=%d", ErrWeightInvalid, item.ID, item.Weight)
+		}
+		if _, ok := ids[item.ID]; ok {
+			return fmt.Errorf("%w: id=%d", ErrDuplicateItem, item.ID)
+		}
+		ids[item.ID] = struct{}{}
+		total += item.Weight
+	}
+	if total > WeightTotal {
+		return fmt.Errorf("%w: total=%d", ErrWeightOverflow, total)
+	}
+	return nil
+}
+
+// Version 计算奖品列表的版本，奖品顺序或者权重变化都会得到不同的版本
+func Version(items []Item) string {
+	h := fnv.New64a()
+	for _, item := range items {
+		h.Write([]byte(strconv.FormatUint(uint64(item.ID), 10)))
+		h.Write([]byte{':'})
+		h.Write([]byte(strconv.FormatInt(item.Weight, 10)))
+		h.Write([]byte{';'})
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// ProbToWeight 将小数概率转为权重，概率最多精确到小数点后7位
+func ProbToWeight(prob float64) (int64, error) {
+	if math.IsNaN(prob) || prob < 0 || prob > 1 {
+		return 0, fmt.Errorf("%w: probability=%v", ErrWeightInvalid, prob)
+	}
+	scaled := prob * float64(WeightTotal)
+	weight := math.Round(scaled)
+	if math.Abs(scaled-weight) > 1e-3 {
+		return 0, fmt.Errorf("%w: probability=%v precision exceeds 1e-7", ErrWeightInvalid, prob)
+	}
+	return int64(weight), nil
+}
+
+// WeightToProb 将权重转为小数概率
+func WeightToProb(weight int64) float64 {
+	return float64(weight) / float64(WeightTotal)
+}
+
+// CodeRange 旧版的中奖编码区间，对应奖品的 prize_code 字段 "a-b"
+type CodeRange struct {
+	ID   uint
+	Low  int
+	High int
+}
+
+// ParsePrizeCode 解析 "a-b" 格式的中奖编码区间，codeMax 为旧版编码空间大小
+func ParsePrizeCode(prizeCode string, codeMax int) (int, int, bool) {
+	codes := strings.Split(prizeCode, "-")
+	if len(codes) != 2 {
+		return 0, 0, false
+	}
+	low, err1 := strconv.Atoi(strings.TrimSpace(codes[0]))
+	high, err2 := strconv.Atoi(strings.TrimSpace(codes[1]))
+	if err1 != nil || err2 != nil || high < low || low < 0 || high >= codeMax {
+		return 0, 0, false
+	}
+	return low, high, true
+}
+
+// MigrateCodeRanges 将旧版的中奖编码区间折算成等价的权重
+// 旧版抽奖在 [0, codeMax) 中取随机数，然后按列表顺序线性查找第一个包含该编码的区间，
+// 所以区间重叠的部分归属于排在前面的奖品，没有被覆盖的编码表示不中奖，这里按照同样的规则折算
+// 按区间计算，复杂度只和区间数量有关，和编码空间的大小无关
+func MigrateCodeRanges(ranges []CodeRange, codeMax int) map[uint]int64 {
+	weights := make(map[uint]int64, len(ranges))
+	if codeMax <= 0 {
+		return weights
+	}
+	counts := make(map[uint]int64, len(ranges))
+	// 已经被前面的区间占用的编码，按起点排序并且互不重叠
+	var claimed []CodeRange
+	for _, r := range ranges {
+		low, high := r.Low, r.High
+		if low < 0 {
+			low = 0
+		}
+		if high > codeMax-1 {
+			high = codeMax - 1
+		}
+		if low > high {
+			continue
+		}
+		free := int64(high - low + 1)
+		for _, c := range claimed {
+			if c.Low <= high && low <= c.High {
+				free -= int64(minInt(c.High, high) - maxInt(c.Low, low) + 1)
+			}
+		}
+		if free > 0 {
+			counts[r.ID] += free
+		}
+		claimed = claimRange(claimed, low, high)
+	}
+	for id, cnt := range counts {
+		weights[id] = cnt * WeightTotal / int64(codeMax)
+	}
+	return weights
+}
+
+// claimRange 把 [low, high] 合并到已经占用的区间中
+func claimRange(claimed []CodeRange, low, high int) []CodeRange {
+	merged := make([]CodeRange, 0, len(claimed)+1)
+	i := 0
+	for ; i < len(claimed) && claimed[i].High < low-1; i++ {
+		merged = append(merged, claimed[i])
+	}
+	for ; i < len(claimed) && claimed[i].Low <= high+1; i++ {
+		low, high = minInt(low, claimed[i].Low), maxInt(high, claimed[i].High)
+	}
+	merged = append(merged, CodeRange{Low: low, High: high})
+	return append(merged, claimed[i:]...)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,64 @@
+package draw
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+)
+
+// 可验证的抽奖编码，采用 commit-reveal 方案：
+// 1. 服务端生成随机种子，事先公布种子的 sha256 作为承诺，种子本身保密
+// 2. 每次抽奖的编码 = HMAC-SHA256(种子, 活动ID:用户ID:请求ID:序号) 映射到编码空间
+// 3. 种子轮换之后公开旧种子，任何人都可以校验承诺并重新计算每一次抽奖的编码
+
+// SeedSize 种子的字节数
+const SeedSize = 32
+
+// NewSeed 生成新的随机种子，返回十六进制编码
+func NewSeed() (string, error) {
+	buf := make([]byte, SeedSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("draw: new seed: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Commit 计算种子的承诺值，种子公开之前只公布承诺值
+func Commit(seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	return hex.EncodeToString(sum[:])
+}
+
+// Message 一次抽奖参与计算的消息，请求ID由客户端提供，序号由服务端按种子递增分配
+func Message(activityID uint, userID uint, requestID string, nonce int64) string {
+	return fmt.Sprintf("%d:%d:%s:%d", activityID, userID, requestID, nonce)
+}
+
+// DeriveCode 根据种子和消息计算 [0, space) 范围内的抽奖编码，相同的输入总是得到相同的编码
+// 为了避免取模带来的偏差，落在最后一段不完整区间的随机数会被丢弃，继续使用后面的随机数
+func DeriveCode(seed string, message string, space int64) int64 {
+	if space <= 0 {
+		return 0
+	}
+	limit := math.MaxUint64 - math.MaxUint64%uint64(space)
+	for round := uint64(0); ; round++ {
+		mac := hmac.New(sha256.New, []byte(seed))
+		mac.Write([]byte(message))
+		if round > 0 {
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], round)
+			mac.Write(buf[:])
+		}
+		sum := mac.Sum(nil)
+		for i := 0; i+8 <= len(sum); i += 8 {
+			v := binary.BigEndian.Uint64(sum[i : i+8])
+			if v < limit {
+				return int64(v % uint64(space))
+			}
+		}
+	}
+}
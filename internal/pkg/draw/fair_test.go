@@ -0,0 +1,38 @@
+package draw
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveCode(t *testing.T) {
+	seed, err := NewSeed()
+	assert.NoError(t, err)
+	assert.Len(t, seed, SeedSize*2)
+	assert.Len(t, Commit(seed), 64)
+	assert.NotEqual(t, Commit(seed), Commit(seed+"0"))
+
+	msg := Message(1, 2, "req-1", 3)
+	assert.Equal(t, "1:2:req-1:3", msg)
+	space := int64(5) * WeightTotal
+	code := DeriveCode(seed, msg, space)
+	assert.True(t, code >= 0 && code < space)
+	// 相同的输入总是得到相同的编码
+	assert.Equal(t, code, DeriveCode(seed, msg, space))
+
+	// 固定的种子和消息，防止算法被无意修改导致历史抽奖无法校验
+	assert.Equal(t, int64(2), DeriveCode("seed", "1:2:req-1:3", 10))
+	assert.Equal(t, int64(0), DeriveCode(seed, msg, 0))
+	assert.Equal(t, int64(0), DeriveCode(seed, msg, 1))
+}
+
+func TestDeriveCodeDistribution(t *testing.T) {
+	counts := make([]int, 4)
+	for i := int64(0); i < 4000; i++ {
+		counts[DeriveCode("seed", Message(1, 1, "req", i), 4)]++
+	}
+	for _, cnt := range counts {
+		assert.InDelta(t, 1000, cnt, 150)
+	}
+}
@@ -0,0 +1,102 @@
+package iptrie
+
+import (
+	"net/netip"
+)
+
+// Trie IP网段的前缀树，支持最长前缀匹配
+// IPv4按照IPv4映射的IPv6地址(::ffff:a.b.c.d)保存，IPv4和IPv6共用一棵树
+// 构建之后只读，可以并发查询，修改时重新构建一棵新的树
+type Trie[V any] struct {
+	root node[V]
+	size int
+}
+
+type node[V any] struct {
+	child [2]*node[V]
+	value V
+	set   bool
+}
+
+// New 创建一棵空的前缀树
+func New[V any]() *Trie[V] {
+	return &Trie[V]{}
+}
+
+// Len 网段数量
+func (t *Trie[V]) Len() int {
+	return t.size
+}
+
+// Insert 加入一个网段，相同的网段覆盖之前的值
+func (t *Trie[V]) Insert(prefix netip.Prefix, value V) {
+	addr, bits := toBits(prefix)
+	n := &t.root
+	for i := 0; i < bits; i++ {
+		b := bit(addr, i)
+		if n.child[b] == nil {
+			n.child[b] = &node[V]{}
+		}
+		n = n.child[b]
+	}
+	if !n.set {
+		t.size++
+	}
+	n.value, n.set = value, true
+}
+
+// Lookup 查找包含addr的最长的网段，ok为false表示没有网段包含addr
+func (t *Trie[V]) Lookup(addr netip.Addr) (value V, ok bool) {
+	if !addr.IsValid() {
+		return value, false
+	}
+	a := addr.Unmap().As16()
+	n := &t.root
+	for i := 0; n != nil; i++ {
+		if n.set {
+			value, ok = n.value, true
+		}
+		if i == 128 {
+			break
+		}
+		n = n.child[bit(a, i)]
+	}
+	return value, ok
+}
+
+// LookupAll 查找包含addr的所有网段，从最长的网段开始
+func (t *Trie[V]) LookupAll(addr netip.Addr) []V {
+	if !addr.IsValid() {
+		return nil
+	}
+	var values []V
+	a := addr.Unmap().As16()
+	n := &t.root
+	for i := 0; n != nil; i++ {
+		if n.set {
+			values = append(values, n.value)
+		}
+		if i == 128 {
+			break
+		}
+		n = n.child[bit(a, i)]
+	}
+	for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+		values[i], values[j] = values[j], values[i]
+	}
+	return values
+}
+
+// toBits 网段转换为128位的地址和前缀长度
+func toBits(prefix netip.Prefix) ([16]byte, int) {
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+	return prefix.Addr().As16(), bits
+}
+
+// bit 地址的第i位，从最高位开始
+func bit(addr [16]byte, i int) int {
+	return int(addr[i/8]>>(7-uint(i%8))) & 1
+}
@@ -0,0 +1,66 @@
+package iptrie
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrieLookup(t *testing.T) {
+	trie := New[string]()
+	trie.Insert(netip.MustParsePrefix("10.0.0.0/8"), "a")
+	trie.Insert(netip.MustParsePrefix("10.1.0.0/16"), "b")
+	trie.Insert(netip.MustParsePrefix("10.1.2.3/32"), "c")
+	trie.Insert(netip.MustParsePrefix("2001:db8::/32"), "d")
+	trie.Insert(netip.MustParsePrefix("2001:db8:1::/48"), "e")
+	trie.Insert(netip.MustParsePrefix("10.0.0.0/8"), "a2")
+	assert.Equal(t, 5, trie.Len())
+
+	cases := []struct {
+		ip    string
+		value string
+		ok    bool
+	}{
+		{"10.2.3.4", "a2", true},
+		{"10.1.9.9", "b", true},
+		{"10.1.2.3", "c", true},
+		{"::ffff:10.1.2.3", "c", true},
+		{"11.0.0.1", "", false},
+		{"2001:db8:2::1", "d", true},
+		{"2001:db8:1:ffff::1", "e", true},
+		{"2001:db9::1", "", false},
+		// IPv4映射的IPv6地址和IPv6网段不会互相匹配
+		{"::a01:203", "", false},
+	}
+	for _, c := range cases {
+		value, ok := trie.Lookup(netip.MustParseAddr(c.ip))
+		assert.Equal(t, c.ok, ok, c.ip)
+		assert.Equal(t, c.value, value, c.ip)
+	}
+	_, ok := trie.Lookup(netip.Addr{})
+	assert.False(t, ok)
+}
+
+func TestTrieDefaultRoute(t *testing.T) {
+	trie := New[int]()
+	trie.Insert(netip.MustParsePrefix("::/0"), 1)
+	trie.Insert(netip.MustParsePrefix("0.0.0.0/0"), 2)
+	value, ok := trie.Lookup(netip.MustParseAddr("8.8.8.8"))
+	assert.True(t, ok)
+	assert.Equal(t, 2, value)
+	value, ok = trie.Lookup(netip.MustParseAddr("2001:4860::8888"))
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+}
+
+func TestTrieLookupAll(t *testing.T) {
+	trie := New[string]()
+	trie.Insert(netip.MustParsePrefix("10.0.0.0/8"), "a")
+	trie.Insert(netip.MustParsePrefix("10.1.0.0/16"), "b")
+	trie.Insert(netip.MustParsePrefix("10.1.2.0/24"), "c")
+	assert.Equal(t, []string{"c", "b", "a"}, trie.LookupAll(netip.MustParseAddr("10.1.2.3")))
+	assert.Equal(t, []string{"a"}, trie.LookupAll(netip.MustParseAddr("10.9.0.1")))
+	assert.Empty(t, trie.LookupAll(netip.MustParseAddr("11.0.0.1")))
+	assert.Empty(t, trie.LookupAll(netip.Addr{}))
+}
@@ -0,0 +1,118 @@
+package risk
+
+import (
+	"context"
+	"time"
+)
+
+// Verdict 风控规则的处理结果
+type Verdict string
+
+const (
+	VerdictAllow   Verdict = "allow"   // 放行
+	VerdictDegrade Verdict = "degrade" // 可以抽奖，但是不能中奖
+	VerdictDeny    Verdict = "deny"    // 拒绝抽奖
+)
+
+// severity 处理结果的严重程度，多条规则命中时取最严重的
+func (v Verdict) severity() int {
+	switch v {
+	case VerdictDegrade:
+		return 1
+	case VerdictDeny:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Input 一次抽奖请求中参与风控判断的信息
+type Input struct {
+	ActivityID  uint
+	UserID      uint
+	IP          string
+	DeviceID    string    // 客户端上报的设备ID，可以为空
+	RequestID   string    // 客户端提供的请求ID，参与签名
+	Sign        string    // 客户端对请求的签名
+	UserCreated time.Time // 账号注册时间，零值表示未知
+	Now         time.Time
+}
+
+// Decision 风控的决定，Rule为空表示没有规则命中
+type Decision struct {
+	Verdict Verdict
+	Rule    string // 命中的规则
+	Reason  string // 命中的原因，用于日志
+}
+
+// Allowed 没有规则命中，放行
+var Allowed = Decision{Verdict: VerdictAllow}
+
+// Rule 风控规则，新的规则实现这个接口，并在 Build 中根据配置创建
+type Rule interface {
+	// Name 规则名称，记录在抽奖审计中
+	Name() string
+	// Evaluate 判断一次抽奖请求，没有命中返回 Allowed
+	Evaluate(ctx context.Context, in *Input) (Decision, error)
+}
+
+// Counter 规则使用的计数器，线上使用redis实现，测试使用内存实现
+type Counter interface {
+	// Incr key的计数加1，返回加1之后的计数，第一次计数时设置过期时间
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// AddMember 集合中加入成员，返回加入之后集合的成员数量，第一次加入时设置过期时间
+	AddMember(ctx context.Context, key, member string, ttl time.Duration) (int64, error)
+}
+
+// Chain 活动的规则链，按照配置的顺序执行
+type Chain struct {
+	rules []Rule
+}
+
+// NewChain 创建规则链
+func NewChain(rules ...Rule) *Chain {
+	return &Chain{rules: rules}
+}
+
+// Len 规则数量
+func (c *Chain) Len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.rules)
+}
+
+// NeedAccount 是否有规则需要账号注册时间
+func (c *Chain) NeedAccount() bool {
+	if c == nil {
+		return false
+	}
+	for _, rule := range c.rules {
+		if _, ok := rule.(*AccountAgeRule); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate 执行规则链，命中拒绝的规则之后不再执行后面的规则，否则返回最严重的结果
+// 降级的规则命中之后后面的规则仍然执行，保证计数类规则的计数准确
+func (c *Chain) Evaluate(ctx context.Context, in *Input) (Decision, error) {
+	decision := Allowed
+	if c == nil {
+		return decision, nil
+	}
+	for _, rule := range c.rules {
+		d, err := rule.Evaluate(ctx, in)
+		if err != nil {
+			return Allowed, err
+		}
+		if d.Verdict.severity() > decision.Verdict.severity() {
+			decision = d
+		}
+		if decision.Verdict == VerdictDeny {
+			break
+		}
+	}
+	return decision, nil
+}
@@ -0,0 +1,222 @@
+package risk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memCounter 内存计数器，不处理过期
+type memCounter struct {
+	nums    map[string]int64
+	members map[string]map[string]bool
+	err     error
+}
+
+func newMemCounter() *memCounter {
+	return &memCounter{nums: make(map[string]int64), members: make(map[string]map[string]bool)}
+}
+
+func (m *memCounter) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	m.nums[key]++
+	return m.nums[key], nil
+}
+
+func (m *memCounter) AddMember(ctx context.Context, key, member string, ttl time.Duration) (int64, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	if m.members[key] == nil {
+		m.members[key] = make(map[string]bool)
+	}
+	m.members[key][member] = true
+	return int64(len(m.members[key])), nil
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("")
+	assert.Nil(t, err)
+	assert.Empty(t, rules)
+
+	rules, err = ParseRules(`[{"type":"velocity","dimension":"device","window":60,"max":5},
+		{"type":"account_age","min_age":86400,"verdict":"degrade"}]`)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rules))
+	assert.Equal(t, VerdictDeny, rules[0].Verdict)
+	assert.Equal(t, VerdictDegrade, rules[1].Verdict)
+
+	invalid := []string{
+		`{}`,
+		`[{"type":"unknown"}]`,
+		`[{"type":"velocity","dimension":"phone","window":60,"max":5}]`,
+		`[{"type":"velocity","dimension":"user","window":0,"max":5}]`,
+		`[{"type":"shared_ip","window":60,"max":0}]`,
+		`[{"type":"account_age"}]`,
+		`[{"type":"signature","verdict":"block"}]`,
+	}
+	for _, raw := range invalid {
+		_, err := ParseRules(raw)
+		assert.True(t, errors.Is(err, ErrRuleInvalid), raw)
+	}
+}
+
+func TestVelocityRule(t *testing.T) {
+	counter := newMemCounter()
+	chain, err := Build(1, []RuleConfig{{Type: RuleTypeVelocity, Dimension: DimensionDevice, Window: 60, Max: 2,
+		Verdict: VerdictDeny}}, Options{Counter: counter})
+	assert.Nil(t, err)
+	now := time.Unix(1700000000, 0)
+	in := &Input{UserID: 1, DeviceID: "d1", Now: now}
+	for i := 0; i < 2; i++ {
+		d, err := chain.Evaluate(context.Background(), in)
+		assert.Nil(t, err)
+		assert.Equal(t, Allowed, d)
+	}
+	d, err := chain.Evaluate(context.Background(), in)
+	assert.Nil(t, err)
+	assert.Equal(t, VerdictDeny, d.Verdict)
+	assert.Equal(t, "velocity_device", d.Rule)
+
+	// 下一个窗口重新计数，没有设备ID时不统计
+	in.Now = now.Add(time.Minute)
+	d, _ = chain.Evaluate(context.Background(), in)
+	assert.Equal(t, VerdictAllow, d.Verdict)
+	d, _ = chain.Evaluate(context.Background(), &Input{UserID: 1, Now: now})
+	assert.Equal(t, VerdictAllow, d.Verdict)
+}
+
+func TestSharedIPRule(t *testing.T) {
+	chain, _ := Build(1, []RuleConfig{{Type: RuleTypeSharedIP, Window: 3600, Max: 2, Verdict: VerdictDegrade}},
+		Options{Counter: newMemCounter()})
+	now := time.Unix(1700000000, 0)
+	for _, uid := range []uint{1, 2, 1, 2} {
+		d, _ := chain.Evaluate(context.Background(), &Input{UserID: uid, IP: "1.2.3.4", Now: now})
+		assert.Equal(t, VerdictAllow, d.Verdict)
+	}
+	d, _ := chain.Evaluate(context.Background(), &Input{UserID: 3, IP: "1.2.3.4", Now: now})
+	assert.Equal(t, VerdictDegrade, d.Verdict)
+	assert.Equal(t, RuleTypeSharedIP, d.Rule)
+	d, _ = chain.Evaluate(context.Background(), &Input{UserID: 3, IP: "1.2.3.5", Now: now})
+	assert.Equal(t, VerdictAllow, d.Verdict)
+}
+
+func TestIPv6Counters(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	chain, _ := Build(1, []RuleConfig{
+		{Type: RuleTypeVelocity, Dimension: DimensionIP, Window: 60, Max: 2, Verdict: VerdictDeny},
+	}, Options{Counter: newMemCounter()})
+	// 同一个/64网段中换地址也计入同一个计数
+	for _, ip := range []string{"2001:db8::1", "2001:db8::2"} {
+		d, _ := chain.Evaluate(context.Background(), &Input{UserID: 1, IP: ip, Now: now})
+		assert.Equal(t, VerdictAllow, d.Verdict)
+	}
+	d, _ := chain.Evaluate(context.Background(), &Input{UserID: 1, IP: "2001:db8::ffff:3", Now: now})
+	assert.Equal(t, VerdictDeny, d.Verdict)
+	d, _ = chain.Evaluate(context.Background(), &Input{UserID: 1, IP: "2001:db8:0:1::1", Now: now})
+	assert.Equal(t, VerdictAllow, d.Verdict)
+
+	chain, _ = Build(1, []RuleConfig{{Type: RuleTypeSharedIP, Window: 3600, Max: 1, Verdict: VerdictDeny}},
+		Options{Counter: newMemCounter()})
+	d, _ = chain.Evaluate(context.Background(), &Input{UserID: 1, IP: "2001:db8::1", Now: now})
+	assert.Equal(t, VerdictAllow, d.Verdict)
+	d, _ = chain.Evaluate(context.Background(), &Input{UserID: 2, IP: "2001:db8::2", Now: now})
+	assert.Equal(t, VerdictDeny, d.Verdict)
+	assert.Contains(t, d.Reason, "ip=2001:db8::/64")
+}
+
+func TestCounterKey(t *testing.T) {
+	userRule := RuleConfig{Type: RuleTypeVelocity, Dimension: DimensionUser, Window: 60, Max: 5}
+	ipRule := RuleConfig{Type: RuleTypeVelocity, Dimension: DimensionIP, Window: 60, Max: 5}
+	keys := func(configs ...RuleConfig) []string {
+		chain, err := Build(1, configs, Options{Counter: newMemCounter()})
+		assert.Nil(t, err)
+		var keys []string
+		for _, rule := range chain.rules {
+			keys = append(keys, rule.(*VelocityRule).Key)
+		}
+		return keys
+	}
+
+	// 调整顺序或者删除规则时，其他规则的计数key不变
+	before := keys(userRule, ipRule)
+	assert.Equal(t, []string{before[1], before[0]}, keys(ipRule, userRule))
+	assert.Equal(t, before[1:], keys(ipRule))
+	// 只修改上限时继续使用原来的计数，修改窗口时重新计数
+	userRule.Max = 10
+	assert.Equal(t, before[:1], keys(userRule))
+	userRule.Window = 120
+	assert.NotEqual(t, before[0], keys(userRule)[0])
+	// 完全相同的规则不共用计数
+	same := keys(ipRule, ipRule)
+	assert.Equal(t, before[1], same[0])
+	assert.NotEqual(t, same[0], same[1])
+	// 不同活动不共用计数
+	chain, _ := Build(2, []RuleConfig{ipRule}, Options{Counter: newMemCounter()})
+	assert.NotEqual(t, before[1], chain.rules[0].(*VelocityRule).Key)
+}
+
+func TestAccountAgeRule(t *testing.T) {
+	rule := &AccountAgeRule{MinAge: time.Hour, Verdict: VerdictDeny}
+	now := time.Unix(1700000000, 0)
+	d, _ := rule.Evaluate(context.Background(), &Input{UserCreated: now.Add(-2 * time.Hour), Now: now})
+	assert.Equal(t, VerdictAllow, d.Verdict)
+	d, _ = rule.Evaluate(context.Background(), &Input{UserCreated: now.Add(-time.Minute), Now: now})
+	assert.Equal(t, VerdictDeny, d.Verdict)
+	// 注册时间未知
+	d, _ = rule.Evaluate(context.Background(), &Input{Now: now})
+	assert.Equal(t, VerdictDeny, d.Verdict)
+}
+
+func TestSignatureRule(t *testing.T) {
+	secret := ""
+	rule := &SignatureRule{Secret: func() string { return secret }, Verdict: VerdictDeny}
+	in := &Input{ActivityID: 1, UserID: 2, RequestID: "r1", DeviceID: "d1"}
+	// 没有配置密钥时不校验
+	d, _ := rule.Evaluate(context.Background(), in)
+	assert.Equal(t, VerdictAllow, d.Verdict)
+
+	secret = "s3cret"
+	d, _ = rule.Evaluate(context.Background(), in)
+	assert.Equal(t, VerdictDeny, d.Verdict)
+	in.Sign = Sign(secret, in)
+	d, _ = rule.Evaluate(context.Background(), in)
+	assert.Equal(t, VerdictAllow, d.Verdict)
+	in.DeviceID = "d2"
+	d, _ = rule.Evaluate(context.Background(), in)
+	assert.Equal(t, VerdictDeny, d.Verdict)
+}
+
+func TestChainEvaluate(t *testing.T) {
+	counter := newMemCounter()
+	now := time.Unix(1700000000, 0)
+	chain, _ := Build(1, []RuleConfig{
+		{Type: RuleTypeAccountAge, MinAge: 3600, Verdict: VerdictDegrade},
+		{Type: RuleTypeVelocity, Dimension: DimensionUser, Window: 60, Max: 1, Verdict: VerdictDeny},
+	}, Options{Counter: counter})
+	assert.True(t, chain.NeedAccount())
+
+	// 降级之后继续执行后面的规则，计数准确
+	in := &Input{UserID: 1, Now: now}
+	d, _ := chain.Evaluate(context.Background(), in)
+	assert.Equal(t, VerdictDegrade, d.Verdict)
+	assert.Equal(t, RuleTypeAccountAge, d.Rule)
+	d, _ = chain.Evaluate(context.Background(), in)
+	assert.Equal(t, VerdictDeny, d.Verdict)
+	assert.Equal(t, "velocity_user", d.Rule)
+
+	counter.err = errors.New("redis down")
+	_, err := chain.Evaluate(context.Background(), in)
+	assert.NotNil(t, err)
+
+	var empty *Chain
+	d, err = empty.Evaluate(context.Background(), in)
+	assert.Nil(t, err)
+	assert.Equal(t, Allowed, d)
+	assert.False(t, empty.NeedAccount())
+}
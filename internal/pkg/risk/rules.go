@@ -0,0 +1,298 @@
+package risk
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lottery_single/internal/pkg/utils"
+	"strconv"
+	"time"
+)
+
+// 规则类型
+const (
+	RuleTypeVelocity   = "velocity"    // 窗口内的抽奖次数
+	RuleTypeSharedIP   = "shared_ip"   // 窗口内同一个IP抽奖的账号数量
+	RuleTypeAccountAge = "account_age" // 账号注册时间
+	RuleTypeSignature  = "signature"   // 请求签名
+)
+
+// 抽奖次数的统计维度
+const (
+	DimensionUser   = "user"
+	DimensionIP     = "ip"
+	DimensionDevice = "device"
+)
+
+// MaxRules 一个活动最多配置的规则数量
+const MaxRules = 10
+
+// maxWindow 统计窗口最长30天
+const maxWindow = 30 * 86400
+
+var ErrRuleInvalid = errors.New("risk: rule invalid")
+
+// RuleConfig 活动中配置的一条规则，活动的 risk_rules 字段是这个结构的json数组
+type RuleConfig struct {
+	Type      string  `json:"type"`
+	Dimension string  `json:"dimension,omitempty"` // velocity：统计维度，user、ip、device
+	Window    int64   `json:"window,omitempty"`    // velocity、shared_ip：统计窗口，单位秒
+	Max       int64   `json:"max,omitempty"`       // velocity：窗口内最多抽奖次数，shared_ip：窗口内同一个IP最多的账号数量
+	MinAge    int64   `json:"min_age,omitempty"`   // account_age：账号注册之后多少秒才能抽奖
+	Verdict   Verdict `json:"verdict,omitempty"`   // 命中之后的处理，deny 或者 degrade，不配置为 deny
+}
+
+// Options 创建规则需要的依赖
+type Options struct {
+	Counter Counter
+	Secret  func() string // 请求签名的密钥，每次校验时获取，支持配置热更新
+}
+
+// ParseRules 解析并校验活动的规则配置，空字符串表示没有规则
+func ParseRules(raw string) ([]RuleConfig, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var configs []RuleConfig
+	if err := json.Unmarshal([]byte(raw), &configs); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRuleInvalid, err)
+	}
+	if len(configs) > MaxRules {
+		return nil, fmt.Errorf("%w: at most %d rules", ErrRuleInvalid, MaxRules)
+	}
+	for i := range configs {
+		if err := checkRule(&configs[i]); err != nil {
+			return nil, fmt.Errorf("%w: rule %d %v", ErrRuleInvalid, i, err)
+		}
+	}
+	return configs, nil
+}
+
+// checkRule 校验一条规则，补全默认的处理结果
+func checkRule(c *RuleConfig) error {
+	switch c.Verdict {
+	case "":
+		c.Verdict = VerdictDeny
+	case VerdictDeny, VerdictDegrade:
+	default:
+		return fmt.Errorf("verdict %q", c.Verdict)
+	}
+	switch c.Type {
+	case RuleTypeVelocity:
+		if c.Dimension != DimensionUser && c.Dimension != DimensionIP && c.Dimension != DimensionDevice {
+			return fmt.Errorf("dimension %q", c.Dimension)
+		}
+		fallthrough
+	case RuleTypeSharedIP:
+		if c.Window <= 0 || c.Window > maxWindow {
+			return fmt.Errorf("window %d", c.Window)
+		}
+		if c.Max <= 0 {
+			return fmt.Errorf("max %d", c.Max)
+		}
+	case RuleTypeAccountAge:
+		if c.MinAge <= 0 {
+			return fmt.Errorf("min_age %d", c.MinAge)
+		}
+	case RuleTypeSignature:
+	default:
+		return fmt.Errorf("type %q", c.Type)
+	}
+	return nil
+}
+
+// Build 根据活动的规则配置创建规则链，配置需要先经过 ParseRules 校验
+func Build(activityID uint, configs []RuleConfig, opts Options) (*Chain, error) {
+	rules := make([]Rule, 0, len(configs))
+	seen := make(map[string]int)
+	for _, c := range configs {
+		switch c.Type {
+		case RuleTypeVelocity:
+			rules = append(rules, &VelocityRule{
+				Key:       counterKey(activityID, c, seen),
+				Dimension: c.Dimension,
+				Window:    c.Window,
+				Max:       c.Max,
+				Verdict:   c.Verdict,
+				Counter:   opts.Counter,
+			})
+		case RuleTypeSharedIP:
+			rules = append(rules, &SharedIPRule{
+				Key:     counterKey(activityID, c, seen),
+				Window:  c.Window,
+				Max:     c.Max,
+				Verdict: c.Verdict,
+				Counter: opts.Counter,
+			})
+		case RuleTypeAccountAge:
+			rules = append(rules, &AccountAgeRule{
+				MinAge:  time.Duration(c.MinAge) * time.Second,
+				Verdict: c.Verdict,
+			})
+		case RuleTypeSignature:
+			rules = append(rules, &SignatureRule{
+				Secret:  opts.Secret,
+				Verdict: c.Verdict,
+			})
+		default:
+			return nil, fmt.Errorf("%w: type %q", ErrRuleInvalid, c.Type)
+		}
+	}
+	return NewChain(rules...), nil
+}
+
+// counterKey 规则计数key的前缀，由规则类型、统计维度和窗口计算，和规则在配置中的位置无关，
+// 增删或者调整规则顺序时其他规则继续使用原来的计数，修改维度或者窗口的规则重新计数。
+// 类型、维度和窗口都相同的规则按照出现的顺序区分，避免重复计数
+func counterKey(activityID uint, c RuleConfig, seen map[string]int) string {
+	content := fmt.Sprintf("%s|%s|%d", c.Type, c.Dimension, c.Window)
+	seen[content]++
+	if seen[content] > 1 {
+		content += "|" + strconv.Itoa(seen[content])
+	}
+	sum := sha256.Sum256([]byte(content))
+	return fmt.Sprintf("risk_%s_%d_%s", c.Type, activityID, hex.EncodeToString(sum[:8]))
+}
+
+// windowKey 固定窗口的计数key，窗口结束之后自动过期
+func windowKey(prefix, value string, window int64, now time.Time) string {
+	return prefix + "_" + value + "_" + strconv.FormatInt(now.Unix()/window, 10)
+}
+
+// VelocityRule 窗口内同一个用户、IP或者设备的抽奖次数超过上限
+// 没有上报设备ID的请求不按照设备统计，需要限制时同时配置签名规则
+type VelocityRule struct {
+	Key       string
+	Dimension string
+	Window    int64
+	Max       int64
+	Verdict   Verdict
+	Counter   Counter
+}
+
+func (r *VelocityRule) Name() string {
+	return RuleTypeVelocity + "_" + r.Dimension
+}
+
+func (r *VelocityRule) Evaluate(ctx context.Context, in *Input) (Decision, error) {
+	var value string
+	switch r.Dimension {
+	case DimensionUser:
+		value = strconv.FormatUint(uint64(in.UserID), 10)
+	case DimensionIP:
+		// 和抽奖次数限制一样，IPv6按照/64网段统计
+		value = utils.IPLimitKey(in.IP)
+	case DimensionDevice:
+		value = in.DeviceID
+	}
+	if value == "" {
+		return Allowed, nil
+	}
+	num, err := r.Counter.Incr(ctx, windowKey(r.Key, value, r.Window, in.Now), time.Duration(r.Window)*time.Second)
+	if err != nil {
+		return Allowed, fmt.Errorf("VelocityRule|Evaluate:%v", err)
+	}
+	if num <= r.Max {
+		return Allowed, nil
+	}
+	return Decision{
+		Verdict: r.Verdict,
+		Rule:    r.Name(),
+		Reason:  fmt.Sprintf("%s=%s num=%d max=%d window=%ds", r.Dimension, value, num, r.Max, r.Window),
+	}, nil
+}
+
+// SharedIPRule 窗口内同一个IP抽奖的账号数量超过上限，用于发现集中注册的小号
+type SharedIPRule struct {
+	Key     string
+	Window  int64
+	Max     int64
+	Verdict Verdict
+	Counter Counter
+}
+
+func (r *SharedIPRule) Name() string {
+	return RuleTypeSharedIP
+}
+
+func (r *SharedIPRule) Evaluate(ctx context.Context, in *Input) (Decision, error) {
+	if in.IP == "" {
+		return Allowed, nil
+	}
+	ip := utils.IPLimitKey(in.IP)
+	member := strconv.FormatUint(uint64(in.UserID), 10)
+	num, err := r.Counter.AddMember(ctx, windowKey(r.Key, ip, r.Window, in.Now), member,
+		time.Duration(r.Window)*time.Second)
+	if err != nil {
+		return Allowed, fmt.Errorf("SharedIPRule|Evaluate:%v", err)
+	}
+	if num <= r.Max {
+		return Allowed, nil
+	}
+	return Decision{
+		Verdict: r.Verdict,
+		Rule:    r.Name(),
+		Reason:  fmt.Sprintf("ip=%s accounts=%d max=%d window=%ds", ip, num, r.Max, r.Window),
+	}, nil
+}
+
+// AccountAgeRule 账号注册时间太短，注册时间未知的账号同样命中
+type AccountAgeRule struct {
+	MinAge  time.Duration
+	Verdict Verdict
+}
+
+func (r *AccountAgeRule) Name() string {
+	return RuleTypeAccountAge
+}
+
+func (r *AccountAgeRule) Evaluate(ctx context.Context, in *Input) (Decision, error) {
+	if !in.UserCreated.IsZero() && in.Now.Sub(in.UserCreated) >= r.MinAge {
+		return Allowed, nil
+	}
+	return Decision{
+		Verdict: r.Verdict,
+		Rule:    r.Name(),
+		Reason:  fmt.Sprintf("created=%s min_age=%s", in.UserCreated.Format(time.RFC3339), r.MinAge),
+	}, nil
+}
+
+// SignatureRule 请求签名和服务端计算的不一致，没有配置密钥时不校验
+type SignatureRule struct {
+	Secret  func() string
+	Verdict Verdict
+}
+
+func (r *SignatureRule) Name() string {
+	return RuleTypeSignature
+}
+
+func (r *SignatureRule) Evaluate(ctx context.Context, in *Input) (Decision, error) {
+	var secret string
+	if r.Secret != nil {
+		secret = r.Secret()
+	}
+	if secret == "" {
+		return Allowed, nil
+	}
+	expected := Sign(secret, in)
+	if hmac.Equal([]byte(expected), []byte(in.Sign)) {
+		return Allowed, nil
+	}
+	return Decision{
+		Verdict: r.Verdict,
+		Rule:    r.Name(),
+		Reason:  fmt.Sprintf("sign mismatch request_id=%s device_id=%s", in.RequestID, in.DeviceID),
+	}, nil
+}
+
+// Sign 计算请求签名，hex(HMAC-SHA256(secret, "activity_id|user_id|request_id|device_id"))
+func Sign(secret string, in *Input) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d|%d|%s|%s", in.ActivityID, in.UserID, in.RequestID, in.DeviceID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
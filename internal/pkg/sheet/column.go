@@ -0,0 +1,100 @@
+package sheet
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrEmptyCell 单元格为空
+	ErrEmptyCell = errors.New("sheet: empty cell")
+	// ErrDuplicateCell 单元格的值和前面的行重复
+	ErrDuplicateCell = errors.New("sheet: duplicate cell")
+)
+
+// Cell 从表格中取出的一个值
+type Cell struct {
+	Line  int
+	Value string
+}
+
+// CellError 不合法的一行，重复时FirstLine是第一次出现的行号
+type CellError struct {
+	Line      int
+	Value     string
+	FirstLine int
+	Err       error
+}
+
+// Column 取出表格中的一列，第一个非空行包含headers中的列名时(不区分大小写)作为表头跳过，否则读取第一列。
+// 空行跳过并且不计入总数，normalize返回规范化之后的值，为nil时只去掉首尾空白，规范化之后重复的值只保留第一个
+func Column(rows []Row, headers []string, normalize func(string) (string, error)) ([]Cell, []*CellError, int) {
+	var (
+		cells []Cell
+		errs  []*CellError
+		total int
+		col   int
+		first = make(map[string]int)
+	)
+	for i, row := range rows {
+		if isBlank(row.Cells) {
+			continue
+		}
+		// 第一个非空行可能是表头
+		if total == 0 {
+			if idx := headerIndex(row.Cells, headers); idx >= 0 {
+				col = idx
+				continue
+			}
+		}
+		total++
+		value := ""
+		if col < len(row.Cells) {
+			value = strings.TrimSpace(row.Cells[col])
+		}
+		line := row.Line
+		if line <= 0 {
+			line = i + 1
+		}
+		if value == "" {
+			errs = append(errs, &CellError{Line: line, Err: ErrEmptyCell})
+			continue
+		}
+		if normalize != nil {
+			v, err := normalize(value)
+			if err != nil {
+				errs = append(errs, &CellError{Line: line, Value: value, Err: err})
+				continue
+			}
+			value = v
+		}
+		if first[value] > 0 {
+			errs = append(errs, &CellError{Line: line, Value: value, FirstLine: first[value], Err: ErrDuplicateCell})
+			continue
+		}
+		first[value] = line
+		cells = append(cells, Cell{Line: line, Value: value})
+	}
+	return cells, errs, total
+}
+
+func isBlank(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func headerIndex(cells []string, headers []string) int {
+	for i, cell := range cells {
+		cell = strings.TrimSpace(cell)
+		for _, header := range headers {
+			if strings.EqualFold(cell, header) {
+				return i
+			}
+		}
+	}
+	return -1
+}
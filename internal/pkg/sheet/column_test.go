@@ -0,0 +1,44 @@
+package sheet
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColumn(t *testing.T) {
+	errUpper := errors.New("upper")
+	normalize := func(v string) (string, error) {
+		if strings.ToUpper(v) == v {
+			return "", errUpper
+		}
+		return strings.ToLower(v), nil
+	}
+	rows := []Row{
+		{Line: 1, Cells: []string{"remark", " Code "}},
+		{Line: 2, Cells: []string{"x", " a001 "}},
+		{Line: 3, Cells: []string{"", ""}},
+		{Line: 4, Cells: []string{"y"}},
+		{Line: 5, Cells: []string{"z", "B002"}},
+		{Line: 6, Cells: []string{"", "A001x"}},
+		{Line: 7, Cells: []string{"", "a001"}},
+		{Cells: []string{"", "b003"}},
+	}
+	cells, errs, total := Column(rows, []string{"code"}, normalize)
+	assert.Equal(t, 6, total)
+	assert.Equal(t, []Cell{{Line: 2, Value: "a001"}, {Line: 6, Value: "a001x"}, {Line: 8, Value: "b003"}}, cells)
+	assert.Equal(t, []*CellError{
+		{Line: 4, Err: ErrEmptyCell},
+		{Line: 5, Value: "B002", Err: errUpper},
+		{Line: 7, Value: "a001", FirstLine: 2, Err: ErrDuplicateCell},
+	}, errs)
+
+	// 没有表头时读取第一列，第一行也计入总数
+	cells, errs, total = Column([]Row{{Line: 1, Cells: []string{" A001", "x"}}, {Line: 2, Cells: []string{"A001"}}},
+		[]string{"code"}, nil)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []Cell{{Line: 1, Value: "A001"}}, cells)
+	assert.Equal(t, []*CellError{{Line: 2, Value: "A001", FirstLine: 1, Err: ErrDuplicateCell}}, errs)
+}
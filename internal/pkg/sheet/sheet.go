@@ -0,0 +1,98 @@
+package sheet
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// 读取后台上传的CSV和Excel表格，只读取第一个工作表，行号从1开始，和表格软件中看到的行号一致
+
+var (
+	// ErrUnsupportedFormat 文件格式不支持
+	ErrUnsupportedFormat = errors.New("sheet: unsupported file format")
+	// ErrTooManyRows 文件行数超过限制
+	ErrTooManyRows = errors.New("sheet: too many rows")
+)
+
+// utf8BOM Excel导出的CSV文件开头可能带有BOM
+const utf8BOM = "\xEF\xBB\xBF"
+
+// Row 表格中的一行
+type Row struct {
+	Line  int
+	Cells []string
+}
+
+// Supported 根据文件扩展名判断是否支持
+func Supported(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv", ".xlsx":
+		return true
+	}
+	return false
+}
+
+// Read 根据文件扩展名读取表格，maxRows大于0时限制最多读取的行数
+func Read(name string, r io.Reader, maxRows int) ([]Row, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return ReadCSV(r, maxRows)
+	case ".xlsx":
+		return ReadXLSX(r, maxRows)
+	}
+	return nil, fmt.Errorf("%w:%s", ErrUnsupportedFormat, name)
+}
+
+// ReadCSV 读取CSV，每行的列数可以不同
+func ReadCSV(r io.Reader, maxRows int) ([]Row, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("sheet: read csv: %v", err)
+	}
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte(utf8BOM))))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	var rows []Row
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sheet: read csv: %v", err)
+		}
+		if maxRows > 0 && len(rows) >= maxRows {
+			return nil, fmt.Errorf("%w:max %d", ErrTooManyRows, maxRows)
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, Row{Line: line, Cells: record})
+	}
+	return rows, nil
+}
+
+// ReadXLSX 读取Excel的第一个工作表，空行也会返回，保证行号正确
+func ReadXLSX(r io.Reader, maxRows int) ([]Row, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("sheet: read xlsx: %v", err)
+	}
+	defer f.Close()
+	records, err := f.GetRows(f.GetSheetName(0))
+	if err != nil {
+		return nil, fmt.Errorf("sheet: read xlsx: %v", err)
+	}
+	if maxRows > 0 && len(records) > maxRows {
+		return nil, fmt.Errorf("%w:max %d", ErrTooManyRows, maxRows)
+	}
+	rows := make([]Row, 0, len(records))
+	for i, record := range records {
+		rows = append(rows, Row{Line: i + 1, Cells: record})
+	}
+	return rows, nil
+}
@@ -0,0 +1,53 @@
+package sheet
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestReadCSV(t *testing.T) {
+	data := utf8BOM + "code,remark\nA001,x\n\n\"B\n002\",y\nC003\n"
+	rows, err := Read("coupons.CSV", strings.NewReader(data), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []Row{
+		{Line: 1, Cells: []string{"code", "remark"}},
+		{Line: 2, Cells: []string{"A001", "x"}},
+		{Line: 4, Cells: []string{"B\n002", "y"}},
+		{Line: 6, Cells: []string{"C003"}},
+	}, rows)
+
+	_, err = Read("coupons.csv", strings.NewReader("a\nb\nc\n"), 2)
+	assert.True(t, errors.Is(err, ErrTooManyRows))
+}
+
+func TestReadXLSX(t *testing.T) {
+	f := excelize.NewFile()
+	sheet := f.GetSheetName(0)
+	assert.NoError(t, f.SetCellValue(sheet, "A1", "券码"))
+	assert.NoError(t, f.SetCellValue(sheet, "A2", "A001"))
+	assert.NoError(t, f.SetCellValue(sheet, "A4", "B002"))
+	var buf bytes.Buffer
+	assert.NoError(t, f.Write(&buf))
+
+	rows, err := Read("coupons.xlsx", bytes.NewReader(buf.Bytes()), 0)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 4)
+	assert.Equal(t, Row{Line: 2, Cells: []string{"A001"}}, rows[1])
+	assert.Empty(t, rows[2].Cells)
+	assert.Equal(t, Row{Line: 4, Cells: []string{"B002"}}, rows[3])
+
+	_, err = Read("coupons.xlsx", bytes.NewReader(buf.Bytes()), 3)
+	assert.True(t, errors.Is(err, ErrTooManyRows))
+}
+
+func TestUnsupported(t *testing.T) {
+	assert.True(t, Supported("a.xlsx"))
+	assert.False(t, Supported("a.xls"))
+	_, err := Read("a.txt", strings.NewReader("A001"), 0)
+	assert.True(t, errors.Is(err, ErrUnsupportedFormat))
+}
@@ -0,0 +1,52 @@
+package task
+
+import (
+	"context"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/service"
+	"time"
+)
+
+/**
+ * 超过有效期还没有发放的优惠券修改为已过期，并从缓存中移除
+ * 每10分钟执行一次
+ */
+
+func DoCouponExpireTask() {
+	go ExpireCoupons()
+}
+
+func ExpireCoupons() {
+	expired, err := service.GetAdminService().ExpireCoupons(context.Background())
+	if err != nil {
+		log.Errorf("ExpireCoupons err:%v", err)
+	}
+	for prizeID, num := range expired {
+		log.Infof("ExpireCoupons prize_id=%d expired num:%d", prizeID, num)
+	}
+	time.AfterFunc(10*time.Minute, ExpireCoupons)
+}
+
+/**
+ * 优惠券缓存对账，修正缓存和数据库中可以发放的优惠券之间的差异
+ * 每30分钟执行一次
+ */
+
+func DoCouponReconcileTask() {
+	go ReconcileCoupons()
+}
+
+func ReconcileCoupons() {
+	list, err := service.GetAdminService().ReCacheAllCoupons(context.Background(), constant.CouponReconcileSourceTask, false)
+	if err != nil {
+		log.Errorf("ReconcileCoupons err:%v", err)
+	}
+	for _, reconcile := range list {
+		if reconcile.AddedNum > 0 || reconcile.RemovedNum > 0 {
+			log.Infof("ReconcileCoupons prize_id=%d db_num=%d cache_num=%d added=%d removed=%d",
+				reconcile.PrizeId, reconcile.DbNum, reconcile.CacheNum, reconcile.AddedNum, reconcile.RemovedNum)
+		}
+	}
+	time.AfterFunc(30*time.Minute, ReconcileCoupons)
+}
@@ -3,10 +3,12 @@ package task
 import (
 	"context"
 	"fmt"
+	"lottery_single/configs"
 	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/pkg/middlewares/cache"
 	"lottery_single/internal/pkg/middlewares/log"
 	"lottery_single/internal/pkg/utils"
+	"lottery_single/internal/service"
 	"time"
 )
 
@@ -21,10 +23,16 @@ func DoResetIPLotteryNumsTask() {
 
 func ResetIPLotteryNums() {
 	log.Infof("重置所有的IP抽奖次数")
-	for i := 0; i < constant.IpFrameSize; i++ {
-		key := fmt.Sprintf("day_ip_num_%d", i)
-		if err := cache.GetRedisCli().Delete(context.Background(), key); err != nil {
-			log.Errorf("ResetIPLotteryNums err:%v", err)
+	activityList, err := service.GetActivityService().GetActivityList(context.Background())
+	if err != nil {
+		log.Errorf("ResetIPLotteryNums err:%v", err)
+	}
+	for _, activity := range activityList {
+		for i := 0; i < configs.GetLotteryConfig().IpFrameSize; i++ {
+			key := fmt.Sprintf(constant.IpLotteryDayNumPrefix+"%d_%d", activity.Id, i)
+			if err = cache.GetRedisCli().Delete(context.Background(), key); err != nil {
+				log.Errorf("ResetIPLotteryNums err:%v", err)
+			}
 		}
 	}
 
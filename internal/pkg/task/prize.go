@@ -26,11 +26,24 @@ func DoPrizePlanTask() {
 	go FillAllPrizePool()
 }
 
-// ResetAllPrizePlan 重置所有奖品的发奖计划
+// ResetAllPrizePlan 重置所有活动所有奖品的发奖计划
 func ResetAllPrizePlan() {
 	log.Infof("Resetting all prizes!!!!!")
+	activityList, err := service.GetActivityService().GetActivityList(context.Background())
+	if err != nil {
+		log.Errorf("ResetAllPrizePlan err:%v", err)
+	}
+	for _, activity := range activityList {
+		resetPrizePlan(activity.Id)
+	}
+	// 每5分钟执行一次
+	time.AfterFunc(5*time.Minute, ResetAllPrizePlan)
+}
+
+// resetPrizePlan 重置某个活动所有奖品的发奖计划
+func resetPrizePlan(activityID uint) {
 	adminService := service.GetAdminService()
-	prizeList, err := adminService.GetPrizeList(context.Background())
+	prizeList, err := adminService.GetPrizeList(context.Background(), activityID)
 	if err != nil {
 		log.Errorf("ResetAllPrizePlan err:%v", err)
 	}
@@ -42,30 +55,39 @@ func ResetAllPrizePlan() {
 				log.Errorf("ResetAllPrizePlan err:%v", err)
 			}
 			// 通过读取缓存将db的数据同步到缓存中
-			_, err = adminService.GetPrizeListWithCache(context.Background())
+			_, err = adminService.GetPrizeListWithCache(context.Background(), activityID)
 			if err != nil {
 				log.Errorf("ResetAllPrizePlan err:%v", err)
 			}
 		}
 	}
-	// 每5分钟执行一次
-	time.AfterFunc(5*time.Minute, ResetAllPrizePlan)
 }
 
 func FillAllPrizePool() {
 	log.Infof("FillAllPrizePool!!!!")
-	totalNum, err := fillPrizePool()
+	activityList, err := service.GetActivityService().GetActivityList(context.Background())
 	if err != nil {
 		log.Errorf("FillAllPrizePool err:%v", err)
 	}
-	log.Infof("FillAllPrizePool with num:%d", totalNum)
+	now := time.Now()
+	for _, activity := range activityList {
+		// 活动已经关闭或者结束，不需要再往奖品池放奖品
+		if activity.SysStatus != constant.ActivityStatusNormal || activity.EndTime.Before(now) {
+			continue
+		}
+		totalNum, err := fillPrizePool(activity.Id)
+		if err != nil {
+			log.Errorf("FillAllPrizePool activity_id=%d err:%v", activity.Id, err)
+		}
+		log.Infof("FillAllPrizePool activity_id=%d with num:%d", activity.Id, totalNum)
+	}
 	time.AfterFunc(time.Minute, FillAllPrizePool)
 }
 
-func fillPrizePool() (int, error) {
+func fillPrizePool(activityID uint) (int, error) {
 	totalNum := 0
 	adminService := service.GetAdminService()
-	prizeList, err := adminService.GetPrizeList(context.Background())
+	prizeList, err := adminService.GetPrizeList(context.Background(), activityID)
 	now := time.Now()
 	if err != nil {
 		log.Errorf("FillPrizePool err:%v", err)
@@ -110,7 +132,7 @@ func fillPrizePool() (int, error) {
 			index = i + 1
 		}
 		if prizeNum > 0 {
-			incrPrizePool(prize.Id, prizeNum)
+			incrPrizePool(activityID, prize.Id, prizeNum)
 			totalNum += prizeNum
 		}
 		// 更新发奖计划
@@ -127,8 +149,9 @@ func fillPrizePool() (int, error) {
 				return 0, fmt.Errorf("FillPrizePool|Marshal:%v", err)
 			}
 			updatePrize := &model.Prize{
-				Id:        prize.Id,
-				PrizePlan: string(bytes),
+				Id:         prize.Id,
+				ActivityId: activityID,
+				PrizePlan:  string(bytes),
 			}
 			if err = adminService.UpdateDbPrizeWithCache(context.Background(), gormcli.GetDB(), updatePrize, "prize_plan"); err != nil {
 				log.Errorf("FillPrizePool|UpdateDbPrizeWithCache err:%v", err)
@@ -137,7 +160,7 @@ func fillPrizePool() (int, error) {
 		}
 		if totalNum > 0 {
 			// 将更新后的数据加载到缓存中
-			_, err = adminService.GetPrizeListWithCache(context.Background())
+			_, err = adminService.GetPrizeListWithCache(context.Background(), activityID)
 			if err != nil {
 				log.Errorf("FillPrizePool|GetPrizeListWithCache err:%v", err)
 				return 0, fmt.Errorf("FillPrizePool|GetPrizeListWithCache:%v", err)
@@ -147,9 +170,9 @@ func fillPrizePool() (int, error) {
 	return totalNum, nil
 }
 
-// incrPrizePool 根据计划数据，往奖品池增加奖品数量
-func incrPrizePool(prizeID uint, num int) (int, error) {
-	key := constant.PrizePoolCacheKey
+// incrPrizePool 根据计划数据，往活动的奖品池增加奖品数量
+func incrPrizePool(activityID uint, prizeID uint, num int) (int, error) {
+	key := fmt.Sprintf(constant.PrizePoolCacheKeyPrefix+"%d", activityID)
 	idStr := strconv.Itoa(int(prizeID))
 	cnt, err := cache.GetRedisCli().HIncrBy(context.Background(), key, idStr, int64(num))
 	if err != nil {
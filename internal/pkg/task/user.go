@@ -3,10 +3,12 @@ package task
 import (
 	"context"
 	"fmt"
+	"lottery_single/configs"
 	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/pkg/middlewares/cache"
 	"lottery_single/internal/pkg/middlewares/log"
 	"lottery_single/internal/pkg/utils"
+	"lottery_single/internal/service"
 	"time"
 )
 
@@ -20,10 +22,16 @@ func DoResetUserLotteryNumsTask() {
 
 func ResetUserLotteryNums() {
 	log.Infof("重置今日用户抽奖次数")
-	for i := 0; i < constant.IpFrameSize; i++ {
-		key := fmt.Sprintf(constant.UserLotteryDayNumPrefix+"%d", i)
-		if err := cache.GetRedisCli().Delete(context.Background(), key); err != nil {
-			log.Errorf("ResetIPLotteryNums err:%v", err)
+	activityList, err := service.GetActivityService().GetActivityList(context.Background())
+	if err != nil {
+		log.Errorf("ResetUserLotteryNums err:%v", err)
+	}
+	for _, activity := range activityList {
+		for i := 0; i < configs.GetLotteryConfig().UserFrameSize; i++ {
+			key := fmt.Sprintf(constant.UserLotteryDayNumPrefix+"%d_%d", activity.Id, i)
+			if err = cache.GetRedisCli().Delete(context.Background(), key); err != nil {
+				log.Errorf("ResetUserLotteryNums err:%v", err)
+			}
 		}
 	}
 
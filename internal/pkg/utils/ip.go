@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ipv6LimitBits IPv6的次数限制按照/64网段统计，一个用户通常可以使用整个/64网段的地址
+const ipv6LimitBits = 64
+
+// ParseIP 解析IPv4或者IPv6地址，IPv4映射的IPv6地址转换为IPv4
+func ParseIP(ip string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// NormalizeIP IP转换为规范的格式，相同的IP只有一种写法，不是合法的IP时原样返回
+func NormalizeIP(ip string) string {
+	addr, ok := ParseIP(ip)
+	if !ok {
+		return ip
+	}
+	return addr.WithZone("").String()
+}
+
+// ParseIPRange 解析单个IP或者CIDR网段，返回规范化之后的网段，单个IP为/32或者/128
+func ParseIPRange(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, ok := ParseIP(s)
+		if !ok || addr.Zone() != "" {
+			return netip.Prefix{}, fmt.Errorf("invalid ip %q", s)
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid ip range %q", s)
+	}
+	// IPv4映射的IPv6网段转换为IPv4网段
+	if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// FormatIPRange 网段的规范格式，单个IP不带前缀长度
+func FormatIPRange(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
+
+// IsIPRange 黑名单中的记录是否是网段
+func IsIPRange(s string) bool {
+	return strings.Contains(s, "/")
+}
+
+// IPLimitKey IP抽奖次数统计使用的key，IPv4按照单个地址，IPv6按照/64网段
+func IPLimitKey(ip string) string {
+	addr, ok := ParseIP(ip)
+	if !ok || addr.Is4() {
+		return NormalizeIP(ip)
+	}
+	prefix, _ := addr.WithZone("").Prefix(ipv6LimitBits)
+	return prefix.String()
+}
+
+// IPShard IP抽奖次数缓存的分段，IPv4和 Ip4toInt 的结果保持一致，IPv6使用/64网段的哈希
+func IPShard(ip string, size int) int64 {
+	if size <= 0 {
+		return 0
+	}
+	addr, ok := ParseIP(ip)
+	if !ok || addr.Is4() {
+		return Ip4toInt(NormalizeIP(ip)) % int64(size)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(IPLimitKey(ip)))
+	return int64(h.Sum32()) % int64(size)
+}
+
+// ParseIPRanges 解析一组IP或者CIDR网段，有一个不合法时返回错误
+func ParseIPRanges(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		prefix, err := ParseIPRange(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// ipInRanges IP是否在任意一个网段中
+func ipInRanges(addr netip.Addr, ranges []netip.Prefix) bool {
+	for _, prefix := range ranges {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP 根据连接的地址和代理转发的请求头获取客户端的IP
+// 只有连接来自可信代理时才使用 X-Forwarded-For 和 X-Real-IP，
+// X-Forwarded-For 从右向左跳过可信代理，第一个不可信的地址就是客户端，客户端自己填写的部分不会被采用
+func ClientIP(remoteAddr string, header http.Header, trusted []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(remoteAddr))
+	if err != nil {
+		host = remoteAddr
+	}
+	remote, ok := ParseIP(host)
+	if !ok {
+		return NormalizeIP(host)
+	}
+	if !ipInRanges(remote, trusted) {
+		return remote.WithZone("").String()
+	}
+	var hops []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := ParseIP(hops[i])
+		if !ok {
+			// 格式不对的地址不能再往前追溯，使用最后一个可信的结果
+			return client.WithZone("").String()
+		}
+		client = addr
+		if !ipInRanges(addr, trusted) {
+			return addr.WithZone("").String()
+		}
+	}
+	if len(hops) > 0 {
+		return client.WithZone("").String()
+	}
+	if addr, ok := ParseIP(header.Get("X-Real-IP")); ok {
+		return addr.WithZone("").String()
+	}
+	return remote.WithZone("").String()
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIPRange(t *testing.T) {
+	cases := map[string]string{
+		"1.2.3.4":             "1.2.3.4",
+		" 1.2.3.4 ":           "1.2.3.4",
+		"::ffff:1.2.3.4":      "1.2.3.4",
+		"10.1.2.3/16":         "10.1.0.0/16",
+		"2001:DB8::1":         "2001:db8::1",
+		"2001:db8:0:0:1::/64": "2001:db8::/64",
+		"::ffff:10.0.0.0/104": "10.0.0.0/8",
+		"2001:db8::1/128":     "2001:db8::1",
+		"192.168.0.1/32":      "192.168.0.1",
+	}
+	for in, want := range cases {
+		prefix, err := ParseIPRange(in)
+		assert.Nil(t, err, in)
+		assert.Equal(t, want, FormatIPRange(prefix), in)
+	}
+	for _, in := range []string{"", "1.2.3", "1.2.3.4/33", "fe80::1%eth0", "abc/8"} {
+		_, err := ParseIPRange(in)
+		assert.NotNil(t, err, in)
+	}
+}
+
+func TestIPLimitKey(t *testing.T) {
+	assert.Equal(t, "1.2.3.4", IPLimitKey("::ffff:1.2.3.4"))
+	assert.Equal(t, "2001:db8:1:2::/64", IPLimitKey("2001:db8:1:2:aaaa::1"))
+	assert.Equal(t, IPLimitKey("2001:db8:1:2::1"), IPLimitKey("2001:db8:1:2:ffff::9"))
+	assert.Equal(t, "unknown", IPLimitKey("unknown"))
+}
+
+func TestIPShard(t *testing.T) {
+	// IPv4的分段和之前保持一致，升级之后当天的次数不会丢失
+	assert.Equal(t, Ip4toInt("1.2.3.5")%2, IPShard("1.2.3.5", 2))
+	assert.Equal(t, IPShard("2001:db8::1", 4), IPShard("2001:db8::2", 4))
+	shard := IPShard("2001:db8::1", 4)
+	assert.True(t, shard >= 0 && shard < 4)
+	assert.Equal(t, int64(0), IPShard("1.2.3.5", 0))
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseIPRanges([]string{"10.0.0.0/8", "::1"})
+	assert.Nil(t, err)
+	header := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Add(kv[i], kv[i+1])
+		}
+		return h
+	}
+	cases := []struct {
+		remote string
+		header http.Header
+		want   string
+	}{
+		// 不是可信代理时忽略转发的请求头
+		{"1.2.3.4:5678", header("X-Forwarded-For", "9.9.9.9"), "1.2.3.4"},
+		{"10.0.0.1:80", header("X-Forwarded-For", "9.9.9.9, 5.6.7.8, 10.0.0.2"), "5.6.7.8"},
+		{"10.0.0.1:80", header("X-Forwarded-For", "9.9.9.9", "X-Forwarded-For", "10.0.0.3"), "9.9.9.9"},
+		{"10.0.0.1:80", header("X-Forwarded-For", "10.0.0.3"), "10.0.0.3"},
+		{"10.0.0.1:80", header("X-Forwarded-For", "bad, 10.0.0.3"), "10.0.0.3"},
+		{"10.0.0.1:80", header("X-Real-IP", "5.6.7.8"), "5.6.7.8"},
+		{"10.0.0.1:80", header(), "10.0.0.1"},
+		{"[::1]:80", header("X-Forwarded-For", "2001:db8::1"), "2001:db8::1"},
+		{"[2001:db8::2]:80", header("X-Real-IP", "5.6.7.8"), "2001:db8::2"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, ClientIP(c.remote, c.header, trusted), c.remote)
+	}
+	_, err = ParseIPRanges([]string{"10.0.0.0/8", "bad"})
+	assert.NotNil(t, err)
+}
@@ -77,6 +77,15 @@ func Random(max int) int {
 	return randomGenerator.Intn(max)
 }
 
+// Random64 返回一个 [0, max) 范围内的随机整数，抽奖编码空间超过int32时使用
+// 使用全局的rand，可以在多个协程中并发调用
+func Random64(max int64) int64 {
+	if max <= 0 {
+		return 0
+	}
+	return rand.Int63n(max)
+}
+
 // encrypt 对一个字符串进行加密
 func encrypt(key, text []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
@@ -231,6 +240,30 @@ func GetInt64(i interface{}, d int64) int64 {
 	return d
 }
 
+// GetFloat64 从接口安全获取到浮点数类型
+func GetFloat64(i interface{}, d float64) float64 {
+	if i == nil {
+		return d
+	}
+	switch v := i.(type) {
+	case string:
+		num, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return d
+		}
+		return num
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return d
+}
+
 // GetString 从接口安全获取到字符串类型
 func GetString(str interface{}, d string) string {
 	if str == nil {
@@ -254,6 +287,15 @@ func GetInt64FromMap(dm map[string]interface{}, key string, d int64) int64 {
 	return GetInt64(data, d)
 }
 
+// GetFloat64FromMap 从map中得到指定的key
+func GetFloat64FromMap(dm map[string]interface{}, key string, d float64) float64 {
+	data, ok := dm[key]
+	if !ok {
+		return d
+	}
+	return GetFloat64(data, d)
+}
+
 func GetStringFromMap(dm map[string]interface{}, key string, d string) string {
 	data, ok := dm[key]
 	if !ok {
@@ -271,8 +313,10 @@ func GetTodayIntDay() int {
 
 // JWTClaims 自定义格式内容
 type JWTClaims struct {
-	UserID         uint   `json:"user_id"`
-	UserName       string `json:"user_name"`
+	UserID         uint     `json:"user_id"`
+	UserName       string   `json:"user_name"`
+	Roles          []string `json:"roles"`      // 管理后台角色，普通用户为空
+	TokenType      string   `json:"token_type"` // access 或 refresh
 	StandardClaims jwt.StandardClaims
 }
 
@@ -280,34 +324,29 @@ func (j JWTClaims) Valid() error {
 	return nil
 }
 
-// GenerateJwtToken 生成token
-func GenerateJwtToken(secret string, issuer string, userId uint, userName string) (string, error) {
+// GenerateJwtToken 生成token，密钥ID写入header，校验时根据密钥ID选择密钥
+func GenerateJwtToken(kid string, secret string, claims JWTClaims) (string, error) {
 	hmacSampleSecret := []byte(secret) //密钥，不能泄露
-	token := jwt.New(jwt.SigningMethodHS256)
-	nowTime := time.Now().Unix()
-	token.Claims = JWTClaims{
-		UserID:   userId,
-		UserName: userName,
-		StandardClaims: jwt.StandardClaims{
-			NotBefore: nowTime,                                             // 签名生效时间
-			ExpiresAt: time.Now().Add(constant.TokenExpireDuration).Unix(), // 签名过期时间
-			Issuer:    issuer,                                              // 签名颁发者
-		},
-	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = kid
 	tokenString, err := token.SignedString(hmacSampleSecret)
 	return tokenString, err
 }
 
-// ParseJwtToken 解析token
-func ParseJwtToken(tokenString string, secret string) (*JWTClaims, error) {
-	var hmacSampleSecret = []byte(secret)
-	//前面例子生成的token
+// ParseJwtToken 解析token，getSecret根据header中的密钥ID返回密钥，过期时间由调用方校验
+func ParseJwtToken(tokenString string, getSecret func(kid string) (string, bool)) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(t *jwt.Token) (interface{}, error) {
-		return hmacSampleSecret, nil
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		secret, ok := getSecret(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		return []byte(secret), nil
 	})
-
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 	claims := token.Claims.(*JWTClaims)
@@ -0,0 +1,141 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/cache"
+	"lottery_single/internal/pkg/middlewares/log"
+	"time"
+)
+
+type ActivityRepo struct {
+}
+
+func NewActivityRepo() *ActivityRepo {
+	return &ActivityRepo{}
+}
+
+func (r *ActivityRepo) Get(db *gorm.DB, id uint) (*model.Activity, error) {
+	activity := &model.Activity{}
+	err := db.Model(&model.Activity{}).Where("id = ?", id).First(activity).Error
+	if err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ActivityRepo|Get:%v", err)
+	}
+	return activity, nil
+}
+
+// GetWithCache 优先从缓存获取活动信息，缓存没有再从db获取并同步到缓存
+func (r *ActivityRepo) GetWithCache(db *gorm.DB, id uint) (*model.Activity, error) {
+	activity, err := r.GetByCache(id)
+	if err == nil && activity != nil {
+		return activity, nil
+	}
+	activity, err = r.Get(db, id)
+	if err != nil {
+		return nil, fmt.Errorf("ActivityRepo|GetWithCache:%v", err)
+	}
+	if activity == nil {
+		return nil, nil
+	}
+	if err = r.SetByCache(activity); err != nil {
+		return nil, fmt.Errorf("ActivityRepo|GetWithCache:%v", err)
+	}
+	return activity, nil
+}
+
+func (r *ActivityRepo) GetAll(db *gorm.DB) ([]*model.Activity, error) {
+	var activities []*model.Activity
+	err := db.Model(&model.Activity{}).Order("id asc").Find(&activities).Error
+	if err != nil {
+		return nil, fmt.Errorf("ActivityRepo|GetAll:%v", err)
+	}
+	return activities, nil
+}
+
+func (r *ActivityRepo) Create(db *gorm.DB, activity *model.Activity) error {
+	err := db.Model(&model.Activity{}).Create(activity).Error
+	if err != nil {
+		return fmt.Errorf("ActivityRepo|Create:%v", err)
+	}
+	return nil
+}
+
+func (r *ActivityRepo) Update(db *gorm.DB, activity *model.Activity, cols ...string) error {
+	var err error
+	if len(cols) == 0 {
+		err = db.Model(activity).Updates(activity).Error
+	} else {
+		err = db.Model(activity).Select(cols).Updates(activity).Error
+	}
+	if err != nil {
+		return fmt.Errorf("ActivityRepo|Update:%v", err)
+	}
+	return nil
+}
+
+func (r *ActivityRepo) UpdateWithCache(db *gorm.DB, activity *model.Activity, cols ...string) error {
+	// 先更新数据库再删除缓存，先删除缓存时并发的查询会把旧数据重新写入缓存
+	if err := r.Update(db, activity, cols...); err != nil {
+		return err
+	}
+	if err := r.UpdateByCache(activity); err != nil {
+		return fmt.Errorf("ActivityRepo|UpdateWithCache:%v", err)
+	}
+	return nil
+}
+
+// SetByCache 活动信息保存到缓存
+func (r *ActivityRepo) SetByCache(activity *model.Activity) error {
+	if activity == nil || activity.Id <= 0 {
+		return nil
+	}
+	bytes, err := json.Marshal(activity)
+	if err != nil {
+		log.Errorf("ActivityRepo|SetByCache marshal err:%v", err)
+		return fmt.Errorf("ActivityRepo|SetByCache:%v", err)
+	}
+	key := fmt.Sprintf(constant.ActivityCacheKeyPrefix+"%d", activity.Id)
+	if err = cache.GetRedisCli().Set(context.Background(), key, string(bytes),
+		time.Second*time.Duration(constant.ActivityCacheTime)); err != nil {
+		log.Errorf("ActivityRepo|SetByCache set cache err:%v", err)
+		return fmt.Errorf("ActivityRepo|SetByCache:%v", err)
+	}
+	return nil
+}
+
+// GetByCache 从缓存获取活动信息
+func (r *ActivityRepo) GetByCache(id uint) (*model.Activity, error) {
+	key := fmt.Sprintf(constant.ActivityCacheKeyPrefix+"%d", id)
+	value, ok, err := cache.GetRedisCli().Get(context.Background(), key)
+	if err != nil {
+		return nil, fmt.Errorf("ActivityRepo|GetByCache:%v", err)
+	}
+	if !ok || value == "" {
+		return nil, nil
+	}
+	activity := &model.Activity{}
+	if err = json.Unmarshal([]byte(value), activity); err != nil {
+		log.Errorf("ActivityRepo|GetByCache unmarshal err:%v", err)
+		return nil, fmt.Errorf("ActivityRepo|GetByCache:%v", err)
+	}
+	return activity, nil
+}
+
+// UpdateByCache 活动信息更新，直接清空缓存
+func (r *ActivityRepo) UpdateByCache(activity *model.Activity) error {
+	if activity == nil || activity.Id <= 0 {
+		return nil
+	}
+	key := fmt.Sprintf(constant.ActivityCacheKeyPrefix+"%d", activity.Id)
+	if err := cache.GetRedisCli().Delete(context.Background(), key); err != nil {
+		return fmt.Errorf("ActivityRepo|UpdateByCache:%v", err)
+	}
+	return nil
+}
@@ -5,12 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"lottery_single/internal/model"
 	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/pkg/middlewares/cache"
 	"lottery_single/internal/pkg/middlewares/log"
 	"lottery_single/internal/pkg/utils"
 	"strconv"
+	"time"
 )
 
 type BlackIpRepo struct {
@@ -90,6 +92,16 @@ func (r *BlackIpRepo) GetAll(db *gorm.DB) ([]*model.BlackIp, error) {
 	return BlackIps, nil
 }
 
+// GetRanges 获取所有网段的黑名单，包括已经过期的网段
+func (r *BlackIpRepo) GetRanges(db *gorm.DB) ([]*model.BlackIp, error) {
+	var blackIps []*model.BlackIp
+	err := db.Model(&model.BlackIp{}).Where("ip LIKE ?", "%/%").Find(&blackIps).Error
+	if err != nil {
+		return nil, fmt.Errorf("BlackIpRepo|GetRanges:%v", err)
+	}
+	return blackIps, nil
+}
+
 func (r *BlackIpRepo) CountAll(db *gorm.DB) (int64, error) {
 	var num int64
 	err := db.Model(&model.BlackIp{}).Count(&num).Error
@@ -107,6 +119,35 @@ func (r *BlackIpRepo) Create(db *gorm.DB, BlackIp *model.BlackIp) error {
 	return nil
 }
 
+// Upsert IP不在黑名单中时新建，已经存在时只更新cols中的字段，依赖ip的唯一索引，返回是否新建
+func (r *BlackIpRepo) Upsert(db *gorm.DB, blackIp *model.BlackIp, cols ...string) (bool, error) {
+	result := db.Model(&model.BlackIp{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "ip"}},
+		DoUpdates: clause.AssignmentColumns(append(cols, "sys_updated")),
+	}).Create(blackIp)
+	if result.Error != nil {
+		return false, fmt.Errorf("BlackIpRepo|Upsert:%v", result.Error)
+	}
+	// MySQL新建时影响1行，更新时影响2行，值没有变化时影响0行
+	return result.RowsAffected == 1, nil
+}
+
+// Extend 拉黑IP，已经在黑名单中时只会延长到期时间，不会缩短之前更长的拉黑，依赖ip的唯一索引
+func (r *BlackIpRepo) Extend(db *gorm.DB, blackIp *model.BlackIp) error {
+	err := db.Model(&model.BlackIp{}).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "ip"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"black_time": gorm.Expr("CASE WHEN black_time > ? THEN black_time ELSE ? END",
+				blackIp.BlackTime, blackIp.BlackTime),
+			"sys_updated": time.Now(),
+		}),
+	}).Create(blackIp).Error
+	if err != nil {
+		return fmt.Errorf("BlackIpRepo|Extend:%v", err)
+	}
+	return nil
+}
+
 func (r *BlackIpRepo) Delete(db *gorm.DB, id uint) error {
 	BlackIp := &model.BlackIp{Id: id}
 	if err := db.Model(&model.BlackIp{}).Delete(BlackIp).Error; err != nil {
@@ -115,18 +156,27 @@ func (r *BlackIpRepo) Delete(db *gorm.DB, id uint) error {
 	return nil
 }
 
-func (r *BlackIpRepo) DeleteWithCache(db *gorm.DB, id uint) error {
-	blackIp := &model.BlackIp{Id: id}
-	if err := db.Model(&model.BlackIp{}).Delete(blackIp).Error; err != nil {
-		return fmt.Errorf("BlackIpRepo|Delete:%v")
+// DeleteWithCache 删除黑名单记录之后删除缓存，返回删除的记录，记录不存在时返回nil
+func (r *BlackIpRepo) DeleteWithCache(db *gorm.DB, id uint) (*model.BlackIp, error) {
+	blackIp := &model.BlackIp{}
+	err := db.Model(&model.BlackIp{}).Where("id = ?", id).First(blackIp).Error
+	if err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("BlackIpRepo|DeleteWithCache:%v", err)
 	}
-	return nil
+	if err = db.Model(&model.BlackIp{}).Delete(blackIp).Error; err != nil {
+		return nil, fmt.Errorf("BlackIpRepo|DeleteWithCache:%v", err)
+	}
+	if err = r.UpdateByCache(blackIp); err != nil {
+		return nil, fmt.Errorf("BlackIpRepo|DeleteWithCache:%v", err)
+	}
+	return blackIp, nil
 }
 
+// Update 先更新数据库再删除缓存，避免删除缓存之后被旧数据重新写入
 func (r *BlackIpRepo) Update(db *gorm.DB, ip string, blackIp *model.BlackIp, cols ...string) error {
-	if err := r.UpdateByCache(&model.BlackIp{Ip: ip}); err != nil {
-		return fmt.Errorf("BlackIpRepo|UpdateWithCache:%v", err)
-	}
 	var err error
 	if len(cols) == 0 {
 		err = db.Model(blackIp).Where("ip=?", ip).Updates(blackIp).Error
@@ -136,13 +186,13 @@ func (r *BlackIpRepo) Update(db *gorm.DB, ip string, blackIp *model.BlackIp, col
 	if err != nil {
 		return fmt.Errorf("BlackIpRepo|Update:%v", err)
 	}
+	if err = r.UpdateByCache(&model.BlackIp{Ip: ip}); err != nil {
+		return fmt.Errorf("BlackIpRepo|Update:%v", err)
+	}
 	return nil
 }
 
 func (r *BlackIpRepo) UpdateWithCache(db *gorm.DB, ip string, blackIp *model.BlackIp, cols ...string) error {
-	if err := r.UpdateByCache(&model.BlackIp{Ip: ip}); err != nil {
-		return fmt.Errorf("BlackIpRepo|UpdateWithCache:%v", err)
-	}
 	var err error
 	if len(cols) == 0 {
 		err = db.Model(blackIp).Where("ip=?", ip).Updates(blackIp).Error
@@ -150,7 +200,10 @@ func (r *BlackIpRepo) UpdateWithCache(db *gorm.DB, ip string, blackIp *model.Bla
 		err = db.Model(blackIp).Where("ip=?", ip).Select(cols).Updates(blackIp).Error
 	}
 	if err != nil {
-		return fmt.Errorf("BlackIpRepo|Update:%v", err)
+		return fmt.Errorf("BlackIpRepo|UpdateWithCache:%v", err)
+	}
+	if err = r.UpdateByCache(&model.BlackIp{Ip: ip}); err != nil {
+		return fmt.Errorf("BlackIpRepo|UpdateWithCache:%v", err)
 	}
 	return nil
 }
@@ -223,11 +276,12 @@ func (s *BlackIpRepo) GetByCache(ip string) (*model.BlackIp, error) {
 	return blackIp, nil
 }
 
+// UpdateByCache 删除IP黑名单缓存，抽奖时缓存缺失会从数据库重新加载
 func (r *BlackIpRepo) UpdateByCache(blackIp *model.BlackIp) error {
 	if blackIp == nil || blackIp.Ip == "" {
-		return fmt.Errorf("BlackIpRepo|UpdateByCache invalid blackUser")
+		return fmt.Errorf("BlackIpRepo|UpdateByCache invalid blackIp")
 	}
-	key := fmt.Sprintf(constant.UserCacheKeyPrefix+"%s", blackIp.Ip)
+	key := fmt.Sprintf(constant.IpCacheKeyPrefix+"%s", blackIp.Ip)
 	if err := cache.GetRedisCli().Delete(context.Background(), key); err != nil {
 		return fmt.Errorf("BlackIpRepo|UpdateByCache:%v", err)
 	}
@@ -5,12 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"lottery_single/internal/model"
 	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/pkg/middlewares/cache"
 	"lottery_single/internal/pkg/middlewares/log"
 	"lottery_single/internal/pkg/utils"
 	"strconv"
+	"time"
 )
 
 type BlackUserRepo struct {
@@ -45,7 +47,7 @@ func (r *BlackUserRepo) GetByUserID(db *gorm.DB, uid uint) (*model.BlackUser, er
 	blackUser := &model.BlackUser{
 		UserId: uid,
 	}
-	err := db.Model(&model.BlackUser{}).First(blackUser).Error
+	err := db.Model(&model.BlackUser{}).Where("user_id = ?", uid).First(blackUser).Error
 	if err != nil {
 		if err.Error() == gorm.ErrRecordNotFound.Error() {
 			return nil, nil
@@ -55,6 +57,20 @@ func (r *BlackUserRepo) GetByUserID(db *gorm.DB, uid uint) (*model.BlackUser, er
 	return blackUser, nil
 }
 
+// GetByUserIDForUpdate 在事务中查询并锁住用户的黑名单记录，不存在时返回nil
+func (r *BlackUserRepo) GetByUserIDForUpdate(db *gorm.DB, uid uint) (*model.BlackUser, error) {
+	blackUser := &model.BlackUser{}
+	err := db.Model(&model.BlackUser{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", uid).First(blackUser).Error
+	if err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("BlackUserRepo|GetByUserIDForUpdate:%v", err)
+	}
+	return blackUser, nil
+}
+
 func (r *BlackUserRepo) GetByUserIDWithCache(db *gorm.DB, uid uint) (*model.BlackUser, error) {
 	// 优先从缓存获取
 	blackUser, err := r.GetByCache(uid)
@@ -66,7 +82,7 @@ func (r *BlackUserRepo) GetByUserIDWithCache(db *gorm.DB, uid uint) (*model.Blac
 	blackUser = &model.BlackUser{
 		UserId: uid,
 	}
-	err = db.Model(&model.BlackUser{}).First(blackUser).Error
+	err = db.Model(&model.BlackUser{}).Where("user_id = ?", uid).First(blackUser).Error
 	if err != nil {
 		if err.Error() == gorm.ErrRecordNotFound.Error() {
 			return nil, nil
@@ -89,6 +105,35 @@ func (r *BlackUserRepo) GetAll(db *gorm.DB) ([]*model.BlackUser, error) {
 	return BlackUsers, nil
 }
 
+// BlackUserQuery 用户黑名单的查询条件
+type BlackUserQuery struct {
+	UserID uint
+	Active *bool     // true只查询还在有效期内的，false只查询已经过期或者洗白的，空表示全部
+	Now    time.Time // 判断是否有效的时间
+}
+
+// GetList 按照ID倒序分页查询用户黑名单，cursor为上一页最后一条记录的ID，0表示第一页
+func (r *BlackUserRepo) GetList(db *gorm.DB, query *BlackUserQuery, cursor uint, limit int) ([]*model.BlackUser, error) {
+	var list []*model.BlackUser
+	db = db.Model(&model.BlackUser{})
+	if cursor > 0 {
+		db = db.Where("id < ?", cursor)
+	}
+	if query.UserID > 0 {
+		db = db.Where("user_id = ?", query.UserID)
+	}
+	if query.Active != nil && *query.Active {
+		db = db.Where("black_time > ?", query.Now)
+	}
+	if query.Active != nil && !*query.Active {
+		db = db.Where("black_time <= ?", query.Now)
+	}
+	if err := db.Order("id desc").Limit(limit).Find(&list).Error; err != nil {
+		return nil, fmt.Errorf("BlackUserRepo|GetList:%v", err)
+	}
+	return list, nil
+}
+
 func (r *BlackUserRepo) CountAll(db *gorm.DB) (int64, error) {
 	var num int64
 	err := db.Model(&model.BlackUser{}).Count(&num).Error
@@ -106,6 +151,34 @@ func (r *BlackUserRepo) Create(db *gorm.DB, BlackUser *model.BlackUser) error {
 	return nil
 }
 
+// Upsert 用户不在黑名单中时新建，已经存在时只更新cols中的字段，依赖user_id的唯一索引
+func (r *BlackUserRepo) Upsert(db *gorm.DB, blackUser *model.BlackUser, cols ...string) error {
+	err := db.Model(&model.BlackUser{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns(append(cols, "sys_updated")),
+	}).Create(blackUser).Error
+	if err != nil {
+		return fmt.Errorf("BlackUserRepo|Upsert:%v", err)
+	}
+	return nil
+}
+
+// Extend 拉黑用户，已经在黑名单中时只会延长到期时间，不会缩短之前更长的拉黑，依赖user_id的唯一索引
+func (r *BlackUserRepo) Extend(db *gorm.DB, blackUser *model.BlackUser) error {
+	err := db.Model(&model.BlackUser{}).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"black_time": gorm.Expr("CASE WHEN black_time > ? THEN black_time ELSE ? END",
+				blackUser.BlackTime, blackUser.BlackTime),
+			"sys_updated": time.Now(),
+		}),
+	}).Create(blackUser).Error
+	if err != nil {
+		return fmt.Errorf("BlackUserRepo|Extend:%v", err)
+	}
+	return nil
+}
+
 func (r *BlackUserRepo) Delete(db *gorm.DB, id uint) error {
 	BlackUser := &model.BlackUser{Id: id}
 	if err := db.Model(&model.BlackUser{}).Delete(BlackUser).Error; err != nil {
@@ -0,0 +1,35 @@
+package repo
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"lottery_single/internal/model"
+)
+
+type BlackUserLogRepo struct {
+}
+
+func NewBlackUserLogRepo() *BlackUserLogRepo {
+	return &BlackUserLogRepo{}
+}
+
+// Create 记录一次拉黑或者洗白操作
+func (r *BlackUserLogRepo) Create(db *gorm.DB, blackUserLog *model.BlackUserLog) error {
+	if err := db.Model(&model.BlackUserLog{}).Create(blackUserLog).Error; err != nil {
+		return fmt.Errorf("BlackUserLogRepo|Create:%v", err)
+	}
+	return nil
+}
+
+// GetListByUser 按照ID倒序分页查询用户的操作记录，cursor为上一页最后一条记录的ID，0表示第一页
+func (r *BlackUserLogRepo) GetListByUser(db *gorm.DB, uid uint, cursor uint, limit int) ([]*model.BlackUserLog, error) {
+	var list []*model.BlackUserLog
+	db = db.Model(&model.BlackUserLog{}).Where("user_id = ?", uid)
+	if cursor > 0 {
+		db = db.Where("id < ?", cursor)
+	}
+	if err := db.Order("id desc").Limit(limit).Find(&list).Error; err != nil {
+		return nil, fmt.Errorf("BlackUserLogRepo|GetListByUser:%v", err)
+	}
+	return list, nil
+}
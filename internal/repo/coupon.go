@@ -10,6 +10,7 @@ import (
 	"lottery_single/internal/pkg/middlewares/cache"
 	"lottery_single/internal/pkg/middlewares/log"
 	"strconv"
+	"time"
 )
 
 type CouponRepo struct {
@@ -73,10 +74,33 @@ func (r *CouponRepo) Create(db *gorm.DB, coupon *model.Coupon) error {
 	return nil
 }
 
+// CreateBatch 批量写入优惠券，一条SQL写入，失败时整批都不写入
+func (r *CouponRepo) CreateBatch(db *gorm.DB, coupons []*model.Coupon) error {
+	if len(coupons) == 0 {
+		return nil
+	}
+	if err := db.Model(&model.Coupon{}).Create(coupons).Error; err != nil {
+		return fmt.Errorf("CouponRepo|CreateBatch:%v", err)
+	}
+	return nil
+}
+
+// GetExistCodes 返回codes中已经存在的优惠券编码，codes数量由调用方控制
+func (r *CouponRepo) GetExistCodes(db *gorm.DB, codes []string) ([]string, error) {
+	var exists []string
+	if len(codes) == 0 {
+		return exists, nil
+	}
+	if err := db.Model(&model.Coupon{}).Where("code in ?", codes).Pluck("code", &exists).Error; err != nil {
+		return nil, fmt.Errorf("CouponRepo|GetExistCodes:%v", err)
+	}
+	return exists, nil
+}
+
 func (r *CouponRepo) Delete(db *gorm.DB, id uint) error {
 	coupon := &model.Coupon{Id: id}
 	if err := db.Model(&model.Coupon{}).Delete(coupon).Error; err != nil {
-		return fmt.Errorf("CouponRepo|Delete:%v")
+		return fmt.Errorf("CouponRepo|Delete:%v", err)
 	}
 	return nil
 }
@@ -107,6 +131,81 @@ func (r *CouponRepo) UpdateByCode(db *gorm.DB, code string, coupon *model.Coupon
 	return nil
 }
 
+// UpdateStatusByCode 修改优惠券状态，优惠券不是from状态时返回false
+func (r *CouponRepo) UpdateStatusByCode(db *gorm.DB, code string, from, to uint) (bool, error) {
+	res := db.Model(&model.Coupon{}).Where("code = ? and sys_status = ?", code, from).Update("sys_status", to)
+	if res.Error != nil {
+		return false, fmt.Errorf("CouponRepo|UpdateStatusByCode:%v", res.Error)
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// IssueByCode 发放优惠券，只有正常并且没有过期的优惠券可以发放，返回false表示优惠券已经不能发放
+func (r *CouponRepo) IssueByCode(db *gorm.DB, code string, now time.Time) (bool, error) {
+	res := db.Model(&model.Coupon{}).Where("code = ? and sys_status = ?", code, constant.CouponStatusNormal).
+		Where("valid_to is null or valid_to > ?", now).Update("sys_status", constant.CouponStatusIssued)
+	if res.Error != nil {
+		return false, fmt.Errorf("CouponRepo|IssueByCode:%v", res.Error)
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// GetByCodes 根据编码查询优惠券
+func (r *CouponRepo) GetByCodes(db *gorm.DB, codes []string) ([]*model.Coupon, error) {
+	var coupons []*model.Coupon
+	if len(codes) == 0 {
+		return coupons, nil
+	}
+	if err := db.Model(&model.Coupon{}).Where("code in ?", codes).Find(&coupons).Error; err != nil {
+		return nil, fmt.Errorf("CouponRepo|GetByCodes:%v", err)
+	}
+	return coupons, nil
+}
+
+// GetByBatch 查询奖品某个导入批次的优惠券
+func (r *CouponRepo) GetByBatch(db *gorm.DB, prizeID uint, batchNo string) ([]*model.Coupon, error) {
+	var coupons []*model.Coupon
+	err := db.Model(&model.Coupon{}).Where("prize_id = ? and batch_no = ?", prizeID, batchNo).Find(&coupons).Error
+	if err != nil {
+		return nil, fmt.Errorf("CouponRepo|GetByBatch:%v", err)
+	}
+	return coupons, nil
+}
+
+// GetUsefulCodes 查询奖品可以发放的优惠券编码，正常并且没有过期
+func (r *CouponRepo) GetUsefulCodes(db *gorm.DB, prizeID uint, now time.Time) ([]string, error) {
+	var codes []string
+	err := db.Model(&model.Coupon{}).Where("prize_id = ? and sys_status = ?", prizeID, constant.CouponStatusNormal).
+		Where("valid_to is null or valid_to > ?", now).Pluck("code", &codes).Error
+	if err != nil {
+		return nil, fmt.Errorf("CouponRepo|GetUsefulCodes:%v", err)
+	}
+	return codes, nil
+}
+
+// GetExpired 查询已经超过有效期但是还没有发放的优惠券，按id升序最多返回limit条
+func (r *CouponRepo) GetExpired(db *gorm.DB, now time.Time, limit int) ([]*model.Coupon, error) {
+	var coupons []*model.Coupon
+	err := db.Model(&model.Coupon{}).Where("sys_status = ? and valid_to <= ?", constant.CouponStatusNormal, now).
+		Order("id asc").Limit(limit).Find(&coupons).Error
+	if err != nil {
+		return nil, fmt.Errorf("CouponRepo|GetExpired:%v", err)
+	}
+	return coupons, nil
+}
+
+// UpdateStatusByIDs 批量修改优惠券状态，只修改from状态的优惠券，返回修改的数量
+func (r *CouponRepo) UpdateStatusByIDs(db *gorm.DB, ids []uint, from, to uint) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	res := db.Model(&model.Coupon{}).Where("id in ? and sys_status = ?", ids, from).Update("sys_status", to)
+	if res.Error != nil {
+		return 0, fmt.Errorf("CouponRepo|UpdateStatusByIDs:%v", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
 // GetFromCache 根据id从缓存获取奖品
 func (r *CouponRepo) GetFromCache(id uint) (*model.Coupon, error) {
 	redisCli := cache.GetRedisCli()
@@ -131,7 +230,8 @@ func (r *CouponRepo) GetFromCache(id uint) (*model.Coupon, error) {
 func (r *CouponRepo) GetGetNextUsefulCoupon(db *gorm.DB, prizeID, couponID int) (*model.Coupon, error) {
 	coupon := &model.Coupon{}
 	err := db.Model(coupon).Where("prize_id=?", prizeID).Where("id > ?", couponID).
-		Where("sys_status = ?", 1).First(coupon).Error
+		Where("sys_status = ?", constant.CouponStatusNormal).
+		Where("valid_to is null or valid_to > ?", time.Now()).First(coupon).Error
 	if err != nil {
 		if err.Error() == gorm.ErrRecordNotFound.Error() {
 			return nil, nil
@@ -154,6 +254,72 @@ func (r *CouponRepo) ImportCacheCoupon(prizeID uint, code string) (bool, error)
 	return true, nil
 }
 
+// ImportCacheCoupons 批量往缓存导入优惠券，返回新加入的数量
+func (r *CouponRepo) ImportCacheCoupons(prizeID uint, codes ...string) (int64, error) {
+	if len(codes) == 0 {
+		return 0, nil
+	}
+	key := fmt.Sprintf(constant.PrizeCouponCacheKey+"%d", prizeID)
+	cnt, err := cache.GetRedisCli().SAdd(context.Background(), key, codes...)
+	if err != nil {
+		return 0, fmt.Errorf("CouponRepo|ImportCacheCoupons:%v", err)
+	}
+	return cnt, nil
+}
+
+// RemoveCacheCoupons 从缓存中移除优惠券，返回移除的数量
+func (r *CouponRepo) RemoveCacheCoupons(prizeID uint, codes ...string) (int64, error) {
+	if len(codes) == 0 {
+		return 0, nil
+	}
+	key := fmt.Sprintf(constant.PrizeCouponCacheKey+"%d", prizeID)
+	cnt, err := cache.GetRedisCli().SRem(context.Background(), key, codes...)
+	if err != nil {
+		return 0, fmt.Errorf("CouponRepo|RemoveCacheCoupons:%v", err)
+	}
+	return cnt, nil
+}
+
+// GetCacheCoupons 获取缓存中奖品的所有优惠券编码，使用SSCAN分批读取，避免阻塞redis
+func (r *CouponRepo) GetCacheCoupons(prizeID uint) ([]string, error) {
+	key := fmt.Sprintf(constant.PrizeCouponCacheKey+"%d", prizeID)
+	var (
+		codes  []string
+		cursor uint64
+	)
+	for {
+		ret, next, err := cache.GetRedisCli().SScan(context.Background(), key, cursor, "", 1000)
+		if err != nil {
+			return nil, fmt.Errorf("CouponRepo|GetCacheCoupons:%v", err)
+		}
+		codes = append(codes, ret...)
+		if next == 0 {
+			return codes, nil
+		}
+		cursor = next
+	}
+}
+
+// SetImportReport 保存优惠券导入的错误报告
+func (r *CouponRepo) SetImportReport(reportID string, report string) error {
+	key := constant.CouponImportReportKeyPrefix + reportID
+	expire := time.Duration(constant.CouponImportReportCacheTime) * time.Second
+	if err := cache.GetRedisCli().Set(context.Background(), key, report, expire); err != nil {
+		return fmt.Errorf("CouponRepo|SetImportReport:%v", err)
+	}
+	return nil
+}
+
+// GetImportReport 获取优惠券导入的错误报告，报告不存在或者已经过期返回false
+func (r *CouponRepo) GetImportReport(reportID string) (string, bool, error) {
+	key := constant.CouponImportReportKeyPrefix + reportID
+	report, ok, err := cache.GetRedisCli().Get(context.Background(), key)
+	if err != nil {
+		return "", false, fmt.Errorf("CouponRepo|GetImportReport:%v", err)
+	}
+	return report, ok, nil
+}
+
 // ReSetCacheCoupon 根据库存优惠券重置优惠券缓存
 func (r *CouponRepo) ReSetCacheCoupon(db *gorm.DB, prizeID uint) (int64, int64, error) {
 	var successNum, failureNum int64 = 0, 0
@@ -167,9 +333,11 @@ func (r *CouponRepo) ReSetCacheCoupon(db *gorm.DB, prizeID uint) (int64, int64,
 	key := fmt.Sprintf(constant.PrizeCouponCacheKey+"%d", prizeID)
 	// 这里先用临时keu统计，在原key上统计的话，因为db里的数量可能变化，没有同部到缓存中，比如db里面减少了10条数据，如果在原key上增加，那么缓存就会多处10条数据，所以根据db全部统计完了之后，在覆盖
 	tmpKey := "tmp_" + key
+	now := time.Now()
 	for _, coupon := range couponList {
 		code := coupon.Code
-		if coupon.SysStatus == 1 {
+		// 已经过期的优惠券等待过期任务处理，不放入缓存
+		if coupon.SysStatus == constant.CouponStatusNormal && (coupon.ValidTo == nil || coupon.ValidTo.After(now)) {
 			cnt, err := cache.GetRedisCli().SAdd(context.Background(), tmpKey, code)
 			if err != nil {
 				return 0, 0, fmt.Errorf("CouponRepo|ReSetCacheCoupon:%v", err)
@@ -199,7 +367,7 @@ func (r *CouponRepo) GetCacheCouponNum(db *gorm.DB, prizeID uint) (int64, int64,
 		return 0, 0, nil
 	}
 	for _, coupon := range couponList {
-		if coupon.SysStatus == 1 {
+		if coupon.SysStatus == constant.CouponStatusNormal {
 			dbNum++
 		}
 	}
@@ -0,0 +1,34 @@
+package repo
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"lottery_single/internal/model"
+)
+
+type CouponReconcileRepo struct {
+}
+
+func NewCouponReconcileRepo() *CouponReconcileRepo {
+	return &CouponReconcileRepo{}
+}
+
+// GetList 按照ID倒序获取对账记录，prizeID为0时获取所有奖品的记录
+func (r *CouponReconcileRepo) GetList(db *gorm.DB, prizeID uint, limit int) ([]*model.CouponReconcile, error) {
+	var list []*model.CouponReconcile
+	query := db.Model(&model.CouponReconcile{})
+	if prizeID > 0 {
+		query = query.Where("prize_id = ?", prizeID)
+	}
+	if err := query.Order("id desc").Limit(limit).Find(&list).Error; err != nil {
+		return nil, fmt.Errorf("CouponReconcileRepo|GetList:%v", err)
+	}
+	return list, nil
+}
+
+func (r *CouponReconcileRepo) Create(db *gorm.DB, reconcile *model.CouponReconcile) error {
+	if err := db.Model(&model.CouponReconcile{}).Create(reconcile).Error; err != nil {
+		return fmt.Errorf("CouponReconcileRepo|Create:%v", err)
+	}
+	return nil
+}
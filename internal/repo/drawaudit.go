@@ -0,0 +1,48 @@
+package repo
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"lottery_single/internal/model"
+)
+
+type DrawAuditRepo struct {
+}
+
+func NewDrawAuditRepo() *DrawAuditRepo {
+	return &DrawAuditRepo{}
+}
+
+func (r *DrawAuditRepo) Get(db *gorm.DB, id uint) (*model.DrawAudit, error) {
+	audit := &model.DrawAudit{}
+	err := db.Model(&model.DrawAudit{}).Where("id = ?", id).First(audit).Error
+	if err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("DrawAuditRepo|Get:%v", err)
+	}
+	return audit, nil
+}
+
+// GetByUser 按照ID倒序获取用户的审计记录，requestID不为空时只查询该请求
+func (r *DrawAuditRepo) GetByUser(db *gorm.DB, uid uint, requestID string, limit int) ([]*model.DrawAudit, error) {
+	var audits []*model.DrawAudit
+	query := db.Model(&model.DrawAudit{}).Where("user_id = ?", uid)
+	if requestID != "" {
+		query = query.Where("request_id = ?", requestID)
+	}
+	err := query.Order("id desc").Limit(limit).Find(&audits).Error
+	if err != nil {
+		return nil, fmt.Errorf("DrawAuditRepo|GetByUser:%v", err)
+	}
+	return audits, nil
+}
+
+func (r *DrawAuditRepo) Create(db *gorm.DB, audit *model.DrawAudit) error {
+	err := db.Model(&model.DrawAudit{}).Create(audit).Error
+	if err != nil {
+		return fmt.Errorf("DrawAuditRepo|Create:%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/cache"
+	"strconv"
+)
+
+type DrawSeedRepo struct {
+}
+
+func NewDrawSeedRepo() *DrawSeedRepo {
+	return &DrawSeedRepo{}
+}
+
+func (r *DrawSeedRepo) Get(db *gorm.DB, id uint) (*model.DrawSeed, error) {
+	seed := &model.DrawSeed{}
+	err := db.Model(&model.DrawSeed{}).Where("id = ?", id).First(seed).Error
+	if err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("DrawSeedRepo|Get:%v", err)
+	}
+	return seed, nil
+}
+
+// GetActive 获取正在使用的种子，没有返回nil
+func (r *DrawSeedRepo) GetActive(db *gorm.DB) (*model.DrawSeed, error) {
+	seed := &model.DrawSeed{}
+	err := db.Model(&model.DrawSeed{}).Where("sys_status = ?", constant.DrawSeedStatusActive).
+		Order("id desc").First(seed).Error
+	if err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("DrawSeedRepo|GetActive:%v", err)
+	}
+	return seed, nil
+}
+
+// GetList 按照ID倒序获取种子列表
+func (r *DrawSeedRepo) GetList(db *gorm.DB, limit int) ([]*model.DrawSeed, error) {
+	var seeds []*model.DrawSeed
+	err := db.Model(&model.DrawSeed{}).Order("id desc").Limit(limit).Find(&seeds).Error
+	if err != nil {
+		return nil, fmt.Errorf("DrawSeedRepo|GetList:%v", err)
+	}
+	return seeds, nil
+}
+
+func (r *DrawSeedRepo) Create(db *gorm.DB, seed *model.DrawSeed) error {
+	err := db.Model(&model.DrawSeed{}).Create(seed).Error
+	if err != nil {
+		return fmt.Errorf("DrawSeedRepo|Create:%v", err)
+	}
+	return nil
+}
+
+// RevealActive 公开所有正在使用的种子
+func (r *DrawSeedRepo) RevealActive(db *gorm.DB) error {
+	err := db.Model(&model.DrawSeed{}).Where("sys_status = ?", constant.DrawSeedStatusActive).
+		Update("sys_status", constant.DrawSeedStatusRevealed).Error
+	if err != nil {
+		return fmt.Errorf("DrawSeedRepo|RevealActive:%v", err)
+	}
+	return nil
+}
+
+// IncrNonce 分配种子下的抽奖序号
+func (r *DrawSeedRepo) IncrNonce(seedID uint) (int64, error) {
+	key := constant.DrawNonceKeyPrefix + strconv.Itoa(int(seedID))
+	nonce, err := cache.GetRedisCli().Incr(context.Background(), key)
+	if err != nil {
+		return 0, fmt.Errorf("DrawSeedRepo|IncrNonce:%v", err)
+	}
+	return nonce, nil
+}
@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"time"
+)
+
+type FulfilmentRepo struct {
+}
+
+func NewFulfilmentRepo() *FulfilmentRepo {
+	return &FulfilmentRepo{}
+}
+
+func (r *FulfilmentRepo) Get(db *gorm.DB, id uint) (*model.FulfilmentOrder, error) {
+	order := &model.FulfilmentOrder{}
+	err := db.Model(&model.FulfilmentOrder{}).Where("id = ?", id).First(order).Error
+	if err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("FulfilmentRepo|Get:%v", err)
+	}
+	return order, nil
+}
+
+// GetByResultID 根据中奖记录获取发货单
+func (r *FulfilmentRepo) GetByResultID(db *gorm.DB, resultID uint) (*model.FulfilmentOrder, error) {
+	order := &model.FulfilmentOrder{}
+	err := db.Model(&model.FulfilmentOrder{}).Where("result_id = ?", resultID).First(order).Error
+	if err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("FulfilmentRepo|GetByResultID:%v", err)
+	}
+	return order, nil
+}
+
+// FulfilmentQuery 发货单的查询条件，零值表示不过滤
+type FulfilmentQuery struct {
+	ActivityID uint
+	UserID     uint
+	PrizeID    uint
+	Status     *uint
+	IDs        []uint
+	BeginTime  *time.Time // 包含
+	EndTime    *time.Time // 不包含
+}
+
+// GetList 按照ID倒序分页查询发货单，cursor为上一页最后一条记录的ID，0表示第一页
+func (r *FulfilmentRepo) GetList(db *gorm.DB, query *FulfilmentQuery, cursor uint, limit int) ([]*model.FulfilmentOrder, error) {
+	var list []*model.FulfilmentOrder
+	db = db.Model(&model.FulfilmentOrder{})
+	if cursor > 0 {
+		db = db.Where("id < ?", cursor)
+	}
+	if query.ActivityID > 0 {
+		db = db.Where("activity_id = ?", query.ActivityID)
+	}
+	if query.UserID > 0 {
+		db = db.Where("user_id = ?", query.UserID)
+	}
+	if query.PrizeID > 0 {
+		db = db.Where("prize_id = ?", query.PrizeID)
+	}
+	if query.Status != nil {
+		db = db.Where("sys_status = ?", *query.Status)
+	}
+	if len(query.IDs) > 0 {
+		db = db.Where("id in ?", query.IDs)
+	}
+	if query.BeginTime != nil {
+		db = db.Where("sys_created >= ?", *query.BeginTime)
+	}
+	if query.EndTime != nil {
+		db = db.Where("sys_created < ?", *query.EndTime)
+	}
+	if err := db.Order("id desc").Limit(limit).Find(&list).Error; err != nil {
+		return nil, fmt.Errorf("FulfilmentRepo|GetList:%v", err)
+	}
+	return list, nil
+}
+
+func (r *FulfilmentRepo) Create(db *gorm.DB, order *model.FulfilmentOrder) error {
+	if err := db.Model(&model.FulfilmentOrder{}).Create(order).Error; err != nil {
+		return fmt.Errorf("FulfilmentRepo|Create:%v", err)
+	}
+	return nil
+}
+
+// UpdateByStatus 发货单状态是from时才修改，状态已经被修改过时返回false
+func (r *FulfilmentRepo) UpdateByStatus(db *gorm.DB, id uint, from uint, fields map[string]interface{}) (bool, error) {
+	res := db.Model(&model.FulfilmentOrder{}).Where("id = ? and sys_status = ?", id, from).Updates(fields)
+	if res.Error != nil {
+		return false, fmt.Errorf("FulfilmentRepo|UpdateByStatus:%v", res.Error)
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// CancelByResultID 取消中奖记录还没有签收的发货单，中奖记录被删除或者判定作弊时调用
+func (r *FulfilmentRepo) CancelByResultID(db *gorm.DB, resultID uint, remark string) (int64, error) {
+	res := db.Model(&model.FulfilmentOrder{}).Where("result_id = ? and sys_status in ?", resultID,
+		[]uint{constant.FulfilmentStatusPending, constant.FulfilmentStatusShipped}).
+		Updates(map[string]interface{}{"sys_status": constant.FulfilmentStatusCancelled, "remark": remark})
+	if res.Error != nil {
+		return 0, fmt.Errorf("FulfilmentRepo|CancelByResultID:%v", res.Error)
+	}
+	return res.RowsAffected, nil
+}
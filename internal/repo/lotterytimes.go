@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"gorm.io/gorm"
+	"lottery_single/configs"
 	"lottery_single/internal/model"
 	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/pkg/middlewares/cache"
@@ -37,12 +38,10 @@ func (r *LotteryTimesRepo) Get(id uint) (*model.LotteryTimes, error) {
 	return lotteryTimes, nil
 }
 
-func (r *LotteryTimesRepo) GetByUserIDAndDay(uid uint, day uint) (*model.LotteryTimes, error) {
-	lotteryTimes := &model.LotteryTimes{
-		UserId: uid,
-		Day:    day,
-	}
-	err := r.Db.Model(&model.LotteryTimes{}).First(lotteryTimes).Error
+func (r *LotteryTimesRepo) GetByUserIDAndDay(activityID uint, uid uint, day uint) (*model.LotteryTimes, error) {
+	lotteryTimes := &model.LotteryTimes{}
+	err := r.Db.Model(&model.LotteryTimes{}).Where("activity_id = ? and user_id = ? and day = ?", activityID, uid, day).
+		First(lotteryTimes).Error
 	if err != nil {
 		if err.Error() == gorm.ErrRecordNotFound.Error() {
 			return nil, nil
@@ -81,7 +80,7 @@ func (r *LotteryTimesRepo) Create(lotteryTimes *model.LotteryTimes) error {
 func (r *LotteryTimesRepo) Delete(id uint) error {
 	lotteryTimes := &model.LotteryTimes{Id: id}
 	if err := r.Db.Model(&model.LotteryTimes{}).Delete(lotteryTimes).Error; err != nil {
-		return fmt.Errorf("LotteryTimesRepo|Delete:%v")
+		return fmt.Errorf("LotteryTimesRepo|Delete:%v", err)
 	}
 	return nil
 }
@@ -99,11 +98,11 @@ func (r *LotteryTimesRepo) Update(lotteryTimes *model.LotteryTimes, cols ...stri
 	return nil
 }
 
-// IncrUserDayLotteryNum 每天缓存的用户抽奖次数递增，返回递增后的数值
-func (r *LotteryTimesRepo) IncrUserDayLotteryNum(uid uint) int64 {
-	i := uid % constant.UserFrameSize
+// IncrUserDayLotteryNum 每天缓存的用户在某个活动的抽奖次数递增，返回递增后的数值
+func (r *LotteryTimesRepo) IncrUserDayLotteryNum(activityID uint, uid uint) int64 {
+	i := uid % uint(configs.GetLotteryConfig().UserFrameSize)
 	// 集群的redis统计数递增
-	key := fmt.Sprintf(constant.UserLotteryDayNumPrefix+"%d", i)
+	key := fmt.Sprintf(constant.UserLotteryDayNumPrefix+"%d_%d", activityID, i)
 	ret, err := cache.GetRedisCli().HIncrBy(context.Background(), key, fmt.Sprint(uid), 1)
 	if err != nil {
 		log.Errorf("LotteryTimesRepo|IncrUserDayLotteryNum:%v", err)
@@ -112,13 +111,24 @@ func (r *LotteryTimesRepo) IncrUserDayLotteryNum(uid uint) int64 {
 	return ret
 }
 
+// GetUserDayLotteryNum 获取缓存的用户当天在某个活动的抽奖次数，不增加次数
+func (r *LotteryTimesRepo) GetUserDayLotteryNum(activityID uint, uid uint) (int64, error) {
+	i := uid % uint(configs.GetLotteryConfig().UserFrameSize)
+	key := fmt.Sprintf(constant.UserLotteryDayNumPrefix+"%d_%d", activityID, i)
+	ret, err := cache.GetRedisCli().HIncrBy(context.Background(), key, fmt.Sprint(uid), 0)
+	if err != nil {
+		return 0, fmt.Errorf("LotteryTimesRepo|GetUserDayLotteryNum:%v", err)
+	}
+	return ret, nil
+}
+
 // InitUserLuckyNum 从给定的数据直接初始化用户的参与抽奖次数
-func (r *LotteryTimesRepo) InitUserLuckyNum(uid uint, num int64) error {
+func (r *LotteryTimesRepo) InitUserLuckyNum(activityID uint, uid uint, num int64) error {
 	if num <= 1 {
 		return nil
 	}
-	i := uid % constant.UserFrameSize
-	key := fmt.Sprintf(constant.UserLotteryDayNumPrefix+"%d", i)
+	i := uid % uint(configs.GetLotteryConfig().UserFrameSize)
+	key := fmt.Sprintf(constant.UserLotteryDayNumPrefix+"%d_%d", activityID, i)
 	_, err := cache.GetRedisCli().HSet(context.Background(), key, fmt.Sprint(uid), num)
 	if err != nil {
 		log.Errorf("LotteryTimesRepo|InitUserLuckyNum:%v", err)
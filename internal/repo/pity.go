@@ -0,0 +1,77 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/cache"
+	"strconv"
+)
+
+type PityRepo struct {
+}
+
+func NewPityRepo() *PityRepo {
+	return &PityRepo{}
+}
+
+// Get 获取用户在活动中的连续未中奖计数，没有记录时返回nil
+func (r *PityRepo) Get(db *gorm.DB, activityID uint, uid uint) (*model.PityCounter, error) {
+	counter := &model.PityCounter{}
+	err := db.Model(&model.PityCounter{}).Where("activity_id = ? and user_id = ?", activityID, uid).
+		First(counter).Error
+	if err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("PityRepo|Get:%v", err)
+	}
+	return counter, nil
+}
+
+// Save 保存用户在活动中的连续未中奖次数，没有记录时创建
+func (r *PityRepo) Save(db *gorm.DB, activityID uint, uid uint, losses int64) error {
+	counter := &model.PityCounter{ActivityId: activityID, UserId: uid, Losses: losses}
+	err := db.Model(&model.PityCounter{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "activity_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"losses", "sys_updated"}),
+	}).Create(counter).Error
+	if err != nil {
+		return fmt.Errorf("PityRepo|Save:%v", err)
+	}
+	return nil
+}
+
+// GetByCache 从缓存中获取连续未中奖次数，ok为false表示缓存中没有
+func (r *PityRepo) GetByCache(activityID uint, uid uint) (int64, bool, error) {
+	key := fmt.Sprintf(constant.PityLossKeyPrefix+"%d", activityID)
+	field := fmt.Sprint(uid)
+	exists, err := cache.GetRedisCli().HExists(context.Background(), key, field)
+	if err != nil {
+		return 0, false, fmt.Errorf("PityRepo|GetByCache:%v", err)
+	}
+	if !exists {
+		return 0, false, nil
+	}
+	res, err := cache.GetRedisCli().HGet(context.Background(), key, field)
+	if err != nil {
+		return 0, false, fmt.Errorf("PityRepo|GetByCache:%v", err)
+	}
+	losses, err := strconv.ParseInt(res, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("PityRepo|GetByCache:%v", err)
+	}
+	return losses, true, nil
+}
+
+// SetByCache 连续未中奖次数保存到缓存
+func (r *PityRepo) SetByCache(activityID uint, uid uint, losses int64) error {
+	key := fmt.Sprintf(constant.PityLossKeyPrefix+"%d", activityID)
+	if _, err := cache.GetRedisCli().HSet(context.Background(), key, fmt.Sprint(uid), losses); err != nil {
+		return fmt.Errorf("PityRepo|SetByCache:%v", err)
+	}
+	return nil
+}
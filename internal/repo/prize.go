@@ -35,8 +35,8 @@ func (r *PrizeReop) Get(db *gorm.DB, id uint) (*model.Prize, error) {
 	return prize, nil
 }
 
-func (r *PrizeReop) GetWithCache(db *gorm.DB, id uint) (*model.Prize, error) {
-	prizeList, err := r.GetAllWithCache(db)
+func (r *PrizeReop) GetWithCache(db *gorm.DB, activityID uint, id uint) (*model.Prize, error) {
+	prizeList, err := r.GetAllWithCache(db, activityID)
 	if err != nil {
 		return nil, fmt.Errorf("PrizeRepo|GetWithCache:%v", err)
 	}
@@ -57,19 +57,29 @@ func (r *PrizeReop) GetAll(db *gorm.DB) ([]*model.Prize, error) {
 	return prizes, nil
 }
 
-func (r *PrizeReop) GetAllWithCache(db *gorm.DB) ([]*model.Prize, error) {
-	prizeList, err := r.GetAllByCache()
+// GetAllByActivity 获取某个活动的所有奖品
+func (r *PrizeReop) GetAllByActivity(db *gorm.DB, activityID uint) ([]*model.Prize, error) {
+	var prizes []*model.Prize
+	err := db.Model(&model.Prize{}).Where("activity_id = ?", activityID).Find(&prizes).Error
+	if err != nil {
+		return nil, fmt.Errorf("PrizeRepo|GetAllByActivity:%v", err)
+	}
+	return prizes, nil
+}
+
+func (r *PrizeReop) GetAllWithCache(db *gorm.DB, activityID uint) ([]*model.Prize, error) {
+	prizeList, err := r.GetAllByCache(activityID)
 	if err != nil {
 		return nil, fmt.Errorf("PrizeRepo|GetAllWithCache:%v", err)
 	}
 	if prizeList == nil {
 		// 缓存没查到，从db获取
-		prizeList, err = r.GetAll(db)
+		prizeList, err = r.GetAllByActivity(db, activityID)
 		if err != nil {
 			return nil, fmt.Errorf("PrizeRepo|GetAllWithCache:%v", err)
 		}
 		// 将数据更新到缓存中
-		if err = r.SetAllByCache(prizeList); err != nil {
+		if err = r.SetAllByCache(activityID, prizeList); err != nil {
 			return nil, fmt.Errorf("PrizeRepo|GetAllWithCache:%v", err)
 		}
 	}
@@ -85,8 +95,8 @@ func (r *PrizeReop) CountAll(db *gorm.DB) (int64, error) {
 	return num, nil
 }
 
-func (r *PrizeReop) CountAllWithCache(db *gorm.DB) (int64, error) {
-	prizeList, err := r.GetAllWithCache(db)
+func (r *PrizeReop) CountAllWithCache(db *gorm.DB, activityID uint) (int64, error) {
+	prizeList, err := r.GetAllWithCache(db, activityID)
 	if err != nil {
 		return 0, fmt.Errorf("PrizeRepo|CountAllWithCache:%v", err)
 	}
@@ -111,14 +121,16 @@ func (r *PrizeReop) CreateWithCache(db *gorm.DB, prize *model.Prize) error {
 func (r *PrizeReop) Delete(db *gorm.DB, id uint) error {
 	prize := &model.Prize{Id: id}
 	if err := db.Model(&model.Prize{}).Delete(prize).Error; err != nil {
-		return fmt.Errorf("PrizeRepo|Delete:%v")
+		return fmt.Errorf("PrizeRepo|Delete:%v", err)
 	}
 	return nil
 }
 
 func (r *PrizeReop) DeleteWithCache(db *gorm.DB, id uint) error {
-	prize := &model.Prize{
-		Id: id,
+	// 需要知道奖品所属的活动才能清空对应的缓存
+	prize, err := r.Get(db, id)
+	if err != nil {
+		return fmt.Errorf("PrizeRepo|DeleteWithCache:%v", err)
 	}
 	if err := r.UpdateByCache(prize); err != nil {
 		return fmt.Errorf("PrizeRepo|DeleteWithCache:%v", err)
@@ -167,10 +179,10 @@ func (r *PrizeReop) GetFromCache(id uint) (*model.Prize, error) {
 	return &prize, nil
 }
 
-func (r *PrizeReop) GetAllUsefulPrizeList(db *gorm.DB) ([]*model.Prize, error) {
+func (r *PrizeReop) GetAllUsefulPrizeList(db *gorm.DB, activityID uint) ([]*model.Prize, error) {
 	now := time.Now()
 	list := make([]*model.Prize, 0)
-	err := db.Model(&model.Prize{}).Where("begin_time<=?", now).Where("end_time >= ?", now).
+	err := db.Model(&model.Prize{}).Where("activity_id = ?", activityID).Where("begin_time<=?", now).Where("end_time >= ?", now).
 		Where("prize_num>?", 0).Where("sys_status=?", 1).Order("sys_updated desc").
 		Order("display_order asc").Find(&list).Error
 	if err != nil {
@@ -180,9 +192,9 @@ func (r *PrizeReop) GetAllUsefulPrizeList(db *gorm.DB) ([]*model.Prize, error) {
 }
 
 // GetAllUsefulPrizeListWithCache 筛选出符合条件的奖品列表
-func (r *PrizeReop) GetAllUsefulPrizeListWithCache(db *gorm.DB) ([]*model.Prize, error) {
+func (r *PrizeReop) GetAllUsefulPrizeListWithCache(db *gorm.DB, activityID uint) ([]*model.Prize, error) {
 	// 优先从缓存取，缓存没取到，从db取
-	prizeList, err := r.GetAllWithCache(db)
+	prizeList, err := r.GetAllWithCache(db, activityID)
 	if err != nil {
 		return nil, fmt.Errorf("PrizeRepo|GetAllUsefulPrizeListWithCache:%v", err)
 	}
@@ -210,8 +222,8 @@ func (r *PrizeReop) DecrLeftNum(db *gorm.DB, id int, num int) (bool, error) {
 }
 
 // DecrLeftNumByPool 奖品缓冲池 对应奖品数量递减
-func (r *PrizeReop) DecrLeftNumByPool(prizeID int) (int64, error) {
-	key := constant.PrizePoolCacheKey
+func (r *PrizeReop) DecrLeftNumByPool(activityID uint, prizeID int) (int64, error) {
+	key := fmt.Sprintf(constant.PrizePoolCacheKeyPrefix+"%d", activityID)
 	field := strconv.Itoa(prizeID)
 	cnt, err := cache.GetRedisCli().HIncrBy(context.Background(), key, field, -1)
 	if err != nil {
@@ -220,16 +232,27 @@ func (r *PrizeReop) DecrLeftNumByPool(prizeID int) (int64, error) {
 	return cnt, nil
 }
 
+// IncrLeftNumByPool 奖品缓冲池 对应奖品数量递增，用于发奖失败之后回补奖品池
+func (r *PrizeReop) IncrLeftNumByPool(activityID uint, prizeID int, num int) (int64, error) {
+	key := fmt.Sprintf(constant.PrizePoolCacheKeyPrefix+"%d", activityID)
+	field := strconv.Itoa(prizeID)
+	cnt, err := cache.GetRedisCli().HIncrBy(context.Background(), key, field, int64(num))
+	if err != nil {
+		return 0, fmt.Errorf("PrizeRepo|IncrLeftNumByPool:%v", err)
+	}
+	return cnt, nil
+}
+
 func (r *PrizeReop) IncrLeftNum(db *gorm.DB, id int, column string, num int) error {
 	if err := db.Model(&model.Prize{}).Where("id = ?", id).
-		Update(column, gorm.Expr(column+" + ？", num)).Error; err != nil {
+		Update(column, gorm.Expr(column+" + ?", num)).Error; err != nil {
 		return fmt.Errorf("PrizeRepo|IncrLeftNum err: %v", err)
 	}
 	return nil
 }
 
-// SetAllByCache 全量数据保存到redis中
-func (r *PrizeReop) SetAllByCache(prizeList []*model.Prize) error {
+// SetAllByCache 某个活动的全量奖品数据保存到redis中
+func (r *PrizeReop) SetAllByCache(activityID uint, prizeList []*model.Prize) error {
 	value := ""
 	if len(prizeList) > 0 {
 		prizeMapList := make([]map[string]interface{}, len(prizeList))
@@ -237,10 +260,12 @@ func (r *PrizeReop) SetAllByCache(prizeList []*model.Prize) error {
 			prize := prizeList[i]
 			prizeMap := make(map[string]interface{})
 			prizeMap["Id"] = prize.Id
+			prizeMap["ActivityId"] = prize.ActivityId
 			prizeMap["Title"] = prize.Title
 			prizeMap["PrizeNum"] = prize.PrizeNum
 			prizeMap["LeftNum"] = prize.LeftNum
 			prizeMap["PrizeCode"] = prize.PrizeCode
+			prizeMap["Probability"] = prize.Probability
 			prizeMap["PrizeTime"] = prize.PrizeTime
 			prizeMap["Img"] = prize.Img
 			prizeMap["DisplayOrder"] = prize.DisplayOrder
@@ -264,16 +289,18 @@ func (r *PrizeReop) SetAllByCache(prizeList []*model.Prize) error {
 		}
 		value = string(bytes)
 	}
-	if err := cache.GetRedisCli().Set(context.Background(), constant.AllPrizeCacheKey, value, time.Second*time.Duration(constant.AllPrizeCacheTime)); err != nil {
+	key := fmt.Sprintf(constant.AllPrizeCacheKeyPrefix+"%d", activityID)
+	if err := cache.GetRedisCli().Set(context.Background(), key, value, time.Second*time.Duration(constant.AllPrizeCacheTime)); err != nil {
 		log.Errorf("SetAllByCache|set cache err:%v", err)
 		return fmt.Errorf("SetAllByCache|set cache err:%v", err)
 	}
 	return nil
 }
 
-// GetAllByCache 从缓存中获取所有的奖品信息
-func (r *PrizeReop) GetAllByCache() ([]*model.Prize, error) {
-	valutStr, ok, err := cache.GetRedisCli().Get(context.Background(), constant.AllPrizeCacheKey)
+// GetAllByCache 从缓存中获取某个活动所有的奖品信息
+func (r *PrizeReop) GetAllByCache(activityID uint) ([]*model.Prize, error) {
+	key := fmt.Sprintf(constant.AllPrizeCacheKeyPrefix+"%d", activityID)
+	valutStr, ok, err := cache.GetRedisCli().Get(context.Background(), key)
 	if err != nil {
 		return nil, fmt.Errorf("PrizeRepo|GetAllByCache:%v", err)
 	}
@@ -332,10 +359,12 @@ func (r *PrizeReop) GetAllByCache() ([]*model.Prize, error) {
 		}
 		prize := &model.Prize{
 			Id:           uint(id),
+			ActivityId:   uint(utils.GetInt64FromMap(prizeMap, "ActivityId", 0)),
 			Title:        utils.GetStringFromMap(prizeMap, "Title", ""),
 			PrizeNum:     int(utils.GetInt64FromMap(prizeMap, "PrizeNum", 0)),
 			LeftNum:      int(utils.GetInt64FromMap(prizeMap, "LeftNum", 0)),
 			PrizeCode:    utils.GetStringFromMap(prizeMap, "PrizeCode", ""),
+			Probability:  utils.GetFloat64FromMap(prizeMap, "Probability", 0),
 			PrizeTime:    uint(utils.GetInt64FromMap(prizeMap, "PrizeTime", 0)),
 			Img:          utils.GetStringFromMap(prizeMap, "Img", ""),
 			DisplayOrder: uint(utils.GetInt64FromMap(prizeMap, "DisplayOrder", 0)),
@@ -356,20 +385,21 @@ func (r *PrizeReop) GetAllByCache() ([]*model.Prize, error) {
 	return prizeList, nil
 }
 
-// UpdateByCache 数据更新，需要更新缓存，直接清空缓存数据
+// UpdateByCache 数据更新，需要更新缓存，直接清空奖品所属活动的缓存数据
 func (r *PrizeReop) UpdateByCache(prize *model.Prize) error {
 	if prize == nil || prize.Id <= 0 {
 		return nil
 	}
-	if err := cache.GetRedisCli().Delete(context.Background(), constant.AllPrizeCacheKey); err != nil {
+	key := fmt.Sprintf(constant.AllPrizeCacheKeyPrefix+"%d", prize.ActivityId)
+	if err := cache.GetRedisCli().Delete(context.Background(), key); err != nil {
 		return fmt.Errorf("PrizeRepo|UpdateByCache err:%v", err)
 	}
 	return nil
 }
 
 // GetPrizePoolNum 获取奖品缓冲池中获取数据
-func (r *PrizeReop) GetPrizePoolNum(prizeID uint) (int, error) {
-	key := constant.PrizePoolCacheKey
+func (r *PrizeReop) GetPrizePoolNum(activityID uint, prizeID uint) (int, error) {
+	key := fmt.Sprintf(constant.PrizePoolCacheKeyPrefix+"%d", activityID)
 	field := strconv.Itoa(int(prizeID))
 	res, err := cache.GetRedisCli().HGet(context.Background(), key, field)
 	if err != nil {
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"gorm.io/gorm"
 	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/pkg/middlewares/cache"
 	"lottery_single/internal/pkg/middlewares/log"
 	"strconv"
+	"time"
 )
 
 type ResultRepo struct {
@@ -54,6 +56,79 @@ func (r *ResultRepo) GetAll(db *gorm.DB) ([]*model.Result, error) {
 	}
 	return results, nil
 }
+
+// ResultQuery 中奖记录的查询条件，零值表示不过滤
+type ResultQuery struct {
+	ActivityID uint
+	UserID     uint
+	PrizeID    uint
+	PrizeType  *uint
+	Status     *uint
+	IP         string
+	BeginTime  *time.Time // 包含
+	EndTime    *time.Time // 不包含
+}
+
+// GetList 按照ID倒序分页查询中奖记录，cursor为上一页最后一条记录的ID，0表示第一页
+func (r *ResultRepo) GetList(db *gorm.DB, query *ResultQuery, cursor uint, limit int) ([]*model.Result, error) {
+	var results []*model.Result
+	db = db.Model(&model.Result{})
+	if cursor > 0 {
+		db = db.Where("id < ?", cursor)
+	}
+	if query.ActivityID > 0 {
+		db = db.Where("activity_id = ?", query.ActivityID)
+	}
+	if query.UserID > 0 {
+		db = db.Where("user_id = ?", query.UserID)
+	}
+	if query.PrizeID > 0 {
+		db = db.Where("prize_id = ?", query.PrizeID)
+	}
+	if query.PrizeType != nil {
+		db = db.Where("prize_type = ?", *query.PrizeType)
+	}
+	if query.Status != nil {
+		db = db.Where("sys_status = ?", *query.Status)
+	}
+	if query.IP != "" {
+		db = db.Where("sys_ip = ?", query.IP)
+	}
+	if query.BeginTime != nil {
+		db = db.Where("sys_created >= ?", *query.BeginTime)
+	}
+	if query.EndTime != nil {
+		db = db.Where("sys_created < ?", *query.EndTime)
+	}
+	err := db.Order("id desc").Limit(limit).Find(&results).Error
+	if err != nil {
+		return nil, fmt.Errorf("ResultRepo|GetList:%v", err)
+	}
+	return results, nil
+}
+
+// GetByRequestID 根据抽奖请求ID获取用户的中奖记录
+func (r *ResultRepo) GetByRequestID(db *gorm.DB, uid uint, requestID string) (*model.Result, error) {
+	result := &model.Result{}
+	err := db.Model(&model.Result{}).Where("user_id = ? and request_id = ?", uid, requestID).First(result).Error
+	if err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ResultRepo|GetByRequestID:%v", err)
+	}
+	return result, nil
+}
+
+// UpdateStatus 修改中奖记录状态，状态已经被修改过时返回false
+func (r *ResultRepo) UpdateStatus(db *gorm.DB, id uint, from, to uint) (bool, error) {
+	res := db.Model(&model.Result{}).Where("id = ? and sys_status = ?", id, from).UpdateColumn("sys_status", to)
+	if res.Error != nil {
+		return false, fmt.Errorf("ResultRepo|UpdateStatus:%v", res.Error)
+	}
+	return res.RowsAffected > 0, nil
+}
+
 func (r *ResultRepo) CountAll(db *gorm.DB) (int64, error) {
 	var num int64
 	err := db.Model(&model.Result{}).Count(&num).Error
@@ -74,7 +149,7 @@ func (r *ResultRepo) Create(db *gorm.DB, result *model.Result) error {
 func (r *ResultRepo) Delete(db *gorm.DB, id uint) error {
 	result := &model.Result{Id: id}
 	if err := db.Model(&model.Result{}).Delete(result).Error; err != nil {
-		return fmt.Errorf("ResultRepo|Delete:%v")
+		return fmt.Errorf("ResultRepo|Delete:%v", err)
 	}
 	return nil
 }
@@ -111,3 +186,26 @@ func (r *ResultRepo) GetFromCache(id uint) (*model.Result, error) {
 
 	return &result, nil
 }
+
+// SetRequestByCache 保存抽奖请求的处理结果
+func (r *ResultRepo) SetRequestByCache(uid uint, requestID string, value string) error {
+	key := fmt.Sprintf(constant.LotteryRequestKeyPrefix+"%d_%s", uid, requestID)
+	if err := cache.GetRedisCli().Set(context.Background(), key, value,
+		time.Second*time.Duration(constant.LotteryRequestCacheTime)); err != nil {
+		return fmt.Errorf("ResultRepo|SetRequestByCache:%v", err)
+	}
+	return nil
+}
+
+// GetRequestByCache 获取抽奖请求的处理结果，没有处理过返回空字符串
+func (r *ResultRepo) GetRequestByCache(uid uint, requestID string) (string, error) {
+	key := fmt.Sprintf(constant.LotteryRequestKeyPrefix+"%d_%s", uid, requestID)
+	value, ok, err := cache.GetRedisCli().Get(context.Background(), key)
+	if err != nil {
+		return "", fmt.Errorf("ResultRepo|GetRequestByCache:%v", err)
+	}
+	if !ok {
+		return "", nil
+	}
+	return value, nil
+}
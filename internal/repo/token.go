@@ -0,0 +1,62 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/cache"
+	"strconv"
+	"time"
+)
+
+// luaDelKey 删除key，key存在返回1，不存在返回0，保证刷新token只能使用一次
+const luaDelKey = `return redis.call('DEL', KEYS[1])`
+
+// TokenRepo 登录token的注销记录和刷新token都保存在redis中，过期之后自动删除
+type TokenRepo struct {
+}
+
+func NewTokenRepo() *TokenRepo {
+	return &TokenRepo{}
+}
+
+// SetRevoked 注销token，expire为token剩余的有效期
+func (r *TokenRepo) SetRevoked(jti string, expire time.Duration) error {
+	if expire <= 0 {
+		return nil
+	}
+	key := constant.JwtRevokedKeyPrefix + jti
+	if err := cache.GetRedisCli().Set(context.Background(), key, "1", expire); err != nil {
+		return fmt.Errorf("TokenRepo|SetRevoked:%v", err)
+	}
+	return nil
+}
+
+// IsRevoked token是否已经注销
+func (r *TokenRepo) IsRevoked(jti string) (bool, error) {
+	key := constant.JwtRevokedKeyPrefix + jti
+	_, ok, err := cache.GetRedisCli().Get(context.Background(), key)
+	if err != nil {
+		return false, fmt.Errorf("TokenRepo|IsRevoked:%v", err)
+	}
+	return ok, nil
+}
+
+// SetRefresh 保存签发的刷新token
+func (r *TokenRepo) SetRefresh(jti string, uid uint, expire time.Duration) error {
+	key := constant.JwtRefreshKeyPrefix + jti
+	if err := cache.GetRedisCli().Set(context.Background(), key, strconv.Itoa(int(uid)), expire); err != nil {
+		return fmt.Errorf("TokenRepo|SetRefresh:%v", err)
+	}
+	return nil
+}
+
+// DelRefresh 删除刷新token，返回false表示刷新token不存在，已经使用过或者已经注销
+func (r *TokenRepo) DelRefresh(jti string) (bool, error) {
+	key := constant.JwtRefreshKeyPrefix + jti
+	ok, err := cache.GetRedisCli().EvalBool(context.Background(), luaDelKey, []string{key})
+	if err != nil {
+		return false, fmt.Errorf("TokenRepo|DelRefresh:%v", err)
+	}
+	return ok, nil
+}
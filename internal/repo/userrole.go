@@ -0,0 +1,38 @@
+package repo
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"lottery_single/internal/model"
+)
+
+type UserRoleRepo struct {
+}
+
+func NewUserRoleRepo() *UserRoleRepo {
+	return &UserRoleRepo{}
+}
+
+// GetRolesByUserID 获取用户的所有角色
+func (r *UserRoleRepo) GetRolesByUserID(db *gorm.DB, uid uint) ([]string, error) {
+	var roles []string
+	err := db.Model(&model.UserRole{}).Where("user_id = ?", uid).Order("id asc").Pluck("role", &roles).Error
+	if err != nil {
+		return nil, fmt.Errorf("UserRoleRepo|GetRolesByUserID:%v", err)
+	}
+	return roles, nil
+}
+
+// SetRoles 覆盖设置用户的角色，需要在事务中调用
+func (r *UserRoleRepo) SetRoles(db *gorm.DB, uid uint, roles []string) error {
+	if err := db.Where("user_id = ?", uid).Delete(&model.UserRole{}).Error; err != nil {
+		return fmt.Errorf("UserRoleRepo|SetRoles:%v", err)
+	}
+	for _, role := range roles {
+		userRole := &model.UserRole{UserId: uid, Role: role}
+		if err := db.Model(&model.UserRole{}).Create(userRole).Error; err != nil {
+			return fmt.Errorf("UserRoleRepo|SetRoles:%v", err)
+		}
+	}
+	return nil
+}
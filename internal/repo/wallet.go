@@ -0,0 +1,77 @@
+package repo
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+)
+
+type WalletRepo struct {
+}
+
+func NewWalletRepo() *WalletRepo {
+	return &WalletRepo{}
+}
+
+// GetWallet 获取用户的钱包，没有钱包时返回nil
+func (r *WalletRepo) GetWallet(db *gorm.DB, uid uint) (*model.Wallet, error) {
+	wallet := &model.Wallet{}
+	err := db.Model(&model.Wallet{}).Where("user_id = ?", uid).First(wallet).Error
+	if err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("WalletRepo|GetWallet:%v", err)
+	}
+	return wallet, nil
+}
+
+// AddBalance 修改用户余额，没有钱包时先创建，不允许为负时余额不足返回false
+func (r *WalletRepo) AddBalance(db *gorm.DB, uid uint, amount int64, allowNegative bool) (bool, error) {
+	err := db.Model(&model.Wallet{}).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&model.Wallet{UserId: uid}).Error
+	if err != nil {
+		return false, fmt.Errorf("WalletRepo|AddBalance:%v", err)
+	}
+	query := db.Model(&model.Wallet{}).Where("user_id = ?", uid)
+	if !allowNegative {
+		query = query.Where("balance + ? >= 0", amount)
+	}
+	res := query.UpdateColumn("balance", gorm.Expr("balance + ?", amount))
+	if res.Error != nil {
+		return false, fmt.Errorf("WalletRepo|AddBalance:%v", res.Error)
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// GetLedgerByTxNo 获取一笔交易的所有分录
+func (r *WalletRepo) GetLedgerByTxNo(db *gorm.DB, txNo string) ([]*model.WalletLedger, error) {
+	var list []*model.WalletLedger
+	if err := db.Model(&model.WalletLedger{}).Where("tx_no = ?", txNo).Order("id").Find(&list).Error; err != nil {
+		return nil, fmt.Errorf("WalletRepo|GetLedgerByTxNo:%v", err)
+	}
+	return list, nil
+}
+
+// CreateLedgers 写入一笔交易的分录，交易号和账户唯一，重复记账时返回错误
+func (r *WalletRepo) CreateLedgers(db *gorm.DB, list []*model.WalletLedger) error {
+	if err := db.Model(&model.WalletLedger{}).Create(list).Error; err != nil {
+		return fmt.Errorf("WalletRepo|CreateLedgers:%v", err)
+	}
+	return nil
+}
+
+// GetUserLedgers 按照ID倒序分页查询用户账户的分录，cursor为上一页最后一条记录的ID，0表示第一页
+func (r *WalletRepo) GetUserLedgers(db *gorm.DB, uid uint, cursor uint, limit int) ([]*model.WalletLedger, error) {
+	var list []*model.WalletLedger
+	query := db.Model(&model.WalletLedger{}).Where("user_id = ? and account = ?", uid, constant.WalletAccountUser)
+	if cursor > 0 {
+		query = query.Where("id < ?", cursor)
+	}
+	if err := query.Order("id desc").Limit(limit).Find(&list).Error; err != nil {
+		return nil, fmt.Errorf("WalletRepo|GetUserLedgers:%v", err)
+	}
+	return list, nil
+}
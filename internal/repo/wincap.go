@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/cache"
+	"lottery_single/internal/pkg/middlewares/log"
+	"time"
+)
+
+type WinCapRepo struct {
+}
+
+func NewWinCapRepo() *WinCapRepo {
+	return &WinCapRepo{}
+}
+
+// Get 获取规则，没有时返回nil
+func (r *WinCapRepo) Get(db *gorm.DB, id uint) (*model.WinCap, error) {
+	winCap := &model.WinCap{}
+	err := db.Model(&model.WinCap{}).Where("id = ?", id).First(winCap).Error
+	if err != nil {
+		if err.Error() == gorm.ErrRecordNotFound.Error() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("WinCapRepo|Get:%v", err)
+	}
+	return winCap, nil
+}
+
+// GetListByActivity 获取活动的所有规则
+func (r *WinCapRepo) GetListByActivity(db *gorm.DB, activityID uint) ([]*model.WinCap, error) {
+	var list []*model.WinCap
+	if err := db.Model(&model.WinCap{}).Where("activity_id = ?", activityID).Order("id").Find(&list).Error; err != nil {
+		return nil, fmt.Errorf("WinCapRepo|GetListByActivity:%v", err)
+	}
+	return list, nil
+}
+
+// GetListByActivityWithCache 优先从缓存获取活动的规则，缓存没有再从db获取并同步到缓存
+func (r *WinCapRepo) GetListByActivityWithCache(db *gorm.DB, activityID uint) ([]*model.WinCap, error) {
+	key := fmt.Sprintf(constant.WinCapCacheKeyPrefix+"%d", activityID)
+	value, ok, err := cache.GetRedisCli().Get(context.Background(), key)
+	if err == nil && ok {
+		var list []*model.WinCap
+		if err = json.Unmarshal([]byte(value), &list); err == nil {
+			return list, nil
+		}
+		log.Errorf("WinCapRepo|GetListByActivityWithCache unmarshal err:%v", err)
+	}
+	list, err := r.GetListByActivity(db, activityID)
+	if err != nil {
+		return nil, fmt.Errorf("WinCapRepo|GetListByActivityWithCache:%v", err)
+	}
+	// 没有规则时也缓存空列表，避免每次抽奖都查询数据库
+	if list == nil {
+		list = []*model.WinCap{}
+	}
+	bytes, err := json.Marshal(list)
+	if err != nil {
+		return nil, fmt.Errorf("WinCapRepo|GetListByActivityWithCache:%v", err)
+	}
+	if err = cache.GetRedisCli().Set(context.Background(), key, string(bytes),
+		time.Second*time.Duration(constant.WinCapCacheTime)); err != nil {
+		return nil, fmt.Errorf("WinCapRepo|GetListByActivityWithCache:%v", err)
+	}
+	return list, nil
+}
+
+// DeleteByCache 规则修改之后清空活动的规则缓存
+func (r *WinCapRepo) DeleteByCache(activityID uint) error {
+	key := fmt.Sprintf(constant.WinCapCacheKeyPrefix+"%d", activityID)
+	if err := cache.GetRedisCli().Delete(context.Background(), key); err != nil {
+		return fmt.Errorf("WinCapRepo|DeleteByCache:%v", err)
+	}
+	return nil
+}
+
+func (r *WinCapRepo) Create(db *gorm.DB, winCap *model.WinCap) error {
+	if err := db.Model(&model.WinCap{}).Create(winCap).Error; err != nil {
+		return fmt.Errorf("WinCapRepo|Create:%v", err)
+	}
+	return nil
+}
+
+func (r *WinCapRepo) Delete(db *gorm.DB, id uint) error {
+	if err := db.Where("id = ?", id).Delete(&model.WinCap{}).Error; err != nil {
+		return fmt.Errorf("WinCapRepo|Delete:%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"lottery_single/configs"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/repo"
+	"time"
+)
+
+// ActivityService 抽奖活动管理
+type ActivityService interface {
+	GetActivity(ctx context.Context, id uint) (*model.Activity, error)
+	GetActivityWithCache(ctx context.Context, id uint) (*model.Activity, error)
+	GetActivityList(ctx context.Context) ([]*model.Activity, error)
+	AddActivity(ctx context.Context, activity *model.Activity) error
+	UpdateActivity(ctx context.Context, activity *model.Activity) error
+	CheckActivity(ctx context.Context, id uint) (bool, *model.Activity, error)
+}
+
+type activityService struct {
+	activityRepo *repo.ActivityRepo
+}
+
+var activityServiceImpl *activityService
+
+func InitActivityService() {
+	activityServiceImpl = &activityService{
+		activityRepo: repo.NewActivityRepo(),
+	}
+}
+
+func GetActivityService() ActivityService {
+	return activityServiceImpl
+}
+
+func (a *activityService) GetActivity(ctx context.Context, id uint) (*model.Activity, error) {
+	activity, err := a.activityRepo.Get(gormcli.GetDB(), id)
+	if err != nil {
+		log.ErrorContextf(ctx, "activityService|GetActivity:%v", err)
+		return nil, fmt.Errorf("activityService|GetActivity:%v", err)
+	}
+	return activity, nil
+}
+
+func (a *activityService) GetActivityWithCache(ctx context.Context, id uint) (*model.Activity, error) {
+	activity, err := a.activityRepo.GetWithCache(gormcli.GetDB(), id)
+	if err != nil {
+		log.ErrorContextf(ctx, "activityService|GetActivityWithCache:%v", err)
+		return nil, fmt.Errorf("activityService|GetActivityWithCache:%v", err)
+	}
+	return activity, nil
+}
+
+func (a *activityService) GetActivityList(ctx context.Context) ([]*model.Activity, error) {
+	list, err := a.activityRepo.GetAll(gormcli.GetDB())
+	if err != nil {
+		log.ErrorContextf(ctx, "activityService|GetActivityList:%v", err)
+		return nil, fmt.Errorf("activityService|GetActivityList:%v", err)
+	}
+	return list, nil
+}
+
+// AddActivity 新增活动，没有设置抽奖次数限制的在抽奖时使用配置中的限制
+func (a *activityService) AddActivity(ctx context.Context, activity *model.Activity) error {
+	if activity.SysStatus == 0 {
+		activity.SysStatus = constant.ActivityStatusNormal
+	}
+	if err := a.activityRepo.Create(gormcli.GetDB(), activity); err != nil {
+		log.ErrorContextf(ctx, "activityService|AddActivity:%v", err)
+		return fmt.Errorf("activityService|AddActivity:%v", err)
+	}
+	return nil
+}
+
+// UpdateActivity 修改活动，同时清空活动缓存
+func (a *activityService) UpdateActivity(ctx context.Context, activity *model.Activity) error {
+	if activity == nil || activity.Id <= 0 {
+		return fmt.Errorf("activityService|UpdateActivity invalid activity")
+	}
+	if err := a.activityRepo.UpdateWithCache(gormcli.GetDB(), activity, "title", "description", "begin_time",
+		"end_time", "user_day_limit", "ip_day_limit", "black_time", "black_policy", "draw_cost", "bundle_guarantee",
+		"pity_boost_after", "pity_boost_step", "pity_guarantee_after", "pity_prize_id", "risk_rules", "sys_status"); err != nil {
+		log.ErrorContextf(ctx, "activityService|UpdateActivity:%v", err)
+		return fmt.Errorf("activityService|UpdateActivity:%v", err)
+	}
+	return nil
+}
+
+// CheckActivity 验证活动是否可以抽奖，活动存在、状态正常并且在活动时间内才可以
+// 返回的活动中没有单独设置的限制已经按照当前配置填充，调用方直接使用即可
+func (a *activityService) CheckActivity(ctx context.Context, id uint) (bool, *model.Activity, error) {
+	activity, err := a.GetActivityWithCache(ctx, id)
+	if err != nil {
+		return false, nil, err
+	}
+	if activity == nil || activity.SysStatus != constant.ActivityStatusNormal {
+		return false, activity, nil
+	}
+	now := time.Now()
+	if activity.BeginTime.After(now) || activity.EndTime.Before(now) {
+		return false, activity, nil
+	}
+	return true, withLotteryConfig(activity), nil
+}
+
+// withLotteryConfig 活动没有单独设置的限制使用配置中的值，返回副本，不修改缓存中的活动
+func withLotteryConfig(activity *model.Activity) *model.Activity {
+	conf := configs.GetLotteryConfig()
+	copied := *activity
+	if copied.UserDayLimit <= 0 {
+		copied.UserDayLimit = conf.UserPrizeMax
+	}
+	if copied.IpDayLimit <= 0 {
+		copied.IpDayLimit = conf.IpLimitMax
+	}
+	if copied.BlackTime <= 0 {
+		copied.BlackTime = conf.DefaultBlackTime
+	}
+	return &copied
+}
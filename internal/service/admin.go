@@ -3,19 +3,24 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"lottery_single/configs"
 	"lottery_single/internal/model"
 	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/draw"
 	"lottery_single/internal/pkg/middlewares/cache"
 	"lottery_single/internal/pkg/middlewares/gormcli"
 	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/sheet"
 	"lottery_single/internal/pkg/utils"
 	"lottery_single/internal/repo"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // AdminService 后将系统后台管理功能
@@ -26,17 +31,18 @@ type AdminService interface {
 	DeleteUser(ctx context.Context, userID uint) error
 	GetAllUsers(ctx context.Context) ([]*model.User, error)
 	//IP黑名单
-	AddBlackIP(ctx context.Context, blackIP *model.BlackIp) error
-	DeleteBlackIP(ctx context.Context, id uint) error
+	AddBlackIP(ctx context.Context, ban *BlackIpBan) (*model.BlackIp, bool, error)
+	ImportBlackIPRows(ctx context.Context, rows []sheet.Row, ban *BlackIpBan) (*BlackIpImportResult, error)
+	DeleteBlackIP(ctx context.Context, id uint) (*model.BlackIp, error)
 	GetAllBlackIPs(ctx context.Context) ([]*model.BlackIp, error)
 	// 奖品操作
 	AddPrize(ctx context.Context, viewPrize *ViewPrize) error
 	AddPrizeWithCache(ctx context.Context, viewPrize *ViewPrize) error
 	AddPrizeWithPool(ctx context.Context, viewPrize *ViewPrize) error
-	GetPrizeList(ctx context.Context) ([]*model.Prize, error)
-	GetPrizeListWithCache(ctx context.Context) ([]*model.Prize, error)
-	GetViewPrizeList(ctx context.Context) ([]*ViewPrize, error)
-	GetViewPrizeListWithCache(ctx context.Context) ([]*ViewPrize, error)
+	GetPrizeList(ctx context.Context, activityID uint) ([]*model.Prize, error)
+	GetPrizeListWithCache(ctx context.Context, activityID uint) ([]*model.Prize, error)
+	GetViewPrizeList(ctx context.Context, activityID uint) ([]*ViewPrize, error)
+	GetViewPrizeListWithCache(ctx context.Context, activityID uint) ([]*ViewPrize, error)
 	GetPrize(ctx context.Context, id uint) (*ViewPrize, error)
 	UpdatePrize(ctx context.Context, viewPrize *ViewPrize) error
 	UpdateDbPrizeWithCache(ctx context.Context, db *gorm.DB, prize *model.Prize, cols ...string) error
@@ -48,23 +54,46 @@ type AdminService interface {
 	GetCouponList(ctx context.Context, prizeID uint) ([]*ViewCouponInfo, int64, int64, error)
 	ImportCoupon(ctx context.Context, prizeID uint, codes string) (int, int, error)
 	ImportCouponWithCache(ctx context.Context, prizeID uint, codes string) (int, int, error)
+	ImportCouponRows(ctx context.Context, prizeID uint, rows []sheet.Row, opt *CouponImportOption) (*CouponImportResult, error)
+	GetCouponImportReport(ctx context.Context, reportID string) (string, bool, error)
+	VoidCoupons(ctx context.Context, filter *CouponFilter) (*CouponStatusResult, error)
+	RestoreCoupons(ctx context.Context, filter *CouponFilter) (*CouponStatusResult, error)
+	ExpireCoupons(ctx context.Context) (map[uint]int64, error)
+	ReCacheCoupon(ctx context.Context, prizeID uint, source string, dryRun bool) (*model.CouponReconcile, error)
+	ReCacheAllCoupons(ctx context.Context, source string, dryRun bool) ([]*model.CouponReconcile, error)
+	ListCouponReconciles(ctx context.Context, prizeID uint, limit int) ([]*model.CouponReconcile, error)
+
+	// 中奖记录操作
+	UpdateResultStatus(ctx context.Context, id uint, status uint, returnStock bool) (*model.Result, error)
 }
 
 type adminService struct {
-	couponRepo  *repo.CouponRepo
-	prizeRepo   *repo.PrizeReop
-	userRepo    *repo.UserRepo
-	blackIpRepo *repo.BlackIpRepo
+	couponRepo    *repo.CouponRepo
+	reconcileRepo *repo.CouponReconcileRepo
+	prizeRepo     *repo.PrizeReop
+	userRepo      *repo.UserRepo
+	blackIpRepo   *repo.BlackIpRepo
+	blackUserRepo *repo.BlackUserRepo
+	resultRepo    *repo.ResultRepo
+	activityRepo  *repo.ActivityRepo
+	fulfilRepo    *repo.FulfilmentRepo
+	walletService *walletService
 }
 
 var adminServiceImpl *adminService
 
 func InitAdminService() {
 	adminServiceImpl = &adminService{
-		couponRepo:  repo.NewCouponRepo(),
-		prizeRepo:   repo.NewPrizeRepo(),
-		userRepo:    repo.NewUserRepo(),
-		blackIpRepo: repo.NewBlackIpRepo(),
+		couponRepo:    repo.NewCouponRepo(),
+		reconcileRepo: repo.NewCouponReconcileRepo(),
+		prizeRepo:     repo.NewPrizeRepo(),
+		userRepo:      repo.NewUserRepo(),
+		blackIpRepo:   repo.NewBlackIpRepo(),
+		blackUserRepo: repo.NewBlackUserRepo(),
+		resultRepo:    repo.NewResultRepo(),
+		activityRepo:  repo.NewActivityRepo(),
+		fulfilRepo:    repo.NewFulfilmentRepo(),
+		walletService: walletServiceImpl,
 	}
 }
 
@@ -76,23 +105,111 @@ func (a *adminService) AddUser(ctx context.Context, user *model.User) error {
 	return a.userRepo.CreateUser(gormcli.GetDB(), user)
 }
 
-func (a *adminService) AddBlackIP(ctx context.Context, blackIP *model.BlackIp) error {
-	return a.blackIpRepo.Create(gormcli.GetDB(), blackIP)
+// ErrInvalidBlackIP IP黑名单的地址不是合法的IP或者CIDR网段
+var ErrInvalidBlackIP = errors.New("invalid black ip")
+
+// blackIpExpireTime 校验拉黑时长和原因，返回黑名单的到期时间
+func blackIpExpireTime(ban *BlackIpBan, now time.Time) (time.Time, error) {
+	ban.Preset = strings.TrimSpace(ban.Preset)
+	ban.Reason = strings.TrimSpace(ban.Reason)
+	if utf8.RuneCountInString(ban.Reason) > constant.BlackIpReasonMaxLen {
+		return time.Time{}, fmt.Errorf("%w:reason is at most %d characters", ErrInvalidBlackIP,
+			constant.BlackIpReasonMaxLen)
+	}
+	if ban.BlackTime == nil {
+		duration, err := blackDuration(ban.Preset, ban.Seconds, constant.BlackIpMaxSeconds)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w:%v", ErrInvalidBlackIP, err)
+		}
+		return now.Add(duration), nil
+	}
+	if ban.Preset != "" || ban.Seconds != 0 {
+		return time.Time{}, fmt.Errorf("%w:only one of preset, seconds and black_time is allowed", ErrInvalidBlackIP)
+	}
+	maxTime := now.Add(constant.BlackIpMaxSeconds * time.Second)
+	if !ban.BlackTime.After(now) || ban.BlackTime.After(maxTime) {
+		return time.Time{}, fmt.Errorf("%w:black_time must be after now and within %d seconds", ErrInvalidBlackIP,
+			constant.BlackIpMaxSeconds)
+	}
+	return *ban.BlackTime, nil
+}
+
+// AddBlackIP 添加IP黑名单，支持IPv4、IPv6和CIDR网段，保存规范化之后的格式，IP已经存在时更新到期时间，返回是否新建
+func (a *adminService) AddBlackIP(ctx context.Context, ban *BlackIpBan) (*model.BlackIp, bool, error) {
+	prefix, err := utils.ParseIPRange(ban.IP)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w:%v", ErrInvalidBlackIP, err)
+	}
+	blackTime, err := blackIpExpireTime(ban, time.Now())
+	if err != nil {
+		return nil, false, err
+	}
+	blackIP := &model.BlackIp{
+		Ip:        utils.FormatIPRange(prefix),
+		BlackTime: blackTime,
+		Reason:    ban.Reason,
+		Operator:  ban.Operator,
+	}
+	created, err := a.upsertBlackIP(gormcli.GetDB(), blackIP)
+	GetLimitService().InvalidateBlackIpRanges()
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|AddBlackIP:%v", err)
+		return nil, false, fmt.Errorf("adminService|AddBlackIP:%v", err)
+	}
+	log.InfoContextf(ctx, "adminService|AddBlackIP ip=%s black_time=%s operator=%s created=%v", blackIP.Ip,
+		blackTime.Format(time.RFC3339), ban.Operator, created)
+	return blackIP, created, nil
+}
+
+// upsertBlackIP IP不存在时新建，存在时更新到期时间、原因和操作人，写入数据库之后删除缓存
+func (a *adminService) upsertBlackIP(db *gorm.DB, blackIP *model.BlackIp) (bool, error) {
+	// 依赖ip的唯一索引，同时添加同一个IP时后写入的只更新记录
+	created, err := a.blackIpRepo.Upsert(db, blackIP, "black_time", "reason", "operator")
+	if err != nil {
+		return false, err
+	}
+	saved, err := a.blackIpRepo.GetByIP(db, blackIP.Ip)
+	if err != nil {
+		return false, err
+	}
+	if saved != nil {
+		*blackIP = *saved
+	}
+	// 之前删除的记录可能还留在缓存中
+	return created, a.blackIpRepo.UpdateByCache(blackIP)
 }
 
-func (a *adminService) DeleteBlackIP(ctx context.Context, id uint) error {
-	return a.blackIpRepo.Delete(gormcli.GetDB(), id)
+// DeleteBlackIP 删除IP黑名单，同时删除缓存，记录不存在时返回nil
+func (a *adminService) DeleteBlackIP(ctx context.Context, id uint) (*model.BlackIp, error) {
+	blackIP, err := a.blackIpRepo.DeleteWithCache(gormcli.GetDB(), id)
+	GetLimitService().InvalidateBlackIpRanges()
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|DeleteBlackIP:%v", err)
+		return nil, fmt.Errorf("adminService|DeleteBlackIP:%v", err)
+	}
+	if blackIP != nil {
+		log.InfoContextf(ctx, "adminService|DeleteBlackIP id=%d ip=%s", id, blackIP.Ip)
+	}
+	return blackIP, nil
 }
 
 func (a *adminService) GetAllBlackIPs(ctx context.Context) ([]*model.BlackIp, error) {
 	return a.blackIpRepo.GetAll(gormcli.GetDB())
 }
 
-// GetPrizeList 获取db奖品列表
-func (a *adminService) GetPrizeList(ctx context.Context) ([]*model.Prize, error) {
+// GetPrizeList 获取db奖品列表，activityID为0时获取所有活动的奖品
+func (a *adminService) GetPrizeList(ctx context.Context, activityID uint) ([]*model.Prize, error) {
 	log.InfoContextf(ctx, "GetPrizeList!!!!!")
+	var (
+		list []*model.Prize
+		err  error
+	)
 	db := gormcli.GetDB()
-	list, err := a.prizeRepo.GetAll(db)
+	if activityID > 0 {
+		list, err = a.prizeRepo.GetAllByActivity(db, activityID)
+	} else {
+		list, err = a.prizeRepo.GetAll(db)
+	}
 	if err != nil {
 		log.ErrorContextf(ctx, "prizeService|GetPrizeList err:%v", err)
 		return nil, fmt.Errorf("prizeService|GetPrizeList: %v", err)
@@ -107,11 +224,11 @@ func (a *adminService) DeletePrize(ctx context.Context, id uint) error {
 	return a.prizeRepo.Delete(gormcli.GetDB(), id)
 }
 
-// GetPrizeListWithCache 获取db奖品列表
-func (a *adminService) GetPrizeListWithCache(ctx context.Context) ([]*model.Prize, error) {
+// GetPrizeListWithCache 获取某个活动的奖品列表
+func (a *adminService) GetPrizeListWithCache(ctx context.Context, activityID uint) ([]*model.Prize, error) {
 	log.InfoContextf(ctx, "GetPrizeListWithCache!!!!!")
 	db := gormcli.GetDB()
-	list, err := a.prizeRepo.GetAllWithCache(db)
+	list, err := a.prizeRepo.GetAllWithCache(db, activityID)
 	if err != nil {
 		log.ErrorContextf(ctx, "prizeService|GetPrizeList err:%v", err)
 		return nil, fmt.Errorf("prizeService|GetPrizeList: %v", err)
@@ -120,10 +237,10 @@ func (a *adminService) GetPrizeListWithCache(ctx context.Context) ([]*model.Priz
 }
 
 // GetViewPrizeList 获取奖品列表,这个方法用于管理后台使用，因为管理后台不需要高性能，所以不走缓存
-func (a *adminService) GetViewPrizeList(ctx context.Context) ([]*ViewPrize, error) {
+func (a *adminService) GetViewPrizeList(ctx context.Context, activityID uint) ([]*ViewPrize, error) {
 	log.InfoContextf(ctx, "GetPrizeList!!!!!")
 	db := gormcli.GetDB()
-	list, err := a.prizeRepo.GetAll(db)
+	list, err := a.prizeRepo.GetAllByActivity(db, activityID)
 	if err != nil {
 		log.ErrorContextf(ctx, "prizeService|GetPrizeList err:%v", err)
 		return nil, fmt.Errorf("prizeService|GetPrizeList: %v", err)
@@ -133,18 +250,19 @@ func (a *adminService) GetViewPrizeList(ctx context.Context) ([]*ViewPrize, erro
 		if prize.SysStatus != constant.PrizeStatusNormal {
 			continue
 		}
-		num, err := a.prizeRepo.GetPrizePoolNum(prize.Id)
+		num, err := a.prizeRepo.GetPrizePoolNum(activityID, prize.Id)
 		if err != nil {
 			return nil, fmt.Errorf("prizeService|GetPrizeList: %v", err)
 		}
 		title := fmt.Sprintf("【%d】%s", num, prize.Title)
 		prizeList = append(prizeList, &ViewPrize{
-			Id:        prize.Id,
-			Title:     title,
-			Img:       prize.Img,
-			PrizeNum:  prize.PrizeNum,
-			LeftNum:   prize.LeftNum,
-			PrizeType: prize.PrizeType,
+			Id:         prize.Id,
+			ActivityId: prize.ActivityId,
+			Title:      title,
+			Img:        prize.Img,
+			PrizeNum:   prize.PrizeNum,
+			LeftNum:    prize.LeftNum,
+			PrizeType:  prize.PrizeType,
 		})
 
 	}
@@ -152,10 +270,10 @@ func (a *adminService) GetViewPrizeList(ctx context.Context) ([]*ViewPrize, erro
 }
 
 // GetViewPrizeListWithCache 获取奖品列表,优先从缓存获取
-func (a *adminService) GetViewPrizeListWithCache(ctx context.Context) ([]*ViewPrize, error) {
+func (a *adminService) GetViewPrizeListWithCache(ctx context.Context, activityID uint) ([]*ViewPrize, error) {
 	log.InfoContextf(ctx, "GetViewPrizeListWithCache!!!!!")
 	db := gormcli.GetDB()
-	list, err := a.prizeRepo.GetAllWithCache(db)
+	list, err := a.prizeRepo.GetAllWithCache(db, activityID)
 	if err != nil {
 		log.ErrorContextf(ctx, "prizeService|GetPrizeList err:%v", err)
 		return nil, fmt.Errorf("prizeService|GetPrizeList: %v", err)
@@ -166,12 +284,13 @@ func (a *adminService) GetViewPrizeListWithCache(ctx context.Context) ([]*ViewPr
 			continue
 		}
 		prizeList = append(prizeList, &ViewPrize{
-			Id:        prize.Id,
-			Title:     prize.Title,
-			Img:       prize.Img,
-			PrizeNum:  prize.PrizeNum,
-			LeftNum:   prize.LeftNum,
-			PrizeType: prize.PrizeType,
+			Id:         prize.Id,
+			ActivityId: prize.ActivityId,
+			Title:      prize.Title,
+			Img:        prize.Img,
+			PrizeNum:   prize.PrizeNum,
+			LeftNum:    prize.LeftNum,
+			PrizeType:  prize.PrizeType,
 		})
 	}
 	return prizeList, nil
@@ -185,12 +304,21 @@ func (a *adminService) GetPrize(ctx context.Context, id uint) (*ViewPrize, error
 		return nil, fmt.Errorf("prizeService|GetPrize:%v", err)
 	}
 	prize := &ViewPrize{
-		Id:        prizeModel.Id,
-		Title:     prizeModel.Title,
-		Img:       prizeModel.Img,
-		PrizeNum:  prizeModel.PrizeNum,
-		LeftNum:   prizeModel.LeftNum,
-		PrizeType: prizeModel.PrizeType,
+		Id:           prizeModel.Id,
+		ActivityId:   prizeModel.ActivityId,
+		Title:        prizeModel.Title,
+		Img:          prizeModel.Img,
+		PrizeNum:     prizeModel.PrizeNum,
+		LeftNum:      prizeModel.LeftNum,
+		PrizeCode:    prizeModel.PrizeCode,
+		Probability:  prizeModel.Probability,
+		PrizeType:    prizeModel.PrizeType,
+		PrizeProfile: prizeModel.PrizeProfile,
+	}
+	if prizeModel.PrizeType == constant.PrizeTypeCouponSame {
+		if prize.CouponProfile, err = parseCouponProfile(prizeModel.PrizeProfile); err != nil {
+			log.ErrorContextf(ctx, "prizeService|GetPrize prize_id=%d:%v", id, err)
+		}
 	}
 	return prize, nil
 }
@@ -203,10 +331,12 @@ func (a *adminService) AddPrize(ctx context.Context, viewPrize *ViewPrize) error
 		}
 	}()
 	prize := model.Prize{
+		ActivityId:   prizeActivityID(viewPrize),
 		Title:        viewPrize.Title,
 		PrizeNum:     viewPrize.PrizeNum,
 		LeftNum:      viewPrize.PrizeNum,
 		PrizeCode:    viewPrize.PrizeCode,
+		Probability:  viewPrize.Probability,
 		PrizeTime:    viewPrize.PrizeTime,
 		Img:          viewPrize.Img,
 		DisplayOrder: viewPrize.DisplayOrder,
@@ -216,6 +346,14 @@ func (a *adminService) AddPrize(ctx context.Context, viewPrize *ViewPrize) error
 		PrizePlan:    viewPrize.PrizePlan,
 		SysStatus:    1,
 	}
+	profile, err := newPrizeProfile(viewPrize, time.Now())
+	if err != nil {
+		return fmt.Errorf("adminService|AddPrize:%w", err)
+	}
+	prize.PrizeProfile = profile
+	if err := a.checkPrizeProbability(ctx, &prize); err != nil {
+		return fmt.Errorf("adminService|AddPrize:%w", err)
+	}
 	// 因为奖品是全量string缓存，新增奖品之后缓存有变动，所有要更新
 	if err := a.prizeRepo.Create(gormcli.GetDB(), &prize); err != nil {
 		log.Errorf("adminService|AddPrize err:%v", err)
@@ -227,10 +365,12 @@ func (a *adminService) AddPrize(ctx context.Context, viewPrize *ViewPrize) error
 // AddPrizeWithPool 带奖品池的新增奖品实现
 func (a *adminService) AddPrizeWithPool(ctx context.Context, viewPrize *ViewPrize) error {
 	prize := model.Prize{
+		ActivityId:   prizeActivityID(viewPrize),
 		Title:        viewPrize.Title,
 		PrizeNum:     viewPrize.PrizeNum,
 		LeftNum:      viewPrize.PrizeNum,
 		PrizeCode:    viewPrize.PrizeCode,
+		Probability:  viewPrize.Probability,
 		PrizeTime:    viewPrize.PrizeTime,
 		Img:          viewPrize.Img,
 		DisplayOrder: viewPrize.DisplayOrder,
@@ -241,6 +381,14 @@ func (a *adminService) AddPrizeWithPool(ctx context.Context, viewPrize *ViewPriz
 		SysStatus:    1,
 		//SysUpdated:   time.Now(),
 	}
+	profile, err := newPrizeProfile(viewPrize, time.Now())
+	if err != nil {
+		return fmt.Errorf("adminService|AddPrizeWithPool:%w", err)
+	}
+	prize.PrizeProfile = profile
+	if err := a.checkPrizeProbability(ctx, &prize); err != nil {
+		return fmt.Errorf("adminService|AddPrize:%w", err)
+	}
 	// 因为奖品是全量string缓存，新增奖品之后缓存有变动，所有要更新
 	if err := a.prizeRepo.CreateWithCache(gormcli.GetDB(), &prize); err != nil {
 		log.Errorf("adminService|AddPrize err:%v", err)
@@ -256,10 +404,12 @@ func (a *adminService) AddPrizeWithPool(ctx context.Context, viewPrize *ViewPriz
 // AddPrizeWithCache 带缓存优化的新增奖品
 func (a *adminService) AddPrizeWithCache(ctx context.Context, viewPrize *ViewPrize) error {
 	prize := model.Prize{
+		ActivityId:   prizeActivityID(viewPrize),
 		Title:        viewPrize.Title,
 		PrizeNum:     viewPrize.PrizeNum,
 		LeftNum:      viewPrize.PrizeNum,
 		PrizeCode:    viewPrize.PrizeCode,
+		Probability:  viewPrize.Probability,
 		PrizeTime:    viewPrize.PrizeTime,
 		Img:          viewPrize.Img,
 		DisplayOrder: viewPrize.DisplayOrder,
@@ -270,6 +420,14 @@ func (a *adminService) AddPrizeWithCache(ctx context.Context, viewPrize *ViewPri
 		SysStatus:    1,
 		//SysUpdated:   time.Now(),
 	}
+	profile, err := newPrizeProfile(viewPrize, time.Now())
+	if err != nil {
+		return fmt.Errorf("adminService|AddPrizeWithCache:%w", err)
+	}
+	prize.PrizeProfile = profile
+	if err := a.checkPrizeProbability(ctx, &prize); err != nil {
+		return fmt.Errorf("adminService|AddPrize:%w", err)
+	}
 	// 因为奖品是全量string缓存，新增奖品之后缓存有变动，所有要更新
 	if err := a.prizeRepo.CreateWithCache(gormcli.GetDB(), &prize); err != nil {
 		log.Errorf("adminService|AddPrize err:%v", err)
@@ -292,10 +450,12 @@ func (a *adminService) UpdatePrize(ctx context.Context, viewPrize *ViewPrize) er
 		return fmt.Errorf("adminService|UpdatePrize invalid prize")
 	}
 	prize := model.Prize{
+		Id:           viewPrize.Id,
 		Title:        viewPrize.Title,
 		PrizeNum:     viewPrize.PrizeNum,
 		LeftNum:      viewPrize.LeftNum,
 		PrizeCode:    viewPrize.PrizeCode,
+		Probability:  viewPrize.Probability,
 		PrizeTime:    viewPrize.PrizeTime,
 		Img:          viewPrize.Img,
 		DisplayOrder: viewPrize.DisplayOrder,
@@ -314,6 +474,16 @@ func (a *adminService) UpdatePrize(ctx context.Context, viewPrize *ViewPrize) er
 		log.Errorf("adminService|UpdatePrize prize not exists with id: %d", viewPrize.Id)
 		return fmt.Errorf("adminService|UpdatePrize prize not exists with id: %d", viewPrize.Id)
 	}
+	// 奖品不能修改所属的活动
+	prize.ActivityId = oldPrize.ActivityId
+	profile, err := newPrizeProfile(viewPrize, time.Now())
+	if err != nil {
+		return fmt.Errorf("adminService|UpdatePrize:%w", err)
+	}
+	prize.PrizeProfile = profile
+	if err := a.checkPrizeProbability(ctx, &prize); err != nil {
+		return fmt.Errorf("adminService|UpdatePrize:%w", err)
+	}
 	// 奖品数量发生了改变
 	if prize.PrizeNum != oldPrize.PrizeNum {
 		if prize.PrizeNum <= 0 {
@@ -323,8 +493,8 @@ func (a *adminService) UpdatePrize(ctx context.Context, viewPrize *ViewPrize) er
 			prize.LeftNum = 0
 		}
 	}
-	if a.prizeRepo.Update(gormcli.GetDB(), &prize, "title", "prize_num", "left_num", "prize_code", "prize_time", "img",
-		"display_order", "prize_type", "begin_time", "end_time", "prize_plan"); err != nil {
+	if a.prizeRepo.Update(gormcli.GetDB(), &prize, "title", "prize_num", "left_num", "prize_code", "probability", "prize_time", "img",
+		"display_order", "prize_type", "prize_profile", "begin_time", "end_time", "prize_plan"); err != nil {
 		log.Errorf("adminService|UpdatePrize Update prize err:%v", err)
 		return fmt.Errorf("adminService|UpdatePrize Update prize:%v", err)
 	}
@@ -338,10 +508,12 @@ func (a *adminService) UpdatePrizeWithPool(ctx context.Context, viewPrize *ViewP
 		return fmt.Errorf("adminService|UpdatePrize invalid prize")
 	}
 	prize := model.Prize{
+		Id:           viewPrize.Id,
 		Title:        viewPrize.Title,
 		PrizeNum:     viewPrize.PrizeNum,
 		LeftNum:      viewPrize.LeftNum,
 		PrizeCode:    viewPrize.PrizeCode,
+		Probability:  viewPrize.Probability,
 		PrizeTime:    viewPrize.PrizeTime,
 		Img:          viewPrize.Img,
 		DisplayOrder: viewPrize.DisplayOrder,
@@ -360,6 +532,16 @@ func (a *adminService) UpdatePrizeWithPool(ctx context.Context, viewPrize *ViewP
 		log.Errorf("adminService|UpdatePrize prize not exists with id: %d", viewPrize.Id)
 		return fmt.Errorf("adminService|UpdatePrize prize not exists with id: %d", viewPrize.Id)
 	}
+	// 奖品不能修改所属的活动
+	prize.ActivityId = oldPrize.ActivityId
+	profile, err := newPrizeProfile(viewPrize, time.Now())
+	if err != nil {
+		return fmt.Errorf("adminService|UpdatePrize:%w", err)
+	}
+	prize.PrizeProfile = profile
+	if err := a.checkPrizeProbability(ctx, &prize); err != nil {
+		return fmt.Errorf("adminService|UpdatePrize:%w", err)
+	}
 	// 奖品数量发生了改变
 	if prize.PrizeNum != oldPrize.PrizeNum {
 		if prize.PrizeNum <= 0 {
@@ -379,14 +561,56 @@ func (a *adminService) UpdatePrizeWithPool(ctx context.Context, viewPrize *ViewP
 			return fmt.Errorf("adminService|UpdatePrize ResetPrizePlan prize err:%v", err)
 		}
 	}
-	if a.prizeRepo.Update(gormcli.GetDB(), &prize, "title", "prize_num", "left_num", "prize_code", "prize_time", "img",
-		"display_order", "prize_type", "begin_time", "end_time", "prize_plan"); err != nil {
+	if a.prizeRepo.Update(gormcli.GetDB(), &prize, "title", "prize_num", "left_num", "prize_code", "probability", "prize_time", "img",
+		"display_order", "prize_type", "prize_profile", "begin_time", "end_time", "prize_plan"); err != nil {
 		log.Errorf("adminService|UpdatePrize Update prize err:%v", err)
 		return fmt.Errorf("adminService|UpdatePrize Update prize:%v", err)
 	}
 	return nil
 }
 
+// prizeActivityID 奖品所属的活动，没有指定时属于默认活动
+func prizeActivityID(viewPrize *ViewPrize) uint {
+	if viewPrize.ActivityId > 0 {
+		return viewPrize.ActivityId
+	}
+	return constant.DefaultActivityID
+}
+
+// checkPrizeProbability 校验新增或者修改奖品之后，同一个活动中所有可能参与抽奖的奖品中奖概率总和不超过100%
+func (a *adminService) checkPrizeProbability(ctx context.Context, prize *model.Prize) error {
+	list, err := a.prizeRepo.GetAllByActivity(gormcli.GetDB(), prize.ActivityId)
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|checkPrizeProbability:%v", err)
+		return err
+	}
+	if prize.Probability > 0 {
+		if _, err = draw.ProbToWeight(prize.Probability); err != nil {
+			log.ErrorContextf(ctx, "adminService|checkPrizeProbability:%v", err)
+			return err
+		}
+	}
+	now := time.Now()
+	prizeList := make([]*model.Prize, 0, len(list)+1)
+	for _, p := range list {
+		if p.Id == prize.Id {
+			continue
+		}
+		if p.SysStatus == constant.PrizeStatusNormal && p.PrizeNum > 0 && p.EndTime.After(now) {
+			prizeList = append(prizeList, p)
+		}
+	}
+	if prize.SysStatus == constant.PrizeStatusNormal {
+		prizeList = append(prizeList, prize)
+	}
+	lotteryPrizeList := toLotteryPrizeList(ctx, prizeList)
+	if err = draw.Validate(toDrawItems(lotteryPrizeList)); err != nil {
+		log.ErrorContextf(ctx, "adminService|checkPrizeProbability:%v", err)
+		return err
+	}
+	return nil
+}
+
 // GetCouponList 获取优惠券列表,库存优惠券数量和缓存优惠券数量，当这两个数量不一致的时候，需要重置缓存优惠券数量
 func (a *adminService) GetCouponList(ctx context.Context, prizeID uint) ([]*ViewCouponInfo, int64, int64, error) {
 	var (
@@ -413,9 +637,12 @@ func (a *adminService) GetCouponList(ctx context.Context, prizeID uint) ([]*View
 	}
 	for _, coupon := range couponList {
 		viewCouponList = append(viewCouponList, &ViewCouponInfo{
-			Id:      coupon.Id,
-			PrizeId: coupon.PrizeId,
-			Code:    coupon.Code,
+			Id:        coupon.Id,
+			PrizeId:   coupon.PrizeId,
+			Code:      coupon.Code,
+			BatchNo:   coupon.BatchNo,
+			ValidFrom: coupon.ValidFrom,
+			ValidTo:   coupon.ValidTo,
 			//SysCreated: coupon.SysCreated,
 			//SysUpdated: coupon.SysUpdated,
 			SysStatus: coupon.SysStatus,
@@ -449,7 +676,7 @@ func (a *adminService) ImportCoupon(ctx context.Context, prizeID uint, codes str
 			PrizeId:    prizeID,
 			Code:       code,
 			SysCreated: &currentTime, // 将临时变量的地址赋给 SysCreated 字段
-			SysStatus:  1,
+			SysStatus:  constant.CouponStatusNormal,
 		}
 		if err = a.couponRepo.Create(gormcli.GetDB(), coupon); err != nil {
 			failNum++
@@ -465,7 +692,7 @@ func (a *adminService) ImportCouponWithCache(ctx context.Context, prizeID uint,
 	if prizeID <= 0 {
 		return 0, 0, fmt.Errorf("adminService|ImportCoupon invalid prizeID:%d", prizeID)
 	}
-	prize, err := a.prizeRepo.GetWithCache(gormcli.GetDB(), prizeID)
+	prize, err := a.prizeRepo.Get(gormcli.GetDB(), prizeID)
 	if err != nil {
 		return 0, 0, fmt.Errorf("adminService|ImportCoupon invalid prizeID:%d", prizeID)
 	}
@@ -484,7 +711,7 @@ func (a *adminService) ImportCouponWithCache(ctx context.Context, prizeID uint,
 			PrizeId: prizeID,
 			Code:    code,
 			//SysCreated: time.Now(),
-			SysStatus: 1,
+			SysStatus: constant.CouponStatusNormal,
 		}
 		if err = a.couponRepo.Create(gormcli.GetDB(), coupon); err != nil {
 			failNum++
@@ -504,18 +731,6 @@ func (a *adminService) ImportCouponWithCache(ctx context.Context, prizeID uint,
 	return successNum, failNum, nil
 }
 
-// ReCacheCoupon 根据数据库重置某种奖品的优惠券数据到缓存中
-func (a *adminService) ReCacheCoupon(ctx *context.Context, prizeID uint) (int64, int64, error) {
-	if prizeID <= 0 {
-		return 0, 0, fmt.Errorf("adminService|ReCacheCoupon invalid prizeID:%d", prizeID)
-	}
-	successNum, failureNum, err := a.couponRepo.ReSetCacheCoupon(gormcli.GetDB(), prizeID)
-	if err != nil {
-		return 0, 0, fmt.Errorf("adminService|ReCacheCoupon:%v", err)
-	}
-	return successNum, failureNum, nil
-}
-
 // ResetPrizePlan 重置某种奖品的发奖计划
 func (a *adminService) ResetPrizePlan(ctx context.Context, prize *model.Prize) error {
 	if prize == nil || prize.Id < 1 {
@@ -538,13 +753,13 @@ func (a *adminService) ResetPrizePlan(ctx context.Context, prize *model.Prize) e
 	// PrizeTime, 发奖周期，这类奖品需要在多少天内发完
 	prizePlanDays := int(prize.PrizeTime)
 	if prizePlanDays <= 0 {
-		a.setPrizePool(ctx, prize.Id, prize.LeftNum)
+		a.setPrizePool(ctx, prize.ActivityId, prize.Id, prize.LeftNum)
 		log.InfoContext(ctx, "adminService|ResetGiftPrizePlan|prizePlanDays <= 0")
 		return nil
 	}
 	// 对于设置发奖周期的奖品重新计算出来合适的奖品发放节奏
 	// 奖品池的剩余数先设置为空
-	a.setPrizePool(ctx, prize.Id, 0)
+	a.setPrizePool(ctx, prize.ActivityId, prize.Id, 0)
 	// 发奖周期中的每天的发奖概率一样，一天内24小时，每个小时的概率是不一样的，每个小时内的每一分钟的概率一样
 	prizeNum := prize.PrizeNum
 	// 先计算每天至少发多少奖
@@ -589,6 +804,7 @@ func (a *adminService) ResetPrizePlan(ctx context.Context, prize *model.Prize) e
 	// 保存奖品的分布计划数据
 	info := &model.Prize{
 		Id:         prize.Id,
+		ActivityId: prize.ActivityId,
 		LeftNum:    prize.PrizeNum,
 		PrizePlan:  string(bytes),
 		PrizeBegin: now,
@@ -605,8 +821,9 @@ func (a *adminService) ResetPrizePlan(ctx context.Context, prize *model.Prize) e
 // clearPrizeData 清空奖品的发放计划
 func (a *adminService) clearPrizePlan(ctx context.Context, prize *model.Prize) error {
 	info := &model.Prize{
-		Id:        prize.Id,
-		PrizePlan: "",
+		Id:         prize.Id,
+		ActivityId: prize.ActivityId,
+		PrizePlan:  "",
 	}
 	err := a.prizeRepo.UpdateWithCache(gormcli.GetDB(), info, "prize_plan")
 	if err != nil {
@@ -614,15 +831,15 @@ func (a *adminService) clearPrizePlan(ctx context.Context, prize *model.Prize) e
 		return fmt.Errorf("limitService|clearPrizePlan:%v", err)
 	}
 	//奖品池也设为0
-	if err = a.setPrizePool(ctx, prize.Id, 0); err != nil {
+	if err = a.setPrizePool(ctx, prize.ActivityId, prize.Id, 0); err != nil {
 		return fmt.Errorf("limitService|clearPrizePlan:%v", err)
 	}
 	return nil
 }
 
-// setGiftPool 设置奖品池中某种奖品的数量
-func (a *adminService) setPrizePool(ctx context.Context, id uint, num int) error {
-	key := constant.PrizePoolCacheKey
+// setGiftPool 设置活动奖品池中某种奖品的数量
+func (a *adminService) setPrizePool(ctx context.Context, activityID uint, id uint, num int) error {
+	key := fmt.Sprintf(constant.PrizePoolCacheKeyPrefix+"%d", activityID)
 	idStr := strconv.Itoa(int(id))
 	_, err := cache.GetRedisCli().HSet(ctx, key, idStr, strconv.Itoa(num))
 	if err != nil {
@@ -772,3 +989,127 @@ func (a *adminService) GetAllUsers(ctx context.Context) ([]*model.User, error) {
 	}
 	return users, nil
 }
+
+var (
+	// ErrInvalidResultStatus 中奖记录不能修改为目标状态
+	ErrInvalidResultStatus = errors.New("invalid result status")
+	// errResultStatusChanged 事务中修改状态时发现状态已经被其他请求修改
+	errResultStatusChanged = errors.New("result status changed")
+)
+
+// checkResultStatus 校验中奖记录的状态变化，作弊是最终状态，收回的奖品不能再发给用户
+func checkResultStatus(from, to uint) error {
+	switch to {
+	case constant.ResultStatusNormal, constant.ResultStatusDelete, constant.ResultStatusCheat:
+	default:
+		return fmt.Errorf("invalid result status %d", to)
+	}
+	if from == to {
+		return fmt.Errorf("result status is already %d", to)
+	}
+	if from == constant.ResultStatusCheat {
+		return fmt.Errorf("result marked as cheating can not be changed")
+	}
+	return nil
+}
+
+// UpdateResultStatus 修改中奖记录状态
+// 判定为作弊时拉黑用户和IP，returnStock为true时同时收回奖品：库存和奖品池加回，发放的优惠券作废
+func (a *adminService) UpdateResultStatus(ctx context.Context, id uint, status uint, returnStock bool) (*model.Result, error) {
+	result, err := a.resultRepo.Get(gormcli.GetDB(), id)
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|UpdateResultStatus:%v", err)
+		return nil, fmt.Errorf("adminService|UpdateResultStatus:%v", err)
+	}
+	if result == nil {
+		return nil, nil
+	}
+	if err = checkResultStatus(result.SysStatus, status); err != nil {
+		return nil, fmt.Errorf("adminService|UpdateResultStatus:%w:%v", ErrInvalidResultStatus, err)
+	}
+	cheat := status == constant.ResultStatusCheat
+	returnStock = cheat && returnStock
+
+	var prize *model.Prize
+	if returnStock {
+		if prize, err = a.prizeRepo.Get(gormcli.GetDB(), result.PrizeId); err != nil {
+			log.ErrorContextf(ctx, "adminService|UpdateResultStatus:%v", err)
+			return nil, fmt.Errorf("adminService|UpdateResultStatus:%v", err)
+		}
+	}
+	err = gormcli.Transaction(ctx, func(txctx context.Context) error {
+		db := gormcli.GetDBFromCtx(txctx)
+		ok, err := a.resultRepo.UpdateStatus(db, id, result.SysStatus, status)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errResultStatusChanged
+		}
+		// 删除或者作弊的中奖记录不再发货
+		if status != constant.ResultStatusNormal {
+			if _, err = a.fulfilRepo.CancelByResultID(db, id, fmt.Sprintf("result status changed to %d", status)); err != nil {
+				return err
+			}
+		}
+		if !returnStock {
+			return nil
+		}
+		// 不限量的奖品没有库存，不需要加回
+		if prize != nil && prize.PrizeNum > 0 {
+			if err = a.prizeRepo.IncrLeftNum(db, int(prize.Id), "left_num", 1); err != nil {
+				return err
+			}
+		}
+		if result.PrizeType == constant.PrizeTypeCouponDiff && result.PrizeData != "" {
+			if _, err = a.couponRepo.UpdateStatusByCode(db, result.PrizeData, constant.CouponStatusIssued,
+				constant.CouponStatusRevoked); err != nil {
+				return err
+			}
+		}
+		if result.PrizeType == constant.PrizeTypeVirtualCoin {
+			return a.walletService.revokePrize(db, result)
+		}
+		return nil
+	})
+	if errors.Is(err, errResultStatusChanged) {
+		return nil, fmt.Errorf("adminService|UpdateResultStatus:%w:%v", ErrInvalidResultStatus, err)
+	}
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|UpdateResultStatus:%v", err)
+		return nil, fmt.Errorf("adminService|UpdateResultStatus:%v", err)
+	}
+	result.SysStatus = status
+	log.InfoContextf(ctx, "adminService|UpdateResultStatus id=%d status=%d return_stock=%v", id, status, returnStock)
+
+	// 以下操作在事务之外，失败时只记录日志，奖品池在下一次填充时修正
+	if returnStock && prize != nil && prize.PrizeNum > 0 {
+		if _, err = a.prizeRepo.IncrLeftNumByPool(prize.ActivityId, int(prize.Id), 1); err != nil {
+			log.ErrorContextf(ctx, "adminService|UpdateResultStatus|IncrLeftNumByPool:%v", err)
+		}
+		if err = a.prizeRepo.UpdateByCache(prize); err != nil {
+			log.ErrorContextf(ctx, "adminService|UpdateResultStatus|UpdateByCache:%v", err)
+		}
+	}
+	if cheat {
+		if err = a.blackCheatUser(ctx, result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// blackCheatUser 拉黑作弊的用户和IP，拉黑时间使用活动的配置，已经有更长的拉黑时保持不变
+func (a *adminService) blackCheatUser(ctx context.Context, result *model.Result) error {
+	blackTime := configs.GetLotteryConfig().DefaultBlackTime
+	activity, err := a.activityRepo.Get(gormcli.GetDB(), result.ActivityId)
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|blackCheatUser:%v", err)
+		return fmt.Errorf("adminService|blackCheatUser:%v", err)
+	}
+	if activity != nil {
+		blackTime = withLotteryConfig(activity).BlackTime
+	}
+	info := &LotteryUserInfo{UserID: result.UserId, UserName: result.UserName, IP: result.SysIp}
+	return GetLotteryService().PrizeLargeBlackLimit(ctx, info, blackTime)
+}
@@ -269,7 +269,8 @@ func TestExpr(t *testing.T) {
 
 func TestGetAllPrizeByCache(t *testing.T) {
 	InitTest()
-	valutStr, ok, err := cache.GetRedisCli().Get(context.Background(), constant.AllPrizeCacheKey)
+	valutStr, ok, err := cache.GetRedisCli().Get(context.Background(),
+		fmt.Sprintf(constant.AllPrizeCacheKeyPrefix+"%d", constant.DefaultActivityID))
 	t.Log(valutStr)
 	t.Log(ok)
 	t.Log(err)
@@ -288,9 +289,18 @@ func TestGetPrizeList(t *testing.T) {
 	adminService := GetAdminService()
 
 	// 调用GetPrizeList方法
-	prizes, err := adminService.GetPrizeList(ctx)
+	prizes, err := adminService.GetPrizeList(ctx, constant.DefaultActivityID)
 
 	// 使用assert进行错误检查和结果验证
 	assert.NoError(t, err)
 	assert.NotNil(t, prizes)
 }
+
+func TestCheckResultStatus(t *testing.T) {
+	assert.NoError(t, checkResultStatus(constant.ResultStatusNormal, constant.ResultStatusDelete))
+	assert.NoError(t, checkResultStatus(constant.ResultStatusDelete, constant.ResultStatusNormal))
+	assert.NoError(t, checkResultStatus(constant.ResultStatusNormal, constant.ResultStatusCheat))
+	assert.Error(t, checkResultStatus(constant.ResultStatusNormal, constant.ResultStatusNormal))
+	assert.Error(t, checkResultStatus(constant.ResultStatusCheat, constant.ResultStatusNormal))
+	assert.Error(t, checkResultStatus(constant.ResultStatusNormal, 9))
+}
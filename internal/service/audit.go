@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/draw"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/repo"
+	"sync"
+)
+
+// AuditService 抽奖审计，抽奖编码由服务端种子和请求信息计算得到，种子公开之后可以重新计算校验
+type AuditService interface {
+	RecordDraw(ctx context.Context, trace *DrawTrace, code constant.ErrCode)
+	RotateSeed(ctx context.Context) (*DrawSeedInfo, error)
+	ListSeeds(ctx context.Context, limit int) ([]*DrawSeedInfo, error)
+	ListDrawAudits(ctx context.Context, uid uint, requestID string, limit int) ([]*model.DrawAudit, error)
+	VerifyDraw(ctx context.Context, auditID uint) (*DrawVerifyResult, error)
+}
+
+type auditService struct {
+	seedRepo  *repo.DrawSeedRepo
+	auditRepo *repo.DrawAuditRepo
+
+	// 正在使用的种子，只在轮换种子时变化
+	seedMu sync.Mutex
+	seed   *model.DrawSeed
+}
+
+var auditServiceImpl *auditService
+
+func InitAuditService() {
+	auditServiceImpl = &auditService{
+		seedRepo:  repo.NewDrawSeedRepo(),
+		auditRepo: repo.NewDrawAuditRepo(),
+	}
+}
+
+func GetAuditService() AuditService {
+	return auditServiceImpl
+}
+
+// nextDraw 获取正在使用的种子和本次抽奖的序号，没有种子时生成一个
+func (a *auditService) nextDraw(ctx context.Context) (*model.DrawSeed, int64, error) {
+	seed, err := a.currentSeed(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	nonce, err := a.seedRepo.IncrNonce(seed.Id)
+	if err != nil {
+		return nil, 0, fmt.Errorf("auditService|nextDraw:%v", err)
+	}
+	return seed, nonce, nil
+}
+
+func (a *auditService) currentSeed(ctx context.Context) (*model.DrawSeed, error) {
+	a.seedMu.Lock()
+	defer a.seedMu.Unlock()
+	if a.seed != nil {
+		return a.seed, nil
+	}
+	seed, err := a.seedRepo.GetActive(gormcli.GetDB())
+	if err != nil {
+		return nil, fmt.Errorf("auditService|currentSeed:%v", err)
+	}
+	if seed == nil {
+		if seed, err = a.createSeed(gormcli.GetDB()); err != nil {
+			return nil, fmt.Errorf("auditService|currentSeed:%v", err)
+		}
+		log.InfoContextf(ctx, "auditService|currentSeed create seed id=%d commitment=%s", seed.Id, seed.Commitment)
+	}
+	a.seed = seed
+	return seed, nil
+}
+
+func (a *auditService) createSeed(db *gorm.DB) (*model.DrawSeed, error) {
+	value, err := draw.NewSeed()
+	if err != nil {
+		return nil, err
+	}
+	seed := &model.DrawSeed{
+		Commitment: draw.Commit(value),
+		Seed:       value,
+		SysStatus:  constant.DrawSeedStatusActive,
+	}
+	if err = a.seedRepo.Create(db, seed); err != nil {
+		return nil, err
+	}
+	return seed, nil
+}
+
+// RotateSeed 公开正在使用的种子，并生成新的种子，返回新种子的承诺值
+func (a *auditService) RotateSeed(ctx context.Context) (*DrawSeedInfo, error) {
+	a.seedMu.Lock()
+	defer a.seedMu.Unlock()
+	var seed *model.DrawSeed
+	err := gormcli.Transaction(ctx, func(txctx context.Context) error {
+		db := gormcli.GetDBFromCtx(txctx)
+		if err := a.seedRepo.RevealActive(db); err != nil {
+			return err
+		}
+		var err error
+		seed, err = a.createSeed(db)
+		return err
+	})
+	if err != nil {
+		log.ErrorContextf(ctx, "auditService|RotateSeed:%v", err)
+		return nil, fmt.Errorf("auditService|RotateSeed:%v", err)
+	}
+	a.seed = seed
+	log.InfoContextf(ctx, "auditService|RotateSeed new seed id=%d commitment=%s", seed.Id, seed.Commitment)
+	return toDrawSeedInfo(seed), nil
+}
+
+// ListSeeds 获取最近的种子，已经公开的种子带上种子原文
+func (a *auditService) ListSeeds(ctx context.Context, limit int) ([]*DrawSeedInfo, error) {
+	seeds, err := a.seedRepo.GetList(gormcli.GetDB(), limit)
+	if err != nil {
+		log.ErrorContextf(ctx, "auditService|ListSeeds:%v", err)
+		return nil, fmt.Errorf("auditService|ListSeeds:%v", err)
+	}
+	list := make([]*DrawSeedInfo, 0, len(seeds))
+	for _, seed := range seeds {
+		list = append(list, toDrawSeedInfo(seed))
+	}
+	return list, nil
+}
+
+// RecordDraw 记录抽奖请求的审计信息，写入失败不影响抽奖结果
+func (a *auditService) RecordDraw(ctx context.Context, trace *DrawTrace, code constant.ErrCode) {
+	audit := &model.DrawAudit{
+		ActivityId:    trace.ActivityID,
+		UserId:        trace.UserID,
+		RequestId:     trace.RequestID,
+		Api:           trace.Api,
+		SeedId:        trace.SeedID,
+		Nonce:         trace.Nonce,
+		CodeSpace:     trace.CodeSpace,
+		PrizeCode:     trace.PrizeCode,
+		EngineVersion: trace.EngineVersion,
+		PrizeId:       trace.PrizeID,
+		ResultCode:    int(code),
+		PityLosses:    trace.PityLosses,
+		PityMode:      trace.PityMode,
+		RiskVerdict:   trace.RiskVerdict,
+		RiskRule:      trace.RiskRule,
+		SysIp:         trace.IP,
+		BodyIp:        trace.BodyIP,
+	}
+	if err := a.auditRepo.Create(gormcli.GetDB(), audit); err != nil {
+		log.ErrorContextf(ctx, "auditService|RecordDraw trace=%+v code=%d:%v", trace, code, err)
+	}
+}
+
+// ListDrawAudits 查询用户的抽奖审计记录，用于处理用户投诉
+func (a *auditService) ListDrawAudits(ctx context.Context, uid uint, requestID string, limit int) ([]*model.DrawAudit, error) {
+	if limit <= 0 || limit > constant.DrawAuditListMax {
+		limit = constant.DrawAuditListMax
+	}
+	audits, err := a.auditRepo.GetByUser(gormcli.GetDB(), uid, requestID, limit)
+	if err != nil {
+		log.ErrorContextf(ctx, "auditService|ListDrawAudits:%v", err)
+		return nil, fmt.Errorf("auditService|ListDrawAudits:%v", err)
+	}
+	return audits, nil
+}
+
+// VerifyDraw 使用种子重新计算抽奖编码，并和审计记录中的编码对比，记录不存在返回nil
+func (a *auditService) VerifyDraw(ctx context.Context, auditID uint) (*DrawVerifyResult, error) {
+	audit, err := a.auditRepo.Get(gormcli.GetDB(), auditID)
+	if err != nil {
+		log.ErrorContextf(ctx, "auditService|VerifyDraw:%v", err)
+		return nil, fmt.Errorf("auditService|VerifyDraw:%v", err)
+	}
+	if audit == nil {
+		return nil, nil
+	}
+	result := &DrawVerifyResult{Audit: audit}
+	// 在抽奖之前被拒绝的请求没有抽奖编码
+	if audit.SeedId == 0 {
+		return result, nil
+	}
+	seed, err := a.seedRepo.Get(gormcli.GetDB(), audit.SeedId)
+	if err != nil {
+		log.ErrorContextf(ctx, "auditService|VerifyDraw:%v", err)
+		return nil, fmt.Errorf("auditService|VerifyDraw:%v", err)
+	}
+	if seed == nil {
+		return nil, fmt.Errorf("auditService|VerifyDraw seed not found, seed_id=%d", audit.SeedId)
+	}
+	verifyDraw(result, seed)
+	// 管理后台可以校验还没有公开的种子，但是不返回种子原文
+	result.Seed = toDrawSeedInfo(seed)
+	return result, nil
+}
+
+// verifyDraw 校验种子的承诺值，并重新计算抽奖编码
+func verifyDraw(result *DrawVerifyResult, seed *model.DrawSeed) {
+	audit := result.Audit
+	result.Message = draw.Message(audit.ActivityId, audit.UserId, audit.RequestId, audit.Nonce)
+	result.CommitmentValid = draw.Commit(seed.Seed) == seed.Commitment
+	result.DerivedCode = draw.DeriveCode(seed.Seed, result.Message, audit.CodeSpace)
+	result.Valid = result.CommitmentValid && result.DerivedCode == audit.PrizeCode
+}
+
+func toDrawSeedInfo(seed *model.DrawSeed) *DrawSeedInfo {
+	info := &DrawSeedInfo{
+		Id:         seed.Id,
+		Commitment: seed.Commitment,
+		SysStatus:  seed.SysStatus,
+		SysCreated: seed.SysCreated,
+		SysUpdated: seed.SysUpdated,
+	}
+	if seed.SysStatus == constant.DrawSeedStatusRevealed {
+		info.Seed = seed.Seed
+	}
+	return info
+}
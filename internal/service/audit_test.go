@@ -0,0 +1,48 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/draw"
+)
+
+func TestVerifyDraw(t *testing.T) {
+	value, err := draw.NewSeed()
+	assert.NoError(t, err)
+	seed := &model.DrawSeed{Id: 1, Commitment: draw.Commit(value), Seed: value, SysStatus: constant.DrawSeedStatusActive}
+	audit := &model.DrawAudit{ActivityId: 1, UserId: 2, RequestId: "req-1", SeedId: 1, Nonce: 3, CodeSpace: 1000}
+	audit.PrizeCode = draw.DeriveCode(value, draw.Message(1, 2, "req-1", 3), 1000)
+
+	result := &DrawVerifyResult{Audit: audit}
+	verifyDraw(result, seed)
+	assert.True(t, result.CommitmentValid)
+	assert.True(t, result.Valid)
+	assert.Equal(t, audit.PrizeCode, result.DerivedCode)
+
+	// 篡改了记录中的请求ID
+	tampered := *audit
+	tampered.RequestId = "req-2"
+	result = &DrawVerifyResult{Audit: &tampered}
+	verifyDraw(result, seed)
+	assert.True(t, result.CommitmentValid)
+	assert.Equal(t, tampered.PrizeCode != result.DerivedCode, !result.Valid)
+
+	// 种子和承诺值不一致
+	forged := *seed
+	forged.Seed = value[:len(value)-1] + "0"
+	if forged.Seed == value {
+		forged.Seed = value[:len(value)-1] + "1"
+	}
+	result = &DrawVerifyResult{Audit: audit}
+	verifyDraw(result, &forged)
+	assert.False(t, result.CommitmentValid)
+	assert.False(t, result.Valid)
+
+	// 没有公开的种子不展示原文
+	assert.Empty(t, toDrawSeedInfo(seed).Seed)
+	seed.SysStatus = constant.DrawSeedStatusRevealed
+	assert.Equal(t, value, toDrawSeedInfo(seed).Seed)
+}
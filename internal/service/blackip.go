@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/iptrie"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/utils"
+	"time"
+)
+
+// blackIpRanges 内存中的网段黑名单，定时从数据库刷新，单个IP的黑名单仍然走redis缓存
+type blackIpRanges struct {
+	trie     *iptrie.Trie[*model.BlackIp]
+	loadedAt time.Time
+}
+
+// buildBlackIpRanges 根据数据库中的网段黑名单构建前缀树，格式不对的记录跳过
+func buildBlackIpRanges(blackIps []*model.BlackIp) *iptrie.Trie[*model.BlackIp] {
+	trie := iptrie.New[*model.BlackIp]()
+	for _, blackIp := range blackIps {
+		prefix, err := utils.ParseIPRange(blackIp.Ip)
+		if err != nil {
+			log.Errorf("buildBlackIpRanges|skip black_ip_id=%d:%v", blackIp.Id, err)
+			continue
+		}
+		trie.Insert(prefix, blackIp)
+	}
+	return trie
+}
+
+// matchBlackIpRange IP所在的网段中，从最小的网段开始找到第一个还在有效期内的网段，过期的网段不影响包含它的大网段
+func matchBlackIpRange(trie *iptrie.Trie[*model.BlackIp], ip string, now time.Time) (bool, *model.BlackIp) {
+	addr, ok := utils.ParseIP(ip)
+	if !ok {
+		return true, nil
+	}
+	for _, info := range trie.LookupAll(addr) {
+		if now.Before(info.BlackTime) {
+			return false, info
+		}
+	}
+	return true, nil
+}
+
+// getBlackIpRanges 获取内存中的网段黑名单，过期之后重新加载，加载失败时继续使用之前的数据
+func (l *limitService) getBlackIpRanges(ctx context.Context) (*iptrie.Trie[*model.BlackIp], error) {
+	ranges := l.ipRanges.Load()
+	if ranges != nil && time.Since(ranges.loadedAt) < constant.BlackIpRangeRefreshTime*time.Second {
+		return ranges.trie, nil
+	}
+	l.ipRangesMu.Lock()
+	defer l.ipRangesMu.Unlock()
+	// 等待锁的时候其他请求可能已经加载完成
+	if ranges = l.ipRanges.Load(); ranges != nil &&
+		time.Since(ranges.loadedAt) < constant.BlackIpRangeRefreshTime*time.Second {
+		return ranges.trie, nil
+	}
+	blackIps, err := l.blackIpRepo.GetRanges(gormcli.GetDB())
+	if err != nil {
+		if ranges != nil {
+			log.ErrorContextf(ctx, "limitService|getBlackIpRanges use stale ranges:%v", err)
+			return ranges.trie, nil
+		}
+		return nil, fmt.Errorf("limitService|getBlackIpRanges:%v", err)
+	}
+	ranges = &blackIpRanges{trie: buildBlackIpRanges(blackIps), loadedAt: time.Now()}
+	l.ipRanges.Store(ranges)
+	return ranges.trie, nil
+}
+
+// InvalidateBlackIpRanges IP黑名单修改之后，下一次检查时重新加载网段黑名单，其他实例在刷新间隔之后生效
+func (l *limitService) InvalidateBlackIpRanges() {
+	if ranges := l.ipRanges.Load(); ranges != nil {
+		l.ipRanges.Store(&blackIpRanges{trie: ranges.trie})
+	}
+}
+
+// checkBlackIpRange 检查IP是否在有效的网段黑名单中
+func (l *limitService) checkBlackIpRange(ctx context.Context, ip string) (bool, *model.BlackIp, error) {
+	trie, err := l.getBlackIpRanges(ctx)
+	if err != nil {
+		log.ErrorContextf(ctx, "CheckBlackIP|getBlackIpRanges:%v", err)
+		return false, nil, err
+	}
+	ok, info := matchBlackIpRange(trie, ip, time.Now())
+	return ok, info, nil
+}
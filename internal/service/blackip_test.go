@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/model"
+)
+
+func TestMatchBlackIpRange(t *testing.T) {
+	now := time.Now()
+	trie := buildBlackIpRanges([]*model.BlackIp{
+		{Id: 1, Ip: "10.1.0.0/16", BlackTime: now.Add(time.Hour)},
+		{Id: 2, Ip: "10.2.0.0/16", BlackTime: now.Add(-time.Hour)},
+		{Id: 3, Ip: "2001:db8::/32", BlackTime: now.Add(time.Hour)},
+	})
+	assert.Equal(t, 3, trie.Len())
+
+	ok, info := matchBlackIpRange(trie, "10.1.9.9", now)
+	assert.False(t, ok)
+	assert.Equal(t, uint(1), info.Id)
+	ok, info = matchBlackIpRange(trie, "10.2.0.1", now)
+	assert.True(t, ok)
+	assert.Nil(t, info)
+	ok, info = matchBlackIpRange(trie, "2001:db8:ffff::1", now)
+	assert.False(t, ok)
+	assert.Equal(t, uint(3), info.Id)
+	ok, info = matchBlackIpRange(trie, "10.3.0.1", now)
+	assert.True(t, ok)
+	assert.Nil(t, info)
+	ok, _ = matchBlackIpRange(trie, "", now)
+	assert.True(t, ok)
+}
+
+func TestMatchBlackIpRangeExpiredInsideActive(t *testing.T) {
+	now := time.Now()
+	trie := buildBlackIpRanges([]*model.BlackIp{
+		{Id: 1, Ip: "10.1.0.0/16", BlackTime: now.Add(time.Hour)},
+		{Id: 2, Ip: "10.1.2.0/24", BlackTime: now.Add(-time.Hour)},
+		{Id: 3, Ip: "10.1.3.0/24", BlackTime: now.Add(2 * time.Hour)},
+	})
+	// 过期的小网段不是例外，仍然被包含它的有效大网段拉黑
+	ok, info := matchBlackIpRange(trie, "10.1.2.3", now)
+	assert.False(t, ok)
+	assert.Equal(t, uint(1), info.Id)
+	// 有效的小网段优先
+	ok, info = matchBlackIpRange(trie, "10.1.3.3", now)
+	assert.False(t, ok)
+	assert.Equal(t, uint(3), info.Id)
+	// 大网段也过期之后不再拉黑
+	ok, _ = matchBlackIpRange(trie, "10.1.2.3", now.Add(90*time.Minute))
+	assert.True(t, ok)
+}
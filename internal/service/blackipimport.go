@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/sheet"
+	"lottery_single/internal/pkg/utils"
+	"time"
+)
+
+// 导入失败的原因
+const (
+	blackIpReasonEmpty     = "ip is empty"
+	blackIpReasonFormat    = "invalid ip or ip range"
+	blackIpReasonDuplicate = "duplicate ip in file, first at line %d"
+	blackIpReasonSave      = "save failed"
+)
+
+// blackIpHeaders 表头中IP列的名字，第一行包含其中之一时作为表头跳过
+var blackIpHeaders = []string{"ip", "ip_address", "ip地址"}
+
+// errBlackIpFormat IP或者网段格式不合法
+var errBlackIpFormat = errors.New(blackIpReasonFormat)
+
+// parseBlackIpRows 从表格中取出IP或者网段，转换为规范格式之后检查文件内的重复，空行不计入总数
+func parseBlackIpRows(rows []sheet.Row) ([]sheet.Cell, []*BlackIpImportError, int) {
+	lines, cellErrs, total := sheet.Column(rows, blackIpHeaders, func(ip string) (string, error) {
+		prefix, err := utils.ParseIPRange(ip)
+		if err != nil {
+			return "", errBlackIpFormat
+		}
+		return utils.FormatIPRange(prefix), nil
+	})
+	errs := make([]*BlackIpImportError, 0, len(cellErrs))
+	for _, e := range cellErrs {
+		reason := blackIpReasonFormat
+		switch {
+		case errors.Is(e.Err, sheet.ErrEmptyCell):
+			reason = blackIpReasonEmpty
+		case errors.Is(e.Err, sheet.ErrDuplicateCell):
+			reason = fmt.Sprintf(blackIpReasonDuplicate, e.FirstLine)
+		}
+		errs = append(errs, &BlackIpImportError{Line: e.Line, IP: e.Value, Reason: reason})
+	}
+	return lines, errs, total
+}
+
+// ImportBlackIPRows 批量导入IP黑名单，所有IP使用相同的到期时间和原因，已经存在的IP更新到期时间，逐行写入并删除缓存
+func (a *adminService) ImportBlackIPRows(ctx context.Context, rows []sheet.Row, ban *BlackIpBan) (*BlackIpImportResult,
+	error) {
+	blackTime, err := blackIpExpireTime(ban, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("adminService|ImportBlackIPRows:%w", err)
+	}
+	lines, errs, total := parseBlackIpRows(rows)
+	result := &BlackIpImportResult{Total: total}
+	db := gormcli.GetDB()
+	for _, l := range lines {
+		blackIP := &model.BlackIp{
+			Ip:        l.Value,
+			BlackTime: blackTime,
+			Reason:    ban.Reason,
+			Operator:  ban.Operator,
+		}
+		created, err := a.upsertBlackIP(db, blackIP)
+		if err != nil {
+			log.ErrorContextf(ctx, "adminService|ImportBlackIPRows line=%d ip=%s:%v", l.Line, l.Value, err)
+			errs = append(errs, &BlackIpImportError{Line: l.Line, IP: l.Value, Reason: blackIpReasonSave})
+			continue
+		}
+		if created {
+			result.CreatedNum++
+		} else {
+			result.UpdatedNum++
+		}
+	}
+	if len(lines) > 0 {
+		GetLimitService().InvalidateBlackIpRanges()
+	}
+	result.FailNum = len(errs)
+	if len(errs) > constant.BlackIpImportErrorPreview {
+		errs = errs[:constant.BlackIpImportErrorPreview]
+	}
+	result.Errors = errs
+	log.InfoContextf(ctx, "adminService|ImportBlackIPRows total=%d created=%d updated=%d fail=%d operator=%s",
+		result.Total, result.CreatedNum, result.UpdatedNum, result.FailNum, ban.Operator)
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/sheet"
+)
+
+func TestParseBlackIpRows(t *testing.T) {
+	rows := []sheet.Row{
+		{Line: 1, Cells: []string{"IP地址"}},
+		{Line: 2, Cells: []string{"10.0.0.1"}},
+		{Line: 3, Cells: []string{"10.1.2.3/16"}},
+		{Line: 4, Cells: []string{"::ffff:10.0.0.1"}},
+		{Line: 5, Cells: []string{"10.1.0.0/16"}},
+		{Line: 6, Cells: []string{"not an ip"}},
+		{Line: 7, Cells: []string{"2001:DB8::1"}},
+	}
+	// IPv4映射地址和网段转换为规范格式之后再检查重复
+	lines, errs, total := parseBlackIpRows(rows)
+	assert.Equal(t, 6, total)
+	assert.Equal(t, []sheet.Cell{{Line: 2, Value: "10.0.0.1"}, {Line: 3, Value: "10.1.0.0/16"},
+		{Line: 7, Value: "2001:db8::1"}}, lines)
+	assert.Equal(t, []*BlackIpImportError{
+		{Line: 4, IP: "10.0.0.1", Reason: "duplicate ip in file, first at line 2"},
+		{Line: 5, IP: "10.1.0.0/16", Reason: "duplicate ip in file, first at line 3"},
+		{Line: 6, IP: "not an ip", Reason: blackIpReasonFormat},
+	}, errs)
+}
+
+func TestBlackIpExpireTime(t *testing.T) {
+	now := time.Now()
+	blackTime, err := blackIpExpireTime(&BlackIpBan{Preset: constant.BlackPresetMonth}, now)
+	assert.Nil(t, err)
+	assert.Equal(t, now.Add(30*24*time.Hour), blackTime)
+	blackTime, err = blackIpExpireTime(&BlackIpBan{Seconds: 60, Reason: "刷奖"}, now)
+	assert.Nil(t, err)
+	assert.Equal(t, now.Add(time.Minute), blackTime)
+	future := now.Add(time.Hour)
+	blackTime, err = blackIpExpireTime(&BlackIpBan{BlackTime: &future}, now)
+	assert.Nil(t, err)
+	assert.Equal(t, future, blackTime)
+
+	past := now.Add(-time.Hour)
+	tooLate := now.Add((constant.BlackIpMaxSeconds + 1) * time.Second)
+	invalid := []*BlackIpBan{
+		{},
+		{Preset: "day"},
+		{Preset: constant.BlackPresetWeek, Seconds: 60},
+		{Preset: constant.BlackPresetWeek, BlackTime: &future},
+		{BlackTime: &past},
+		{BlackTime: &tooLate},
+		{Seconds: 60, Reason: string(make([]rune, constant.BlackIpReasonMaxLen+1))},
+	}
+	for _, ban := range invalid {
+		_, err = blackIpExpireTime(ban, now)
+		assert.ErrorIs(t, err, ErrInvalidBlackIP)
+	}
+}
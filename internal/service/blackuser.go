@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/repo"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalidBlackUser 拉黑或者洗白用户的参数不合法
+var ErrInvalidBlackUser = errors.New("invalid black user")
+
+// blackPresetSeconds 预设的拉黑时长
+var blackPresetSeconds = map[string]int64{
+	constant.BlackPresetWeek:  7 * 86400,
+	constant.BlackPresetMonth: 30 * 86400,
+	constant.BlackPresetYear:  365 * 86400,
+}
+
+// BlackUserService 后台管理用户黑名单，每次修改都记录操作人和原因，并且删除 black_user_info_* 缓存
+type BlackUserService interface {
+	Ban(ctx context.Context, ban *BlackUserBan) (*model.BlackUser, error)
+	Lift(ctx context.Context, lift *BlackUserLift) (*model.BlackUser, error)
+	ListBlackUsers(ctx context.Context, query *repo.BlackUserQuery, cursor uint, limit int) (*BlackUserPage, error)
+	ListLogs(ctx context.Context, uid uint, cursor uint, limit int) (*BlackUserLogPage, error)
+}
+
+type blackUserService struct {
+	blackUserRepo    *repo.BlackUserRepo
+	blackUserLogRepo *repo.BlackUserLogRepo
+	userRepo         *repo.UserRepo
+}
+
+var blackUserServiceImpl *blackUserService
+
+func InitBlackUserService() {
+	blackUserServiceImpl = &blackUserService{
+		blackUserRepo:    repo.NewBlackUserRepo(),
+		blackUserLogRepo: repo.NewBlackUserLogRepo(),
+		userRepo:         repo.NewUserRepo(),
+	}
+}
+
+func GetBlackUserService() BlackUserService {
+	return blackUserServiceImpl
+}
+
+// checkBlackReason 原因必填，不能超过255个字符
+func checkBlackReason(reason string) error {
+	if reason == "" || utf8.RuneCountInString(reason) > constant.BlackUserReasonMaxLen {
+		return fmt.Errorf("%w:reason is required and at most %d characters", ErrInvalidBlackUser,
+			constant.BlackUserReasonMaxLen)
+	}
+	return nil
+}
+
+// blackDuration 预设时长和自定义秒数二选一，自定义秒数不能超过maxSeconds，返回拉黑的时长，用户和IP黑名单共用
+func blackDuration(preset string, seconds int64, maxSeconds int64) (time.Duration, error) {
+	switch {
+	case preset != "" && seconds != 0:
+		return 0, fmt.Errorf("only one of preset and seconds is allowed")
+	case preset != "":
+		var ok bool
+		if seconds, ok = blackPresetSeconds[preset]; !ok {
+			return 0, fmt.Errorf("invalid preset %q", preset)
+		}
+	case seconds <= 0 || seconds > maxSeconds:
+		return 0, fmt.Errorf("seconds must be in (0, %d]", maxSeconds)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// blackUserDuration 校验拉黑参数，返回拉黑的时长
+func blackUserDuration(ban *BlackUserBan) (time.Duration, error) {
+	ban.Preset = strings.TrimSpace(ban.Preset)
+	ban.Reason = strings.TrimSpace(ban.Reason)
+	if ban.UserID == 0 {
+		return 0, fmt.Errorf("%w:user_id is required", ErrInvalidBlackUser)
+	}
+	if err := checkBlackReason(ban.Reason); err != nil {
+		return 0, err
+	}
+	duration, err := blackDuration(ban.Preset, ban.Seconds, constant.BlackUserMaxSeconds)
+	if err != nil {
+		return 0, fmt.Errorf("%w:%v", ErrInvalidBlackUser, err)
+	}
+	return duration, nil
+}
+
+// Ban 拉黑用户，到期时间从当前时间开始计算
+func (b *blackUserService) Ban(ctx context.Context, ban *BlackUserBan) (*model.BlackUser, error) {
+	duration, err := blackUserDuration(ban)
+	if err != nil {
+		return nil, fmt.Errorf("blackUserService|Ban:%w", err)
+	}
+	user, err := b.userRepo.Get(gormcli.GetDB(), ban.UserID)
+	if err != nil {
+		log.ErrorContextf(ctx, "blackUserService|Ban:%v", err)
+		return nil, fmt.Errorf("blackUserService|Ban:%v", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("blackUserService|Ban:%w:user %d not found", ErrInvalidBlackUser, ban.UserID)
+	}
+	blackTime := time.Now().Add(duration)
+	var blackUser *model.BlackUser
+	err = gormcli.Transaction(ctx, func(txctx context.Context) error {
+		db := gormcli.GetDBFromCtx(txctx)
+		// 依赖user_id的唯一索引，同时拉黑同一个用户时只会有一条记录
+		err := b.blackUserRepo.Upsert(db, &model.BlackUser{
+			UserId:    ban.UserID,
+			UserName:  user.UserName,
+			RealName:  user.RealName,
+			Mobile:    user.Mobile,
+			BlackTime: blackTime,
+			Reason:    ban.Reason,
+			Operator:  ban.Operator,
+		}, "black_time", "reason", "operator")
+		if err != nil {
+			return err
+		}
+		if blackUser, err = b.blackUserRepo.GetByUserID(db, ban.UserID); err != nil {
+			return err
+		}
+		return b.blackUserLogRepo.Create(db, &model.BlackUserLog{
+			UserId:    ban.UserID,
+			Action:    constant.BlackUserActionBan,
+			BlackTime: blackTime,
+			Reason:    ban.Reason,
+			Operator:  ban.Operator,
+		})
+	})
+	if err != nil {
+		log.ErrorContextf(ctx, "blackUserService|Ban:%v", err)
+		return nil, fmt.Errorf("blackUserService|Ban:%v", err)
+	}
+	b.deleteCache(ctx, ban.UserID)
+	log.InfoContextf(ctx, "blackUserService|Ban user_id=%d black_time=%s operator=%s", ban.UserID,
+		blackTime.Format(time.RFC3339), ban.Operator)
+	return blackUser, nil
+}
+
+// Lift 洗白用户，用户不在黑名单中或者已经过期时返回nil
+func (b *blackUserService) Lift(ctx context.Context, lift *BlackUserLift) (*model.BlackUser, error) {
+	lift.Reason = strings.TrimSpace(lift.Reason)
+	if lift.UserID == 0 {
+		return nil, fmt.Errorf("blackUserService|Lift:%w:user_id is required", ErrInvalidBlackUser)
+	}
+	if err := checkBlackReason(lift.Reason); err != nil {
+		return nil, fmt.Errorf("blackUserService|Lift:%w", err)
+	}
+	now := time.Now()
+	var blackUser *model.BlackUser
+	err := gormcli.Transaction(ctx, func(txctx context.Context) error {
+		db := gormcli.GetDBFromCtx(txctx)
+		var err error
+		// 锁住记录，避免和同时进行的拉黑互相覆盖
+		if blackUser, err = b.blackUserRepo.GetByUserIDForUpdate(db, lift.UserID); err != nil {
+			return err
+		}
+		if blackUser == nil || !now.Before(blackUser.BlackTime) {
+			blackUser = nil
+			return nil
+		}
+		blackUser.BlackTime, blackUser.Reason, blackUser.Operator = now, lift.Reason, lift.Operator
+		if err = b.blackUserRepo.Update(db, lift.UserID, blackUser, "black_time", "reason", "operator"); err != nil {
+			return err
+		}
+		return b.blackUserLogRepo.Create(db, &model.BlackUserLog{
+			UserId:    lift.UserID,
+			Action:    constant.BlackUserActionLift,
+			BlackTime: now,
+			Reason:    lift.Reason,
+			Operator:  lift.Operator,
+		})
+	})
+	if err != nil {
+		log.ErrorContextf(ctx, "blackUserService|Lift:%v", err)
+		return nil, fmt.Errorf("blackUserService|Lift:%v", err)
+	}
+	// 没有在黑名单中时也删除缓存，避免缓存和数据库不一致时无法洗白
+	b.deleteCache(ctx, lift.UserID)
+	if blackUser != nil {
+		log.InfoContextf(ctx, "blackUserService|Lift user_id=%d operator=%s", lift.UserID, lift.Operator)
+	}
+	return blackUser, nil
+}
+
+// deleteCache 删除用户黑名单缓存，抽奖时缓存缺失会从数据库重新加载，删除失败只记录日志
+func (b *blackUserService) deleteCache(ctx context.Context, uid uint) {
+	if err := b.blackUserRepo.UpdateByCache(&model.BlackUser{UserId: uid}); err != nil {
+		log.ErrorContextf(ctx, "blackUserService|deleteCache user_id=%d:%v", uid, err)
+	}
+}
+
+// ListBlackUsers 分页查询用户黑名单，可以只查询有效的或者已经过期的
+func (b *blackUserService) ListBlackUsers(ctx context.Context, query *repo.BlackUserQuery, cursor uint,
+	limit int) (*BlackUserPage, error) {
+	if limit <= 0 {
+		limit = constant.BlackUserListDefaultLimit
+	}
+	if limit > constant.BlackUserListMaxLimit {
+		limit = constant.BlackUserListMaxLimit
+	}
+	if query.Now.IsZero() {
+		query.Now = time.Now()
+	}
+	list, err := b.blackUserRepo.GetList(gormcli.GetDB(), query, cursor, limit+1)
+	if err != nil {
+		log.ErrorContextf(ctx, "blackUserService|ListBlackUsers:%v", err)
+		return nil, fmt.Errorf("blackUserService|ListBlackUsers:%v", err)
+	}
+	page := &BlackUserPage{List: list}
+	if len(list) > limit {
+		page.List = list[:limit]
+		page.HasMore = true
+	}
+	if len(page.List) > 0 {
+		page.NextCursor = page.List[len(page.List)-1].Id
+	}
+	return page, nil
+}
+
+// ListLogs 分页查询用户被拉黑和洗白的操作记录
+func (b *blackUserService) ListLogs(ctx context.Context, uid uint, cursor uint, limit int) (*BlackUserLogPage, error) {
+	if limit <= 0 {
+		limit = constant.BlackUserListDefaultLimit
+	}
+	if limit > constant.BlackUserListMaxLimit {
+		limit = constant.BlackUserListMaxLimit
+	}
+	list, err := b.blackUserLogRepo.GetListByUser(gormcli.GetDB(), uid, cursor, limit+1)
+	if err != nil {
+		log.ErrorContextf(ctx, "blackUserService|ListLogs:%v", err)
+		return nil, fmt.Errorf("blackUserService|ListLogs:%v", err)
+	}
+	page := &BlackUserLogPage{List: list}
+	if len(list) > limit {
+		page.List = list[:limit]
+		page.HasMore = true
+	}
+	if len(page.List) > 0 {
+		page.NextCursor = page.List[len(page.List)-1].Id
+	}
+	return page, nil
+}
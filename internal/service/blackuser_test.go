@@ -0,0 +1,38 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/pkg/constant"
+)
+
+func TestBlackUserDuration(t *testing.T) {
+	d, err := blackUserDuration(&BlackUserBan{UserID: 1, Preset: constant.BlackPresetWeek, Reason: "刷单"})
+	assert.Nil(t, err)
+	assert.Equal(t, 7*24*time.Hour, d)
+	d, err = blackUserDuration(&BlackUserBan{UserID: 1, Preset: constant.BlackPresetYear, Reason: "刷单"})
+	assert.Nil(t, err)
+	assert.Equal(t, 365*24*time.Hour, d)
+	d, err = blackUserDuration(&BlackUserBan{UserID: 1, Seconds: 3600, Reason: " 刷单 "})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, d)
+
+	invalid := []*BlackUserBan{
+		{Preset: constant.BlackPresetWeek, Reason: "刷单"},
+		{UserID: 1, Preset: constant.BlackPresetWeek},
+		{UserID: 1, Preset: constant.BlackPresetWeek, Reason: "   "},
+		{UserID: 1, Preset: constant.BlackPresetWeek, Reason: strings.Repeat("a", 256)},
+		{UserID: 1, Preset: "day", Reason: "刷单"},
+		{UserID: 1, Preset: constant.BlackPresetWeek, Seconds: 60, Reason: "刷单"},
+		{UserID: 1, Reason: "刷单"},
+		{UserID: 1, Seconds: -1, Reason: "刷单"},
+		{UserID: 1, Seconds: constant.BlackUserMaxSeconds + 1, Reason: "刷单"},
+	}
+	for _, ban := range invalid {
+		_, err = blackUserDuration(ban)
+		assert.ErrorIs(t, err, ErrInvalidBlackUser)
+	}
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"time"
+)
+
+// ErrInvalidCouponFilter 批量操作优惠券时没有指定编码，也没有指定奖品和批次
+var ErrInvalidCouponFilter = errors.New("invalid coupon filter")
+
+// findCoupons 根据条件查询优惠券，优先按编码查询
+func (a *adminService) findCoupons(filter *CouponFilter) ([]*model.Coupon, error) {
+	if filter == nil {
+		return nil, ErrInvalidCouponFilter
+	}
+	if len(filter.Codes) > 0 {
+		if len(filter.Codes) > constant.CouponStatusMaxCodes {
+			return nil, fmt.Errorf("%w:too many codes %d", ErrInvalidCouponFilter, len(filter.Codes))
+		}
+		return a.couponRepo.GetByCodes(gormcli.GetDB(), filter.Codes)
+	}
+	if filter.PrizeID == 0 || filter.BatchNo == "" {
+		return nil, ErrInvalidCouponFilter
+	}
+	return a.couponRepo.GetByBatch(gormcli.GetDB(), filter.PrizeID, filter.BatchNo)
+}
+
+// couponGroup 同一个奖品下的优惠券
+type couponGroup struct {
+	prizeID uint
+	ids     []uint
+	codes   []string
+}
+
+// groupCoupons 筛选出可以修改的优惠券并按奖品分组，缓存按奖品区分，数据库也按奖品分批修改
+func groupCoupons(coupons []*model.Coupon, match func(*model.Coupon) bool) ([]*couponGroup, int) {
+	var (
+		groups  []*couponGroup
+		matched int
+		index   = make(map[uint]*couponGroup)
+	)
+	for _, coupon := range coupons {
+		if !match(coupon) {
+			continue
+		}
+		group, ok := index[coupon.PrizeId]
+		if !ok {
+			group = &couponGroup{prizeID: coupon.PrizeId}
+			index[coupon.PrizeId] = group
+			groups = append(groups, group)
+		}
+		group.ids = append(group.ids, coupon.Id)
+		group.codes = append(group.codes, coupon.Code)
+		matched++
+	}
+	return groups, matched
+}
+
+// couponExpired 优惠券是否已经超过有效期
+func couponExpired(coupon *model.Coupon, now time.Time) bool {
+	return coupon.ValidTo != nil && !coupon.ValidTo.After(now)
+}
+
+// VoidCoupons 作废还没有发放的优惠券，并从缓存中移除
+func (a *adminService) VoidCoupons(ctx context.Context, filter *CouponFilter) (*CouponStatusResult, error) {
+	coupons, err := a.findCoupons(filter)
+	if err != nil {
+		return nil, fmt.Errorf("adminService|VoidCoupons:%w", err)
+	}
+	groups, matched := groupCoupons(coupons, func(coupon *model.Coupon) bool {
+		return coupon.SysStatus == constant.CouponStatusNormal
+	})
+	result := &CouponStatusResult{Matched: matched}
+	for _, group := range groups {
+		// 先修改数据库，缓存中的编码在移除之前被领取的话，发放时状态校验不通过，不会发出去
+		updated, err := a.couponRepo.UpdateStatusByIDs(gormcli.GetDB(), group.ids, constant.CouponStatusNormal,
+			constant.CouponStatusVoid)
+		if err != nil {
+			log.ErrorContextf(ctx, "adminService|VoidCoupons prize_id=%d:%v", group.prizeID, err)
+			return nil, fmt.Errorf("adminService|VoidCoupons:%v", err)
+		}
+		result.Updated += updated
+		if _, err = a.couponRepo.RemoveCacheCoupons(group.prizeID, group.codes...); err != nil {
+			log.ErrorContextf(ctx, "adminService|VoidCoupons prize_id=%d:%v", group.prizeID, err)
+			result.CacheFailNum += len(group.codes)
+		}
+	}
+	log.InfoContextf(ctx, "adminService|VoidCoupons filter=%+v result=%+v", filter, result)
+	return result, nil
+}
+
+// RestoreCoupons 恢复作废的优惠券，已经超过有效期的不能恢复，恢复之后放回缓存
+func (a *adminService) RestoreCoupons(ctx context.Context, filter *CouponFilter) (*CouponStatusResult, error) {
+	coupons, err := a.findCoupons(filter)
+	if err != nil {
+		return nil, fmt.Errorf("adminService|RestoreCoupons:%w", err)
+	}
+	now := time.Now()
+	groups, matched := groupCoupons(coupons, func(coupon *model.Coupon) bool {
+		return coupon.SysStatus == constant.CouponStatusVoid && !couponExpired(coupon, now)
+	})
+	result := &CouponStatusResult{Matched: matched}
+	for _, group := range groups {
+		updated, err := a.couponRepo.UpdateStatusByIDs(gormcli.GetDB(), group.ids, constant.CouponStatusVoid,
+			constant.CouponStatusNormal)
+		if err != nil {
+			log.ErrorContextf(ctx, "adminService|RestoreCoupons prize_id=%d:%v", group.prizeID, err)
+			return nil, fmt.Errorf("adminService|RestoreCoupons:%v", err)
+		}
+		result.Updated += updated
+		if _, err = a.couponRepo.ImportCacheCoupons(group.prizeID, group.codes...); err != nil {
+			log.ErrorContextf(ctx, "adminService|RestoreCoupons prize_id=%d:%v", group.prizeID, err)
+			result.CacheFailNum += len(group.codes)
+		}
+	}
+	log.InfoContextf(ctx, "adminService|RestoreCoupons filter=%+v result=%+v", filter, result)
+	return result, nil
+}
+
+// ExpireCoupons 把超过有效期还没有发放的优惠券修改为已过期，并从缓存中移除，返回每个奖品过期的数量
+func (a *adminService) ExpireCoupons(ctx context.Context) (map[uint]int64, error) {
+	expired := make(map[uint]int64)
+	now := time.Now()
+	for {
+		coupons, err := a.couponRepo.GetExpired(gormcli.GetDB(), now, constant.CouponExpireBatchSize)
+		if err != nil {
+			log.ErrorContextf(ctx, "adminService|ExpireCoupons:%v", err)
+			return expired, fmt.Errorf("adminService|ExpireCoupons:%v", err)
+		}
+		groups, _ := groupCoupons(coupons, func(*model.Coupon) bool { return true })
+		for _, group := range groups {
+			updated, err := a.couponRepo.UpdateStatusByIDs(gormcli.GetDB(), group.ids, constant.CouponStatusNormal,
+				constant.CouponStatusExpired)
+			if err != nil {
+				log.ErrorContextf(ctx, "adminService|ExpireCoupons prize_id=%d:%v", group.prizeID, err)
+				return expired, fmt.Errorf("adminService|ExpireCoupons:%v", err)
+			}
+			expired[group.prizeID] += updated
+			// 缓存移除失败时，缓存中的编码发放时状态校验不通过，不会发出去
+			if _, err = a.couponRepo.RemoveCacheCoupons(group.prizeID, group.codes...); err != nil {
+				log.ErrorContextf(ctx, "adminService|ExpireCoupons prize_id=%d:%v", group.prizeID, err)
+			}
+		}
+		if len(coupons) < constant.CouponExpireBatchSize {
+			return expired, nil
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+)
+
+func TestGroupCoupons(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	coupons := []*model.Coupon{
+		{Id: 1, PrizeId: 2, Code: "A001", SysStatus: constant.CouponStatusVoid},
+		{Id: 2, PrizeId: 3, Code: "B001", SysStatus: constant.CouponStatusVoid, ValidTo: &future},
+		{Id: 3, PrizeId: 2, Code: "A002", SysStatus: constant.CouponStatusVoid, ValidTo: &past},
+		{Id: 4, PrizeId: 2, Code: "A003", SysStatus: constant.CouponStatusRevoked},
+		{Id: 5, PrizeId: 2, Code: "A004", SysStatus: constant.CouponStatusVoid, ValidTo: &now},
+		{Id: 6, PrizeId: 2, Code: "A005", SysStatus: constant.CouponStatusVoid},
+	}
+	groups, matched := groupCoupons(coupons, func(coupon *model.Coupon) bool {
+		return coupon.SysStatus == constant.CouponStatusVoid && !couponExpired(coupon, now)
+	})
+	assert.Equal(t, 3, matched)
+	assert.Equal(t, []*couponGroup{
+		{prizeID: 2, ids: []uint{1, 6}, codes: []string{"A001", "A005"}},
+		{prizeID: 3, ids: []uint{2}, codes: []string{"B001"}},
+	}, groups)
+}
+
+func TestNewCouponBatchNo(t *testing.T) {
+	now := time.Date(2024, 5, 1, 8, 30, 0, 0, time.Local)
+	batchNo := newCouponBatchNo(now)
+	assert.Len(t, batchNo, 23)
+	assert.Equal(t, "20240501083000-", batchNo[:15])
+	assert.NotEqual(t, batchNo, newCouponBatchNo(now))
+	assert.True(t, len(batchNo) <= constant.CouponBatchNoMaxLen)
+}
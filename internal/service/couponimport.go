@@ -0,0 +1,197 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/sheet"
+	"lottery_single/internal/pkg/utils"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidCouponPrize 奖品不存在或者不是不同编码的优惠券
+var ErrInvalidCouponPrize = errors.New("prize is not a coupon with different codes")
+
+// 导入失败的原因
+const (
+	couponReasonEmpty     = "code is empty"
+	couponReasonFormat    = "invalid code format"
+	couponReasonDuplicate = "duplicate code in file, first at line %d"
+	couponReasonExists    = "code already exists"
+	couponReasonInsert    = "insert failed"
+)
+
+// couponHeaders 表头中优惠券编码列的名字，第一行包含其中之一时作为表头跳过
+var couponHeaders = []string{"code", "coupon_code", "券码", "优惠券编码"}
+
+// errCouponFormat 优惠券编码格式不合法
+var errCouponFormat = errors.New(couponReasonFormat)
+
+// parseCouponRows 从表格中取出优惠券编码，校验格式和文件内的重复，空行不计入总数
+func parseCouponRows(rows []sheet.Row) ([]sheet.Cell, []*CouponImportError, int) {
+	lines, cellErrs, total := sheet.Column(rows, couponHeaders, func(code string) (string, error) {
+		if !validCouponCode(code) {
+			return "", errCouponFormat
+		}
+		return code, nil
+	})
+	errs := make([]*CouponImportError, 0, len(cellErrs))
+	for _, e := range cellErrs {
+		reason := couponReasonFormat
+		switch {
+		case errors.Is(e.Err, sheet.ErrEmptyCell):
+			reason = couponReasonEmpty
+		case errors.Is(e.Err, sheet.ErrDuplicateCell):
+			reason = fmt.Sprintf(couponReasonDuplicate, e.FirstLine)
+		}
+		errs = append(errs, &CouponImportError{Line: e.Line, Code: e.Value, Reason: reason})
+	}
+	return lines, errs, total
+}
+
+// validCouponCode 优惠券编码只能是可见的ASCII字符，不能包含空白
+func validCouponCode(code string) bool {
+	if len(code) > constant.CouponCodeMaxLen {
+		return false
+	}
+	for i := 0; i < len(code); i++ {
+		if code[i] < 0x21 || code[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// newCouponBatchNo 生成导入批次号，导入时间加上随机后缀
+func newCouponBatchNo(now time.Time) string {
+	return now.Format("20060102150405") + "-" + utils.NewUuid()[:8]
+}
+
+// couponImportReport 生成CSV格式的错误报告
+func couponImportReport(errs []*CouponImportError) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"line", "code", "reason"})
+	for _, e := range errs {
+		w.Write([]string{strconv.Itoa(e.Line), e.Code, e.Reason})
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// ImportCouponRows 导入表格中的优惠券，校验之后分批写入数据库并同步到缓存，失败的行生成错误报告
+func (a *adminService) ImportCouponRows(ctx context.Context, prizeID uint, rows []sheet.Row,
+	opt *CouponImportOption) (*CouponImportResult, error) {
+	prize, err := a.prizeRepo.Get(gormcli.GetDB(), prizeID)
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|ImportCouponRows:%v", err)
+		return nil, fmt.Errorf("adminService|ImportCouponRows:%v", err)
+	}
+	if prize == nil || prize.PrizeType != constant.PrizeTypeCouponDiff {
+		return nil, fmt.Errorf("adminService|ImportCouponRows:%w, prize_id=%d", ErrInvalidCouponPrize, prizeID)
+	}
+	if opt == nil {
+		opt = &CouponImportOption{}
+	}
+	batchNo := opt.BatchNo
+	if batchNo == "" {
+		batchNo = newCouponBatchNo(time.Now())
+	}
+	lines, errs, total := parseCouponRows(rows)
+	result := &CouponImportResult{Total: total, BatchNo: batchNo}
+	for start := 0; start < len(lines); start += constant.CouponImportBatchSize {
+		end := start + constant.CouponImportBatchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		batch := lines[start:end]
+		codes := make([]string, 0, len(batch))
+		for _, l := range batch {
+			codes = append(codes, l.Value)
+		}
+		exists, err := a.couponRepo.GetExistCodes(gormcli.GetDB(), codes)
+		if err != nil {
+			log.ErrorContextf(ctx, "adminService|ImportCouponRows:%v", err)
+			return nil, fmt.Errorf("adminService|ImportCouponRows:%v", err)
+		}
+		existSet := make(map[string]bool, len(exists))
+		for _, code := range exists {
+			existSet[code] = true
+		}
+		coupons := make([]*model.Coupon, 0, len(batch))
+		inserted := make([]sheet.Cell, 0, len(batch))
+		for _, l := range batch {
+			if existSet[l.Value] {
+				errs = append(errs, &CouponImportError{Line: l.Line, Code: l.Value, Reason: couponReasonExists})
+				continue
+			}
+			coupons = append(coupons, &model.Coupon{
+				PrizeId:   prizeID,
+				Code:      l.Value,
+				BatchNo:   batchNo,
+				ValidFrom: opt.ValidFrom,
+				ValidTo:   opt.ValidTo,
+				SysStatus: constant.CouponStatusNormal,
+			})
+			inserted = append(inserted, l)
+		}
+		if len(coupons) == 0 {
+			continue
+		}
+		// 查询和写入之间可能有其他导入写入了相同的编码，唯一索引冲突时整批失败
+		if err = a.couponRepo.CreateBatch(gormcli.GetDB(), coupons); err != nil {
+			log.ErrorContextf(ctx, "adminService|ImportCouponRows prize_id=%d lines %d-%d:%v",
+				prizeID, batch[0].Line, batch[len(batch)-1].Line, err)
+			for _, l := range inserted {
+				errs = append(errs, &CouponImportError{Line: l.Line, Code: l.Value, Reason: couponReasonInsert})
+			}
+			continue
+		}
+		result.SuccessNum += len(coupons)
+		insertedCodes := make([]string, 0, len(inserted))
+		for _, l := range inserted {
+			insertedCodes = append(insertedCodes, l.Value)
+		}
+		if _, err = a.couponRepo.ImportCacheCoupons(prizeID, insertedCodes...); err != nil {
+			log.ErrorContextf(ctx, "adminService|ImportCouponRows prize_id=%d:%v", prizeID, err)
+			result.CacheFailNum += len(insertedCodes)
+		}
+	}
+	result.FailNum = len(errs)
+	if len(errs) == 0 {
+		return result, nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Line < errs[j].Line
+	})
+	result.Errors = errs
+	if len(errs) > constant.CouponImportErrorPreview {
+		result.Errors = errs[:constant.CouponImportErrorPreview]
+	}
+	reportID := utils.NewUuid()
+	if err = a.couponRepo.SetImportReport(reportID, couponImportReport(errs)); err != nil {
+		// 报告保存失败不影响导入结果
+		log.ErrorContextf(ctx, "adminService|ImportCouponRows:%v", err)
+		return result, nil
+	}
+	result.ReportID = reportID
+	return result, nil
+}
+
+// GetCouponImportReport 获取导入错误报告，报告不存在或者已经过期返回false
+func (a *adminService) GetCouponImportReport(ctx context.Context, reportID string) (string, bool, error) {
+	report, ok, err := a.couponRepo.GetImportReport(reportID)
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|GetCouponImportReport:%v", err)
+		return "", false, fmt.Errorf("adminService|GetCouponImportReport:%v", err)
+	}
+	return report, ok, nil
+}
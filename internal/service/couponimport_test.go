@@ -0,0 +1,39 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/pkg/sheet"
+)
+
+func TestParseCouponRows(t *testing.T) {
+	rows := []sheet.Row{
+		{Line: 1, Cells: []string{"remark", "Code"}},
+		{Line: 2, Cells: []string{"x", " A001 "}},
+		{Line: 3, Cells: []string{"", ""}},
+		{Line: 4, Cells: []string{"y"}},
+		{Line: 5, Cells: []string{"z", "A 002"}},
+		{Line: 6, Cells: []string{"", "A001"}},
+		{Line: 7, Cells: []string{"", strings.Repeat("a", 256)}},
+		{Line: 8, Cells: []string{"", "B002"}},
+	}
+	lines, errs, total := parseCouponRows(rows)
+	assert.Equal(t, 6, total)
+	assert.Equal(t, []sheet.Cell{{Line: 2, Value: "A001"}, {Line: 8, Value: "B002"}}, lines)
+	assert.Len(t, errs, 4)
+	assert.Equal(t, &CouponImportError{Line: 4, Reason: couponReasonEmpty}, errs[0])
+	assert.Equal(t, couponReasonFormat, errs[1].Reason)
+	assert.Equal(t, "duplicate code in file, first at line 2", errs[2].Reason)
+	assert.Equal(t, 7, errs[3].Line)
+
+}
+
+func TestCouponImportReport(t *testing.T) {
+	report := couponImportReport([]*CouponImportError{
+		{Line: 3, Code: "a,b", Reason: couponReasonFormat},
+		{Line: 5, Code: "A001", Reason: couponReasonExists},
+	})
+	assert.Equal(t, "line,code,reason\n3,\"a,b\",invalid code format\n5,A001,code already exists\n", report)
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lottery_single/internal/pkg/constant"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalidPrizeProfile 奖品扩展数据不合法，相同编码优惠券的信息缺失或者已经过期
+var ErrInvalidPrizeProfile = errors.New("invalid prize profile")
+
+// parseCouponProfile 解析相同编码优惠券的信息
+func parseCouponProfile(profile string) (*CouponProfile, error) {
+	coupon := &CouponProfile{}
+	if err := json.Unmarshal([]byte(profile), coupon); err != nil {
+		return nil, fmt.Errorf("%w:%v", ErrInvalidPrizeProfile, err)
+	}
+	return coupon, nil
+}
+
+// checkCouponProfile 校验相同编码优惠券的信息，新增和修改奖品时有效期必须还没有结束
+func checkCouponProfile(coupon *CouponProfile, now time.Time) error {
+	switch {
+	case coupon.Code == "" || !validCouponCode(coupon.Code):
+		return fmt.Errorf("%w:invalid coupon code", ErrInvalidPrizeProfile)
+	case coupon.FaceValue <= 0:
+		return fmt.Errorf("%w:face_value must be positive", ErrInvalidPrizeProfile)
+	case coupon.MinSpend < 0:
+		return fmt.Errorf("%w:min_spend must not be negative", ErrInvalidPrizeProfile)
+	case utf8.RuneCountInString(coupon.Rules) > constant.CouponRulesMaxLen:
+		return fmt.Errorf("%w:rules is too long", ErrInvalidPrizeProfile)
+	case couponProfileExpired(coupon, now):
+		return fmt.Errorf("%w:coupon already expired", ErrInvalidPrizeProfile)
+	case coupon.ValidFrom != nil && coupon.ValidTo != nil && !coupon.ValidTo.After(*coupon.ValidFrom):
+		return fmt.Errorf("%w:valid_to must be after valid_from", ErrInvalidPrizeProfile)
+	}
+	return nil
+}
+
+// couponProfileExpired 相同编码优惠券是否已经过期，过期之后不再参与抽奖
+func couponProfileExpired(coupon *CouponProfile, now time.Time) bool {
+	return coupon.ValidTo != nil && !coupon.ValidTo.After(now)
+}
+
+// newPrizeProfile 新增和修改奖品时生成奖品扩展数据，相同编码优惠券校验之后保存为json，虚拟币校验数量
+func newPrizeProfile(viewPrize *ViewPrize, now time.Time) (string, error) {
+	if viewPrize.PrizeType != constant.PrizeTypeCouponSame {
+		if len(viewPrize.PrizeProfile) > constant.PrizeProfileMaxLen {
+			return "", fmt.Errorf("%w:prize_profile is too long", ErrInvalidPrizeProfile)
+		}
+		// 虚拟币奖品的prize_profile是发放的数量，中奖时记入用户钱包
+		if viewPrize.PrizeType == constant.PrizeTypeVirtualCoin {
+			if _, err := parseCoinAmount(viewPrize.PrizeProfile); err != nil {
+				return "", err
+			}
+		}
+		return viewPrize.PrizeProfile, nil
+	}
+	coupon := viewPrize.CouponProfile
+	if coupon == nil {
+		var err error
+		if coupon, err = parseCouponProfile(viewPrize.PrizeProfile); err != nil {
+			return "", err
+		}
+	}
+	if err := checkCouponProfile(coupon, now); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(coupon)
+	if err != nil {
+		return "", fmt.Errorf("%w:%v", ErrInvalidPrizeProfile, err)
+	}
+	if len(data) > constant.PrizeProfileMaxLen {
+		return "", fmt.Errorf("%w:prize_profile is too long", ErrInvalidPrizeProfile)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/pkg/constant"
+)
+
+func TestCheckCouponProfile(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	cases := []struct {
+		coupon *CouponProfile
+		ok     bool
+	}{
+		{&CouponProfile{Code: "SAME100", FaceValue: 1000}, true},
+		{&CouponProfile{Code: "SAME100", FaceValue: 1000, MinSpend: 5000, ValidFrom: &past, ValidTo: &future}, true},
+		{&CouponProfile{FaceValue: 1000}, false},
+		{&CouponProfile{Code: "has space", FaceValue: 1000}, false},
+		{&CouponProfile{Code: "SAME100"}, false},
+		{&CouponProfile{Code: "SAME100", FaceValue: 1000, MinSpend: -1}, false},
+		{&CouponProfile{Code: "SAME100", FaceValue: 1000, ValidTo: &past}, false},
+		{&CouponProfile{Code: "SAME100", FaceValue: 1000, ValidFrom: &future, ValidTo: &future}, false},
+	}
+	for i, c := range cases {
+		err := checkCouponProfile(c.coupon, now)
+		assert.Equal(t, c.ok, err == nil, "case %d: %v", i, err)
+		if err != nil {
+			assert.True(t, errors.Is(err, ErrInvalidPrizeProfile))
+		}
+	}
+}
+
+func TestNewPrizeProfile(t *testing.T) {
+	now := time.Now()
+	profile, err := newPrizeProfile(&ViewPrize{PrizeType: constant.PrizeTypeEntityLarge, PrizeProfile: "anything"}, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "anything", profile)
+
+	profile, err = newPrizeProfile(&ViewPrize{
+		PrizeType:     constant.PrizeTypeCouponSame,
+		CouponProfile: &CouponProfile{Code: "SAME100", FaceValue: 1000, Rules: "满50可用"},
+	}, now)
+	assert.Nil(t, err)
+	coupon, err := parseCouponProfile(profile)
+	assert.Nil(t, err)
+	assert.Equal(t, "SAME100", coupon.Code)
+	assert.Equal(t, int64(1000), coupon.FaceValue)
+
+	// 没有传结构化的信息时解析prize_profile
+	profile, err = newPrizeProfile(&ViewPrize{PrizeType: constant.PrizeTypeCouponSame, PrizeProfile: profile}, now)
+	assert.Nil(t, err)
+	assert.Contains(t, profile, "SAME100")
+
+	_, err = newPrizeProfile(&ViewPrize{PrizeType: constant.PrizeTypeCouponSame, PrizeProfile: "SAME100"}, now)
+	assert.True(t, errors.Is(err, ErrInvalidPrizeProfile))
+}
+
+func TestNewLotteryResultCouponSame(t *testing.T) {
+	prize := &LotteryPrize{
+		Id:         1,
+		PrizeType:  constant.PrizeTypeCouponSame,
+		CouponCode: "SAME100",
+		Coupon:     &CouponProfile{Code: "SAME100", FaceValue: 1000},
+	}
+	result := newLotteryResult(prize, &LotteryUserInfo{UserID: 1}, 1, "req")
+	coupon := &CouponProfile{}
+	assert.Nil(t, json.Unmarshal([]byte(result.PrizeData), coupon))
+	assert.Equal(t, *prize.Coupon, *coupon)
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"sort"
+	"time"
+)
+
+// diffCouponCodes 对比数据库和缓存中的编码，返回缓存中缺少的编码和多出的编码
+func diffCouponCodes(dbCodes, cacheCodes []string) ([]string, []string) {
+	dbSet := make(map[string]bool, len(dbCodes))
+	for _, code := range dbCodes {
+		dbSet[code] = true
+	}
+	cacheSet := make(map[string]bool, len(cacheCodes))
+	for _, code := range cacheCodes {
+		cacheSet[code] = true
+	}
+	var added, removed []string
+	for code := range dbSet {
+		if !cacheSet[code] {
+			added = append(added, code)
+		}
+	}
+	for code := range cacheSet {
+		if !dbSet[code] {
+			removed = append(removed, code)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// sampleCodes 对账记录中只保存部分编码
+func sampleCodes(codes []string) string {
+	if len(codes) == 0 {
+		return "[]"
+	}
+	if len(codes) > constant.CouponReconcileSampleSize {
+		codes = codes[:constant.CouponReconcileSampleSize]
+	}
+	data, _ := json.Marshal(codes)
+	return string(data)
+}
+
+// ReCacheCoupon 对比数据库中可以发放的优惠券和缓存中的优惠券，移除缓存中多出的编码，加入缺少的编码
+// 先读缓存再读数据库，导入时先写数据库再写缓存，所以不会把刚导入的编码当成多出的编码移除
+// 正在发放的编码可能被当成缺少的编码加回缓存，发放时会校验状态，不会重复发放，下一次对账时移除
+func (a *adminService) ReCacheCoupon(ctx context.Context, prizeID uint, source string,
+	dryRun bool) (*model.CouponReconcile, error) {
+	prize, err := a.prizeRepo.Get(gormcli.GetDB(), prizeID)
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|ReCacheCoupon:%v", err)
+		return nil, fmt.Errorf("adminService|ReCacheCoupon:%v", err)
+	}
+	if prize == nil || prize.PrizeType != constant.PrizeTypeCouponDiff {
+		return nil, fmt.Errorf("adminService|ReCacheCoupon:%w, prize_id=%d", ErrInvalidCouponPrize, prizeID)
+	}
+	cacheCodes, err := a.couponRepo.GetCacheCoupons(prizeID)
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|ReCacheCoupon:%v", err)
+		return nil, fmt.Errorf("adminService|ReCacheCoupon:%v", err)
+	}
+	dbCodes, err := a.couponRepo.GetUsefulCodes(gormcli.GetDB(), prizeID, time.Now())
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|ReCacheCoupon:%v", err)
+		return nil, fmt.Errorf("adminService|ReCacheCoupon:%v", err)
+	}
+	added, removed := diffCouponCodes(dbCodes, cacheCodes)
+	reconcile := &model.CouponReconcile{
+		PrizeId:      prizeID,
+		Source:       source,
+		DryRun:       dryRun,
+		DbNum:        int64(len(dbCodes)),
+		CacheNum:     int64(len(cacheCodes)),
+		AddedNum:     int64(len(added)),
+		RemovedNum:   int64(len(removed)),
+		AddedCodes:   sampleCodes(added),
+		RemovedCodes: sampleCodes(removed),
+	}
+	if !dryRun {
+		if err = a.applyCouponDiff(prizeID, added, removed); err != nil {
+			log.ErrorContextf(ctx, "adminService|ReCacheCoupon prize_id=%d:%v", prizeID, err)
+			reconcile.ErrMsg = err.Error()
+			if len(reconcile.ErrMsg) > 255 {
+				reconcile.ErrMsg = reconcile.ErrMsg[:255]
+			}
+		}
+	}
+	// 定时任务没有差异时不记录，避免产生大量无用的记录
+	if source != constant.CouponReconcileSourceTask || len(added) > 0 || len(removed) > 0 || reconcile.ErrMsg != "" {
+		if err := a.reconcileRepo.Create(gormcli.GetDB(), reconcile); err != nil {
+			log.ErrorContextf(ctx, "adminService|ReCacheCoupon reconcile=%+v:%v", reconcile, err)
+		}
+	}
+	if reconcile.ErrMsg != "" {
+		return reconcile, fmt.Errorf("adminService|ReCacheCoupon:%s", reconcile.ErrMsg)
+	}
+	return reconcile, nil
+}
+
+// applyCouponDiff 分批修正缓存
+func (a *adminService) applyCouponDiff(prizeID uint, added, removed []string) error {
+	for start := 0; start < len(removed); start += constant.CouponCacheBatchSize {
+		end := start + constant.CouponCacheBatchSize
+		if end > len(removed) {
+			end = len(removed)
+		}
+		if _, err := a.couponRepo.RemoveCacheCoupons(prizeID, removed[start:end]...); err != nil {
+			return err
+		}
+	}
+	for start := 0; start < len(added); start += constant.CouponCacheBatchSize {
+		end := start + constant.CouponCacheBatchSize
+		if end > len(added) {
+			end = len(added)
+		}
+		if _, err := a.couponRepo.ImportCacheCoupons(prizeID, added[start:end]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReCacheAllCoupons 对所有不同编码优惠券奖品的缓存对账，某个奖品失败不影响其他奖品
+func (a *adminService) ReCacheAllCoupons(ctx context.Context, source string, dryRun bool) ([]*model.CouponReconcile, error) {
+	prizeList, err := a.prizeRepo.GetAll(gormcli.GetDB())
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|ReCacheAllCoupons:%v", err)
+		return nil, fmt.Errorf("adminService|ReCacheAllCoupons:%v", err)
+	}
+	var list []*model.CouponReconcile
+	for _, prize := range prizeList {
+		if prize.PrizeType != constant.PrizeTypeCouponDiff || prize.SysStatus == constant.PrizeStatusDelete {
+			continue
+		}
+		reconcile, err := a.ReCacheCoupon(ctx, prize.Id, source, dryRun)
+		if reconcile != nil {
+			list = append(list, reconcile)
+		}
+		if err != nil {
+			log.ErrorContextf(ctx, "adminService|ReCacheAllCoupons prize_id=%d:%v", prize.Id, err)
+		}
+	}
+	return list, nil
+}
+
+// ListCouponReconciles 获取最近的对账记录
+func (a *adminService) ListCouponReconciles(ctx context.Context, prizeID uint, limit int) ([]*model.CouponReconcile, error) {
+	if limit <= 0 || limit > constant.CouponReconcileListMax {
+		limit = constant.CouponReconcileListMax
+	}
+	list, err := a.reconcileRepo.GetList(gormcli.GetDB(), prizeID, limit)
+	if err != nil {
+		log.ErrorContextf(ctx, "adminService|ListCouponReconciles:%v", err)
+		return nil, fmt.Errorf("adminService|ListCouponReconciles:%v", err)
+	}
+	return list, nil
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/pkg/constant"
+)
+
+func TestDiffCouponCodes(t *testing.T) {
+	// 缓存SSCAN可能返回重复的编码
+	added, removed := diffCouponCodes([]string{"C", "A", "B"}, []string{"B", "D", "B", "E"})
+	assert.Equal(t, []string{"A", "C"}, added)
+	assert.Equal(t, []string{"D", "E"}, removed)
+
+	added, removed = diffCouponCodes([]string{"A"}, []string{"A"})
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+}
+
+func TestSampleCodes(t *testing.T) {
+	assert.Equal(t, "[]", sampleCodes(nil))
+	assert.Equal(t, `["A","B"]`, sampleCodes([]string{"A", "B"}))
+	codes := make([]string, constant.CouponReconcileSampleSize+10)
+	for i := range codes {
+		codes[i] = fmt.Sprint(i)
+	}
+	assert.Contains(t, sampleCodes(codes), `"99"]`)
+}
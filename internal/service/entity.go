@@ -1,6 +1,10 @@
 package service
 
-import "time"
+import (
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"time"
+)
 
 // DayPrizeWeights 定义一天中24小时内，每个小时的发奖比例权重，100的数组，0-23出现的次数为权重大小
 var DayPrizeWeights = [100]int{
@@ -33,10 +37,12 @@ var DayPrizeWeights = [100]int{
 // ViewPrize 对外返回的数据（区别于存储层的数据）
 type ViewPrize struct {
 	Id           uint      `json:"id"`
+	ActivityId   uint      `json:"activity_id"`
 	Title        string    `json:"title"`
 	Img          string    `json:"img"`
 	PrizeNum     int       `json:"prize_num"`
 	PrizeCode    string    `json:"prize_code"`
+	Probability  float64   `json:"probability"`
 	PrizeTime    uint      `json:"prize_time"`
 	LeftNum      int       `json:"left_num"`
 	PrizeType    uint      `json:"prize_type"`
@@ -45,41 +51,272 @@ type ViewPrize struct {
 	EndTime      time.Time `json:"end_time"`
 	DisplayOrder uint      `json:"display_order"`
 	SysStatus    uint      `json:"sys_status"`
+	PrizeProfile string    `json:"prize_profile"`
+	// 相同编码优惠券的信息，不传时从PrizeProfile解析
+	CouponProfile *CouponProfile `json:"coupon_profile,omitempty"`
+}
+
+// CouponProfile 相同编码优惠券的信息，json格式保存在奖品的PrizeProfile中，中奖之后保存在中奖记录的PrizeData中
+type CouponProfile struct {
+	Code      string     `json:"code"`
+	FaceValue int64      `json:"face_value"` // 面值，单位分
+	MinSpend  int64      `json:"min_spend"`  // 满多少可用，单位分，0表示无门槛
+	Rules     string     `json:"rules"`      // 使用规则说明
+	ValidFrom *time.Time `json:"valid_from"` // 为空表示不限
+	ValidTo   *time.Time `json:"valid_to"`   // 为空表示不限
 }
 
 type LoginRsp struct {
-	UserID uint   `json:"user_id"`
-	Token  string `json:"token"`
+	UserID           uint     `json:"user_id"`
+	Token            string   `json:"token"`              // 访问token
+	ExpiresIn        int      `json:"expires_in"`         // 访问token有效期，单位秒
+	RefreshToken     string   `json:"refresh_token"`      // 刷新token，访问token过期之后用来换取新的token
+	RefreshExpiresIn int      `json:"refresh_expires_in"` // 刷新token有效期，单位秒
+	Roles            []string `json:"roles"`
 }
 
 // LotteryPrize 中奖奖品信息
 type LotteryPrize struct {
-	Id            uint   `json:"id"`
-	Title         string `json:"title"`
-	PrizeNum      int    `json:"-"`
-	LeftNum       int    `json:"-"`
-	PrizeCodeLow  int    `json:"-"`
-	PrizeCodeHigh int    `json:"-"`
-	Img           string `json:"img"`
-	DisplayOrder  uint   `json:"display_order"`
-	PrizeType     uint   `json:"prize_type"`
-	PrizeProfile  string `json:"prize_profile"`
-	CouponCode    string `json:"coupon_code"` // 如果中奖奖品是优惠券，这个字段位优惠券编码，否则为空
+	Id           uint   `json:"id"`
+	ActivityId   uint   `json:"activity_id"`
+	Title        string `json:"title"`
+	PrizeNum     int    `json:"-"`
+	LeftNum      int    `json:"-"`
+	Weight       int64  `json:"-"` // 中奖权重，draw.WeightTotal 表示100%
+	Img          string `json:"img"`
+	DisplayOrder uint   `json:"display_order"`
+	PrizeType    uint   `json:"prize_type"`
+	PrizeProfile string `json:"prize_profile"`
+	CouponCode   string `json:"coupon_code"` // 如果中奖奖品是优惠券，这个字段位优惠券编码，否则为空
+	// 相同编码优惠券的信息，其他类型的奖品为空
+	Coupon *CouponProfile `json:"coupon,omitempty"`
+	// 虚拟币奖品的数量，中奖时记入用户钱包，其他类型的奖品为0
+	Coins int64 `json:"coins,omitempty"`
+}
+
+// LotteryRequestRecord 抽奖请求的处理结果，用于相同请求ID的重试请求
+type LotteryRequestRecord struct {
+	Code  constant.ErrCode `json:"code"`
+	Prize *LotteryPrize    `json:"prize"`
+}
+
+// ResultPage 中奖记录的一页，下一页请求时把NextCursor作为cursor传入
+type ResultPage struct {
+	List       []*model.Result `json:"list"`
+	NextCursor uint            `json:"next_cursor"`
+	HasMore    bool            `json:"has_more"`
+}
+
+// FulfilmentAddress 中奖用户提交的收货地址
+type FulfilmentAddress struct {
+	Receiver string `json:"receiver"`
+	Mobile   string `json:"mobile"`
+	Province string `json:"province"`
+	City     string `json:"city"`
+	District string `json:"district"`
+	Address  string `json:"address"`
+}
+
+// FulfilmentStatusUpdate 修改发货单状态，发货时必须填写快递单号
+type FulfilmentStatusUpdate struct {
+	Status          uint   `json:"status"`
+	TrackingCompany string `json:"tracking_company"`
+	TrackingNo      string `json:"tracking_no"`
+	Remark          string `json:"remark"`
+}
+
+// FulfilmentPage 发货单的一页，下一页请求时把NextCursor作为cursor传入
+type FulfilmentPage struct {
+	List       []*model.FulfilmentOrder `json:"list"`
+	NextCursor uint                     `json:"next_cursor"`
+	HasMore    bool                     `json:"has_more"`
+}
+
+// WalletAdjust 后台调整用户的虚拟币余额，相同请求ID只调整一次
+type WalletAdjust struct {
+	UserID    uint   `json:"user_id"`
+	Amount    int64  `json:"amount"` // 正数增加，负数扣减，扣减之后余额不能为负
+	Reason    string `json:"reason"`
+	RequestID string `json:"request_id"`
+	ResultID  uint   `json:"result_id"` // 关联的中奖记录，可以不填
+	Operator  string `json:"-"`
+}
+
+// BlackUserBan 后台拉黑用户，preset和seconds二选一，已经在黑名单中时按照新的时长重新计算到期时间
+type BlackUserBan struct {
+	UserID   uint   `json:"user_id"`
+	Preset   string `json:"preset"`  // 预设时长，week、month、year
+	Seconds  int64  `json:"seconds"` // 自定义时长，单位秒
+	Reason   string `json:"reason"`
+	Operator string `json:"-"`
+}
+
+// BlackUserLift 后台洗白用户，黑名单到期时间改为当前时间
+type BlackUserLift struct {
+	UserID   uint   `json:"user_id"`
+	Reason   string `json:"reason"`
+	Operator string `json:"-"`
+}
+
+// BlackIpBan 后台拉黑IP或者网段，preset、seconds和black_time三选一，IP已经在黑名单中时覆盖到期时间和原因
+type BlackIpBan struct {
+	IP        string     `json:"ip"`
+	Preset    string     `json:"preset"`     // 预设时长，week、month、year
+	Seconds   int64      `json:"seconds"`    // 自定义时长，单位秒
+	BlackTime *time.Time `json:"black_time"` // 指定到期时间
+	Reason    string     `json:"reason"`
+	Operator  string     `json:"-"`
+}
+
+// BlackIpImportResult IP黑名单导入结果，Errors最多返回BlackIpImportErrorPreview条
+type BlackIpImportResult struct {
+	Total      int                   `json:"total"`
+	CreatedNum int                   `json:"created_num"`
+	UpdatedNum int                   `json:"updated_num"`
+	FailNum    int                   `json:"fail_num"`
+	Errors     []*BlackIpImportError `json:"errors"`
+}
+
+// BlackIpImportError 导入失败的一行
+type BlackIpImportError struct {
+	Line   int    `json:"line"`
+	IP     string `json:"ip"`
+	Reason string `json:"reason"`
+}
+
+// BlackUserPage 用户黑名单的一页，下一页请求时把NextCursor作为cursor传入
+type BlackUserPage struct {
+	List       []*model.BlackUser `json:"list"`
+	NextCursor uint               `json:"next_cursor"`
+	HasMore    bool               `json:"has_more"`
+}
+
+// BlackUserLogPage 拉黑和洗白操作记录的一页
+type BlackUserLogPage struct {
+	List       []*model.BlackUserLog `json:"list"`
+	NextCursor uint                  `json:"next_cursor"`
+	HasMore    bool                  `json:"has_more"`
+}
+
+// WalletLedgerPage 用户账本的一页，下一页请求时把NextCursor作为cursor传入
+type WalletLedgerPage struct {
+	Balance    int64                 `json:"balance"`
+	List       []*model.WalletLedger `json:"list"`
+	NextCursor uint                  `json:"next_cursor"`
+	HasMore    bool                  `json:"has_more"`
+}
+
+// DrawTrace 一次抽奖请求的审计信息，抽奖时填写种子、序号和编码，请求处理结束之后写入审计表
+type DrawTrace struct {
+	ActivityID    uint
+	UserID        uint
+	RequestID     string // 参与抽奖编码计算，v3由客户端提供，其他版本使用服务端生成的请求ID
+	Api           string // 抽奖接口版本
+	IP            string
+	BodyIP        string // 请求中填写的和服务端获取的不一致的IP
+	SeedID        uint   // 0表示没有抽奖，在抽奖之前被拒绝
+	Nonce         int64
+	CodeSpace     int64
+	PrizeCode     int64
+	EngineVersion string
+	PrizeID       uint   // 抽中的奖品，最终是否发放看返回码
+	PityLosses    int64  // 抽奖之前连续没有中奖的次数
+	PityMode      string // 保底规则对本次抽奖的调整
+	RiskVerdict   string // 风控规则链的处理结果
+	RiskRule      string // 命中的风控规则
+}
+
+// WinCapReservation 发奖之前占用的中奖次数，没有发奖时归还
+type WinCapReservation struct {
+	caps    []*model.WinCap
+	keys    []string
+	members []string
+}
+
+// PityDraw 保底规则对一次抽奖的调整
+type PityDraw struct {
+	Losses       int64  // 本次抽奖之前连续没有中奖的次数
+	Mode         string // 调整方式，见 constant.PityModeNone 等
+	PrizeID      uint   // 必中时的奖品，0表示有库存的任意奖品
+	BoostPercent int64  // 提高权重时有库存奖品的权重提高的百分比
+}
+
+// DrawSeedInfo 对外展示的抽奖种子，种子公开之前只展示承诺值
+type DrawSeedInfo struct {
+	Id         uint       `json:"id"`
+	Commitment string     `json:"commitment"`
+	Seed       string     `json:"seed"`
+	SysStatus  uint       `json:"sys_status"`
+	SysCreated *time.Time `json:"sys_created"`
+	SysUpdated *time.Time `json:"sys_updated"`
+}
+
+// DrawVerifyResult 抽奖校验结果
+type DrawVerifyResult struct {
+	Audit           *model.DrawAudit `json:"audit"`
+	Seed            *DrawSeedInfo    `json:"seed"`
+	Message         string           `json:"message"`          // 参与HMAC计算的消息
+	CommitmentValid bool             `json:"commitment_valid"` // 种子和承诺值是否一致
+	DerivedCode     int64            `json:"derived_code"`     // 重新计算得到的抽奖编码
+	Valid           bool             `json:"valid"`            // 重新计算的编码和记录的编码是否一致
 }
 
 type LotteryUserInfo struct {
 	UserID   uint   `json:"user_id"`
 	UserName string `json:"user_name"`
 	IP       string `json:"ip"`
+	DrawCost int64  `json:"draw_cost"` // 付费抽奖每次扣除的虚拟币，中奖时和发奖在同一个事务中扣除
 }
 
 type ViewCouponInfo struct {
-	Id         uint      `json:"id"`
-	PrizeId    uint      `json:"prize_id"`
-	Code       string    `json:"code"`
-	SysCreated time.Time `json:"sys_created"`
-	SysUpdated time.Time `json:"sys_updated"`
-	SysStatus  uint      `json:"sys_status"`
+	Id         uint       `json:"id"`
+	PrizeId    uint       `json:"prize_id"`
+	Code       string     `json:"code"`
+	BatchNo    string     `json:"batch_no"`
+	ValidFrom  *time.Time `json:"valid_from"`
+	ValidTo    *time.Time `json:"valid_to"`
+	SysCreated time.Time  `json:"sys_created"`
+	SysUpdated time.Time  `json:"sys_updated"`
+	SysStatus  uint       `json:"sys_status"`
+}
+
+// CouponImportError 导入失败的一行
+type CouponImportError struct {
+	Line   int    `json:"line"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// CouponImportResult 优惠券导入结果，Errors最多返回CouponImportErrorPreview条，完整的错误通过ReportID下载
+type CouponImportResult struct {
+	Total        int                  `json:"total"`
+	SuccessNum   int                  `json:"success_num"`
+	FailNum      int                  `json:"fail_num"`
+	CacheFailNum int                  `json:"cache_fail_num"` // 写入数据库成功但是没有同步到缓存的数量
+	Errors       []*CouponImportError `json:"errors"`
+	ReportID     string               `json:"report_id,omitempty"`
+	BatchNo      string               `json:"batch_no"`
+}
+
+// CouponImportOption 导入优惠券的批次号和有效期，批次号为空时自动生成
+type CouponImportOption struct {
+	BatchNo   string
+	ValidFrom *time.Time
+	ValidTo   *time.Time
+}
+
+// CouponFilter 批量操作优惠券的条件，按编码或者按奖品的导入批次
+type CouponFilter struct {
+	PrizeID uint
+	BatchNo string
+	Codes   []string
+}
+
+// CouponStatusResult 批量作废或者恢复优惠券的结果
+type CouponStatusResult struct {
+	Matched      int   `json:"matched"`        // 符合条件并且可以修改的数量
+	Updated      int64 `json:"updated"`        // 实际修改的数量
+	CacheFailNum int   `json:"cache_fail_num"` // 修改成功但是没有同步到缓存的数量
 }
 
 type TimePrizeInfo struct {
@@ -0,0 +1,314 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/repo"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidFulfilment 收货地址不合法，或者中奖记录不能发货
+	ErrInvalidFulfilment = errors.New("invalid fulfilment")
+	// ErrInvalidFulfilmentStatus 发货单不能修改为目标状态
+	ErrInvalidFulfilmentStatus = errors.New("invalid fulfilment status")
+)
+
+var mobileRegexp = regexp.MustCompile(`^\+?[0-9][0-9-]{5,19}$`)
+
+// FulfilmentService 实物奖品发货，中奖用户提交收货地址生成发货单，后台发货、签收或者取消
+type FulfilmentService interface {
+	SubmitAddress(ctx context.Context, uid uint, resultID uint, addr *FulfilmentAddress) (*model.FulfilmentOrder, error)
+	ListOrders(ctx context.Context, query *repo.FulfilmentQuery, cursor uint, limit int) (*FulfilmentPage, error)
+	UpdateStatus(ctx context.Context, id uint, update *FulfilmentStatusUpdate) (*model.FulfilmentOrder, error)
+	ExportOrders(ctx context.Context, query *repo.FulfilmentQuery) (string, int, error)
+}
+
+type fulfilmentService struct {
+	fulfilmentRepo *repo.FulfilmentRepo
+	resultRepo     *repo.ResultRepo
+}
+
+var fulfilmentServiceImpl *fulfilmentService
+
+func InitFulfilmentService() {
+	fulfilmentServiceImpl = &fulfilmentService{
+		fulfilmentRepo: repo.NewFulfilmentRepo(),
+		resultRepo:     repo.NewResultRepo(),
+	}
+}
+
+func GetFulfilmentService() FulfilmentService {
+	return fulfilmentServiceImpl
+}
+
+// isEntityPrize 实物奖品才需要发货
+func isEntityPrize(prizeType uint) bool {
+	return prizeType == constant.PrizeTypeEntitySmall || prizeType == constant.PrizeTypeEntityMiddle ||
+		prizeType == constant.PrizeTypeEntityLarge
+}
+
+// checkFulfilmentAddress 去掉首尾空格之后校验收货地址，区县可以不填
+func checkFulfilmentAddress(addr *FulfilmentAddress) error {
+	if addr == nil {
+		return fmt.Errorf("%w:address is missing", ErrInvalidFulfilment)
+	}
+	fields := []struct {
+		name     string
+		value    *string
+		maxLen   int
+		required bool
+	}{
+		{"receiver", &addr.Receiver, 50, true},
+		{"mobile", &addr.Mobile, 20, true},
+		{"province", &addr.Province, 50, true},
+		{"city", &addr.City, 50, true},
+		{"district", &addr.District, 50, false},
+		{"address", &addr.Address, 255, true},
+	}
+	for _, field := range fields {
+		*field.value = strings.TrimSpace(*field.value)
+		if field.required && *field.value == "" {
+			return fmt.Errorf("%w:%s is required", ErrInvalidFulfilment, field.name)
+		}
+		if utf8.RuneCountInString(*field.value) > field.maxLen {
+			return fmt.Errorf("%w:%s is too long", ErrInvalidFulfilment, field.name)
+		}
+	}
+	if !mobileRegexp.MatchString(addr.Mobile) {
+		return fmt.Errorf("%w:mobile is invalid", ErrInvalidFulfilment)
+	}
+	return nil
+}
+
+// SubmitAddress 中奖用户提交收货地址，没有发货单时生成，待发货时修改地址
+// 中奖记录不存在或者不属于该用户时返回nil
+func (f *fulfilmentService) SubmitAddress(ctx context.Context, uid uint, resultID uint,
+	addr *FulfilmentAddress) (*model.FulfilmentOrder, error) {
+	if err := checkFulfilmentAddress(addr); err != nil {
+		return nil, fmt.Errorf("fulfilmentService|SubmitAddress:%w", err)
+	}
+	result, err := f.resultRepo.Get(gormcli.GetDB(), resultID)
+	if err != nil {
+		log.ErrorContextf(ctx, "fulfilmentService|SubmitAddress:%v", err)
+		return nil, fmt.Errorf("fulfilmentService|SubmitAddress:%v", err)
+	}
+	if result == nil || result.UserId != uid {
+		return nil, nil
+	}
+	if result.SysStatus != constant.ResultStatusNormal || !isEntityPrize(result.PrizeType) {
+		return nil, fmt.Errorf("fulfilmentService|SubmitAddress:%w:result %d can not be shipped",
+			ErrInvalidFulfilment, resultID)
+	}
+	order, err := f.fulfilmentRepo.GetByResultID(gormcli.GetDB(), resultID)
+	if err != nil {
+		log.ErrorContextf(ctx, "fulfilmentService|SubmitAddress:%v", err)
+		return nil, fmt.Errorf("fulfilmentService|SubmitAddress:%v", err)
+	}
+	if order == nil {
+		order = newFulfilmentOrder(result, addr)
+		if err = f.fulfilmentRepo.Create(gormcli.GetDB(), order); err == nil {
+			log.InfoContextf(ctx, "fulfilmentService|SubmitAddress create order=%d result_id=%d", order.Id, resultID)
+			return order, nil
+		}
+		// 同一条中奖记录并发提交时唯一索引冲突，按修改地址处理
+		if order, _ = f.fulfilmentRepo.GetByResultID(gormcli.GetDB(), resultID); order == nil {
+			log.ErrorContextf(ctx, "fulfilmentService|SubmitAddress:%v", err)
+			return nil, fmt.Errorf("fulfilmentService|SubmitAddress:%v", err)
+		}
+	}
+	if order.SysStatus != constant.FulfilmentStatusPending {
+		return nil, fmt.Errorf("fulfilmentService|SubmitAddress:%w:order status is %d", ErrInvalidFulfilment,
+			order.SysStatus)
+	}
+	ok, err := f.fulfilmentRepo.UpdateByStatus(gormcli.GetDB(), order.Id, constant.FulfilmentStatusPending,
+		addressFields(addr))
+	if err != nil {
+		log.ErrorContextf(ctx, "fulfilmentService|SubmitAddress:%v", err)
+		return nil, fmt.Errorf("fulfilmentService|SubmitAddress:%v", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("fulfilmentService|SubmitAddress:%w:order status changed", ErrInvalidFulfilment)
+	}
+	return f.fulfilmentRepo.Get(gormcli.GetDB(), order.Id)
+}
+
+func newFulfilmentOrder(result *model.Result, addr *FulfilmentAddress) *model.FulfilmentOrder {
+	return &model.FulfilmentOrder{
+		ResultId:   result.Id,
+		ActivityId: result.ActivityId,
+		PrizeId:    result.PrizeId,
+		PrizeName:  result.PrizeName,
+		PrizeType:  result.PrizeType,
+		UserId:     result.UserId,
+		UserName:   result.UserName,
+		Receiver:   addr.Receiver,
+		Mobile:     addr.Mobile,
+		Province:   addr.Province,
+		City:       addr.City,
+		District:   addr.District,
+		Address:    addr.Address,
+		SysStatus:  constant.FulfilmentStatusPending,
+	}
+}
+
+func addressFields(addr *FulfilmentAddress) map[string]interface{} {
+	return map[string]interface{}{
+		"receiver": addr.Receiver,
+		"mobile":   addr.Mobile,
+		"province": addr.Province,
+		"city":     addr.City,
+		"district": addr.District,
+		"address":  addr.Address,
+	}
+}
+
+// ListOrders 分页查询发货单
+func (f *fulfilmentService) ListOrders(ctx context.Context, query *repo.FulfilmentQuery, cursor uint,
+	limit int) (*FulfilmentPage, error) {
+	if limit <= 0 {
+		limit = constant.FulfilmentListDefaultLimit
+	}
+	if limit > constant.FulfilmentListMaxLimit {
+		limit = constant.FulfilmentListMaxLimit
+	}
+	list, err := f.fulfilmentRepo.GetList(gormcli.GetDB(), query, cursor, limit+1)
+	if err != nil {
+		log.ErrorContextf(ctx, "fulfilmentService|ListOrders:%v", err)
+		return nil, fmt.Errorf("fulfilmentService|ListOrders:%v", err)
+	}
+	page := &FulfilmentPage{List: list}
+	if len(list) > limit {
+		page.List = list[:limit]
+		page.HasMore = true
+	}
+	if len(page.List) > 0 {
+		page.NextCursor = page.List[len(page.List)-1].Id
+	}
+	return page, nil
+}
+
+// fulfilmentStatusFields 校验发货单的状态变化，返回需要修改的字段
+// 待发货可以发货或者取消，已发货可以签收或者取消，签收和取消是最终状态
+func fulfilmentStatusFields(from uint, update *FulfilmentStatusUpdate, now time.Time) (map[string]interface{}, error) {
+	update.TrackingCompany = strings.TrimSpace(update.TrackingCompany)
+	update.TrackingNo = strings.TrimSpace(update.TrackingNo)
+	update.Remark = strings.TrimSpace(update.Remark)
+	if utf8.RuneCountInString(update.TrackingCompany) > 50 || len(update.TrackingNo) > 64 ||
+		utf8.RuneCountInString(update.Remark) > 255 {
+		return nil, fmt.Errorf("%w:tracking or remark is too long", ErrInvalidFulfilmentStatus)
+	}
+	fields := map[string]interface{}{"sys_status": update.Status}
+	if update.Remark != "" {
+		fields["remark"] = update.Remark
+	}
+	switch {
+	case from == constant.FulfilmentStatusPending && update.Status == constant.FulfilmentStatusShipped:
+		if update.TrackingNo == "" {
+			return nil, fmt.Errorf("%w:tracking_no is required", ErrInvalidFulfilmentStatus)
+		}
+		fields["tracking_company"] = update.TrackingCompany
+		fields["tracking_no"] = update.TrackingNo
+		fields["shipped_time"] = now
+	case from == constant.FulfilmentStatusShipped && update.Status == constant.FulfilmentStatusDelivered:
+		fields["delivered_time"] = now
+	case (from == constant.FulfilmentStatusPending || from == constant.FulfilmentStatusShipped) &&
+		update.Status == constant.FulfilmentStatusCancelled:
+	default:
+		return nil, fmt.Errorf("%w:can not change status from %d to %d", ErrInvalidFulfilmentStatus, from,
+			update.Status)
+	}
+	return fields, nil
+}
+
+// UpdateStatus 修改发货单状态，发货单不存在时返回nil
+func (f *fulfilmentService) UpdateStatus(ctx context.Context, id uint,
+	update *FulfilmentStatusUpdate) (*model.FulfilmentOrder, error) {
+	order, err := f.fulfilmentRepo.Get(gormcli.GetDB(), id)
+	if err != nil {
+		log.ErrorContextf(ctx, "fulfilmentService|UpdateStatus:%v", err)
+		return nil, fmt.Errorf("fulfilmentService|UpdateStatus:%v", err)
+	}
+	if order == nil {
+		return nil, nil
+	}
+	fields, err := fulfilmentStatusFields(order.SysStatus, update, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("fulfilmentService|UpdateStatus:%w", err)
+	}
+	ok, err := f.fulfilmentRepo.UpdateByStatus(gormcli.GetDB(), id, order.SysStatus, fields)
+	if err != nil {
+		log.ErrorContextf(ctx, "fulfilmentService|UpdateStatus:%v", err)
+		return nil, fmt.Errorf("fulfilmentService|UpdateStatus:%v", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("fulfilmentService|UpdateStatus:%w:order status changed", ErrInvalidFulfilmentStatus)
+	}
+	log.InfoContextf(ctx, "fulfilmentService|UpdateStatus id=%d status=%d->%d", id, order.SysStatus, update.Status)
+	return f.fulfilmentRepo.Get(gormcli.GetDB(), id)
+}
+
+// ExportOrders 导出发货单给仓库，返回csv和导出的数量，一次最多导出FulfilmentExportMaxRows条
+func (f *fulfilmentService) ExportOrders(ctx context.Context, query *repo.FulfilmentQuery) (string, int, error) {
+	list, err := f.fulfilmentRepo.GetList(gormcli.GetDB(), query, 0, constant.FulfilmentExportMaxRows)
+	if err != nil {
+		log.ErrorContextf(ctx, "fulfilmentService|ExportOrders:%v", err)
+		return "", 0, fmt.Errorf("fulfilmentService|ExportOrders:%v", err)
+	}
+	data, err := fulfilmentCSV(list)
+	if err != nil {
+		return "", 0, fmt.Errorf("fulfilmentService|ExportOrders:%v", err)
+	}
+	return data, len(list), nil
+}
+
+// csvText 用户填写的内容以公式字符开头时加上单引号，防止用Excel打开时被当成公式执行
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// fulfilmentCSV 生成发货单csv，按ID升序排列
+func fulfilmentCSV(list []*model.FulfilmentOrder) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"order_id", "result_id", "prize_id", "prize_name", "user_id", "receiver", "mobile",
+		"province", "city", "district", "address", "status", "tracking_company", "tracking_no"})
+	for i := len(list) - 1; i >= 0; i-- {
+		order := list[i]
+		_ = w.Write([]string{
+			strconv.FormatUint(uint64(order.Id), 10),
+			strconv.FormatUint(uint64(order.ResultId), 10),
+			strconv.FormatUint(uint64(order.PrizeId), 10),
+			csvText(order.PrizeName),
+			strconv.FormatUint(uint64(order.UserId), 10),
+			csvText(order.Receiver),
+			order.Mobile,
+			csvText(order.Province),
+			csvText(order.City),
+			csvText(order.District),
+			csvText(order.Address),
+			strconv.FormatUint(uint64(order.SysStatus), 10),
+			order.TrackingCompany,
+			order.TrackingNo,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
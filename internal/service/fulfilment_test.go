@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+)
+
+func TestCheckFulfilmentAddress(t *testing.T) {
+	addr := &FulfilmentAddress{
+		Receiver: " 张三 ",
+		Mobile:   "13800138000",
+		Province: "广东省",
+		City:     "深圳市",
+		Address:  "南山区科技园1号",
+	}
+	assert.Nil(t, checkFulfilmentAddress(addr))
+	assert.Equal(t, "张三", addr.Receiver)
+
+	cases := []*FulfilmentAddress{
+		nil,
+		{Receiver: "张三", Mobile: "13800138000", Province: "广东省", City: "深圳市"},
+		{Receiver: "张三", Mobile: "abc", Province: "广东省", City: "深圳市", Address: "1号"},
+		{Receiver: strings.Repeat("张", 51), Mobile: "13800138000", Province: "广东省", City: "深圳市", Address: "1号"},
+	}
+	for i, c := range cases {
+		err := checkFulfilmentAddress(c)
+		assert.True(t, errors.Is(err, ErrInvalidFulfilment), "case %d", i)
+	}
+}
+
+func TestFulfilmentStatusFields(t *testing.T) {
+	now := time.Now()
+	fields, err := fulfilmentStatusFields(constant.FulfilmentStatusPending,
+		&FulfilmentStatusUpdate{Status: constant.FulfilmentStatusShipped, TrackingNo: " SF123 "}, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "SF123", fields["tracking_no"])
+	assert.Equal(t, now, fields["shipped_time"])
+
+	_, err = fulfilmentStatusFields(constant.FulfilmentStatusPending,
+		&FulfilmentStatusUpdate{Status: constant.FulfilmentStatusShipped}, now)
+	assert.True(t, errors.Is(err, ErrInvalidFulfilmentStatus))
+
+	fields, err = fulfilmentStatusFields(constant.FulfilmentStatusShipped,
+		&FulfilmentStatusUpdate{Status: constant.FulfilmentStatusDelivered}, now)
+	assert.Nil(t, err)
+	assert.Equal(t, now, fields["delivered_time"])
+
+	fields, err = fulfilmentStatusFields(constant.FulfilmentStatusShipped,
+		&FulfilmentStatusUpdate{Status: constant.FulfilmentStatusCancelled, Remark: "lost"}, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "lost", fields["remark"])
+
+	invalid := [][2]uint{
+		{constant.FulfilmentStatusPending, constant.FulfilmentStatusDelivered},
+		{constant.FulfilmentStatusDelivered, constant.FulfilmentStatusCancelled},
+		{constant.FulfilmentStatusCancelled, constant.FulfilmentStatusPending},
+		{constant.FulfilmentStatusPending, constant.FulfilmentStatusPending},
+	}
+	for _, c := range invalid {
+		_, err = fulfilmentStatusFields(c[0], &FulfilmentStatusUpdate{Status: c[1], TrackingNo: "SF123"}, now)
+		assert.True(t, errors.Is(err, ErrInvalidFulfilmentStatus), "%d->%d", c[0], c[1])
+	}
+}
+
+func TestFulfilmentCSV(t *testing.T) {
+	data, err := fulfilmentCSV([]*model.FulfilmentOrder{
+		{Id: 2, ResultId: 20, Receiver: "=cmd", Address: "a,b"},
+		{Id: 1, ResultId: 10, Receiver: "李四", Mobile: "+8613800138000"},
+	})
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[1], "1,10,"))
+	assert.Contains(t, lines[1], ",+8613800138000,")
+	assert.True(t, strings.HasPrefix(lines[2], "2,20,"))
+	assert.Contains(t, lines[2], ",'=cmd,")
+	assert.Contains(t, lines[2], `"a,b"`)
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"lottery_single/configs"
 	"lottery_single/internal/model"
 	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/pkg/middlewares/cache"
@@ -12,25 +13,38 @@ import (
 	"lottery_single/internal/repo"
 	"math"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // LimitService 用户功能
 type LimitService interface {
-	GetUserCurrentLotteryTimes(ctx context.Context, uid uint) (*model.LotteryTimes, error)
-	CheckUserDayLotteryTimes(ctx context.Context, uid uint) (bool, error)
-	CheckUserDayLotteryTimesWithCache(ctx context.Context, uid uint) (bool, error)
-	CheckIPLimit(ctx context.Context, ip string) int64
+	GetUserCurrentLotteryTimes(ctx context.Context, activityID uint, uid uint) (*model.LotteryTimes, error)
+	CheckUserDayLotteryTimes(ctx context.Context, activity *model.Activity, uid uint) (bool, error)
+	CheckUserDayLotteryTimesWithCache(ctx context.Context, activity *model.Activity, uid uint) (bool, error)
+	CheckIPLimit(ctx context.Context, activityID uint, ip string) int64
 	CheckBlackIP(ctx context.Context, ip string) (bool, *model.BlackIp, error)
 	CheckBlackIPWithCache(ctx context.Context, ip string) (bool, *model.BlackIp, error)
 	CheckBlackUser(ctx context.Context, uid uint) (bool, *model.BlackUser, error)
 	CheckBlackUserWithCache(ctx context.Context, uid uint) (bool, *model.BlackUser, error)
+	CheckBeforeDraw(ctx context.Context, activity *model.Activity, uid uint, ip string, prize *LotteryPrize) (*PreDrawCheck, error)
+	CheckDrawTimesLeft(ctx context.Context, activity *model.Activity, uid uint, ip string, n int) (constant.ErrCode, error)
+	InvalidateBlackIpRanges()
 }
 
 type limitService struct {
 	lotteryTimesReop *repo.LotteryTimesRepo
 	blackIpRepo      *repo.BlackIpRepo
 	blackUserRepo    *repo.BlackUserRepo
+	prizeRepo        *repo.PrizeReop
+
+	// redis不支持脚本时，抽奖前置检查退回到逐个检查
+	scriptUnavailable atomic.Bool
+
+	// 内存中的网段黑名单，只有一个请求负责重新加载
+	ipRangesMu sync.Mutex
+	ipRanges   atomic.Pointer[blackIpRanges]
 }
 
 var limitServiceImpl *limitService
@@ -40,6 +54,7 @@ func InitLimitService() {
 		lotteryTimesReop: repo.NewLotteryTimesRepo(gormcli.GetDB(), cache.GetRedisCli()),
 		blackIpRepo:      repo.NewBlackIpRepo(),
 		blackUserRepo:    repo.NewBlackUserRepo(),
+		prizeRepo:        repo.NewPrizeRepo(),
 	}
 }
 
@@ -47,12 +62,12 @@ func GetLimitService() LimitService {
 	return limitServiceImpl
 }
 
-// GetUserCurrentLotteryTimes 获取当天该用户的抽奖次数
-func (l *limitService) GetUserCurrentLotteryTimes(ctx context.Context, uid uint) (*model.LotteryTimes, error) {
+// GetUserCurrentLotteryTimes 获取当天该用户在某个活动的抽奖次数
+func (l *limitService) GetUserCurrentLotteryTimes(ctx context.Context, activityID uint, uid uint) (*model.LotteryTimes, error) {
 	y, m, d := time.Now().Date()
 	strDay := fmt.Sprintf("%d%02d%02d", y, m, d)
 	day, _ := strconv.Atoi(strDay)
-	lotteryTimes, err := l.lotteryTimesReop.GetByUserIDAndDay(uid, uint(day))
+	lotteryTimes, err := l.lotteryTimesReop.GetByUserIDAndDay(activityID, uid, uint(day))
 	if err != nil {
 		log.ErrorContextf(ctx, "lotteryTimesService|GetUserCurrentLotteryTimes:%v", err)
 		return nil, err
@@ -60,15 +75,15 @@ func (l *limitService) GetUserCurrentLotteryTimes(ctx context.Context, uid uint)
 	return lotteryTimes, nil
 }
 
-// CheckUserDayLotteryTimes 判断当天是否还可以进行抽奖
-func (l *limitService) CheckUserDayLotteryTimes(ctx context.Context, uid uint) (bool, error) {
-	userLotteryTimes, err := l.GetUserCurrentLotteryTimes(ctx, uid)
+// CheckUserDayLotteryTimes 判断当天是否还可以进行抽奖，次数限制按照活动的配置
+func (l *limitService) CheckUserDayLotteryTimes(ctx context.Context, activity *model.Activity, uid uint) (bool, error) {
+	userLotteryTimes, err := l.GetUserCurrentLotteryTimes(ctx, activity.Id, uid)
 	if err != nil {
 		return false, fmt.Errorf("checkUserDayLotteryTimes|err:%v", err)
 	}
 	if userLotteryTimes != nil {
 		// 今天的抽奖记录已经达到了抽奖次数限制
-		if userLotteryTimes.Num >= constant.UserPrizeMax {
+		if userLotteryTimes.Num >= uint(activity.UserDayLimit) {
 			return false, nil
 		} else {
 			userLotteryTimes.Num++
@@ -82,9 +97,10 @@ func (l *limitService) CheckUserDayLotteryTimes(ctx context.Context, uid uint) (
 	strDay := fmt.Sprintf("%d%02d%02d", y, m, d)
 	day, _ := strconv.Atoi(strDay)
 	lotteryTimeesInfo := &model.LotteryTimes{
-		UserId: uid,
-		Day:    uint(day),
-		Num:    1,
+		ActivityId: activity.Id,
+		UserId:     uid,
+		Day:        uint(day),
+		Num:        1,
 	}
 	if err := l.lotteryTimesReop.Create(lotteryTimeesInfo); err != nil {
 		return false, fmt.Errorf("updateLotteryTimes｜create:%v", err)
@@ -92,25 +108,30 @@ func (l *limitService) CheckUserDayLotteryTimes(ctx context.Context, uid uint) (
 	return true, nil
 }
 
-func (l *limitService) CheckUserDayLotteryTimesWithCache(ctx context.Context, uid uint) (bool, error) {
+func (l *limitService) CheckUserDayLotteryTimesWithCache(ctx context.Context, activity *model.Activity, uid uint) (bool, error) {
 	// 通过缓存验证
-	userLotteryNum := l.lotteryTimesReop.IncrUserDayLotteryNum(uid)
+	userLotteryNum := l.lotteryTimesReop.IncrUserDayLotteryNum(activity.Id, uid)
 	log.InfoContextf(ctx, "CheckUserDayLotteryTimesWithCache|userLotteryNum = %d", userLotteryNum)
 	// 缓存验证没通过，直接返回
-	if userLotteryNum > constant.UserPrizeMax {
+	if userLotteryNum > int64(activity.UserDayLimit) {
 		return false, nil
 	}
-	// 通过数据库验证，还要在数据库中做一次验证
-	userLotteryTimes, err := l.GetUserCurrentLotteryTimes(ctx, uid)
+	return l.checkUserDayLotteryTimesByDB(ctx, activity, uid, userLotteryNum)
+}
+
+// checkUserDayLotteryTimesByDB 缓存验证通过之后，还要在数据库中做一次验证，并且用数据库的次数修正缓存
+func (l *limitService) checkUserDayLotteryTimesByDB(ctx context.Context, activity *model.Activity, uid uint,
+	userLotteryNum int64) (bool, error) {
+	userLotteryTimes, err := l.GetUserCurrentLotteryTimes(ctx, activity.Id, uid)
 	if err != nil {
 		return false, fmt.Errorf("checkUserDayLotteryTimes|err:%v", err)
 	}
 	if userLotteryTimes != nil {
 		// 数据库验证今天的抽奖记录已经达到了抽奖次数限制，不能在抽奖
-		if userLotteryTimes.Num >= constant.UserPrizeMax {
+		if userLotteryTimes.Num >= uint(activity.UserDayLimit) {
 			// 缓存数据不可靠，不对，需要更新
 			if int64(userLotteryTimes.Num) > userLotteryNum {
-				if err = l.lotteryTimesReop.InitUserLuckyNum(uid, int64(userLotteryTimes.Num)); err != nil {
+				if err = l.lotteryTimesReop.InitUserLuckyNum(activity.Id, uid, int64(userLotteryTimes.Num)); err != nil {
 					return false, fmt.Errorf("limitService|CheckUserDayLotteryTimesWithCache:%v", err)
 				}
 			}
@@ -119,7 +140,7 @@ func (l *limitService) CheckUserDayLotteryTimesWithCache(ctx context.Context, ui
 			userLotteryTimes.Num++
 			// 此时次数抽奖次数增加了，需要更新缓存
 			if int64(userLotteryTimes.Num) > userLotteryNum {
-				if err = l.lotteryTimesReop.InitUserLuckyNum(uid, int64(userLotteryTimes.Num)); err != nil {
+				if err = l.lotteryTimesReop.InitUserLuckyNum(activity.Id, uid, int64(userLotteryTimes.Num)); err != nil {
 					return false, fmt.Errorf("limitService|CheckUserDayLotteryTimesWithCache:%v", err)
 				}
 			}
@@ -134,25 +155,26 @@ func (l *limitService) CheckUserDayLotteryTimesWithCache(ctx context.Context, ui
 	strDay := fmt.Sprintf("%d%02d%02d", y, m, d)
 	day, _ := strconv.Atoi(strDay)
 	lotteryTimesInfo := &model.LotteryTimes{
-		UserId: uid,
-		Day:    uint(day),
-		Num:    1,
+		ActivityId: activity.Id,
+		UserId:     uid,
+		Day:        uint(day),
+		Num:        1,
 	}
 	if err = l.lotteryTimesReop.Create(lotteryTimesInfo); err != nil {
 		return false, fmt.Errorf("updateLotteryTimes｜create:%v", err)
 	}
-	if err = l.lotteryTimesReop.InitUserLuckyNum(uid, 1); err != nil {
+	if err = l.lotteryTimesReop.InitUserLuckyNum(activity.Id, uid, 1); err != nil {
 		return false, fmt.Errorf("limitService|CheckUserDayLotteryTimesWithCache:%v", err)
 	}
 	return true, nil
 }
 
-// CheckIPLimit 验证ip抽奖是否受限制
-func (l *limitService) CheckIPLimit(ctx context.Context, strIp string) int64 {
-	ip := utils.Ip4toInt(strIp)
-	i := ip % constant.IpFrameSize
-	key := fmt.Sprintf("day_ip_num_%d", i)
-	ret, err := cache.GetRedisCli().HIncrBy(ctx, key, strIp, 1)
+// CheckIPLimit ip在某个活动的抽奖次数递增，返回递增后的次数，是否受限制由调用方和活动的配置比较
+// IPv6按照/64网段统计次数
+func (l *limitService) CheckIPLimit(ctx context.Context, activityID uint, strIp string) int64 {
+	i := utils.IPShard(strIp, configs.GetLotteryConfig().IpFrameSize)
+	key := fmt.Sprintf(constant.IpLotteryDayNumPrefix+"%d_%d", activityID, i)
+	ret, err := cache.GetRedisCli().HIncrBy(ctx, key, utils.IPLimitKey(strIp), 1)
 	if err != nil {
 		log.ErrorContextf(ctx, "CheckIPLimit|Incr:%v", err)
 		return math.MaxInt32
@@ -160,35 +182,87 @@ func (l *limitService) CheckIPLimit(ctx context.Context, strIp string) int64 {
 	return ret
 }
 
+// CheckDrawTimesLeft 连抽之前检查用户和IP当天剩余的抽奖次数是否够n次，只检查不增加次数，每次抽奖时仍然会逐次检查
+func (l *limitService) CheckDrawTimesLeft(ctx context.Context, activity *model.Activity, uid uint, ip string,
+	n int) (constant.ErrCode, error) {
+	userNum, err := l.lotteryTimesReop.GetUserDayLotteryNum(activity.Id, uid)
+	if err != nil {
+		log.ErrorContextf(ctx, "limitService|CheckDrawTimesLeft:%v", err)
+		return constant.ErrInternalServer, fmt.Errorf("limitService|CheckDrawTimesLeft:%v", err)
+	}
+	// 缓存中的次数可能比数据库少，以多的为准
+	lotteryTimes, err := l.GetUserCurrentLotteryTimes(ctx, activity.Id, uid)
+	if err != nil {
+		return constant.ErrInternalServer, fmt.Errorf("limitService|CheckDrawTimesLeft:%v", err)
+	}
+	if lotteryTimes != nil && int64(lotteryTimes.Num) > userNum {
+		userNum = int64(lotteryTimes.Num)
+	}
+	ipNum, err := l.getIPDayLotteryNum(ctx, activity.Id, ip)
+	if err != nil {
+		log.ErrorContextf(ctx, "limitService|CheckDrawTimesLeft:%v", err)
+		return constant.ErrInternalServer, fmt.Errorf("limitService|CheckDrawTimesLeft:%v", err)
+	}
+	return drawTimesLeftCode(activity, userNum, ipNum, n), nil
+}
+
+// drawTimesLeftCode 用户和IP已经抽奖的次数再加上n次是否超过活动的限制
+func drawTimesLeftCode(activity *model.Activity, userNum, ipNum int64, n int) constant.ErrCode {
+	if userNum+int64(n) > int64(activity.UserDayLimit) {
+		return constant.ErrUserLimitInvalid
+	}
+	if ipNum+int64(n) > int64(activity.IpDayLimit) {
+		return constant.ErrIPLimitInvalid
+	}
+	return constant.Success
+}
+
+// getIPDayLotteryNum 获取ip当天在某个活动的抽奖次数，不增加次数
+func (l *limitService) getIPDayLotteryNum(ctx context.Context, activityID uint, strIp string) (int64, error) {
+	i := utils.IPShard(strIp, configs.GetLotteryConfig().IpFrameSize)
+	key := fmt.Sprintf(constant.IpLotteryDayNumPrefix+"%d_%d", activityID, i)
+	return cache.GetRedisCli().HIncrBy(ctx, key, utils.IPLimitKey(strIp), 0)
+}
+
+// CheckBlackIP 检查单个IP的黑名单和网段黑名单，命中网段时返回网段的黑名单信息
 func (l *limitService) CheckBlackIP(ctx context.Context, ip string) (bool, *model.BlackIp, error) {
+	ip = utils.NormalizeIP(ip)
 	info, err := l.blackIpRepo.GetByIP(gormcli.GetDB(), ip)
 	if err != nil {
 		log.ErrorContextf(ctx, "CheckBlackIP|GetByIP:%v", err)
 		return false, nil, fmt.Errorf("CheckBlackIP|GetByIP:%v", err)
 	}
-	if info == nil || info.Ip == "" {
-		return true, nil, nil
-	}
-	if time.Now().Before(info.BlackTime) {
+	if info != nil && info.Ip != "" && time.Now().Before(info.BlackTime) {
 		// IP黑名单存在，而且还在黑名单有效期内
 		return false, info, nil
 	}
+	if ok, rangeInfo, err := l.checkBlackIpRange(ctx, ip); err != nil || !ok {
+		return ok, rangeInfo, err
+	}
+	if info == nil || info.Ip == "" {
+		return true, nil, nil
+	}
 	return true, info, nil
 }
 
+// CheckBlackIPWithCache 同 CheckBlackIP，单个IP的黑名单优先从缓存获取
 func (l *limitService) CheckBlackIPWithCache(ctx context.Context, ip string) (bool, *model.BlackIp, error) {
+	ip = utils.NormalizeIP(ip)
 	info, err := l.blackIpRepo.GetByIPWithCache(gormcli.GetDB(), ip)
 	if err != nil {
 		log.ErrorContextf(ctx, "CheckBlackIP|GetByIP:%v", err)
 		return false, nil, fmt.Errorf("CheckBlackIP|GetByIP:%v", err)
 	}
-	if info == nil || info.Ip == "" {
-		return true, nil, nil
-	}
-	if time.Now().Before(info.BlackTime) {
+	if info != nil && info.Ip != "" && time.Now().Before(info.BlackTime) {
 		// IP黑名单存在，而且还在黑名单有效期内
 		return false, info, nil
 	}
+	if ok, rangeInfo, err := l.checkBlackIpRange(ctx, ip); err != nil || !ok {
+		return ok, rangeInfo, err
+	}
+	if info == nil || info.Ip == "" {
+		return true, nil, nil
+	}
 	return true, info, nil
 }
 
@@ -0,0 +1,17 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+)
+
+func TestDrawTimesLeftCode(t *testing.T) {
+	activity := &model.Activity{UserDayLimit: 20, IpDayLimit: 50}
+	assert.Equal(t, constant.Success, drawTimesLeftCode(activity, 10, 40, 10))
+	assert.Equal(t, constant.ErrUserLimitInvalid, drawTimesLeftCode(activity, 11, 0, 10))
+	assert.Equal(t, constant.ErrIPLimitInvalid, drawTimesLeftCode(activity, 0, 41, 10))
+	assert.Equal(t, constant.Success, drawTimesLeftCode(activity, 19, 49, 1))
+}
@@ -2,39 +2,60 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"gorm.io/gorm"
+	"lottery_single/configs"
 	"lottery_single/internal/model"
 	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/draw"
 	"lottery_single/internal/pkg/middlewares/gormcli"
 	"lottery_single/internal/pkg/middlewares/lock"
 	"lottery_single/internal/pkg/middlewares/log"
 	"lottery_single/internal/repo"
-	"strconv"
-	"strings"
+	"sort"
+	"sync"
 	"time"
 )
 
 // LotteryService 抽发奖功能
 type LotteryService interface {
-	GetPrize(ctx context.Context, prizeCode int) (*LotteryPrize, error)
-	GetPrizeWithCache(ctx context.Context, prizeCode int) (*LotteryPrize, error)
-	GetAllUsefulPrizes(ctx context.Context) ([]*LotteryPrize, error)
-	GetAllUsefulPrizesWithCache(ctx context.Context) ([]*LotteryPrize, error)
+	GetPrize(ctx context.Context, activityID uint, trace *DrawTrace) (*LotteryPrize, int64, error)
+	GetPrizeWithCache(ctx context.Context, activityID uint, trace *DrawTrace) (*LotteryPrize, int64, error)
+	GetPityPrizeWithCache(ctx context.Context, activityID uint, trace *DrawTrace, pity *PityDraw) (*LotteryPrize, int64,
+		error)
+	GetAllUsefulPrizes(ctx context.Context, activityID uint) ([]*LotteryPrize, error)
+	GetAllUsefulPrizesWithCache(ctx context.Context, activityID uint) ([]*LotteryPrize, error)
 	PrizeCouponDiff(ctx context.Context, prizeID int) (string, error)
 	PrizeCouponDiffWithCache(ctx context.Context, prizeID int) (string, error)
-	PrizeLargeBlackLimit(ctx context.Context, blackUser *model.BlackUser, blackIp *model.BlackIp, info *LotteryUserInfo) error
+	PrizeLargeBlackLimit(ctx context.Context, info *LotteryUserInfo, blackTime int) error
 	GiveOutPrize(ctx context.Context, prizeID int) (bool, error)
-	GiveOutPrizeWithCache(ctx context.Context, prizeID int) (bool, error)
-	GiveOutPrizeWithPool(ctx context.Context, prizeID int) (bool, error)
-	GetPrizeNumWithPool(ctx context.Context, prizeID uint) (int, error)
+	GiveOutPrizeWithCache(ctx context.Context, activityID uint, prizeID int) (bool, error)
+	GiveOutPrizeWithPool(ctx context.Context, activityID uint, prizeID int) (bool, error)
+	GetPrizeNumWithPool(ctx context.Context, activityID uint, prizeID uint) (int, error)
+	AwardPrizeWithPool(ctx context.Context, prize *LotteryPrize, info *LotteryUserInfo, prizeCode int64, requestID string,
+		poolDecreased bool) (bool, error)
 }
 
+// errPrizeNotEnough 事务中扣减库存失败，需要回滚事务
+var errPrizeNotEnough = errors.New("prize not enough")
+
+// errCouponUnavailable 缓存中领取的优惠券已经作废或者过期，需要回滚事务，优惠券不再放回缓存
+var errCouponUnavailable = errors.New("coupon unavailable")
+
 type lotteryService struct {
 	prizeReop     *repo.PrizeReop
 	couponReop    *repo.CouponRepo
+	resultReop    *repo.ResultRepo
 	blackUserRepo *repo.BlackUserRepo
 	blackIpRepo   *repo.BlackIpRepo
+	auditService  *auditService
+	walletService *walletService
+
+	// 抽奖引擎，每个活动一个，奖品列表版本变化时重新构建
+	engineBuilder draw.Builder
+	engineMu      sync.RWMutex
+	engines       map[uint]draw.Engine
 }
 
 var lotteryServiceImpl *lotteryService
@@ -43,8 +64,13 @@ func NewLotteryService() {
 	lotteryServiceImpl = &lotteryService{
 		prizeReop:     repo.NewPrizeRepo(),
 		couponReop:    repo.NewCouponRepo(),
+		resultReop:    repo.NewResultRepo(),
 		blackUserRepo: repo.NewBlackUserRepo(),
 		blackIpRepo:   repo.NewBlackIpRepo(),
+		auditService:  auditServiceImpl,
+		walletService: walletServiceImpl,
+		engineBuilder: draw.NewAliasEngine,
+		engines:       make(map[uint]draw.Engine),
 	}
 }
 
@@ -56,47 +82,124 @@ func (l *lotteryService) Lottery(ctx context.Context, db *gorm.DB) {
 
 }
 
-func (l *lotteryService) GetPrize(ctx context.Context, prizeCode int) (*LotteryPrize, error) {
-	var prize *LotteryPrize
-	lotteryPrizeList, err := l.GetAllUsefulPrizes(ctx)
+// GetPrize 抽奖，返回中奖的奖品和本次的抽奖编码，没有中奖时奖品为nil，抽奖的审计信息填写到trace中
+func (l *lotteryService) GetPrize(ctx context.Context, activityID uint, trace *DrawTrace) (*LotteryPrize, int64, error) {
+	lotteryPrizeList, err := l.GetAllUsefulPrizes(ctx, activityID)
 	if err != nil {
 		log.ErrorContextf(ctx, "lotteryService|ToLotteryPrize:%v", err)
-		return nil, err
+		return nil, 0, err
 	}
-	for _, lotteryPrize := range lotteryPrizeList {
-		log.InfoContextf(ctx, "Checking prize: %+v", lotteryPrize)
-		if lotteryPrize.PrizeCodeLow <= prizeCode && lotteryPrize.PrizeCodeHigh >= prizeCode {
-			// 中奖编码区间满足条件，说明可以中奖
-			if lotteryPrize.PrizeType < constant.PrizeTypeEntitySmall {
-				log.InfoContext(ctx, "Found suitable prize!")
-				prize = lotteryPrize
-				break
-			}
-		}
+	prize, prizeCode, err := l.drawPrize(ctx, activityID, lotteryPrizeList, trace)
+	if err != nil {
+		return nil, 0, err
 	}
-
-	return prize, nil
+	// 非实物奖直接发，实物奖不在这个版本发放
+	if prize != nil && prize.PrizeType >= constant.PrizeTypeEntitySmall {
+		log.InfoContextf(ctx, "lotteryService|GetPrize skip entity prize: %+v", prize)
+		prize = nil
+	}
+	return prize, prizeCode, nil
 }
 
 // GetPrizeWithCache 获取中奖的奖品类型
-func (l *lotteryService) GetPrizeWithCache(ctx context.Context, prizeCode int) (*LotteryPrize, error) {
-	var prize *LotteryPrize
-	lotteryPrizeList, err := l.GetAllUsefulPrizesWithCache(ctx)
+func (l *lotteryService) GetPrizeWithCache(ctx context.Context, activityID uint, trace *DrawTrace) (*LotteryPrize, int64, error) {
+	lotteryPrizeList, err := l.GetAllUsefulPrizesWithCache(ctx, activityID)
 	if err != nil {
 		log.ErrorContextf(ctx, "lotteryService|ToLotteryPrize:%v", err)
-		return nil, err
+		return nil, 0, err
+	}
+	return l.drawPrize(ctx, activityID, lotteryPrizeList, trace)
+}
+
+// drawPrize 用活动的抽奖引擎从奖品列表中抽出一个奖品
+// 抽奖编码由当前种子和活动ID、用户ID、请求ID、序号计算得到，种子公开之后可以重新计算
+func (l *lotteryService) drawPrize(ctx context.Context, activityID uint, lotteryPrizeList []*LotteryPrize,
+	trace *DrawTrace) (*LotteryPrize, int64, error) {
+	engine, err := l.getEngine(activityID, lotteryPrizeList)
+	if err != nil {
+		log.ErrorContextf(ctx, "lotteryService|drawPrize:%v", err)
+		return nil, 0, fmt.Errorf("lotteryService|drawPrize:%v", err)
+	}
+	return l.drawWithEngine(ctx, activityID, engine, lotteryPrizeList, trace)
+}
+
+// GetPityPrizeWithCache 按照保底规则的调整抽奖，必中时只从有库存的奖品中按照权重的比例抽取，提高权重时只提高有库存的奖品
+// 没有可以调整的奖品时按照正常的概率抽奖，审计信息中记录连续没有中奖的次数和实际的调整方式
+func (l *lotteryService) GetPityPrizeWithCache(ctx context.Context, activityID uint, trace *DrawTrace,
+	pity *PityDraw) (*LotteryPrize, int64, error) {
+	lotteryPrizeList, err := l.GetAllUsefulPrizesWithCache(ctx, activityID)
+	if err != nil {
+		log.ErrorContextf(ctx, "lotteryService|ToLotteryPrize:%v", err)
+		return nil, 0, err
 	}
+	trace.PityLosses = pity.Losses
+	items := pityDrawItems(lotteryPrizeList, pity)
+	if len(items) == 0 {
+		return l.drawPrize(ctx, activityID, lotteryPrizeList, trace)
+	}
+	// 调整之后的奖品列表随连续没有中奖的次数变化，不缓存引擎
+	engine, err := l.engineBuilder(items)
+	if err != nil {
+		log.ErrorContextf(ctx, "lotteryService|GetPityPrizeWithCache:%v", err)
+		return nil, 0, fmt.Errorf("lotteryService|GetPityPrizeWithCache:%v", err)
+	}
+	trace.PityMode = pity.Mode
+	return l.drawWithEngine(ctx, activityID, engine, lotteryPrizeList, trace)
+}
+
+// drawWithEngine 用指定的抽奖引擎抽奖，审计信息中记录引擎的版本
+func (l *lotteryService) drawWithEngine(ctx context.Context, activityID uint, engine draw.Engine,
+	lotteryPrizeList []*LotteryPrize, trace *DrawTrace) (*LotteryPrize, int64, error) {
+	seed, nonce, err := l.auditService.nextDraw(ctx)
+	if err != nil {
+		log.ErrorContextf(ctx, "lotteryService|drawPrize:%v", err)
+		return nil, 0, fmt.Errorf("lotteryService|drawPrize:%v", err)
+	}
+	message := draw.Message(activityID, trace.UserID, trace.RequestID, nonce)
+	prizeCode := draw.DeriveCode(seed.Seed, message, engine.Space())
+	trace.ActivityID = activityID
+	trace.SeedID = seed.Id
+	trace.Nonce = nonce
+	trace.CodeSpace = engine.Space()
+	trace.PrizeCode = prizeCode
+	trace.EngineVersion = engine.Version()
+	log.InfoContextf(ctx, "lotteryService|drawPrize version=%s seed_id=%d nonce=%d prizeCode=%d",
+		engine.Version(), seed.Id, nonce, prizeCode)
+	id, ok := engine.Pick(prizeCode)
+	if !ok {
+		return nil, prizeCode, nil
+	}
+	trace.PrizeID = id
 	for _, lotteryPrize := range lotteryPrizeList {
-		if lotteryPrize.PrizeCodeLow <= prizeCode &&
-			lotteryPrize.PrizeCodeHigh >= prizeCode {
-			// 中奖编码区间满足条件，说明可以中奖
-			//if lotteryPrize.PrizeType < constant.PrizeTypeEntitySmall { //如果非实物奖直接发，实物奖需要看是不是在黑名单外
-			prize = lotteryPrize
-			break
-			//}
+		if lotteryPrize.Id == id {
+			return lotteryPrize, prizeCode, nil
 		}
 	}
-	return prize, nil
+	return nil, prizeCode, nil
+}
+
+// getEngine 获取活动的抽奖引擎，奖品列表的版本没有变化时复用之前构建好的引擎
+func (l *lotteryService) getEngine(activityID uint, lotteryPrizeList []*LotteryPrize) (draw.Engine, error) {
+	items := toDrawItems(lotteryPrizeList)
+	version := draw.Version(items)
+	l.engineMu.RLock()
+	engine := l.engines[activityID]
+	l.engineMu.RUnlock()
+	if engine != nil && engine.Version() == version {
+		return engine, nil
+	}
+
+	l.engineMu.Lock()
+	defer l.engineMu.Unlock()
+	if engine = l.engines[activityID]; engine != nil && engine.Version() == version {
+		return engine, nil
+	}
+	engine, err := l.engineBuilder(items)
+	if err != nil {
+		return nil, err
+	}
+	l.engines[activityID] = engine
+	return engine, nil
 }
 
 // GiveOutPrize 发奖，奖品数量减1
@@ -114,7 +217,7 @@ func (l *lotteryService) GiveOutPrize(ctx context.Context, prizeID int) (bool, e
 }
 
 // GiveOutPrizeWithCache 发奖，奖品数量减1,并且同步更新缓存
-func (l *lotteryService) GiveOutPrizeWithCache(ctx context.Context, prizeID int) (bool, error) {
+func (l *lotteryService) GiveOutPrizeWithCache(ctx context.Context, activityID uint, prizeID int) (bool, error) {
 	// 该类奖品的库存数量减1
 	ok, err := l.prizeReop.DecrLeftNum(gormcli.GetDB(), prizeID, 1)
 	if err != nil {
@@ -125,15 +228,15 @@ func (l *lotteryService) GiveOutPrizeWithCache(ctx context.Context, prizeID int)
 		return false, nil
 	}
 	// 扣减库存成功
-	if err = l.prizeReop.UpdateByCache(&model.Prize{Id: uint(prizeID)}); err != nil {
+	if err = l.prizeReop.UpdateByCache(&model.Prize{Id: uint(prizeID), ActivityId: activityID}); err != nil {
 		log.ErrorContextf(ctx, "lotteryService|GiveOutPrize|UpdateByCache err:%v", err)
 		return false, fmt.Errorf("lotteryService|GiveOutPrize|UpdateByCache:%v", err)
 	}
 	return true, nil
 }
 
-func (l *lotteryService) GiveOutPrizeWithPool(ctx context.Context, prizeID int) (bool, error) {
-	cnt, err := l.prizeReop.DecrLeftNumByPool(prizeID)
+func (l *lotteryService) GiveOutPrizeWithPool(ctx context.Context, activityID uint, prizeID int) (bool, error) {
+	cnt, err := l.prizeReop.DecrLeftNumByPool(activityID, prizeID)
 	if err != nil {
 		log.ErrorContextf(ctx, "lotteryService|GiveOutPrizeWithPool err:%v", err)
 	}
@@ -145,9 +248,126 @@ func (l *lotteryService) GiveOutPrizeWithPool(ctx context.Context, prizeID int)
 	return l.GiveOutPrize(ctx, prizeID)
 }
 
+// AwardPrizeWithPool 发奖，奖品池扣减和优惠券领取在redis中完成，db库存扣减、优惠券状态更新、中奖纪录写入、付费抽奖扣费和虚拟币入账放在一个事务中
+// poolDecreased 表示奖品池已经在抽奖前置检查中扣减过了，不需要再次扣减
+// 事务失败时回补奖品池和优惠券缓存，返回false表示奖品不足，没有发奖，余额不足时返回ErrInsufficientBalance
+func (l *lotteryService) AwardPrizeWithPool(ctx context.Context, prize *LotteryPrize, info *LotteryUserInfo,
+	prizeCode int64, requestID string, poolDecreased bool) (bool, error) {
+	// 1. 扣减奖品池
+	if prize.PrizeNum > 0 && !poolDecreased {
+		cnt, err := l.prizeReop.DecrLeftNumByPool(prize.ActivityId, int(prize.Id))
+		if err != nil {
+			log.ErrorContextf(ctx, "lotteryService|AwardPrizeWithPool err:%v", err)
+			return false, fmt.Errorf("lotteryService|AwardPrizeWithPool:%v", err)
+		}
+		// 扣减之后奖品池的数量小于0，当前时段该奖品不足，不能发奖
+		if cnt < 0 {
+			l.compensatePool(ctx, prize)
+			return false, nil
+		}
+	}
+
+	// 2. 从缓存中领取一个优惠券编码
+	code := ""
+	if prize.PrizeType == constant.PrizeTypeCouponDiff {
+		var err error
+		code, err = l.couponReop.GetNextUsefulCouponFromCache(int(prize.Id))
+		if err != nil {
+			l.compensatePool(ctx, prize)
+			log.ErrorContextf(ctx, "lotteryService|AwardPrizeWithPool err:%v", err)
+			return false, fmt.Errorf("lotteryService|AwardPrizeWithPool:%v", err)
+		}
+		if code == "" {
+			l.compensatePool(ctx, prize)
+			log.InfoContextf(ctx, "lotteryService|AwardPrizeWithPool coupon left is nil with prize_id=%d", prize.Id)
+			return false, nil
+		}
+		prize.CouponCode = code
+	}
+
+	// 3. db中的发奖操作要么全部成功，要么全部失败
+	err := gormcli.Transaction(ctx, func(txctx context.Context) error {
+		db := gormcli.GetDBFromCtx(txctx)
+		if prize.PrizeNum > 0 {
+			ok, err := l.prizeReop.DecrLeftNum(db, int(prize.Id), 1)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errPrizeNotEnough
+			}
+		}
+		if code != "" {
+			ok, err := l.couponReop.IssueByCode(db, code, time.Now())
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errCouponUnavailable
+			}
+		}
+		result := newLotteryResult(prize, info, prizeCode, requestID)
+		if err := l.resultReop.Create(db, result); err != nil {
+			return err
+		}
+		if info.DrawCost > 0 {
+			if err := l.walletService.chargeDraw(db, info.UserID, prize.ActivityId, requestID, info.DrawCost,
+				result.Id); err != nil {
+				return err
+			}
+		}
+		if prize.PrizeType == constant.PrizeTypeVirtualCoin && prize.Coins > 0 {
+			return l.walletService.creditPrize(db, result, prize.Coins)
+		}
+		return nil
+	})
+	if err != nil {
+		// 事务已经回滚，redis中扣减的奖品池和领取的优惠券需要补偿回去
+		if code != "" {
+			prize.CouponCode = ""
+		}
+		l.compensatePool(ctx, prize)
+		if errors.Is(err, errCouponUnavailable) {
+			log.InfoContextf(ctx, "lotteryService|AwardPrizeWithPool coupon unavailable prize_id=%d code=%s", prize.Id, code)
+			return false, nil
+		}
+		l.compensateCoupon(ctx, prize.Id, code)
+		if errors.Is(err, errPrizeNotEnough) {
+			return false, nil
+		}
+		if errors.Is(err, ErrInsufficientBalance) {
+			return false, fmt.Errorf("lotteryService|AwardPrizeWithPool:%w", err)
+		}
+		log.ErrorContextf(ctx, "lotteryService|AwardPrizeWithPool err:%v", err)
+		return false, fmt.Errorf("lotteryService|AwardPrizeWithPool:%v", err)
+	}
+	return true, nil
+}
+
+// compensatePool 回补奖品池中扣减的奖品，失败时只能记录日志，等待下一次奖品池填充时修正
+func (l *lotteryService) compensatePool(ctx context.Context, prize *LotteryPrize) {
+	if prize.PrizeNum <= 0 {
+		return
+	}
+	if _, err := l.prizeReop.IncrLeftNumByPool(prize.ActivityId, int(prize.Id), 1); err != nil {
+		log.ErrorContextf(ctx, "lotteryService|compensatePool activity_id=%d prize_id=%d err:%v",
+			prize.ActivityId, prize.Id, err)
+	}
+}
+
+// compensateCoupon 领取的优惠券编码放回缓存，失败时需要通过重置优惠券缓存修正
+func (l *lotteryService) compensateCoupon(ctx context.Context, prizeID uint, code string) {
+	if code == "" {
+		return
+	}
+	if _, err := l.couponReop.ImportCacheCoupon(prizeID, code); err != nil {
+		log.ErrorContextf(ctx, "lotteryService|compensateCoupon prize_id=%d code=%s err:%v", prizeID, code, err)
+	}
+}
+
 // GetAllUsefulPrizes 获取所有可用奖品
-func (l *lotteryService) GetAllUsefulPrizes(ctx context.Context) ([]*LotteryPrize, error) {
-	list, err := l.prizeReop.GetAllUsefulPrizeList(gormcli.GetDB())
+func (l *lotteryService) GetAllUsefulPrizes(ctx context.Context, activityID uint) ([]*LotteryPrize, error) {
+	list, err := l.prizeReop.GetAllUsefulPrizeList(gormcli.GetDB(), activityID)
 	if err != nil {
 		log.ErrorContextf(ctx, "lotteryService|GetAllUsefulPrizes:%v", err)
 		return nil, fmt.Errorf("lotteryService|GetAllUsefulPrizes:%v", err)
@@ -155,38 +375,12 @@ func (l *lotteryService) GetAllUsefulPrizes(ctx context.Context) ([]*LotteryPriz
 	if len(list) == 0 {
 		return nil, nil
 	}
-	lotteryPrizeList := make([]*LotteryPrize, 0)
-	for _, prize := range list {
-		codes := strings.Split(prize.PrizeCode, "-")
-		if len(codes) == 2 {
-			// 设置了获奖编码范围 a-b 才可以进行抽奖
-			codeA := codes[0]
-			codeB := codes[1]
-			low, err1 := strconv.Atoi(codeA)
-			high, err2 := strconv.Atoi(codeB)
-			if err1 == nil && err2 == nil && high >= low && low >= 0 && high < constant.PrizeCodeMax {
-				lotteryPrize := &LotteryPrize{
-					Id:            prize.Id,
-					Title:         prize.Title,
-					PrizeNum:      prize.PrizeNum,
-					LeftNum:       prize.LeftNum,
-					PrizeCodeLow:  low,
-					PrizeCodeHigh: high,
-					Img:           prize.Img,
-					DisplayOrder:  prize.DisplayOrder,
-					PrizeType:     prize.PrizeType,
-					PrizeProfile:  prize.PrizeProfile,
-				}
-				lotteryPrizeList = append(lotteryPrizeList, lotteryPrize)
-			}
-		}
-	}
-	return lotteryPrizeList, nil
+	return toLotteryPrizeList(ctx, list), nil
 }
 
-func (l *lotteryService) GetAllUsefulPrizesWithCache(ctx context.Context) ([]*LotteryPrize, error) {
+func (l *lotteryService) GetAllUsefulPrizesWithCache(ctx context.Context, activityID uint) ([]*LotteryPrize, error) {
 	// 筛选出符合条件的奖品列表
-	list, err := l.prizeReop.GetAllUsefulPrizeListWithCache(gormcli.GetDB())
+	list, err := l.prizeReop.GetAllUsefulPrizeListWithCache(gormcli.GetDB(), activityID)
 	if err != nil {
 		log.ErrorContextf(ctx, "lotteryService|GetAllUsefulPrizes:%v", err)
 		return nil, fmt.Errorf("lotteryService|GetAllUsefulPrizes:%v", err)
@@ -194,34 +388,236 @@ func (l *lotteryService) GetAllUsefulPrizesWithCache(ctx context.Context) ([]*Lo
 	if len(list) == 0 {
 		return nil, nil
 	}
-	// 对db的prize做一个类型转换，转化为LotteryPrize
+	return toLotteryPrizeList(ctx, list), nil
+}
+
+// toLotteryPrizeList 对db的prize做一个类型转换，转化为LotteryPrize，并计算每个奖品的中奖权重
+// 设置了中奖概率的奖品直接使用中奖概率，没有设置的按照旧版的中奖编码 a-b 折算成等价的权重
+// 相同编码的优惠券信息不合法或者已经过期时不参与抽奖，虚拟币的数量不合法时不参与抽奖
+func toLotteryPrizeList(ctx context.Context, list []*model.Prize) []*LotteryPrize {
+	weights := prizeWeights(ctx, list)
 	lotteryPrizeList := make([]*LotteryPrize, 0)
+	now := time.Now()
+	for _, prize := range list {
+		weight, ok := weights[prize.Id]
+		if !ok {
+			continue
+		}
+		var coupon *CouponProfile
+		if prize.PrizeType == constant.PrizeTypeCouponSame {
+			var err error
+			if coupon, err = parseCouponProfile(prize.PrizeProfile); err != nil || couponProfileExpired(coupon, now) {
+				log.InfoContextf(ctx, "lotteryService|toLotteryPrizeList coupon unavailable prize_id=%d err:%v", prize.Id, err)
+				continue
+			}
+		}
+		var coins int64
+		if prize.PrizeType == constant.PrizeTypeVirtualCoin {
+			var err error
+			if coins, err = parseCoinAmount(prize.PrizeProfile); err != nil {
+				log.InfoContextf(ctx, "lotteryService|toLotteryPrizeList coin amount invalid prize_id=%d err:%v", prize.Id, err)
+				continue
+			}
+		}
+		lotteryPrize := &LotteryPrize{
+			Id:           prize.Id,
+			ActivityId:   prize.ActivityId,
+			Title:        prize.Title,
+			PrizeNum:     prize.PrizeNum,
+			LeftNum:      prize.LeftNum,
+			Weight:       weight,
+			Img:          prize.Img,
+			DisplayOrder: prize.DisplayOrder,
+			PrizeType:    prize.PrizeType,
+			PrizeProfile: prize.PrizeProfile,
+			Coupon:       coupon,
+			Coins:        coins,
+		}
+		if coupon != nil {
+			lotteryPrize.CouponCode = coupon.Code
+		}
+		lotteryPrizeList = append(lotteryPrizeList, lotteryPrize)
+	}
+	return lotteryPrizeList
+}
+
+// prizeWeights 计算每个奖品的中奖权重，既没有中奖概率也没有合法中奖编码的奖品不参与抽奖
+// 中奖编码区间重叠时归属于排在前面的奖品，按照展示顺序和奖品ID排序，抽奖和后台校验得到相同的权重
+func prizeWeights(ctx context.Context, list []*model.Prize) map[uint]int64 {
+	weights := make(map[uint]int64, len(list))
+	ranges := make([]draw.CodeRange, 0)
+	rangePrizes := make([]*model.Prize, 0)
+	prizeCodeMax := configs.GetLotteryConfig().PrizeCodeMax
 	for _, prize := range list {
-		codes := strings.Split(prize.PrizeCode, "-")
-		if len(codes) == 2 {
-			// 设置了获奖编码范围 a-b 才可以进行抽奖
-			codeA := codes[0]
-			codeB := codes[1]
-			low, err1 := strconv.Atoi(codeA)
-			high, err2 := strconv.Atoi(codeB)
-			if err1 == nil && err2 == nil && high >= low && low >= 0 && high < constant.PrizeCodeMax {
-				lotteryPrize := &LotteryPrize{
-					Id:            prize.Id,
-					Title:         prize.Title,
-					PrizeNum:      prize.PrizeNum,
-					LeftNum:       prize.LeftNum,
-					PrizeCodeLow:  low,
-					PrizeCodeHigh: high,
-					Img:           prize.Img,
-					DisplayOrder:  prize.DisplayOrder,
-					PrizeType:     prize.PrizeType,
-					PrizeProfile:  prize.PrizeProfile,
-				}
-				lotteryPrizeList = append(lotteryPrizeList, lotteryPrize)
+		if prize.Probability > 0 {
+			weight, err := draw.ProbToWeight(prize.Probability)
+			if err != nil {
+				log.ErrorContextf(ctx, "lotteryService|prizeWeights prize_id=%d:%v", prize.Id, err)
+				continue
+			}
+			weights[prize.Id] = weight
+			continue
+		}
+		rangePrizes = append(rangePrizes, prize)
+	}
+	sort.SliceStable(rangePrizes, func(i, j int) bool {
+		return codeRangeLess(rangePrizes[i], rangePrizes[j])
+	})
+	for _, prize := range rangePrizes {
+		// 设置了获奖编码范围 a-b 才可以进行抽奖
+		if low, high, ok := draw.ParsePrizeCode(prize.PrizeCode, prizeCodeMax); ok {
+			ranges = append(ranges, draw.CodeRange{ID: prize.Id, Low: low, High: high})
+		}
+	}
+	if len(ranges) > 0 {
+		migrated := migrateCodeRanges(ranges, prizeCodeMax)
+		for _, r := range ranges {
+			weights[r.ID] = migrated[r.ID]
+		}
+	}
+	return weights
+}
+
+// codeRangeLess 中奖编码区间的先后顺序，展示顺序小的在前，相同时ID小的在前，还没有保存的奖品排在最后
+func codeRangeLess(a, b *model.Prize) bool {
+	if a.DisplayOrder != b.DisplayOrder {
+		return a.DisplayOrder < b.DisplayOrder
+	}
+	if a.Id == 0 || b.Id == 0 {
+		return b.Id == 0 && a.Id != 0
+	}
+	return a.Id < b.Id
+}
+
+// codeRangeCache 旧版中奖编码区间折算之后的权重，按照区间列表和编码空间缓存，奖品列表不变时不重复折算
+var codeRangeCache = struct {
+	sync.Mutex
+	weights map[string]map[uint]int64
+}{weights: make(map[string]map[uint]int64)}
+
+// migrateCodeRanges 带缓存的 draw.MigrateCodeRanges，返回的结果不能修改
+func migrateCodeRanges(ranges []draw.CodeRange, codeMax int) map[uint]int64 {
+	key := fmt.Sprintf("%d|%v", codeMax, ranges)
+	codeRangeCache.Lock()
+	defer codeRangeCache.Unlock()
+	if weights, ok := codeRangeCache.weights[key]; ok {
+		return weights
+	}
+	// 奖品修改之后旧的版本不会再用到，数量太多时全部清空
+	if len(codeRangeCache.weights) >= constant.CodeRangeCacheMax {
+		codeRangeCache.weights = make(map[string]map[uint]int64)
+	}
+	weights := draw.MigrateCodeRanges(ranges, codeMax)
+	codeRangeCache.weights[key] = weights
+	return weights
+}
+
+// pityDrawItems 按照保底规则调整之后的奖品列表，不需要调整或者没有可以调整的奖品时返回nil
+func pityDrawItems(lotteryPrizeList []*LotteryPrize, pity *PityDraw) []draw.Item {
+	switch pity.Mode {
+	case constant.PityModeGuarantee, constant.PityModeBundle:
+		// 指定的保底奖品没有库存时，从其他有库存的奖品中抽取
+		for _, lotteryPrize := range lotteryPrizeList {
+			if pity.PrizeID > 0 && lotteryPrize.Id == pity.PrizeID && hasStock(lotteryPrize) {
+				return []draw.Item{{ID: lotteryPrize.Id, Weight: draw.WeightTotal}}
+			}
+		}
+		return guaranteeDrawItems(lotteryPrizeList)
+	case constant.PityModeBoost:
+		return boostDrawItems(lotteryPrizeList, pity.BoostPercent)
+	}
+	return nil
+}
+
+// hasStock 奖品是否还有库存，不限量的奖品总是有库存
+func hasStock(lotteryPrize *LotteryPrize) bool {
+	return lotteryPrize.PrizeNum == 0 || (lotteryPrize.PrizeNum > 0 && lotteryPrize.LeftNum > 0)
+}
+
+// guaranteeDrawItems 必中的奖品列表，只保留有库存并且有中奖概率的奖品，权重按比例放大到总和为100%
+func guaranteeDrawItems(lotteryPrizeList []*LotteryPrize) []draw.Item {
+	var items []draw.Item
+	for _, lotteryPrize := range lotteryPrizeList {
+		if lotteryPrize.Weight <= 0 || !hasStock(lotteryPrize) {
+			continue
+		}
+		items = append(items, draw.Item{ID: lotteryPrize.Id, Weight: lotteryPrize.Weight})
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return scaleDrawItems(items)
+}
+
+// maxBoostPercent 提高权重的最大百分比，最小的权重提高之后也能达到100%
+const maxBoostPercent = draw.WeightTotal * 100
+
+// boostDrawItems 有库存的奖品权重提高percent%，总和超过100%时按比例缩小到100%，此时必中
+func boostDrawItems(lotteryPrizeList []*LotteryPrize, percent int64) []draw.Item {
+	if percent <= 0 {
+		return nil
+	}
+	var (
+		items   []draw.Item
+		total   int64
+		boosted bool
+	)
+	for _, lotteryPrize := range lotteryPrizeList {
+		weight := lotteryPrize.Weight
+		if weight > 0 && hasStock(lotteryPrize) {
+			// 单个奖品提高之后最多为100%，提高的比例足够让最小的权重达到100%即可，避免溢出
+			if percent > maxBoostPercent {
+				percent = maxBoostPercent
+			}
+			weight = weight + weight*percent/100
+			if weight > draw.WeightTotal {
+				weight = draw.WeightTotal
 			}
+			boosted = true
+		}
+		items = append(items, draw.Item{ID: lotteryPrize.Id, Weight: weight})
+		total += weight
+	}
+	if !boosted {
+		return nil
+	}
+	if total > draw.WeightTotal {
+		return scaleDrawItems(items)
+	}
+	return items
+}
+
+// scaleDrawItems 权重按比例缩放到总和为100%，整除剩下的权重给第一个有权重的奖品
+func scaleDrawItems(items []draw.Item) []draw.Item {
+	var total, scaled int64
+	for _, item := range items {
+		total += item.Weight
+	}
+	if total <= 0 {
+		return nil
+	}
+	first := -1
+	for i := range items {
+		items[i].Weight = items[i].Weight * draw.WeightTotal / total
+		scaled += items[i].Weight
+		if first < 0 && items[i].Weight > 0 {
+			first = i
 		}
 	}
-	return lotteryPrizeList, nil
+	if first < 0 {
+		first = 0
+	}
+	items[first].Weight += draw.WeightTotal - scaled
+	return items
+}
+
+// toDrawItems 转换成抽奖引擎需要的奖品列表
+func toDrawItems(lotteryPrizeList []*LotteryPrize) []draw.Item {
+	items := make([]draw.Item, 0, len(lotteryPrizeList))
+	for _, lotteryPrize := range lotteryPrizeList {
+		items = append(items, draw.Item{ID: lotteryPrize.Id, Weight: lotteryPrize.Weight})
+	}
+	return items
 }
 
 // PrizeCouponDiff 发放不同编码的优惠券
@@ -249,7 +645,7 @@ func (l *lotteryService) PrizeCouponDiff(ctx context.Context, prizeID int) (stri
 		return "", nil
 	}
 	// 更新
-	coupon.SysStatus = 2
+	coupon.SysStatus = constant.CouponStatusIssued
 	if err := l.couponReop.Update(db, coupon, "sys_status"); err != nil {
 		log.ErrorContextf(ctx, "lotteryService|PrizeCouponDiff:%v\n", err)
 		return "", err
@@ -267,72 +663,58 @@ func (l *lotteryService) PrizeCouponDiffWithCache(ctx context.Context, prizeID i
 		log.InfoContextf(ctx, "lotteryService|PrizeCouponDiffByCache code is nil with prize_id=%d", prizeID)
 		return "", nil
 	}
-	coupon := model.Coupon{
-		Code:      code,
-		SysStatus: 2,
-	}
-	if err = l.couponReop.UpdateByCode(gormcli.GetDB(), code, &coupon, "sys_status"); err != nil {
+	ok, err := l.couponReop.IssueByCode(gormcli.GetDB(), code, time.Now())
+	if err != nil {
 		return "", fmt.Errorf("lotteryService|PrizeCouponDiffByCache:%v", err)
 	}
+	// 缓存中的优惠券已经作废或者过期，不能发放
+	if !ok {
+		log.InfoContextf(ctx, "lotteryService|PrizeCouponDiffByCache coupon unavailable prize_id=%d code=%s", prizeID, code)
+		return "", nil
+	}
 	return code, nil
 }
 
-func (l *lotteryService) PrizeLargeBlackLimit(ctx context.Context, blackUser *model.BlackUser,
-	blackIp *model.BlackIp, lotteryUserInfo *LotteryUserInfo) error {
-	now := time.Now()
-	blackTime := constant.DefaultBlackTime
-	// 用户黑明单限制
-	if blackUser == nil || blackUser.UserId <= 0 {
-		blackUserInfo := &model.BlackUser{
-			Id:        lotteryUserInfo.UserID,
-			UserName:  lotteryUserInfo.UserName,
-			BlackTime: now.Add(time.Second * time.Duration(blackTime)),
-			//SysCreated: time.Time{},
-			//SysUpdated: time.Time{},
-			SysIp: lotteryUserInfo.IP,
-		}
-		if err := l.blackUserRepo.Create(gormcli.GetDB(), blackUserInfo); err != nil {
-			log.ErrorContextf(ctx, "lotteryService|PrizeLargeBlackLimit:%v", err)
-			return fmt.Errorf("lotteryService|PrizeLargeBlackLimit:%v", err)
-		}
-	} else {
-		blackUserInfo := &model.BlackUser{
-			UserId:    lotteryUserInfo.UserID,
-			BlackTime: now.Add(time.Second * time.Duration(blackTime)),
-		}
-		if err := l.blackUserRepo.Update(gormcli.GetDB(), lotteryUserInfo.UserID, blackUserInfo, "black_time"); err != nil {
-			log.ErrorContextf(ctx, "lotteryService|PrizeLargeBlackLimit:%v", err)
-			return fmt.Errorf("lotteryService|PrizeLargeBlackLimit:%v", err)
-		}
+// PrizeLargeBlackLimit 拉黑中了大奖或者作弊的用户和IP，到期时间只会延长，不会缩短之前后台设置的更长的拉黑
+func (l *lotteryService) PrizeLargeBlackLimit(ctx context.Context, lotteryUserInfo *LotteryUserInfo,
+	blackTime int) error {
+	blackUntil := time.Now().Add(time.Second * time.Duration(blackTime))
+	if err := l.extendBlackLimit(gormcli.GetDB(), lotteryUserInfo, blackUntil); err != nil {
+		log.ErrorContextf(ctx, "lotteryService|PrizeLargeBlackLimit:%v", err)
+		return fmt.Errorf("lotteryService|PrizeLargeBlackLimit:%v", err)
 	}
-	// ip黑明但限制
-	if blackIp == nil || blackIp.Ip == "" {
-		blackIPInfo := &model.BlackIp{
-			Ip:        lotteryUserInfo.IP,
-			BlackTime: now.Add(time.Second * time.Duration(blackTime)),
-			//SysCreated: time.Time{},
-			//SysUpdated: time.Time{},
-		}
-		if err := l.blackIpRepo.Create(gormcli.GetDB(), blackIPInfo); err != nil {
-			log.ErrorContextf(ctx, "lotteryService|PrizeLargeBlackLimit:%v", err)
-			return fmt.Errorf("lotteryService|PrizeLargeBlackLimit:%v", err)
-		}
-	} else {
-		blackIPInfo := &model.BlackIp{
-			Ip:        lotteryUserInfo.IP,
-			BlackTime: now.Add(time.Second * time.Duration(blackTime)),
-			//SysUpdated: time.Time{},
-		}
-		if err := l.blackIpRepo.Update(gormcli.GetDB(), lotteryUserInfo.IP, blackIPInfo, "black_time"); err != nil {
-			log.ErrorContextf(ctx, "lotteryService|PrizeLargeBlackLimit:%v", err)
-			return fmt.Errorf("lotteryService|PrizeLargeBlackLimit:%v", err)
-		}
+	// 缓存中可能还有已经过期的记录，不删除时抽奖会继续按照缓存放行
+	if err := l.blackUserRepo.UpdateByCache(&model.BlackUser{UserId: lotteryUserInfo.UserID}); err != nil {
+		log.ErrorContextf(ctx, "lotteryService|PrizeLargeBlackLimit:%v", err)
+		return fmt.Errorf("lotteryService|PrizeLargeBlackLimit:%v", err)
+	}
+	if lotteryUserInfo.IP == "" {
+		return nil
+	}
+	if err := l.blackIpRepo.UpdateByCache(&model.BlackIp{Ip: lotteryUserInfo.IP}); err != nil {
+		log.ErrorContextf(ctx, "lotteryService|PrizeLargeBlackLimit:%v", err)
+		return fmt.Errorf("lotteryService|PrizeLargeBlackLimit:%v", err)
 	}
 	return nil
 }
 
-func (l *lotteryService) GetPrizeNumWithPool(ctx context.Context, prizeID uint) (int, error) {
-	num, err := l.prizeReop.GetPrizePoolNum(prizeID)
+// extendBlackLimit 把用户和IP的黑名单到期时间延长到blackUntil，没有记录时新建，没有IP时只拉黑用户。
+// 命中的是网段黑名单时单独拉黑这个IP
+func (l *lotteryService) extendBlackLimit(db *gorm.DB, lotteryUserInfo *LotteryUserInfo, blackUntil time.Time) error {
+	err := l.blackUserRepo.Extend(db, &model.BlackUser{
+		UserId:    lotteryUserInfo.UserID,
+		UserName:  lotteryUserInfo.UserName,
+		BlackTime: blackUntil,
+		SysIp:     lotteryUserInfo.IP,
+	})
+	if err != nil || lotteryUserInfo.IP == "" {
+		return err
+	}
+	return l.blackIpRepo.Extend(db, &model.BlackIp{Ip: lotteryUserInfo.IP, BlackTime: blackUntil})
+}
+
+func (l *lotteryService) GetPrizeNumWithPool(ctx context.Context, activityID uint, prizeID uint) (int, error) {
+	num, err := l.prizeReop.GetPrizePoolNum(activityID, prizeID)
 	if err != nil {
 		log.ErrorContextf(ctx, "lotteryService|GetPrizeNumWithPool err: %v", err)
 		return 0, fmt.Errorf("lotteryService|GetPrizeNumWithPool:%v", err)
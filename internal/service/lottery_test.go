@@ -0,0 +1,175 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/draw"
+	"lottery_single/internal/repo"
+)
+
+func TestGuaranteeDrawItems(t *testing.T) {
+	list := []*LotteryPrize{
+		{Id: 1, PrizeNum: 0, Weight: 100},                // 不限量
+		{Id: 2, PrizeNum: 10, LeftNum: 0, Weight: 1000},  // 没有库存
+		{Id: 3, PrizeNum: 10, LeftNum: 5, Weight: 200},   // 有库存
+		{Id: 4, PrizeNum: 10, LeftNum: 5, Weight: 0},     // 没有中奖概率
+		{Id: 5, PrizeNum: -1, LeftNum: 5, Weight: 10000}, // 无效的数量
+	}
+	items := guaranteeDrawItems(list)
+	assert.Len(t, items, 2)
+	assert.Equal(t, uint(1), items[0].ID)
+	assert.Equal(t, uint(3), items[1].ID)
+	assert.Equal(t, draw.WeightTotal, items[0].Weight+items[1].Weight)
+	assert.Equal(t, draw.WeightTotal*2/3, items[1].Weight)
+
+	// 保底的奖品列表一定会中奖
+	engine, err := draw.NewAliasEngine(items)
+	assert.Nil(t, err)
+	for code := int64(0); code < engine.Space(); code += engine.Space() / 1000 {
+		_, ok := engine.Pick(code)
+		assert.True(t, ok)
+	}
+
+	assert.Nil(t, guaranteeDrawItems([]*LotteryPrize{{Id: 2, PrizeNum: 10, Weight: 1000}}))
+}
+
+func TestBoostDrawItems(t *testing.T) {
+	list := []*LotteryPrize{
+		{Id: 1, PrizeNum: 0, Weight: 1000},
+		{Id: 2, PrizeNum: 10, LeftNum: 0, Weight: 1000},
+	}
+	items := boostDrawItems(list, 50)
+	assert.Len(t, items, 2)
+	assert.Equal(t, int64(1500), items[0].Weight)
+	// 没有库存的奖品不提高
+	assert.Equal(t, int64(1000), items[1].Weight)
+	assert.Nil(t, boostDrawItems(list, 0))
+
+	// 提高之后超过100%时缩小到100%
+	items = boostDrawItems([]*LotteryPrize{{Id: 1, Weight: draw.WeightTotal / 2}, {Id: 3, Weight: draw.WeightTotal / 4}}, 100)
+	assert.Equal(t, draw.WeightTotal, items[0].Weight+items[1].Weight)
+	assert.Equal(t, draw.WeightTotal/3, items[1].Weight)
+
+	// 提高的比例很大时不会溢出
+	items = boostDrawItems([]*LotteryPrize{{Id: 1, Weight: 1}}, 1<<62)
+	assert.Equal(t, draw.WeightTotal, items[0].Weight)
+}
+
+func TestPityDrawItems(t *testing.T) {
+	list := []*LotteryPrize{
+		{Id: 1, PrizeNum: 0, Weight: 100},
+		{Id: 2, PrizeNum: 10, LeftNum: 3, Weight: 100},
+		{Id: 3, PrizeNum: 10, LeftNum: 0, Weight: 100},
+	}
+	assert.Nil(t, pityDrawItems(list, &PityDraw{Mode: constant.PityModeNone}))
+
+	items := pityDrawItems(list, &PityDraw{Mode: constant.PityModeGuarantee, PrizeID: 2})
+	assert.Equal(t, []draw.Item{{ID: 2, Weight: draw.WeightTotal}}, items)
+
+	// 指定的奖品没有库存时从其他有库存的奖品中抽取
+	items = pityDrawItems(list, &PityDraw{Mode: constant.PityModeGuarantee, PrizeID: 3})
+	assert.Len(t, items, 2)
+	items = pityDrawItems(list, &PityDraw{Mode: constant.PityModeBundle})
+	assert.Len(t, items, 2)
+}
+
+func TestCodeRangeOrder(t *testing.T) {
+	// 新增奖品校验时还没有ID，保存之后ID最大，两次排序的结果要一致
+	unsaved := []*model.Prize{
+		{Id: 0, DisplayOrder: 1, PrizeCode: "0-4999"},
+		{Id: 7, DisplayOrder: 1, PrizeCode: "4000-8999"},
+		{Id: 3, DisplayOrder: 2, PrizeCode: "0-99"},
+		{Id: 9, DisplayOrder: 0, PrizeCode: "100-200"},
+	}
+	sort.SliceStable(unsaved, func(i, j int) bool { return codeRangeLess(unsaved[i], unsaved[j]) })
+	saved := []*model.Prize{
+		{Id: 3, DisplayOrder: 2, PrizeCode: "0-99"},
+		{Id: 12, DisplayOrder: 1, PrizeCode: "0-4999"},
+		{Id: 9, DisplayOrder: 0, PrizeCode: "100-200"},
+		{Id: 7, DisplayOrder: 1, PrizeCode: "4000-8999"},
+	}
+	sort.SliceStable(saved, func(i, j int) bool { return codeRangeLess(saved[i], saved[j]) })
+	for i := range saved {
+		assert.Equal(t, saved[i].PrizeCode, unsaved[i].PrizeCode)
+	}
+	assert.Equal(t, []uint{9, 7, 0, 3}, []uint{unsaved[0].Id, unsaved[1].Id, unsaved[2].Id, unsaved[3].Id})
+}
+
+func TestMigrateCodeRangesCached(t *testing.T) {
+	ranges := []draw.CodeRange{{ID: 1, Low: 0, High: 4999}, {ID: 2, Low: 4000, High: 8999}}
+	weights := migrateCodeRanges(ranges, 10000)
+	assert.Equal(t, map[uint]int64{1: 5000000, 2: 4000000}, weights)
+	// 相同的区间列表直接返回缓存的结果
+	again := migrateCodeRanges([]draw.CodeRange{{ID: 1, Low: 0, High: 4999}, {ID: 2, Low: 4000, High: 8999}}, 10000)
+	assert.Equal(t, fmt.Sprintf("%p", weights), fmt.Sprintf("%p", again))
+}
+
+func newTestBlackDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		`create table t_black_user (id integer primary key autoincrement, user_id integer not null default 0,
+			user_name text not null default '', black_time datetime, real_name text not null default '',
+			mobile text not null default '', address text not null default '', sys_created datetime,
+			sys_updated datetime, sys_ip text not null default '', reason text not null default '',
+			operator text not null default '')`,
+		`create unique index uk_user_id on t_black_user(user_id)`,
+		`create table t_black_ip (id integer primary key autoincrement, ip text not null default '',
+			black_time datetime, sys_created datetime, sys_updated datetime, reason text not null default '',
+			operator text not null default '')`,
+		`create unique index uk_ip on t_black_ip(ip)`,
+	} {
+		assert.Nil(t, db.Exec(stmt).Error)
+	}
+	return db
+}
+
+func TestExtendBlackLimit(t *testing.T) {
+	db := newTestBlackDB(t)
+	l := &lotteryService{blackUserRepo: repo.NewBlackUserRepo(), blackIpRepo: repo.NewBlackIpRepo()}
+	now := time.Now().Truncate(time.Second)
+	week, year := now.Add(7*24*time.Hour), now.Add(365*24*time.Hour)
+	info := &LotteryUserInfo{UserID: 1, UserName: "u1", IP: "10.0.0.1"}
+
+	// 没有记录时新建
+	assert.Nil(t, l.extendBlackLimit(db, info, week))
+	blackUser, err := l.blackUserRepo.GetByUserID(db, 1)
+	assert.Nil(t, err)
+	assert.True(t, week.Equal(blackUser.BlackTime))
+	blackIP, err := l.blackIpRepo.GetByIP(db, "10.0.0.1")
+	assert.Nil(t, err)
+	assert.True(t, week.Equal(blackIP.BlackTime))
+
+	// 更长的拉黑时延长
+	assert.Nil(t, l.extendBlackLimit(db, info, year))
+	blackUser, _ = l.blackUserRepo.GetByUserID(db, 1)
+	assert.True(t, year.Equal(blackUser.BlackTime))
+	blackIP, _ = l.blackIpRepo.GetByIP(db, "10.0.0.1")
+	assert.True(t, year.Equal(blackIP.BlackTime))
+
+	// 已经有更长的拉黑时不会缩短
+	assert.Nil(t, l.extendBlackLimit(db, info, week))
+	blackUser, _ = l.blackUserRepo.GetByUserID(db, 1)
+	assert.True(t, year.Equal(blackUser.BlackTime))
+	blackIP, _ = l.blackIpRepo.GetByIP(db, "10.0.0.1")
+	assert.True(t, year.Equal(blackIP.BlackTime))
+
+	// 没有IP时只拉黑用户
+	assert.Nil(t, l.extendBlackLimit(db, &LotteryUserInfo{UserID: 2}, week))
+	var num int64
+	assert.Nil(t, db.Model(&model.BlackIp{}).Count(&num).Error)
+	assert.Equal(t, int64(1), num)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/repo"
+)
+
+// PityService 连续没有中奖的保底规则，计数保存在redis中，同时写入数据库，redis中丢失时从数据库恢复
+// 调用方需要持有用户抽奖锁，同一个用户的计数不会并发修改
+type PityService interface {
+	GetPityDraw(ctx context.Context, activity *model.Activity, uid uint) (*PityDraw, error)
+	RecordOutcome(ctx context.Context, activity *model.Activity, uid uint, pity *PityDraw, code constant.ErrCode)
+}
+
+type pityService struct {
+	pityRepo *repo.PityRepo
+}
+
+var pityServiceImpl *pityService
+
+func InitPityService() {
+	pityServiceImpl = &pityService{
+		pityRepo: repo.NewPityRepo(),
+	}
+}
+
+func GetPityService() PityService {
+	return pityServiceImpl
+}
+
+// hasPityRule 活动是否配置了保底规则
+func hasPityRule(activity *model.Activity) bool {
+	return activity.PityGuaranteeAfter > 0 || (activity.PityBoostAfter > 0 && activity.PityBoostStep > 0)
+}
+
+// newPityDraw 根据活动的保底规则和连续没有中奖的次数，计算本次抽奖的调整，必中优先于提高权重
+func newPityDraw(activity *model.Activity, losses int64) *PityDraw {
+	pity := &PityDraw{Losses: losses, Mode: constant.PityModeNone}
+	if activity.PityGuaranteeAfter > 0 && losses >= int64(activity.PityGuaranteeAfter) {
+		pity.Mode = constant.PityModeGuarantee
+		pity.PrizeID = activity.PityPrizeId
+		return pity
+	}
+	if activity.PityBoostAfter > 0 && activity.PityBoostStep > 0 && losses >= int64(activity.PityBoostAfter) {
+		pity.Mode = constant.PityModeBoost
+		pity.BoostPercent = (losses - int64(activity.PityBoostAfter) + 1) * int64(activity.PityBoostStep)
+	}
+	return pity
+}
+
+// GetPityDraw 获取用户连续没有中奖的次数，计算本次抽奖的调整，活动没有保底规则时不调整
+func (p *pityService) GetPityDraw(ctx context.Context, activity *model.Activity, uid uint) (*PityDraw, error) {
+	if !hasPityRule(activity) {
+		return &PityDraw{Mode: constant.PityModeNone}, nil
+	}
+	losses, ok, err := p.pityRepo.GetByCache(activity.Id, uid)
+	if err != nil {
+		log.ErrorContextf(ctx, "pityService|GetPityDraw:%v", err)
+		return nil, fmt.Errorf("pityService|GetPityDraw:%v", err)
+	}
+	if !ok {
+		counter, err := p.pityRepo.Get(gormcli.GetDB(), activity.Id, uid)
+		if err != nil {
+			log.ErrorContextf(ctx, "pityService|GetPityDraw:%v", err)
+			return nil, fmt.Errorf("pityService|GetPityDraw:%v", err)
+		}
+		if counter != nil {
+			losses = counter.Losses
+		}
+		if err = p.pityRepo.SetByCache(activity.Id, uid, losses); err != nil {
+			log.ErrorContextf(ctx, "pityService|GetPityDraw:%v", err)
+		}
+	}
+	return newPityDraw(activity, losses), nil
+}
+
+// pityLossesAfter 本次抽奖之后连续没有中奖的次数，被拒绝的请求不计数，返回false
+func pityLossesAfter(losses int64, code constant.ErrCode) (int64, bool) {
+	switch code {
+	case constant.Success:
+		return 0, true
+	case constant.ErrNotWon, constant.ErrPrizeNotEnough:
+		return losses + 1, true
+	}
+	return losses, false
+}
+
+// RecordOutcome 按照抽奖结果更新连续没有中奖的次数，中奖时清零，失败只记录日志，不影响抽奖结果
+func (p *pityService) RecordOutcome(ctx context.Context, activity *model.Activity, uid uint, pity *PityDraw,
+	code constant.ErrCode) {
+	if pity == nil || !hasPityRule(activity) {
+		return
+	}
+	losses, ok := pityLossesAfter(pity.Losses, code)
+	if !ok || losses == pity.Losses {
+		return
+	}
+	if err := p.pityRepo.Save(gormcli.GetDB(), activity.Id, uid, losses); err != nil {
+		log.ErrorContextf(ctx, "pityService|RecordOutcome:%v", err)
+		return
+	}
+	if err := p.pityRepo.SetByCache(activity.Id, uid, losses); err != nil {
+		log.ErrorContextf(ctx, "pityService|RecordOutcome:%v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+)
+
+func TestNewPityDraw(t *testing.T) {
+	activity := &model.Activity{PityBoostAfter: 30, PityBoostStep: 10, PityGuaranteeAfter: 50, PityPrizeId: 7}
+	assert.True(t, hasPityRule(activity))
+	assert.False(t, hasPityRule(&model.Activity{PityBoostAfter: 30}))
+
+	pity := newPityDraw(activity, 29)
+	assert.Equal(t, constant.PityModeNone, pity.Mode)
+	assert.Equal(t, int64(29), pity.Losses)
+
+	pity = newPityDraw(activity, 30)
+	assert.Equal(t, constant.PityModeBoost, pity.Mode)
+	assert.Equal(t, int64(10), pity.BoostPercent)
+	pity = newPityDraw(activity, 49)
+	assert.Equal(t, int64(200), pity.BoostPercent)
+
+	pity = newPityDraw(activity, 50)
+	assert.Equal(t, constant.PityModeGuarantee, pity.Mode)
+	assert.Equal(t, uint(7), pity.PrizeID)
+}
+
+func TestPityLossesAfter(t *testing.T) {
+	losses, ok := pityLossesAfter(5, constant.Success)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), losses)
+	losses, ok = pityLossesAfter(5, constant.ErrNotWon)
+	assert.True(t, ok)
+	assert.Equal(t, int64(6), losses)
+	losses, ok = pityLossesAfter(5, constant.ErrPrizeNotEnough)
+	assert.True(t, ok)
+	assert.Equal(t, int64(6), losses)
+	for _, code := range []constant.ErrCode{constant.ErrUserLimitInvalid, constant.ErrBlackedUser,
+		constant.ErrBalanceNotEnough} {
+		_, ok = pityLossesAfter(5, code)
+		assert.False(t, ok)
+	}
+}
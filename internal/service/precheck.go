@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"lottery_single/configs"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/cache"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/utils"
+	"strings"
+	"time"
+)
+
+// PreDrawCheck 抽奖前置检查的结果
+type PreDrawCheck struct {
+	Code          constant.ErrCode // 检查不通过时的错误码，通过时为Success
+	PoolDecreased bool             // 奖品池是否已经扣减，只有检查通过时才会为true
+	BlackIp       *model.BlackIp   // 从数据库中查到的IP黑名单信息，脚本检查时可能为空
+	BlackUser     *model.BlackUser // 从数据库中查到的用户黑名单信息，脚本检查时可能为空
+}
+
+// 前置检查脚本的结论
+const (
+	preDrawPass      = 0
+	preDrawUserLimit = 1
+	preDrawIpLimit   = 2
+	preDrawBlackIp   = 3
+	preDrawBlackUser = 4
+	preDrawPoolEmpty = 5
+)
+
+// preDrawResult 前置检查脚本的返回值
+type preDrawResult struct {
+	verdict       int64
+	userNum       int64 // 用户今天的抽奖次数
+	ipNum         int64 // IP今天的抽奖次数
+	blackIpMiss   bool  // 缓存中没有IP黑名单信息，需要从数据库确认
+	blackUserMiss bool  // 缓存中没有用户黑名单信息，需要从数据库确认
+}
+
+// LuaPreDrawCheck 用户次数、IP次数、IP黑名单、用户黑名单检查和奖品池扣减，一次请求完成
+// KEYS: 用户次数key，IP次数key，IP黑名单key，用户黑名单key，奖品池key
+// ARGV: 用户ID，IP，用户每天次数限制，IP每天次数限制，当前时间，是否检查黑名单，奖品ID(0表示不扣减奖品池)
+// 返回: {结论，用户次数，IP次数，IP黑名单缓存是否缺失，用户黑名单缓存是否缺失}
+const LuaPreDrawCheck = `
+  local userNum = redis.call('hincrby', KEYS[1], ARGV[1], 1)
+  if userNum > tonumber(ARGV[3]) then
+    return {1, userNum, 0, 0, 0}
+  end
+  local ipNum = redis.call('hincrby', KEYS[2], ARGV[2], 1)
+  if ipNum > tonumber(ARGV[4]) then
+    return {2, userNum, ipNum, 0, 0}
+  end
+  local ipMiss, userMiss = 0, 0
+  if ARGV[6] == '1' then
+    local ipBlackTime = redis.call('hget', KEYS[3], 'BlackTime')
+    if not ipBlackTime then
+      ipMiss = 1
+    elseif ipBlackTime > ARGV[5] then
+      return {3, userNum, ipNum, 0, 0}
+    end
+    local userBlackTime = redis.call('hget', KEYS[4], 'BlackTime')
+    if not userBlackTime then
+      userMiss = 1
+    elseif userBlackTime > ARGV[5] then
+      return {4, userNum, ipNum, ipMiss, 0}
+    end
+  end
+  if ARGV[7] ~= '0' then
+    local left = redis.call('hincrby', KEYS[5], ARGV[7], -1)
+    if left < 0 then
+      redis.call('hincrby', KEYS[5], ARGV[7], 1)
+      return {5, userNum, ipNum, ipMiss, userMiss}
+    end
+  end
+  return {0, userNum, ipNum, ipMiss, userMiss}
+`
+
+// CheckBeforeDraw 抽奖前置检查，次数限制、黑名单和奖品池扣减通过一个lua脚本完成
+// prize为空或者奖品不限量时不扣减奖品池，脚本不可用时退回到逐个检查，此时不扣减奖品池
+func (l *limitService) CheckBeforeDraw(ctx context.Context, activity *model.Activity, uid uint, ip string,
+	prize *LotteryPrize) (*PreDrawCheck, error) {
+	ip = utils.NormalizeIP(ip)
+	if l.scriptUnavailable.Load() {
+		return l.checkBeforeDrawByStep(ctx, activity, uid, ip)
+	}
+	var prizeID uint
+	if prize != nil && prize.PrizeNum > 0 {
+		prizeID = prize.Id
+	}
+	result, err := l.evalPreDrawScript(ctx, activity, uid, ip, prizeID)
+	// 只有redis不支持脚本时才退回到逐个检查，超时等错误时脚本可能已经执行，不能再计一次次数
+	if err != nil && isScriptUnavailable(err) {
+		l.scriptUnavailable.Store(true)
+		log.ErrorContextf(ctx, "limitService|CheckBeforeDraw script unavailable, check by step:%v", err)
+		return l.checkBeforeDrawByStep(ctx, activity, uid, ip)
+	}
+	if err != nil {
+		log.ErrorContextf(ctx, "limitService|CheckBeforeDraw:%v", err)
+		return nil, fmt.Errorf("limitService|CheckBeforeDraw:%v", err)
+	}
+
+	check := &PreDrawCheck{Code: constant.Success}
+	switch result.verdict {
+	case preDrawUserLimit:
+		check.Code = constant.ErrUserLimitInvalid
+	case preDrawIpLimit:
+		check.Code = constant.ErrIPLimitInvalid
+	case preDrawBlackIp:
+		check.Code = constant.ErrBlackedIP
+	case preDrawBlackUser:
+		check.Code = constant.ErrBlackedUser
+	case preDrawPoolEmpty:
+		check.Code = constant.ErrNotWon
+	}
+	if check.Code != constant.Success {
+		return check, nil
+	}
+
+	// 脚本检查通过，数据库中的次数和缓存缺失的黑名单还要再确认一次，不通过时回补奖品池
+	if err = l.checkAfterScript(ctx, activity, uid, ip, result, check); err != nil || check.Code != constant.Success {
+		if prizeID > 0 {
+			if _, e := l.prizeRepo.IncrLeftNumByPool(activity.Id, int(prizeID), 1); e != nil {
+				log.ErrorContextf(ctx, "limitService|CheckBeforeDraw|IncrLeftNumByPool prize_id=%d:%v", prizeID, e)
+			}
+		}
+		return check, err
+	}
+	check.PoolDecreased = prizeID > 0
+	return check, nil
+}
+
+// evalPreDrawScript 执行前置检查脚本
+func (l *limitService) evalPreDrawScript(ctx context.Context, activity *model.Activity, uid uint, ip string,
+	prizeID uint) (*preDrawResult, error) {
+	lotteryConf := configs.GetLotteryConfig()
+	keys := []string{
+		fmt.Sprintf(constant.UserLotteryDayNumPrefix+"%d_%d", activity.Id, uid%uint(lotteryConf.UserFrameSize)),
+		fmt.Sprintf(constant.IpLotteryDayNumPrefix+"%d_%d", activity.Id, utils.IPShard(ip, lotteryConf.IpFrameSize)),
+		fmt.Sprintf(constant.IpCacheKeyPrefix+"%s", ip),
+		fmt.Sprintf(constant.UserCacheKeyPrefix+"%d", uid),
+		fmt.Sprintf(constant.PrizePoolCacheKeyPrefix+"%d", activity.Id),
+	}
+	checkBlack := 0
+	if activity.BlackPolicy != constant.BlackPolicyNone {
+		checkBlack = 1
+	}
+	// 黑名单缓存中的时间格式是 SysTimeFormat，同样格式的时间可以直接按字符串比较
+	now := utils.FormatFromUnixTime(time.Now().Unix())
+	ret, err := cache.GetRedisCli().EvalResults(ctx, LuaPreDrawCheck, keys,
+		fmt.Sprint(uid), utils.IPLimitKey(ip), activity.UserDayLimit, activity.IpDayLimit, now, checkBlack, prizeID)
+	if err != nil {
+		return nil, fmt.Errorf("limitService|evalPreDrawScript:%v", err)
+	}
+	return parsePreDrawResult(ret)
+}
+
+// checkAfterScript 脚本检查通过之后，在数据库中确认用户次数和缓存中缺失的黑名单信息
+func (l *limitService) checkAfterScript(ctx context.Context, activity *model.Activity, uid uint, ip string,
+	result *preDrawResult, check *PreDrawCheck) error {
+	ok, err := l.checkUserDayLotteryTimesByDB(ctx, activity, uid, result.userNum)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		check.Code = constant.ErrUserLimitInvalid
+		return nil
+	}
+	// 缓存中没有单个IP的黑名单时从数据库确认，同时检查网段黑名单，缓存中的单个IP没有命中时只检查网段黑名单
+	if result.blackIpMiss {
+		ok, check.BlackIp, err = l.CheckBlackIPWithCache(ctx, ip)
+	} else if activity.BlackPolicy != constant.BlackPolicyNone {
+		var rangeInfo *model.BlackIp
+		if ok, rangeInfo, err = l.checkBlackIpRange(ctx, ip); !ok {
+			check.BlackIp = rangeInfo
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		check.Code = constant.ErrBlackedIP
+		return nil
+	}
+	if result.blackUserMiss {
+		ok, check.BlackUser, err = l.CheckBlackUserWithCache(ctx, uid)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			check.Code = constant.ErrBlackedUser
+			return nil
+		}
+	}
+	return nil
+}
+
+// checkBeforeDrawByStep 逐个检查次数限制和黑名单，不扣减奖品池
+func (l *limitService) checkBeforeDrawByStep(ctx context.Context, activity *model.Activity, uid uint,
+	ip string) (*PreDrawCheck, error) {
+	check := &PreDrawCheck{Code: constant.Success}
+	ok, err := l.CheckUserDayLotteryTimesWithCache(ctx, activity, uid)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		check.Code = constant.ErrUserLimitInvalid
+		return check, nil
+	}
+	if l.CheckIPLimit(ctx, activity.Id, ip) > int64(activity.IpDayLimit) {
+		check.Code = constant.ErrIPLimitInvalid
+		return check, nil
+	}
+	// 活动的黑名单策略为不校验时，跳过黑名单验证
+	if activity.BlackPolicy == constant.BlackPolicyNone {
+		return check, nil
+	}
+	ok, check.BlackIp, err = l.CheckBlackIPWithCache(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		check.Code = constant.ErrBlackedIP
+		return check, nil
+	}
+	ok, check.BlackUser, err = l.CheckBlackUserWithCache(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		check.Code = constant.ErrBlackedUser
+	}
+	return check, nil
+}
+
+// parsePreDrawResult 解析前置检查脚本的返回值
+func parsePreDrawResult(ret []interface{}) (*preDrawResult, error) {
+	if len(ret) != 5 {
+		return nil, fmt.Errorf("parsePreDrawResult invalid result length %d", len(ret))
+	}
+	values := make([]int64, len(ret))
+	for i, v := range ret {
+		n, ok := v.(int64)
+		if !ok {
+			return nil, fmt.Errorf("parsePreDrawResult invalid result %v", v)
+		}
+		values[i] = n
+	}
+	if values[0] < preDrawPass || values[0] > preDrawPoolEmpty {
+		return nil, fmt.Errorf("parsePreDrawResult invalid verdict %d", values[0])
+	}
+	return &preDrawResult{
+		verdict:       values[0],
+		userNum:       values[1],
+		ipNum:         values[2],
+		blackIpMiss:   values[3] == 1,
+		blackUserMiss: values[4] == 1,
+	}, nil
+}
+
+// isScriptUnavailable redis不支持或者禁用了脚本，之后的请求都直接逐个检查
+func isScriptUnavailable(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "unknown command") || strings.Contains(msg, "NOSCRIPT") ||
+		strings.Contains(msg, "CROSSSLOT")
+}
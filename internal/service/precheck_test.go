@@ -0,0 +1,31 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePreDrawResult(t *testing.T) {
+	result, err := parsePreDrawResult([]interface{}{int64(0), int64(3), int64(5), int64(1), int64(0)})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(preDrawPass), result.verdict)
+	assert.Equal(t, int64(3), result.userNum)
+	assert.Equal(t, int64(5), result.ipNum)
+	assert.True(t, result.blackIpMiss)
+	assert.False(t, result.blackUserMiss)
+
+	_, err = parsePreDrawResult([]interface{}{int64(0), int64(3)})
+	assert.Error(t, err)
+	_, err = parsePreDrawResult([]interface{}{"0", int64(3), int64(5), int64(1), int64(0)})
+	assert.Error(t, err)
+	_, err = parsePreDrawResult([]interface{}{int64(9), int64(3), int64(5), int64(1), int64(0)})
+	assert.Error(t, err)
+}
+
+func TestIsScriptUnavailable(t *testing.T) {
+	assert.True(t, isScriptUnavailable(errors.New("ERR unknown command 'eval'")))
+	assert.True(t, isScriptUnavailable(errors.New("CROSSSLOT Keys in request don't hash to the same slot")))
+	assert.False(t, isScriptUnavailable(errors.New("i/o timeout")))
+}
@@ -2,46 +2,181 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"gorm.io/gorm"
 	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/pkg/middlewares/gormcli"
 	"lottery_single/internal/pkg/middlewares/log"
 	"lottery_single/internal/repo"
 )
 
 type ResultService interface {
-	LotteryResult(ctx context.Context, prize *LotteryPrize, uid uint, userName, ip string, prizeCode int) error
+	LotteryResult(ctx context.Context, prize *LotteryPrize, uid uint, userName, ip string, prizeCode int64) error
+	GetLotteryRequest(ctx context.Context, uid uint, requestID string) (*LotteryRequestRecord, error)
+	SetLotteryRequest(ctx context.Context, uid uint, requestID string, record *LotteryRequestRecord) error
+	ListResults(ctx context.Context, query *repo.ResultQuery, cursor uint, limit int) (*ResultPage, error)
 }
 
 type resultService struct {
-	resultReop *repo.ResultRepo
+	resultReop    *repo.ResultRepo
+	walletService *walletService
 }
 
 var resultServiceImpl *resultService
 
 func GetResultService() ResultService {
 	resultServiceImpl = &resultService{
-		resultReop: repo.NewResultRepo(),
+		resultReop:    repo.NewResultRepo(),
+		walletService: walletServiceImpl,
 	}
 	return resultServiceImpl
 }
 
-func (r *resultService) LotteryResult(ctx context.Context, prize *LotteryPrize, uid uint, userName, ip string, prizeCode int) error {
-	result := model.Result{
-		PrizeId:   prize.Id,
-		PrizeName: prize.Title,
-		PrizeType: prize.PrizeType,
-		UserId:    uid,
-		UserName:  userName,
-		PrizeCode: uint(prizeCode),
-		PrizeData: prize.PrizeProfile,
-		//SysCreated: time.Now(),
-		SysIp:     ip,
-		SysStatus: 0,
+// LotteryResult 记录中奖纪录，ctx中带有事务时在事务中写入，虚拟币奖品和中奖纪录在同一个事务中入账
+func (r *resultService) LotteryResult(ctx context.Context, prize *LotteryPrize, uid uint, userName, ip string, prizeCode int64) error {
+	info := &LotteryUserInfo{
+		UserID:   uid,
+		UserName: userName,
+		IP:       ip,
 	}
-	if err := r.resultReop.Create(gormcli.GetDB(), &result); err != nil {
+	result := newLotteryResult(prize, info, prizeCode, "")
+	err := gormcli.GetDBFromCtx(ctx).Transaction(func(db *gorm.DB) error {
+		if err := r.resultReop.Create(db, result); err != nil {
+			return err
+		}
+		if prize.PrizeType == constant.PrizeTypeVirtualCoin && prize.Coins > 0 {
+			return r.walletService.creditPrize(db, result, prize.Coins)
+		}
+		return nil
+	})
+	if err != nil {
 		log.ErrorContextf(ctx, "resultService|LotteryResult:%v", err)
 		return fmt.Errorf("resultService|LotteryResult:%v", err)
 	}
 	return nil
 }
+
+// GetLotteryRequest 获取抽奖请求的处理结果，没有处理过返回nil
+// 缓存中没有的时候再查一次中奖记录，防止缓存过期或者写缓存失败之后重复发奖
+func (r *resultService) GetLotteryRequest(ctx context.Context, uid uint, requestID string) (*LotteryRequestRecord, error) {
+	value, err := r.resultReop.GetRequestByCache(uid, requestID)
+	if err != nil {
+		log.ErrorContextf(ctx, "resultService|GetLotteryRequest:%v", err)
+		return nil, fmt.Errorf("resultService|GetLotteryRequest:%v", err)
+	}
+	if value != "" {
+		record := &LotteryRequestRecord{}
+		if err = json.Unmarshal([]byte(value), record); err != nil {
+			log.ErrorContextf(ctx, "resultService|GetLotteryRequest unmarshal:%v", err)
+			return nil, fmt.Errorf("resultService|GetLotteryRequest:%v", err)
+		}
+		return record, nil
+	}
+	result, err := r.resultReop.GetByRequestID(gormcli.GetDB(), uid, requestID)
+	if err != nil {
+		log.ErrorContextf(ctx, "resultService|GetLotteryRequest:%v", err)
+		return nil, fmt.Errorf("resultService|GetLotteryRequest:%v", err)
+	}
+	if result == nil {
+		return nil, nil
+	}
+	record := &LotteryRequestRecord{
+		Code: constant.Success,
+		Prize: &LotteryPrize{
+			Id:         result.PrizeId,
+			ActivityId: result.ActivityId,
+			Title:      result.PrizeName,
+			PrizeType:  result.PrizeType,
+		},
+	}
+	switch result.PrizeType {
+	case constant.PrizeTypeCouponDiff:
+		record.Prize.CouponCode = result.PrizeData
+	case constant.PrizeTypeCouponSame:
+		record.Prize.PrizeProfile = result.PrizeData
+		coupon, err := parseCouponProfile(result.PrizeData)
+		if err != nil {
+			log.ErrorContextf(ctx, "resultService|GetLotteryRequest result_id=%d:%v", result.Id, err)
+			break
+		}
+		record.Prize.Coupon = coupon
+		record.Prize.CouponCode = coupon.Code
+	case constant.PrizeTypeVirtualCoin:
+		record.Prize.PrizeProfile = result.PrizeData
+		record.Prize.Coins, _ = parseCoinAmount(result.PrizeData)
+	default:
+		record.Prize.PrizeProfile = result.PrizeData
+	}
+	return record, nil
+}
+
+// SetLotteryRequest 保存抽奖请求的处理结果，相同请求ID的重试请求直接返回这个结果
+func (r *resultService) SetLotteryRequest(ctx context.Context, uid uint, requestID string, record *LotteryRequestRecord) error {
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		log.ErrorContextf(ctx, "resultService|SetLotteryRequest marshal:%v", err)
+		return fmt.Errorf("resultService|SetLotteryRequest:%v", err)
+	}
+	if err = r.resultReop.SetRequestByCache(uid, requestID, string(bytes)); err != nil {
+		log.ErrorContextf(ctx, "resultService|SetLotteryRequest:%v", err)
+		return fmt.Errorf("resultService|SetLotteryRequest:%v", err)
+	}
+	return nil
+}
+
+// newLotteryResult 构造中奖纪录，不同编码的优惠券在获奖信息中记录发放的优惠券编码，相同编码的优惠券记录优惠券信息的json
+func newLotteryResult(prize *LotteryPrize, info *LotteryUserInfo, prizeCode int64, requestID string) *model.Result {
+	prizeData := prize.PrizeProfile
+	if prize.PrizeType == constant.PrizeTypeCouponDiff && prize.CouponCode != "" {
+		prizeData = prize.CouponCode
+	}
+	if prize.PrizeType == constant.PrizeTypeCouponSame && prize.Coupon != nil {
+		if data, err := json.Marshal(prize.Coupon); err == nil {
+			prizeData = string(data)
+		}
+	}
+	return &model.Result{
+		ActivityId: prize.ActivityId,
+		PrizeId:    prize.Id,
+		PrizeName:  prize.Title,
+		PrizeType:  prize.PrizeType,
+		UserId:     info.UserID,
+		UserName:   info.UserName,
+		PrizeCode:  uint64(prizeCode),
+		PrizeData:  prizeData,
+		RequestId:  requestID,
+		//SysCreated: time.Now(),
+		SysIp:     info.IP,
+		SysStatus: 0,
+	}
+}
+
+// ListResults 按照ID倒序分页查询中奖记录，多查一条用来判断是否还有下一页
+func (r *resultService) ListResults(ctx context.Context, query *repo.ResultQuery, cursor uint, limit int) (*ResultPage, error) {
+	if limit <= 0 {
+		limit = constant.ResultListDefaultLimit
+	}
+	if limit > constant.ResultListMaxLimit {
+		limit = constant.ResultListMaxLimit
+	}
+	results, err := r.resultReop.GetList(gormcli.GetDB(), query, cursor, limit+1)
+	if err != nil {
+		log.ErrorContextf(ctx, "resultService|ListResults:%v", err)
+		return nil, fmt.Errorf("resultService|ListResults:%v", err)
+	}
+	return newResultPage(results, limit), nil
+}
+
+func newResultPage(results []*model.Result, limit int) *ResultPage {
+	page := &ResultPage{List: results}
+	if len(results) > limit {
+		page.List = results[:limit]
+		page.HasMore = true
+	}
+	if len(page.List) > 0 {
+		page.NextCursor = page.List[len(page.List)-1].Id
+	}
+	return page
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/model"
+)
+
+func TestNewResultPage(t *testing.T) {
+	results := []*model.Result{{Id: 9}, {Id: 7}, {Id: 4}}
+	page := newResultPage(results, 2)
+	assert.Len(t, page.List, 2)
+	assert.True(t, page.HasMore)
+	assert.Equal(t, uint(7), page.NextCursor)
+
+	page = newResultPage(results, 3)
+	assert.Len(t, page.List, 3)
+	assert.False(t, page.HasMore)
+	assert.Equal(t, uint(4), page.NextCursor)
+
+	page = newResultPage(nil, 3)
+	assert.Empty(t, page.List)
+	assert.False(t, page.HasMore)
+	assert.Equal(t, uint(0), page.NextCursor)
+}
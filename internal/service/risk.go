@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"lottery_single/configs"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/middlewares/cache"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/risk"
+	"lottery_single/internal/repo"
+	"sync"
+	"time"
+)
+
+// RiskService 活动的风控规则链，规则按照活动配置的顺序执行，每次命中都记录日志和抽奖审计
+type RiskService interface {
+	Evaluate(ctx context.Context, activity *model.Activity, in *risk.Input) (risk.Decision, error)
+}
+
+type riskService struct {
+	userRepo *repo.UserRepo
+	counter  risk.Counter
+
+	// 解析好的规则链，活动的规则配置修改之后重新解析
+	mu     sync.Mutex
+	chains map[uint]*riskChain
+}
+
+// riskChain 活动的规则链和解析时的配置
+type riskChain struct {
+	raw   string
+	chain *risk.Chain
+}
+
+var riskServiceImpl *riskService
+
+func InitRiskService() {
+	riskServiceImpl = &riskService{
+		userRepo: repo.NewUserRepo(),
+		counter:  redisRiskCounter{},
+		chains:   make(map[uint]*riskChain),
+	}
+}
+
+func GetRiskService() RiskService {
+	return riskServiceImpl
+}
+
+// Evaluate 执行活动的规则链，没有配置规则时直接放行
+func (r *riskService) Evaluate(ctx context.Context, activity *model.Activity, in *risk.Input) (risk.Decision, error) {
+	if activity.RiskRules == "" {
+		return risk.Allowed, nil
+	}
+	chain, err := r.getChain(activity)
+	if err != nil {
+		return risk.Allowed, fmt.Errorf("riskService|Evaluate:%v", err)
+	}
+	if in.Now.IsZero() {
+		in.Now = time.Now()
+	}
+	if chain.NeedAccount() && in.UserCreated.IsZero() {
+		user, err := r.userRepo.Get(gormcli.GetDB(), in.UserID)
+		if err != nil {
+			return risk.Allowed, fmt.Errorf("riskService|Evaluate:%v", err)
+		}
+		if user != nil && user.CreatedAt != nil {
+			in.UserCreated = *user.CreatedAt
+		}
+	}
+	decision, err := chain.Evaluate(ctx, in)
+	if err != nil {
+		return risk.Allowed, fmt.Errorf("riskService|Evaluate:%v", err)
+	}
+	if decision.Verdict != risk.VerdictAllow {
+		log.InfoContextf(ctx, "riskService|Evaluate activity_id=%d user_id=%d ip=%s verdict=%s rule=%s reason=%s",
+			in.ActivityID, in.UserID, in.IP, decision.Verdict, decision.Rule, decision.Reason)
+	}
+	return decision, nil
+}
+
+// getChain 获取活动的规则链，配置没有变化时复用之前解析的结果
+func (r *riskService) getChain(activity *model.Activity) (*risk.Chain, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.chains[activity.Id]; ok && c.raw == activity.RiskRules {
+		return c.chain, nil
+	}
+	rules, err := risk.ParseRules(activity.RiskRules)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := risk.Build(activity.Id, rules, risk.Options{Counter: r.counter, Secret: riskSignSecret})
+	if err != nil {
+		return nil, err
+	}
+	r.chains[activity.Id] = &riskChain{raw: activity.RiskRules, chain: chain}
+	return chain, nil
+}
+
+// riskSignSecret 请求签名的密钥，修改配置之后自动生效
+func riskSignSecret() string {
+	return configs.GetLotteryConfig().SignSecret
+}
+
+// redisRiskCounter 规则使用的redis计数器
+type redisRiskCounter struct{}
+
+func (redisRiskCounter) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	redisCli := cache.GetRedisCli()
+	num, err := redisCli.Incr(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("redisRiskCounter|Incr:%v", err)
+	}
+	if num == 1 {
+		redisCli.Expire(ctx, key, ttl)
+	}
+	return num, nil
+}
+
+func (redisRiskCounter) AddMember(ctx context.Context, key, member string, ttl time.Duration) (int64, error) {
+	redisCli := cache.GetRedisCli()
+	added, err := redisCli.SAdd(ctx, key, member)
+	if err != nil {
+		return 0, fmt.Errorf("redisRiskCounter|AddMember:%v", err)
+	}
+	num, err := redisCli.SCard(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("redisRiskCounter|AddMember:%v", err)
+	}
+	if added > 0 && num == 1 {
+		redisCli.Expire(ctx, key, ttl)
+	}
+	return num, nil
+}
@@ -1,8 +1,16 @@
 package service
 
 func Init() {
+	InitWalletService()
 	InitAdminService()
+	InitActivityService()
 	InitLimitService()
+	InitAuditService()
+	InitFulfilmentService()
+	InitPityService()
+	InitWinCapService()
+	InitRiskService()
+	InitBlackUserService()
 	NewLotteryService()
 	NewUserService()
 }
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lottery_single/configs"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/utils"
+	"time"
+)
+
+var (
+	// ErrTokenInvalid token格式错误、签名错误、类型不对或者已经注销
+	ErrTokenInvalid = errors.New("token invalid")
+	// ErrTokenExpired token已经过期
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// issueTokens 签发访问token和刷新token，刷新token保存到redis中用于注销和防止重复使用
+func (p *userService) issueTokens(ctx context.Context, uid uint, userName string, roles []string) (*LoginRsp, error) {
+	conf := configs.GetJwtConfig()
+	access, _, err := signToken(conf, constant.TokenTypeAccess, conf.AccessTokenTTL, uid, userName, roles)
+	if err != nil {
+		return nil, fmt.Errorf("userService|issueTokens:%v", err)
+	}
+	// 刷新token中不保存角色，刷新时重新查询，角色修改之后刷新token即可生效
+	refresh, refreshID, err := signToken(conf, constant.TokenTypeRefresh, conf.RefreshTokenTTL, uid, userName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("userService|issueTokens:%v", err)
+	}
+	if err = p.tokenRepo.SetRefresh(refreshID, uid, time.Duration(conf.RefreshTokenTTL)*time.Second); err != nil {
+		return nil, fmt.Errorf("userService|issueTokens:%v", err)
+	}
+	return &LoginRsp{
+		UserID:           uid,
+		Token:            access,
+		ExpiresIn:        conf.AccessTokenTTL,
+		RefreshToken:     refresh,
+		RefreshExpiresIn: conf.RefreshTokenTTL,
+		Roles:            roles,
+	}, nil
+}
+
+// signToken 使用当前密钥签发token，返回token和token ID
+func signToken(conf *configs.JwtConf, tokenType string, ttl int, uid uint, userName string, roles []string) (string, string, error) {
+	now := time.Now()
+	jti := utils.NewUuid()
+	claims := utils.JWTClaims{
+		UserID:    uid,
+		UserName:  userName,
+		Roles:     roles,
+		TokenType: tokenType,
+	}
+	claims.StandardClaims.Id = jti
+	claims.StandardClaims.Issuer = conf.Issuer
+	claims.StandardClaims.IssuedAt = now.Unix()
+	claims.StandardClaims.NotBefore = now.Unix()
+	claims.StandardClaims.ExpiresAt = now.Add(time.Duration(ttl) * time.Second).Unix()
+	token, err := utils.GenerateJwtToken(conf.CurrentKid, conf.Keys[conf.CurrentKid], claims)
+	if err != nil {
+		return "", "", err
+	}
+	return token, jti, nil
+}
+
+// parseToken 校验签名、签发者、类型和有效期，不检查是否已经注销
+func parseToken(token, tokenType string) (*utils.JWTClaims, error) {
+	conf := configs.GetJwtConfig()
+	claims, err := utils.ParseJwtToken(token, func(kid string) (string, bool) {
+		secret, ok := conf.Keys[kid]
+		return secret, ok && secret != ""
+	})
+	if err != nil || claims == nil {
+		return nil, fmt.Errorf("%w:%v", ErrTokenInvalid, err)
+	}
+	if claims.TokenType != tokenType || claims.StandardClaims.Issuer != conf.Issuer || claims.StandardClaims.Id == "" {
+		return nil, fmt.Errorf("%w:token_type=%s issuer=%s", ErrTokenInvalid, claims.TokenType, claims.StandardClaims.Issuer)
+	}
+	if time.Now().Unix() > claims.StandardClaims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+// ParseToken 解析访问token，已经注销的token返回ErrTokenInvalid
+func (p *userService) ParseToken(ctx context.Context, token string) (*utils.JWTClaims, error) {
+	claims, err := parseToken(token, constant.TokenTypeAccess)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := p.tokenRepo.IsRevoked(claims.StandardClaims.Id)
+	if err != nil {
+		log.ErrorContextf(ctx, "userService|ParseToken:%v", err)
+		return nil, fmt.Errorf("userService|ParseToken:%v", err)
+	}
+	if revoked {
+		return nil, fmt.Errorf("%w:token revoked, user_id=%d", ErrTokenInvalid, claims.UserID)
+	}
+	return claims, nil
+}
+
+// RefreshToken 使用刷新token换取新的访问token和刷新token，旧的刷新token失效
+func (p *userService) RefreshToken(ctx context.Context, refreshToken string) (*LoginRsp, error) {
+	claims, err := parseToken(refreshToken, constant.TokenTypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := p.tokenRepo.DelRefresh(claims.StandardClaims.Id)
+	if err != nil {
+		log.ErrorContextf(ctx, "userService|RefreshToken:%v", err)
+		return nil, fmt.Errorf("userService|RefreshToken:%v", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w:refresh token used or revoked, user_id=%d", ErrTokenInvalid, claims.UserID)
+	}
+	user, err := p.userReop.Get(gormcli.GetDB(), claims.UserID)
+	if err != nil {
+		log.ErrorContextf(ctx, "userService|RefreshToken:%v", err)
+		return nil, fmt.Errorf("userService|RefreshToken:%v", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("%w:user not found, user_id=%d", ErrTokenInvalid, claims.UserID)
+	}
+	roles, err := p.userRoleRepo.GetRolesByUserID(gormcli.GetDB(), user.Id)
+	if err != nil {
+		log.ErrorContextf(ctx, "userService|RefreshToken:%v", err)
+		return nil, fmt.Errorf("userService|RefreshToken:%v", err)
+	}
+	return p.issueTokens(ctx, user.Id, user.UserName, roles)
+}
+
+// Logout 注销当前的访问token，传了刷新token的话一起注销
+func (p *userService) Logout(ctx context.Context, claims *utils.JWTClaims, refreshToken string) error {
+	expire := time.Until(time.Unix(claims.StandardClaims.ExpiresAt, 0))
+	if err := p.tokenRepo.SetRevoked(claims.StandardClaims.Id, expire); err != nil {
+		log.ErrorContextf(ctx, "userService|Logout:%v", err)
+		return fmt.Errorf("userService|Logout:%v", err)
+	}
+	if refreshToken == "" {
+		return nil
+	}
+	refreshClaims, err := parseToken(refreshToken, constant.TokenTypeRefresh)
+	if err != nil {
+		// 刷新token已经过期或者无效，不影响注销
+		log.InfoContextf(ctx, "userService|Logout parse refresh token:%v", err)
+		return nil
+	}
+	if refreshClaims.UserID != claims.UserID {
+		return fmt.Errorf("%w:refresh token belongs to user_id=%d", ErrTokenInvalid, refreshClaims.UserID)
+	}
+	if _, err = p.tokenRepo.DelRefresh(refreshClaims.StandardClaims.Id); err != nil {
+		log.ErrorContextf(ctx, "userService|Logout:%v", err)
+		return fmt.Errorf("userService|Logout:%v", err)
+	}
+	return nil
+}
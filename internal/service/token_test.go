@@ -0,0 +1,39 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/configs"
+	"lottery_single/internal/pkg/constant"
+)
+
+func TestParseToken(t *testing.T) {
+	conf := configs.GetJwtConfig()
+	token, jti, err := signToken(conf, constant.TokenTypeAccess, 60, 1, "admin", []string{constant.RoleOperator})
+	assert.NoError(t, err)
+	claims, err := parseToken(token, constant.TokenTypeAccess)
+	assert.NoError(t, err)
+	assert.Equal(t, jti, claims.StandardClaims.Id)
+	assert.Equal(t, uint(1), claims.UserID)
+	assert.Equal(t, []string{constant.RoleOperator}, claims.Roles)
+
+	// 访问token不能当作刷新token使用
+	_, err = parseToken(token, constant.TokenTypeRefresh)
+	assert.True(t, errors.Is(err, ErrTokenInvalid))
+
+	expired, _, err := signToken(conf, constant.TokenTypeAccess, -60, 1, "admin", nil)
+	assert.NoError(t, err)
+	_, err = parseToken(expired, constant.TokenTypeAccess)
+	assert.True(t, errors.Is(err, ErrTokenExpired))
+
+	// 使用不在密钥列表中的密钥签发的token
+	other := *conf
+	other.CurrentKid = "unknown"
+	other.Keys = map[string]string{"unknown": "secret"}
+	forged, _, err := signToken(&other, constant.TokenTypeAccess, 60, 1, "admin", nil)
+	assert.NoError(t, err)
+	_, err = parseToken(forged, constant.TokenTypeAccess)
+	assert.True(t, errors.Is(err, ErrTokenInvalid))
+}
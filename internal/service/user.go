@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/bcrypt"
 	"lottery_single/internal/model"
@@ -12,21 +13,37 @@ import (
 	"lottery_single/internal/repo"
 )
 
+var (
+	// ErrInvalidRole 角色不存在
+	ErrInvalidRole = errors.New("invalid role")
+	// ErrUserNotFound 用户不存在
+	ErrUserNotFound = errors.New("user not found")
+)
+
 // UserService 用户功能
 type UserService interface {
 	Login(ctx context.Context, userName, passWord string) (*LoginRsp, error)
 	Register(ctx context.Context, user *model.User) error
+	GetUserRoles(ctx context.Context, uid uint) ([]string, error)
+	SetUserRoles(ctx context.Context, uid uint, roles []string) error
+	ParseToken(ctx context.Context, token string) (*utils.JWTClaims, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*LoginRsp, error)
+	Logout(ctx context.Context, claims *utils.JWTClaims, refreshToken string) error
 }
 
 type userService struct {
-	userReop *repo.UserRepo
+	userReop     *repo.UserRepo
+	userRoleRepo *repo.UserRoleRepo
+	tokenRepo    *repo.TokenRepo
 }
 
 var userServiceImpl *userService
 
 func NewUserService() {
 	userServiceImpl = &userService{
-		userReop: repo.NewUserRepo(),
+		userReop:     repo.NewUserRepo(),
+		userRoleRepo: repo.NewUserRoleRepo(),
+		tokenRepo:    repo.NewTokenRepo(),
 	}
 }
 
@@ -70,16 +87,18 @@ func (p *userService) Login(ctx context.Context, userName, passWord string) (*Lo
 
 	log.Infof("Password verified successfully for user: %s", userName)
 
-	// 生成 JWT Token
-	token, err := utils.GenerateJwtToken(constant.SecretKey, constant.Issuer, info.Id, userName)
+	// 管理后台角色写入token，鉴权时不需要再查库
+	roles, err := p.userRoleRepo.GetRolesByUserID(gormcli.GetDB(), info.Id)
 	if err != nil {
-		log.Errorf("Error generating JWT token: %v", err)
+		log.Errorf("Error fetching user roles: %v", err)
 		return nil, err
 	}
 
-	response := &LoginRsp{
-		UserID: info.Id,
-		Token:  token,
+	// 签发访问token和刷新token
+	response, err := p.issueTokens(ctx, info.Id, userName, roles)
+	if err != nil {
+		log.Errorf("Error generating JWT token: %v", err)
+		return nil, err
 	}
 
 	log.Infof("User %s logged in successfully", userName)
@@ -108,6 +127,58 @@ func (p *userService) Register(ctx context.Context, user *model.User) error {
 
 	return nil
 }
+
+// GetUserRoles 获取用户的管理后台角色
+func (p *userService) GetUserRoles(ctx context.Context, uid uint) ([]string, error) {
+	roles, err := p.userRoleRepo.GetRolesByUserID(gormcli.GetDB(), uid)
+	if err != nil {
+		log.ErrorContextf(ctx, "userService|GetUserRoles:%v", err)
+		return nil, fmt.Errorf("userService|GetUserRoles:%v", err)
+	}
+	return roles, nil
+}
+
+// SetUserRoles 覆盖设置用户的管理后台角色，用户重新登录之后生效
+func (p *userService) SetUserRoles(ctx context.Context, uid uint, roles []string) error {
+	roles, err := uniqueRoles(roles)
+	if err != nil {
+		return fmt.Errorf("userService|SetUserRoles:%w", err)
+	}
+	user, err := p.userReop.Get(gormcli.GetDB(), uid)
+	if err != nil {
+		log.ErrorContextf(ctx, "userService|SetUserRoles:%v", err)
+		return fmt.Errorf("userService|SetUserRoles:%v", err)
+	}
+	if user == nil {
+		return fmt.Errorf("userService|SetUserRoles:%w:%d", ErrUserNotFound, uid)
+	}
+	err = gormcli.Transaction(ctx, func(txctx context.Context) error {
+		return p.userRoleRepo.SetRoles(gormcli.GetDBFromCtx(txctx), uid, roles)
+	})
+	if err != nil {
+		log.ErrorContextf(ctx, "userService|SetUserRoles:%v", err)
+		return fmt.Errorf("userService|SetUserRoles:%v", err)
+	}
+	return nil
+}
+
+// uniqueRoles 校验角色并去掉重复的角色，保持原来的顺序
+func uniqueRoles(roles []string) ([]string, error) {
+	seen := make(map[string]bool, len(roles))
+	result := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if !constant.IsValidRole(role) {
+			return nil, fmt.Errorf("%w:%s", ErrInvalidRole, role)
+		}
+		if seen[role] {
+			continue
+		}
+		seen[role] = true
+		result = append(result, role)
+	}
+	return result, nil
+}
+
 func isHashed(password string) bool {
 
 	return len(password) > 1
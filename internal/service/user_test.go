@@ -0,0 +1,21 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/pkg/constant"
+)
+
+func TestUniqueRoles(t *testing.T) {
+	roles, err := uniqueRoles([]string{constant.RoleOperator, constant.RoleAuditor, constant.RoleOperator})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{constant.RoleOperator, constant.RoleAuditor}, roles)
+
+	roles, err = uniqueRoles(nil)
+	assert.Nil(t, err)
+	assert.Empty(t, roles)
+
+	_, err = uniqueRoles([]string{constant.RoleOperator, "admin"})
+	assert.ErrorIs(t, err, ErrInvalidRole)
+}
@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/repo"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidWalletAdjust 后台调整的参数不合法
+	ErrInvalidWalletAdjust = errors.New("invalid wallet adjust")
+	// ErrInsufficientBalance 扣减之后余额为负
+	ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
+// WalletService 虚拟币钱包，中奖发放、作弊收回和后台调整都通过账本记账，相同交易号只记一次
+type WalletService interface {
+	GetBalance(ctx context.Context, uid uint) (int64, error)
+	ListLedgers(ctx context.Context, uid uint, cursor uint, limit int) (*WalletLedgerPage, error)
+	Adjust(ctx context.Context, adjust *WalletAdjust) (*model.WalletLedger, error)
+	ChargeDraw(ctx context.Context, uid uint, activityID uint, requestID string, cost int64) error
+}
+
+type walletService struct {
+	walletRepo *repo.WalletRepo
+}
+
+var walletServiceImpl *walletService
+
+func InitWalletService() {
+	walletServiceImpl = &walletService{
+		walletRepo: repo.NewWalletRepo(),
+	}
+}
+
+func GetWalletService() WalletService {
+	return walletServiceImpl
+}
+
+// parseCoinAmount 解析虚拟币奖品的数量，prize_profile为正整数或者 {"coins":100}
+func parseCoinAmount(profile string) (int64, error) {
+	profile = strings.TrimSpace(profile)
+	coins, err := strconv.ParseInt(profile, 10, 64)
+	if err != nil {
+		var v struct {
+			Coins int64 `json:"coins"`
+		}
+		if json.Unmarshal([]byte(profile), &v) != nil {
+			return 0, fmt.Errorf("%w:coin amount is not a number", ErrInvalidPrizeProfile)
+		}
+		coins = v.Coins
+	}
+	if coins <= 0 {
+		return 0, fmt.Errorf("%w:coin amount must be positive", ErrInvalidPrizeProfile)
+	}
+	return coins, nil
+}
+
+// walletTx 一笔虚拟币交易，用户账户和系统账户各记一条分录
+type walletTx struct {
+	txNo          string
+	bizType       string
+	systemAccount string
+	uid           uint
+	amount        int64
+	resultID      uint
+	reason        string
+	operator      string
+	allowNegative bool // 作弊收回时余额可以为负
+}
+
+// newWalletLedgers 生成交易的两条分录，金额相加为0
+func newWalletLedgers(tx *walletTx, balance int64) []*model.WalletLedger {
+	entry := func(account string, amount, balanceAfter int64) *model.WalletLedger {
+		return &model.WalletLedger{
+			TxNo:         tx.txNo,
+			Account:      account,
+			UserId:       tx.uid,
+			Amount:       amount,
+			BalanceAfter: balanceAfter,
+			BizType:      tx.bizType,
+			ResultId:     tx.resultID,
+			Reason:       tx.reason,
+			Operator:     tx.operator,
+		}
+	}
+	return []*model.WalletLedger{
+		entry(constant.WalletAccountUser, tx.amount, balance),
+		entry(tx.systemAccount, -tx.amount, 0),
+	}
+}
+
+// userLedger 返回交易中用户账户的分录
+func userLedger(list []*model.WalletLedger) *model.WalletLedger {
+	for _, ledger := range list {
+		if ledger.Account == constant.WalletAccountUser {
+			return ledger
+		}
+	}
+	return nil
+}
+
+// post 记账，需要在事务中调用，交易号已经记过账时直接返回已有的用户分录
+func (w *walletService) post(db *gorm.DB, tx *walletTx) (*model.WalletLedger, error) {
+	exists, err := w.walletRepo.GetLedgerByTxNo(db, tx.txNo)
+	if err != nil {
+		return nil, err
+	}
+	if ledger := userLedger(exists); ledger != nil {
+		return ledger, nil
+	}
+	ok, err := w.walletRepo.AddBalance(db, tx.uid, tx.amount, tx.allowNegative)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInsufficientBalance
+	}
+	wallet, err := w.walletRepo.GetWallet(db, tx.uid)
+	if err != nil {
+		return nil, err
+	}
+	if wallet == nil {
+		return nil, fmt.Errorf("walletService|post:wallet of user %d not found", tx.uid)
+	}
+	list := newWalletLedgers(tx, wallet.Balance)
+	if err = w.walletRepo.CreateLedgers(db, list); err != nil {
+		return nil, err
+	}
+	return list[0], nil
+}
+
+// creditPrize 中奖之后发放虚拟币，在发奖事务中调用，交易号为中奖记录ID
+func (w *walletService) creditPrize(db *gorm.DB, result *model.Result, coins int64) error {
+	_, err := w.post(db, &walletTx{
+		txNo:          fmt.Sprintf("%s_%d", constant.WalletBizPrize, result.Id),
+		bizType:       constant.WalletBizPrize,
+		systemAccount: constant.WalletAccountSystemPrize,
+		uid:           result.UserId,
+		amount:        coins,
+		resultID:      result.Id,
+		reason:        result.PrizeName,
+	})
+	return err
+}
+
+// revokePrize 中奖记录判定作弊时收回发放的虚拟币，没有发放过时不处理，余额可以扣成负数
+func (w *walletService) revokePrize(db *gorm.DB, result *model.Result) error {
+	credited, err := w.walletRepo.GetLedgerByTxNo(db, fmt.Sprintf("%s_%d", constant.WalletBizPrize, result.Id))
+	if err != nil {
+		return err
+	}
+	ledger := userLedger(credited)
+	if ledger == nil {
+		return nil
+	}
+	_, err = w.post(db, &walletTx{
+		txNo:          fmt.Sprintf("%s_%d", constant.WalletBizPrizeRevoke, result.Id),
+		bizType:       constant.WalletBizPrizeRevoke,
+		systemAccount: constant.WalletAccountSystemPrize,
+		uid:           result.UserId,
+		amount:        -ledger.Amount,
+		resultID:      result.Id,
+		reason:        "result marked as cheating",
+		allowNegative: true,
+	})
+	return err
+}
+
+// chargeDraw 付费抽奖扣费，在事务中调用，交易号为用户ID和抽奖请求ID，余额不足时返回ErrInsufficientBalance
+func (w *walletService) chargeDraw(db *gorm.DB, uid uint, activityID uint, requestID string, cost int64,
+	resultID uint) error {
+	_, err := w.post(db, &walletTx{
+		txNo:          fmt.Sprintf("%s_%d_%s", constant.WalletBizDraw, uid, requestID),
+		bizType:       constant.WalletBizDraw,
+		systemAccount: constant.WalletAccountSystemDraw,
+		uid:           uid,
+		amount:        -cost,
+		resultID:      resultID,
+		reason:        fmt.Sprintf("draw activity %d", activityID),
+	})
+	return err
+}
+
+// ChargeDraw 没有中奖的付费抽奖单独扣费，中奖时在发奖事务中扣费
+func (w *walletService) ChargeDraw(ctx context.Context, uid uint, activityID uint, requestID string,
+	cost int64) error {
+	if cost <= 0 {
+		return nil
+	}
+	err := gormcli.Transaction(ctx, func(txctx context.Context) error {
+		return w.chargeDraw(gormcli.GetDBFromCtx(txctx), uid, activityID, requestID, cost, 0)
+	})
+	if errors.Is(err, ErrInsufficientBalance) {
+		return fmt.Errorf("walletService|ChargeDraw:%w", err)
+	}
+	if err != nil {
+		log.ErrorContextf(ctx, "walletService|ChargeDraw:%v", err)
+		return fmt.Errorf("walletService|ChargeDraw:%v", err)
+	}
+	return nil
+}
+
+// GetBalance 获取用户余额，没有钱包时为0
+func (w *walletService) GetBalance(ctx context.Context, uid uint) (int64, error) {
+	wallet, err := w.walletRepo.GetWallet(gormcli.GetDB(), uid)
+	if err != nil {
+		log.ErrorContextf(ctx, "walletService|GetBalance:%v", err)
+		return 0, fmt.Errorf("walletService|GetBalance:%v", err)
+	}
+	if wallet == nil {
+		return 0, nil
+	}
+	return wallet.Balance, nil
+}
+
+// ListLedgers 分页查询用户的余额变动记录
+func (w *walletService) ListLedgers(ctx context.Context, uid uint, cursor uint, limit int) (*WalletLedgerPage, error) {
+	if limit <= 0 {
+		limit = constant.WalletLedgerDefaultLimit
+	}
+	if limit > constant.WalletLedgerMaxLimit {
+		limit = constant.WalletLedgerMaxLimit
+	}
+	balance, err := w.GetBalance(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	list, err := w.walletRepo.GetUserLedgers(gormcli.GetDB(), uid, cursor, limit+1)
+	if err != nil {
+		log.ErrorContextf(ctx, "walletService|ListLedgers:%v", err)
+		return nil, fmt.Errorf("walletService|ListLedgers:%v", err)
+	}
+	page := &WalletLedgerPage{Balance: balance, List: list}
+	if len(list) > limit {
+		page.List = list[:limit]
+		page.HasMore = true
+	}
+	if len(page.List) > 0 {
+		page.NextCursor = page.List[len(page.List)-1].Id
+	}
+	return page, nil
+}
+
+// checkWalletAdjust 校验后台调整的参数
+func checkWalletAdjust(adjust *WalletAdjust) error {
+	adjust.Reason = strings.TrimSpace(adjust.Reason)
+	adjust.RequestID = strings.TrimSpace(adjust.RequestID)
+	switch {
+	case adjust.UserID == 0:
+		return fmt.Errorf("%w:user_id is required", ErrInvalidWalletAdjust)
+	case adjust.Amount == 0:
+		return fmt.Errorf("%w:amount must not be zero", ErrInvalidWalletAdjust)
+	case adjust.Reason == "" || utf8.RuneCountInString(adjust.Reason) > constant.WalletReasonMaxLen:
+		return fmt.Errorf("%w:reason is required and at most %d characters", ErrInvalidWalletAdjust,
+			constant.WalletReasonMaxLen)
+	case adjust.RequestID == "" || len(adjust.RequestID) > constant.LotteryRequestIDMaxLen:
+		return fmt.Errorf("%w:request_id is required and at most %d characters", ErrInvalidWalletAdjust,
+			constant.LotteryRequestIDMaxLen)
+	}
+	return nil
+}
+
+// Adjust 后台调整用户余额，相同请求ID重复调用时返回第一次的分录
+func (w *walletService) Adjust(ctx context.Context, adjust *WalletAdjust) (*model.WalletLedger, error) {
+	if err := checkWalletAdjust(adjust); err != nil {
+		return nil, fmt.Errorf("walletService|Adjust:%w", err)
+	}
+	tx := &walletTx{
+		txNo:          fmt.Sprintf("%s_%s", constant.WalletBizAdjust, adjust.RequestID),
+		bizType:       constant.WalletBizAdjust,
+		systemAccount: constant.WalletAccountSystemAdjust,
+		uid:           adjust.UserID,
+		amount:        adjust.Amount,
+		resultID:      adjust.ResultID,
+		reason:        adjust.Reason,
+		operator:      adjust.Operator,
+	}
+	var ledger *model.WalletLedger
+	err := gormcli.Transaction(ctx, func(txctx context.Context) error {
+		var err error
+		ledger, err = w.post(gormcli.GetDBFromCtx(txctx), tx)
+		return err
+	})
+	if errors.Is(err, ErrInsufficientBalance) {
+		return nil, fmt.Errorf("walletService|Adjust:%w", err)
+	}
+	if err != nil {
+		// 相同请求ID并发调整时唯一索引冲突，另一个请求已经记账
+		exists, _ := w.walletRepo.GetLedgerByTxNo(gormcli.GetDB(), tx.txNo)
+		if ledger = userLedger(exists); ledger == nil {
+			log.ErrorContextf(ctx, "walletService|Adjust:%v", err)
+			return nil, fmt.Errorf("walletService|Adjust:%v", err)
+		}
+	}
+	if ledger.UserId != adjust.UserID || ledger.Amount != adjust.Amount {
+		return nil, fmt.Errorf("walletService|Adjust:%w:request_id %s already used by ledger %d",
+			ErrInvalidWalletAdjust, adjust.RequestID, ledger.Id)
+	}
+	log.InfoContextf(ctx, "walletService|Adjust user_id=%d amount=%d operator=%s ledger=%d", adjust.UserID,
+		adjust.Amount, adjust.Operator, ledger.Id)
+	return ledger, nil
+}
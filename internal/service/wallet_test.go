@@ -0,0 +1,131 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/repo"
+)
+
+func TestParseCoinAmount(t *testing.T) {
+	coins, err := parseCoinAmount(" 100 ")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), coins)
+
+	coins, err = parseCoinAmount(`{"coins":50}`)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(50), coins)
+
+	for _, profile := range []string{"", "100个金币", "0", "-5", `{"coins":0}`} {
+		_, err = parseCoinAmount(profile)
+		assert.True(t, errors.Is(err, ErrInvalidPrizeProfile), profile)
+	}
+}
+
+func TestCheckWalletAdjust(t *testing.T) {
+	adjust := &WalletAdjust{UserID: 1, Amount: -10, Reason: " 补偿 ", RequestID: "r1"}
+	assert.Nil(t, checkWalletAdjust(adjust))
+	assert.Equal(t, "补偿", adjust.Reason)
+
+	for _, adjust := range []*WalletAdjust{
+		{Amount: 10, Reason: "r", RequestID: "r1"},
+		{UserID: 1, Reason: "r", RequestID: "r1"},
+		{UserID: 1, Amount: 10, RequestID: "r1"},
+		{UserID: 1, Amount: 10, Reason: "r"},
+	} {
+		assert.True(t, errors.Is(checkWalletAdjust(adjust), ErrInvalidWalletAdjust))
+	}
+}
+
+func newTestWalletDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	// 内存数据库每个连接是独立的库
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	// 模型中的列类型是mysql的，sqlite中单独建表
+	for _, stmt := range []string{
+		`create table t_wallet (id integer primary key autoincrement, user_id integer not null default 0,
+			balance integer not null default 0, sys_created datetime, sys_updated datetime)`,
+		`create unique index uk_user_id on t_wallet(user_id)`,
+		`create table t_wallet_ledger (id integer primary key autoincrement, tx_no text not null default '',
+			account text not null default '', user_id integer not null default 0, amount integer not null default 0,
+			balance_after integer not null default 0, biz_type text not null default '',
+			result_id integer not null default 0, reason text not null default '', operator text not null default '',
+			sys_created datetime)`,
+		`create unique index uk_tx_account on t_wallet_ledger(tx_no, account)`,
+	} {
+		assert.Nil(t, db.Exec(stmt).Error)
+	}
+	return db
+}
+
+func TestWalletPost(t *testing.T) {
+	db := newTestWalletDB(t)
+	w := &walletService{walletRepo: repo.NewWalletRepo()}
+	result := &model.Result{Id: 7, UserId: 3, PrizeName: "coins"}
+
+	// 相同中奖记录只入账一次
+	assert.Nil(t, w.creditPrize(db, result, 100))
+	assert.Nil(t, w.creditPrize(db, result, 100))
+	wallet, err := w.walletRepo.GetWallet(db, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), wallet.Balance)
+
+	// 后台扣减不能扣成负数
+	_, err = w.post(db, &walletTx{txNo: "adjust_a", bizType: constant.WalletBizAdjust,
+		systemAccount: constant.WalletAccountSystemAdjust, uid: 3, amount: -150})
+	assert.True(t, errors.Is(err, ErrInsufficientBalance))
+	ledger, err := w.post(db, &walletTx{txNo: "adjust_b", bizType: constant.WalletBizAdjust,
+		systemAccount: constant.WalletAccountSystemAdjust, uid: 3, amount: -30})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(70), ledger.BalanceAfter)
+
+	// 作弊收回可以扣成负数，重复收回只扣一次
+	assert.Nil(t, w.revokePrize(db, result))
+	assert.Nil(t, w.revokePrize(db, result))
+	wallet, _ = w.walletRepo.GetWallet(db, 3)
+	assert.Equal(t, int64(-30), wallet.Balance)
+
+	// 每笔交易的分录相加为0
+	var sum int64
+	assert.Nil(t, db.Model(&model.WalletLedger{}).Select("sum(amount)").Scan(&sum).Error)
+	assert.Equal(t, int64(0), sum)
+	list, err := w.walletRepo.GetUserLedgers(db, 3, 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, list, 3)
+}
+
+func TestWalletChargeDraw(t *testing.T) {
+	db := newTestWalletDB(t)
+	w := &walletService{walletRepo: repo.NewWalletRepo()}
+	assert.Nil(t, w.creditPrize(db, &model.Result{Id: 1, UserId: 5}, 25))
+
+	// 相同抽奖请求只扣一次
+	assert.Nil(t, w.chargeDraw(db, 5, 2, "req1", 10, 0))
+	assert.Nil(t, w.chargeDraw(db, 5, 2, "req1", 10, 0))
+	assert.Nil(t, w.chargeDraw(db, 5, 2, "req2", 10, 9))
+	wallet, err := w.walletRepo.GetWallet(db, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), wallet.Balance)
+
+	// 余额不足时不扣费
+	assert.True(t, errors.Is(w.chargeDraw(db, 5, 2, "req3", 10, 0), ErrInsufficientBalance))
+	wallet, _ = w.walletRepo.GetWallet(db, 5)
+	assert.Equal(t, int64(5), wallet.Balance)
+
+	list, err := w.walletRepo.GetLedgerByTxNo(db, "draw_5_req2")
+	assert.Nil(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, int64(-10), list[0].Amount)
+	assert.Equal(t, uint(9), list[0].ResultId)
+	assert.Equal(t, constant.WalletAccountSystemDraw, list[1].Account)
+}
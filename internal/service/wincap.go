@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+	"lottery_single/internal/pkg/middlewares/cache"
+	"lottery_single/internal/pkg/middlewares/gormcli"
+	"lottery_single/internal/pkg/middlewares/log"
+	"lottery_single/internal/pkg/utils"
+	"lottery_single/internal/repo"
+	"time"
+)
+
+// ErrInvalidWinCap 中奖次数上限规则不合法
+var ErrInvalidWinCap = errors.New("invalid win cap")
+
+// WinCapService 中奖次数上限，按照用户或者IP统计每天或者活动期间的中奖次数，超过上限时按照没有中奖处理
+type WinCapService interface {
+	ListCaps(ctx context.Context, activityID uint) ([]*model.WinCap, error)
+	AddCap(ctx context.Context, winCap *model.WinCap) error
+	DeleteCap(ctx context.Context, id uint) (bool, error)
+	Reserve(ctx context.Context, activity *model.Activity, uid uint, ip string, prize *LotteryPrize) (*WinCapReservation,
+		bool, error)
+	Release(ctx context.Context, reservation *WinCapReservation)
+	HasCaps(ctx context.Context, activityID uint) (bool, error)
+}
+
+type winCapService struct {
+	winCapRepo   *repo.WinCapRepo
+	activityRepo *repo.ActivityRepo
+	prizeRepo    *repo.PrizeReop
+}
+
+var winCapServiceImpl *winCapService
+
+func InitWinCapService() {
+	winCapServiceImpl = &winCapService{
+		winCapRepo:   repo.NewWinCapRepo(),
+		activityRepo: repo.NewActivityRepo(),
+		prizeRepo:    repo.NewPrizeRepo(),
+	}
+}
+
+func GetWinCapService() WinCapService {
+	return winCapServiceImpl
+}
+
+// LuaWinCapReserve 中奖次数计数全部加1，任意一个超过上限时全部回退
+// KEYS: 每个规则的计数key
+// ARGV: 每个规则依次为 计数成员，上限，过期秒数
+// 返回: {超过上限的规则序号，从1开始，0表示都没有超过}
+const LuaWinCapReserve = `
+  for i = 1, #KEYS do
+    local num = redis.call('hincrby', KEYS[i], ARGV[i*3-2], 1)
+    redis.call('expire', KEYS[i], ARGV[i*3])
+    if num > tonumber(ARGV[i*3-1]) then
+      for j = 1, i do
+        redis.call('hincrby', KEYS[j], ARGV[j*3-2], -1)
+      end
+      return {i}
+    end
+  end
+  return {0}
+`
+
+// checkWinCap 校验规则的参数
+func checkWinCap(winCap *model.WinCap) error {
+	switch {
+	case winCap.ActivityId == 0:
+		return fmt.Errorf("%w:activity_id is required", ErrInvalidWinCap)
+	case winCap.Scope != constant.WinCapScopeUser && winCap.Scope != constant.WinCapScopeIp:
+		return fmt.Errorf("%w:invalid scope %d", ErrInvalidWinCap, winCap.Scope)
+	case winCap.PrizeKind > constant.WinCapKindVirtual:
+		return fmt.Errorf("%w:invalid prize_kind %d", ErrInvalidWinCap, winCap.PrizeKind)
+	case winCap.Period != constant.WinCapPeriodDay && winCap.Period != constant.WinCapPeriodActivity:
+		return fmt.Errorf("%w:invalid period %d", ErrInvalidWinCap, winCap.Period)
+	case winCap.MaxWins <= 0:
+		return fmt.Errorf("%w:max_wins must be positive", ErrInvalidWinCap)
+	}
+	return nil
+}
+
+// matchWinCap 规则是否限制该奖品
+func matchWinCap(winCap *model.WinCap, prize *LotteryPrize) bool {
+	if winCap.PrizeId > 0 && winCap.PrizeId != prize.Id {
+		return false
+	}
+	entity := prize.PrizeType >= constant.PrizeTypeEntitySmall
+	switch winCap.PrizeKind {
+	case constant.WinCapKindEntity:
+		return entity
+	case constant.WinCapKindVirtual:
+		return !entity
+	}
+	return true
+}
+
+// winCapCounter 规则在当前周期的计数key和过期秒数，每天的计数保留到第二天结束，活动期间的计数保留到活动结束之后一天
+func winCapCounter(winCap *model.WinCap, activity *model.Activity, now time.Time) (string, int64) {
+	if winCap.Period == constant.WinCapPeriodActivity {
+		ttl := int64(activity.EndTime.Sub(now).Seconds()) + 86400
+		if ttl < 86400 {
+			ttl = 86400
+		}
+		return fmt.Sprintf(constant.WinCapCountKeyPrefix+"%d_all", winCap.Id), ttl
+	}
+	y, m, d := now.Date()
+	tomorrow := time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+	return fmt.Sprintf(constant.WinCapCountKeyPrefix+"%d_%d%02d%02d", winCap.Id, y, m, d),
+		int64(tomorrow.Sub(now).Seconds()) + 86400
+}
+
+// ListCaps 查询活动的规则
+func (w *winCapService) ListCaps(ctx context.Context, activityID uint) ([]*model.WinCap, error) {
+	list, err := w.winCapRepo.GetListByActivity(gormcli.GetDB(), activityID)
+	if err != nil {
+		log.ErrorContextf(ctx, "winCapService|ListCaps:%v", err)
+		return nil, fmt.Errorf("winCapService|ListCaps:%v", err)
+	}
+	return list, nil
+}
+
+// AddCap 新增规则，同时清空活动的规则缓存
+func (w *winCapService) AddCap(ctx context.Context, winCap *model.WinCap) error {
+	if err := checkWinCap(winCap); err != nil {
+		return fmt.Errorf("winCapService|AddCap:%w", err)
+	}
+	db := gormcli.GetDB()
+	activity, err := w.activityRepo.Get(db, winCap.ActivityId)
+	if err != nil {
+		log.ErrorContextf(ctx, "winCapService|AddCap:%v", err)
+		return fmt.Errorf("winCapService|AddCap:%v", err)
+	}
+	if activity == nil {
+		return fmt.Errorf("winCapService|AddCap:%w:activity %d not found", ErrInvalidWinCap, winCap.ActivityId)
+	}
+	if winCap.PrizeId > 0 {
+		prize, err := w.prizeRepo.Get(db, winCap.PrizeId)
+		if err != nil {
+			log.ErrorContextf(ctx, "winCapService|AddCap:%v", err)
+			return fmt.Errorf("winCapService|AddCap:%v", err)
+		}
+		if prize == nil || prize.ActivityId != winCap.ActivityId {
+			return fmt.Errorf("winCapService|AddCap:%w:prize %d not in activity %d", ErrInvalidWinCap,
+				winCap.PrizeId, winCap.ActivityId)
+		}
+	}
+	list, err := w.winCapRepo.GetListByActivity(db, winCap.ActivityId)
+	if err != nil {
+		log.ErrorContextf(ctx, "winCapService|AddCap:%v", err)
+		return fmt.Errorf("winCapService|AddCap:%v", err)
+	}
+	if len(list) >= constant.WinCapMaxRules {
+		return fmt.Errorf("winCapService|AddCap:%w:at most %d rules per activity", ErrInvalidWinCap,
+			constant.WinCapMaxRules)
+	}
+	if err = w.winCapRepo.Create(db, winCap); err != nil {
+		log.ErrorContextf(ctx, "winCapService|AddCap:%v", err)
+		return fmt.Errorf("winCapService|AddCap:%v", err)
+	}
+	if err = w.winCapRepo.DeleteByCache(winCap.ActivityId); err != nil {
+		log.ErrorContextf(ctx, "winCapService|AddCap:%v", err)
+		return fmt.Errorf("winCapService|AddCap:%v", err)
+	}
+	return nil
+}
+
+// DeleteCap 删除规则，同时清空活动的规则缓存，规则不存在时返回false
+func (w *winCapService) DeleteCap(ctx context.Context, id uint) (bool, error) {
+	db := gormcli.GetDB()
+	winCap, err := w.winCapRepo.Get(db, id)
+	if err != nil {
+		log.ErrorContextf(ctx, "winCapService|DeleteCap:%v", err)
+		return false, fmt.Errorf("winCapService|DeleteCap:%v", err)
+	}
+	if winCap == nil {
+		return false, nil
+	}
+	if err = w.winCapRepo.Delete(db, id); err != nil {
+		log.ErrorContextf(ctx, "winCapService|DeleteCap:%v", err)
+		return false, fmt.Errorf("winCapService|DeleteCap:%v", err)
+	}
+	if err = w.winCapRepo.DeleteByCache(winCap.ActivityId); err != nil {
+		log.ErrorContextf(ctx, "winCapService|DeleteCap:%v", err)
+		return false, fmt.Errorf("winCapService|DeleteCap:%v", err)
+	}
+	return true, nil
+}
+
+// HasCaps 活动是否配置了中奖次数上限规则
+func (w *winCapService) HasCaps(ctx context.Context, activityID uint) (bool, error) {
+	list, err := w.winCapRepo.GetListByActivityWithCache(gormcli.GetDB(), activityID)
+	if err != nil {
+		log.ErrorContextf(ctx, "winCapService|HasCaps:%v", err)
+		return false, fmt.Errorf("winCapService|HasCaps:%v", err)
+	}
+	return len(list) > 0, nil
+}
+
+// winCapMember 规则计数中的成员，按照IP统计时和抽奖次数限制一样，IPv6按照/64网段统计
+func winCapMember(winCap *model.WinCap, uid uint, ip string) string {
+	if winCap.Scope == constant.WinCapScopeIp {
+		return utils.IPLimitKey(ip)
+	}
+	return fmt.Sprint(uid)
+}
+
+// Reserve 中奖之后发奖之前占用中奖次数，超过上限时返回false，不占用任何次数
+// 没有发奖时需要调用Release归还占用的次数
+func (w *winCapService) Reserve(ctx context.Context, activity *model.Activity, uid uint, ip string,
+	prize *LotteryPrize) (*WinCapReservation, bool, error) {
+	list, err := w.winCapRepo.GetListByActivityWithCache(gormcli.GetDB(), activity.Id)
+	if err != nil {
+		log.ErrorContextf(ctx, "winCapService|Reserve:%v", err)
+		return nil, false, fmt.Errorf("winCapService|Reserve:%v", err)
+	}
+	reservation := &WinCapReservation{}
+	var args []interface{}
+	now := time.Now()
+	for _, winCap := range list {
+		if !matchWinCap(winCap, prize) {
+			continue
+		}
+		key, ttl := winCapCounter(winCap, activity, now)
+		member := winCapMember(winCap, uid, ip)
+		reservation.caps = append(reservation.caps, winCap)
+		reservation.keys = append(reservation.keys, key)
+		reservation.members = append(reservation.members, member)
+		args = append(args, member, winCap.MaxWins, ttl)
+	}
+	if len(reservation.keys) == 0 {
+		return reservation, true, nil
+	}
+	ret, err := cache.GetRedisCli().EvalResults(ctx, LuaWinCapReserve, reservation.keys, args...)
+	if err != nil {
+		log.ErrorContextf(ctx, "winCapService|Reserve:%v", err)
+		return nil, false, fmt.Errorf("winCapService|Reserve:%v", err)
+	}
+	if len(ret) != 1 {
+		return nil, false, fmt.Errorf("winCapService|Reserve invalid result %v", ret)
+	}
+	index, ok := ret[0].(int64)
+	if !ok || index < 0 || index > int64(len(reservation.keys)) {
+		return nil, false, fmt.Errorf("winCapService|Reserve invalid result %v", ret)
+	}
+	if index > 0 {
+		winCap := reservation.caps[index-1]
+		log.InfoContextf(ctx, "winCapService|Reserve capped by win_cap_id=%d prize_id=%d user_id=%d ip=%s",
+			winCap.Id, prize.Id, uid, ip)
+		return nil, false, nil
+	}
+	return reservation, true, nil
+}
+
+// Release 没有发奖时归还占用的中奖次数，失败只记录日志
+func (w *winCapService) Release(ctx context.Context, reservation *WinCapReservation) {
+	if reservation == nil {
+		return
+	}
+	for i, key := range reservation.keys {
+		if _, err := cache.GetRedisCli().HIncrBy(ctx, key, reservation.members[i], -1); err != nil {
+			log.ErrorContextf(ctx, "winCapService|Release key=%s member=%s:%v", key, reservation.members[i], err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
+)
+
+func TestCheckWinCap(t *testing.T) {
+	assert.Nil(t, checkWinCap(&model.WinCap{ActivityId: 1, Scope: constant.WinCapScopeUser,
+		Period: constant.WinCapPeriodDay, MaxWins: 3}))
+	for _, winCap := range []*model.WinCap{
+		{Scope: constant.WinCapScopeUser, Period: constant.WinCapPeriodDay, MaxWins: 3},
+		{ActivityId: 1, Scope: 3, Period: constant.WinCapPeriodDay, MaxWins: 3},
+		{ActivityId: 1, Scope: constant.WinCapScopeIp, PrizeKind: 3, Period: constant.WinCapPeriodDay, MaxWins: 3},
+		{ActivityId: 1, Scope: constant.WinCapScopeIp, Period: 0, MaxWins: 3},
+		{ActivityId: 1, Scope: constant.WinCapScopeIp, Period: constant.WinCapPeriodActivity},
+	} {
+		assert.True(t, errors.Is(checkWinCap(winCap), ErrInvalidWinCap), "%+v", winCap)
+	}
+}
+
+func TestMatchWinCap(t *testing.T) {
+	entity := &LotteryPrize{Id: 1, PrizeType: constant.PrizeTypeEntityLarge}
+	coin := &LotteryPrize{Id: 2, PrizeType: constant.PrizeTypeVirtualCoin}
+	assert.True(t, matchWinCap(&model.WinCap{}, entity))
+	assert.True(t, matchWinCap(&model.WinCap{PrizeId: 1}, entity))
+	assert.False(t, matchWinCap(&model.WinCap{PrizeId: 1}, coin))
+	assert.True(t, matchWinCap(&model.WinCap{PrizeKind: constant.WinCapKindEntity}, entity))
+	assert.False(t, matchWinCap(&model.WinCap{PrizeKind: constant.WinCapKindEntity}, coin))
+	assert.True(t, matchWinCap(&model.WinCap{PrizeKind: constant.WinCapKindVirtual}, coin))
+}
+
+func TestWinCapCounter(t *testing.T) {
+	now := time.Date(2024, 5, 6, 23, 0, 0, 0, time.Local)
+	activity := &model.Activity{EndTime: now.Add(48 * time.Hour)}
+	key, ttl := winCapCounter(&model.WinCap{Id: 3, Period: constant.WinCapPeriodDay}, activity, now)
+	assert.Equal(t, "win_cap_count_3_20240506", key)
+	assert.Equal(t, int64(3600+86400), ttl)
+
+	key, ttl = winCapCounter(&model.WinCap{Id: 3, Period: constant.WinCapPeriodActivity}, activity, now)
+	assert.True(t, strings.HasSuffix(key, "_all"))
+	assert.Equal(t, int64(3*86400), ttl)
+
+	// 活动已经结束时至少保留一天
+	_, ttl = winCapCounter(&model.WinCap{Id: 3, Period: constant.WinCapPeriodActivity},
+		&model.Activity{EndTime: now.Add(-time.Hour)}, now)
+	assert.Equal(t, int64(86400), ttl)
+}
+
+func TestWinCapMember(t *testing.T) {
+	userCap := &model.WinCap{Scope: constant.WinCapScopeUser}
+	ipCap := &model.WinCap{Scope: constant.WinCapScopeIp}
+	assert.Equal(t, "7", winCapMember(userCap, 7, "10.0.0.1"))
+	assert.Equal(t, "10.0.0.1", winCapMember(ipCap, 7, "::ffff:10.0.0.1"))
+	// 同一个/64网段中的IPv6地址共用中奖次数
+	assert.Equal(t, "2001:db8:1:2::/64", winCapMember(ipCap, 7, "2001:db8:1:2::1"))
+	assert.Equal(t, winCapMember(ipCap, 7, "2001:db8:1:2::1"), winCapMember(ipCap, 8, "2001:db8:1:2:abcd::9"))
+}
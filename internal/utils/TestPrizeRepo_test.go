@@ -10,6 +10,7 @@ import (
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"lottery_single/internal/model"
+	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/repo"
 )
 
@@ -23,7 +24,7 @@ func TestPrizeRepo(t *testing.T) {
 
 	// 在这里使用奖品仓库的方法进行测试
 	t.Run("TestGetAllWithCache", func(t *testing.T) {
-		prizes, err := prizeRepo.GetAllWithCache(db)
+		prizes, err := prizeRepo.GetAllWithCache(db, constant.DefaultActivityID)
 		assert.NoError(t, err)
 		assert.NotNil(t, prizes)
 	})
@@ -31,7 +32,7 @@ func TestPrizeRepo(t *testing.T) {
 	t.Run("TestDecrLeftNumByPool", func(t *testing.T) {
 		prizeID := 1 // 奖品ID，请确保该ID在测试数据库中存在
 		// 尝试递减奖品池中奖品的数量
-		cnt, err := prizeRepo.DecrLeftNumByPool(prizeID)
+		cnt, err := prizeRepo.DecrLeftNumByPool(constant.DefaultActivityID, prizeID)
 		assert.NoError(t, err)
 		assert.GreaterOrEqual(t, cnt, int64(0))
 	})
@@ -45,7 +46,7 @@ func TestPrizeRepo(t *testing.T) {
 			// 在这里添加更多奖品
 		}
 		// 保存奖品列表到缓存中
-		err := prizeRepo.SetAllByCache(prizes)
+		err := prizeRepo.SetAllByCache(constant.DefaultActivityID, prizes)
 		assert.NoError(t, err)
 	})
 
@@ -60,7 +61,7 @@ func TestPrizeRepo(t *testing.T) {
 
 	t.Run("TestGetAllByCache", func(t *testing.T) {
 		// 从缓存中获取所有奖品
-		prizes, err := prizeRepo.GetAllByCache()
+		prizes, err := prizeRepo.GetAllByCache(constant.DefaultActivityID)
 		assert.NoError(t, err)
 		assert.NotNil(t, prizes)
 	})
@@ -1,16 +1,19 @@
 package router
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 
 	"io"
 	"lottery_single/configs"
+	"lottery_single/internal/handlers"
 	"lottery_single/internal/pkg/constant"
 	"lottery_single/internal/pkg/middlewares/log"
 	"lottery_single/internal/pkg/utils"
+	"lottery_single/internal/service"
 	"net/http"
 	"strconv"
-	"time"
+	"strings"
 )
 
 // InitRouterAndServe 路由配置、启动服务
@@ -30,33 +33,59 @@ func InitRouterAndServe() {
 	}
 }
 
+// JWTAuth 登录鉴权中间件，管理后台和需要登录的用户接口使用，token放在Authorization头中，支持 Bearer 前缀
 func JWTAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := c.Request.Header.Get("Authorization")
+		token := strings.TrimSpace(strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "))
 		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"status": -1, "msg": "请求未携带token，无权限访问"})
-			c.Abort()
+			abortWithCode(c, http.StatusUnauthorized, constant.ErrUnauthorized)
 			return
-
 		}
-		claims, err := utils.ParseJwtToken(token, constant.SecretKey)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"status": -1, "msg": err.Error()})
-			c.Abort()
+		claims, err := service.GetUserService().ParseToken(c.Request.Context(), token)
+		switch {
+		case errors.Is(err, service.ErrTokenExpired):
+			abortWithCode(c, http.StatusUnauthorized, constant.ErrTokenExpired)
+			return
+		case errors.Is(err, service.ErrTokenInvalid):
+			log.Infof("JWTAuth|ParseToken:%v", err)
+			abortWithCode(c, http.StatusUnauthorized, constant.ErrUnauthorized)
+			return
+		case err != nil:
+			log.Errorf("JWTAuth|ParseToken:%v", err)
+			abortWithCode(c, http.StatusInternalServerError, constant.ErrInternalServer)
 			return
 		}
-		//token超时
-		if time.Now().Unix() > claims.StandardClaims.ExpiresAt {
-			c.JSON(http.StatusUnauthorized, gin.H{"status": -1, "msg": "token过期"})
-			c.Abort() //阻止执行
+		c.Set(constant.JWTUserKey, claims)
+		c.Next()
+	}
+}
+
+// RequirePermission 权限校验中间件，需要在JWTAuth之后使用
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get(constant.JWTUserKey)
+		claims, _ := value.(*utils.JWTClaims)
+		if !ok || claims == nil {
+			abortWithCode(c, http.StatusUnauthorized, constant.ErrUnauthorized)
+			return
+		}
+		if !constant.HasPermission(claims.Roles, perm) {
+			log.Infof("RequirePermission|user_id=%d roles=%v has no permission %s", claims.UserID, claims.Roles, perm)
+			abortWithCode(c, http.StatusForbidden, constant.ErrForbidden)
 			return
 		}
-		//可以在这里做权限校验
-		c.Set("jwtUser", claims)
 		c.Next()
 	}
 }
 
+// abortWithCode 按照统一的返回格式结束请求
+func abortWithCode(c *gin.Context, status int, code constant.ErrCode) {
+	c.AbortWithStatusJSON(status, handlers.HttpResponse{
+		Code: code,
+		Msg:  constant.GetErrMsg(code),
+	})
+}
+
 // setAppRunMode 设置运行模式
 func setAppRunMode() {
 	if configs.GetGlobalConfig().AppConfig.RunMode == "release" {
@@ -3,6 +3,7 @@ package router
 import (
 	"github.com/gin-gonic/gin"
 	"lottery_single/internal/handlers"
+	"lottery_single/internal/pkg/constant"
 	"net/http"
 )
 
@@ -28,36 +29,68 @@ func setRoutes(r *gin.Engine) {
 	setAdminRoutes(r)
 	setLotteryRoutes(r)
 	setBlackIpRoutes(r)
+	setBlackUserRoutes(r)
+	setActivityRoutes(r)
+	setDrawAuditRoutes(r)
+	setFulfilmentRoutes(r)
+	setWalletRoutes(r)
 }
 
 func setAdminRoutes(r *gin.Engine) {
-	adminGroup := r.Group("admin")
+	// 登录和注册不需要鉴权
+	publicGroup := r.Group("admin")
+	// 用户登录
+	publicGroup.POST("/login", handlers.Login)
+	//注册
+	publicGroup.POST("/register", handlers.Register)
+	// 刷新token
+	publicGroup.POST("/refresh_token", handlers.RefreshToken)
+
+	adminGroup := r.Group("admin", JWTAuth())
+	// 退出登录，所有登录用户都可以调用
+	adminGroup.POST("/logout", handlers.Logout)
 
 	// 获取奖品列表
-	adminGroup.GET("/get_prize_list", handlers.GetPrizeList)
+	adminGroup.GET("/get_prize_list", RequirePermission(constant.PermPrizeView), handlers.GetPrizeList)
 	// 设置添加抽奖奖品
-	adminGroup.POST("/add_prize", handlers.PrizeAdd)
+	adminGroup.POST("/add_prize", RequirePermission(constant.PermPrizeEdit), handlers.PrizeAdd)
+	// 修改抽奖奖品
+	adminGroup.PUT("/update_prize", RequirePermission(constant.PermPrizeEdit), handlers.UpdatePrize)
 
 	// 上传图片
-	adminGroup.POST("/upload", handlers.UploadImage)
+	adminGroup.POST("/upload", RequirePermission(constant.PermPrizeEdit), handlers.UploadImage)
 	// 删除奖品
-	adminGroup.DELETE("/delete_prize/:id", handlers.DeletePrize)
+	adminGroup.DELETE("/delete_prize/:id", RequirePermission(constant.PermPrizeEdit), handlers.DeletePrize)
+
+	// 查询中奖记录
+	adminGroup.GET("/results", RequirePermission(constant.PermResultView), handlers.ListResults)
+	// 修改中奖记录状态，删除或者判定作弊
+	adminGroup.PUT("/results/:id/status", RequirePermission(constant.PermResultEdit), handlers.UpdateResultStatus)
 
 	// 导入优惠券
-	adminGroup.POST("/import_coupon", handlers.CouponImport)
+	adminGroup.POST("/import_coupon", RequirePermission(constant.PermCouponEdit), handlers.CouponImport)
+	// 下载优惠券导入的错误报告
+	adminGroup.GET("/import_coupon/report/:id", RequirePermission(constant.PermCouponView), handlers.GetCouponImportReport)
 	// 获取优惠券列表
-	adminGroup.GET("/get_coupon_list", handlers.GetCouponList)
-
-	// 用户登录
-	adminGroup.POST("/login", handlers.Login)
-	//注册
-	adminGroup.POST("/register", handlers.Register)
+	adminGroup.GET("/get_coupon_list", RequirePermission(constant.PermCouponView), handlers.GetCouponList)
+	// 作废优惠券
+	adminGroup.POST("/coupon/void", RequirePermission(constant.PermCouponEdit), handlers.VoidCoupons)
+	// 恢复作废的优惠券
+	adminGroup.POST("/coupon/restore", RequirePermission(constant.PermCouponEdit), handlers.RestoreCoupons)
+	// 优惠券缓存对账
+	adminGroup.POST("/coupon/recache", RequirePermission(constant.PermCouponEdit), handlers.RecacheCoupons)
+	// 优惠券缓存对账记录
+	adminGroup.GET("/coupon/reconcile_logs", RequirePermission(constant.PermCouponView), handlers.ListCouponReconciles)
 
 	//用户管理
-	adminGroup.POST("/add_user", handlers.AddUser)
-	adminGroup.PUT("/update_user/:userID", handlers.UpdateUser)
-	adminGroup.DELETE("/delete_user/:userID", handlers.DeleteUser)
-	adminGroup.GET("/get_all_users", handlers.GetAllUsers)
+	userGroup := adminGroup.Group("", RequirePermission(constant.PermUserManage))
+	userGroup.POST("/add_user", handlers.AddUser)
+	userGroup.PUT("/update_user/:userID", handlers.UpdateUser)
+	userGroup.DELETE("/delete_user/:userID", handlers.DeleteUser)
+	userGroup.GET("/get_all_users", handlers.GetAllUsers)
+	// 用户角色管理
+	userGroup.GET("/user_roles/:userID", handlers.GetUserRoles)
+	userGroup.PUT("/user_roles/:userID", handlers.SetUserRoles)
 }
 
 func setLotteryRoutes(r *gin.Engine) {
@@ -66,18 +99,88 @@ func setLotteryRoutes(r *gin.Engine) {
 	lotteryGroup.POST("/v1/get_lucky", handlers.LotteryV1)
 	// 优化V1版中奖逻辑
 	lotteryGroup.POST("/v2/get_lucky", handlers.LotteryV2)
+	// 奖品池、事务发奖、请求幂等版本
+	lotteryGroup.POST("/v3/get_lucky", handlers.LotteryV3)
+	// V3版本连抽，一次请求完成多次抽奖
+	lotteryGroup.POST("/v3/get_lucky_bundle", handlers.LotteryBundle)
 
 	//lotteryGroup.Use(AuthMiddleWare())
 	// 抽奖结果展示
 	lotteryGroup.GET("/show_results", handlers.ShowLotteryResult)
+	// 登录用户自己的中奖记录
+	lotteryGroup.GET("/my_results", JWTAuth(), handlers.MyResults)
+	// 中奖用户提交实物奖品的收货地址
+	lotteryGroup.POST("/fulfilment/address", JWTAuth(), handlers.SubmitFulfilmentAddress)
+	// 登录用户自己的发货单
+	lotteryGroup.GET("/my_fulfilments", JWTAuth(), handlers.MyFulfilments)
+	// 登录用户的虚拟币余额和余额变动记录
+	lotteryGroup.GET("/my_wallet", JWTAuth(), handlers.MyWallet)
+	// 抽奖种子的承诺值和已经公开的种子
+	lotteryGroup.GET("/draw_seeds", handlers.ListDrawSeeds)
 }
 
 func setBlackIpRoutes(r *gin.Engine) {
-	blackIpGroup := r.Group("/admin/blackip")
+	blackIpGroup := r.Group("/admin/blackip", JWTAuth())
 	// 添加IP到黑名单
-	blackIpGroup.POST("/add", handlers.AddBlackIP)
+	blackIpGroup.POST("/add", RequirePermission(constant.PermBlackListEdit), handlers.AddBlackIP)
+	// 批量导入IP黑名单
+	blackIpGroup.POST("/import", RequirePermission(constant.PermBlackListEdit), handlers.ImportBlackIP)
 	// 删除黑名单中的IP
-	blackIpGroup.DELETE("/delete/:id", handlers.DeleteBlackIP)
+	blackIpGroup.DELETE("/delete/:id", RequirePermission(constant.PermBlackListEdit), handlers.DeleteBlackIP)
 	// 查看所有黑名单IP
-	blackIpGroup.GET("/list", handlers.ListBlackIP)
+	blackIpGroup.GET("/list", RequirePermission(constant.PermBlackListView), handlers.ListBlackIP)
+}
+
+func setBlackUserRoutes(r *gin.Engine) {
+	blackUserGroup := r.Group("/admin/blackuser", JWTAuth())
+	// 拉黑用户，按周、月、年或者自定义时长
+	blackUserGroup.POST("/ban", RequirePermission(constant.PermBlackListEdit), handlers.BanUser)
+	// 洗白用户
+	blackUserGroup.POST("/lift", RequirePermission(constant.PermBlackListEdit), handlers.LiftUserBan)
+	// 查看有效或者过期的黑名单用户
+	blackUserGroup.GET("/list", RequirePermission(constant.PermBlackListView), handlers.ListBlackUsers)
+	// 查看用户被拉黑和洗白的操作记录
+	blackUserGroup.GET("/logs", RequirePermission(constant.PermBlackListView), handlers.ListBlackUserLogs)
+}
+
+func setActivityRoutes(r *gin.Engine) {
+	activityGroup := r.Group("/admin/activity", JWTAuth())
+	// 新增抽奖活动
+	activityGroup.POST("/add", RequirePermission(constant.PermActivityEdit), handlers.AddActivity)
+	// 修改抽奖活动
+	activityGroup.PUT("/update/:id", RequirePermission(constant.PermActivityEdit), handlers.UpdateActivity)
+	// 查看所有抽奖活动
+	activityGroup.GET("/list", RequirePermission(constant.PermActivityView), handlers.ListActivity)
+	// 活动的中奖次数上限规则
+	activityGroup.GET("/win_caps", RequirePermission(constant.PermActivityView), handlers.ListWinCaps)
+	activityGroup.POST("/win_cap/add", RequirePermission(constant.PermActivityEdit), handlers.AddWinCap)
+	activityGroup.DELETE("/win_cap/delete/:id", RequirePermission(constant.PermActivityEdit), handlers.DeleteWinCap)
+}
+
+func setDrawAuditRoutes(r *gin.Engine) {
+	drawAuditGroup := r.Group("/admin/draw_audit", JWTAuth())
+	// 查询用户的抽奖审计记录
+	drawAuditGroup.GET("/list", RequirePermission(constant.PermDrawAuditView), handlers.ListDrawAudits)
+	// 校验一次抽奖
+	drawAuditGroup.GET("/verify/:id", RequirePermission(constant.PermDrawAuditView), handlers.VerifyDraw)
+	// 公开当前种子并生成新种子
+	drawAuditGroup.POST("/rotate_seed", RequirePermission(constant.PermDrawSeedEdit), handlers.RotateDrawSeed)
+}
+
+func setFulfilmentRoutes(r *gin.Engine) {
+	fulfilmentGroup := r.Group("/admin/fulfilment", JWTAuth())
+	// 查询发货单
+	fulfilmentGroup.GET("/list", RequirePermission(constant.PermFulfilmentView), handlers.ListFulfilments)
+	// 导出发货单给仓库
+	fulfilmentGroup.GET("/export", RequirePermission(constant.PermFulfilmentView), handlers.ExportFulfilments)
+	// 发货、签收或者取消发货单
+	fulfilmentGroup.PUT("/:id/status", RequirePermission(constant.PermFulfilmentEdit), handlers.UpdateFulfilmentStatus)
+}
+
+func setWalletRoutes(r *gin.Engine) {
+	walletGroup := r.Group("/admin/wallet", JWTAuth())
+	// 查询用户的虚拟币余额和余额变动记录
+	walletGroup.GET("/ledgers", RequirePermission(constant.PermWalletView), handlers.ListWalletLedgers)
+	// 调整用户的虚拟币余额
+	walletGroup.POST("/adjust", RequirePermission(constant.PermWalletEdit), handlers.AdjustWallet)
 }
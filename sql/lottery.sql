@@ -1,18 +1,49 @@
 use lottery_system;
 
+DROP TABLE IF EXISTS `t_activity`;
+CREATE TABLE `t_activity`
+(
+    `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+    `title` varchar(255) NOT NULL DEFAULT '' COMMENT '活动名称',
+    `description` varchar(1024) NOT NULL DEFAULT '' COMMENT '活动描述',
+    `begin_time` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '活动开始时间',
+    `end_time` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '活动结束时间',
+    `user_day_limit` int(11) NOT NULL DEFAULT '0' COMMENT '每个用户每天最多抽奖次数，0-使用配置',
+    `ip_day_limit` int(11) NOT NULL DEFAULT '0' COMMENT '同一个IP每天最多抽奖次数，0-使用配置',
+    `black_time` int(11) NOT NULL DEFAULT '0' COMMENT '中实物大奖之后拉黑的时间，单位秒，0-使用配置',
+    `black_policy` smallint(5) unsigned NOT NULL DEFAULT '0' COMMENT '黑名单策略，0-校验并且中大奖拉黑，1-只校验，2-不校验',
+    `draw_cost` bigint(20) NOT NULL DEFAULT '0' COMMENT '每次抽奖扣除的虚拟币，0-免费',
+    `bundle_guarantee` smallint(5) unsigned NOT NULL DEFAULT '0' COMMENT '连抽保底，0-不保底，1-十连抽至少中奖一次',
+    `pity_boost_after` int(11) NOT NULL DEFAULT '0' COMMENT '连续没有中奖的次数达到之后开始提高中奖权重，0-不提高',
+    `pity_boost_step` int(11) NOT NULL DEFAULT '0' COMMENT '超过之后每多一次没有中奖，中奖权重提高的百分比',
+    `pity_guarantee_after` int(11) NOT NULL DEFAULT '0' COMMENT '连续没有中奖的次数达到之后下一次必中，0-不保底',
+    `pity_prize_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '保底的奖品ID，0-有库存的任意奖品',
+    `risk_rules` varchar(2048) NOT NULL DEFAULT '' COMMENT '风控规则链，json数组，空表示不启用',
+    `sys_status` smallint(5) unsigned NOT NULL DEFAULT '1' COMMENT '状态，1-正常，2-关闭',
+    `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
+    `sys_updated` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '修改时间',
+    PRIMARY KEY (`id`)
+)ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='抽奖活动表';
+
+-- 默认活动，兼容没有指定活动的奖品和抽奖请求
+INSERT INTO `t_activity` (`id`, `title`, `description`, `begin_time`, `end_time`, `user_day_limit`, `ip_day_limit`, `black_time`, `black_policy`, `sys_status`, `sys_created`, `sys_updated`)
+VALUES (1, '默认活动', '未指定活动的奖品和抽奖请求都属于默认活动', '2000-01-01 00:00:00', '2099-12-31 23:59:59', 0, 0, 0, 0, 1, NOW(), NOW());
+
 DROP TABLE IF EXISTS `t_prize`;
 CREATE TABLE `t_prize`
 (
     `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+    `activity_id` int(10) unsigned NOT NULL DEFAULT '1' COMMENT '所属活动ID，关联t_activity表',
     `title` varchar(255) NOT NULL DEFAULT '' COMMENT '奖品名称',
     `prize_num` int(11) NOT NULL DEFAULT '-1' COMMENT '奖品数量，0 无限量，>0限量，<0无奖品',
     `left_num` int(11) NOT NULL DEFAULT '0' COMMENT '剩余数量',
-    `prize_code` varchar(50) NOT NULL DEFAULT '' COMMENT '0-9999表示100%，0-0表示万分之一的中奖概率',
+    `prize_code` varchar(50) NOT NULL DEFAULT '' COMMENT '0-9999表示100%，0-0表示万分之一的中奖概率，旧版字段，未设置中奖概率时自动折算',
+    `probability` decimal(10,7) NOT NULL DEFAULT '0.0000000' COMMENT '中奖概率，1表示100%，最小精度0.0000001',
     `prize_time` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '发奖周期，多少天，以天为单位',
     `img` varchar(255) NOT NULL DEFAULT '' COMMENT '奖品图片',
     `display_order` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '位置序号，小的排在前面',
     `prize_type` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '奖品类型，1-虚拟币，2-虚拟券，3-实物小奖，4-实物大奖',
-    `prize_profile` varchar(255) NOT NULL DEFAULT '' COMMENT '奖品扩展数据，如：虚拟币数量',
+    `prize_profile` varchar(1024) NOT NULL DEFAULT '' COMMENT '奖品扩展数据，如：虚拟币数量，相同编码优惠券的信息',
     `begin_time` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '奖品有效周期：开始时间',
     `end_time` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '奖品有效周期：结束时间',
     `prize_plan` mediumtext COMMENT '发奖计划，[[时间1,数量1],[时间2,数量2]]',
@@ -22,7 +53,8 @@ CREATE TABLE `t_prize`
     `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
     `sys_updated` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT'修改时间',
     `sys_ip` varchar(50) NOT NULL DEFAULT '' COMMENT '操作人IP',
-    PRIMARY KEY (`id`)
+    PRIMARY KEY (`id`),
+    KEY `idx_activity_id` (`activity_id`)
 )ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='奖品表';
 
 
@@ -31,34 +63,158 @@ CREATE TABLE `t_coupon` (
                             `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
                             `prize_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '奖品ID，关联lt_prize表',
                             `code` varchar(255) NOT NULL DEFAULT '' COMMENT '虚拟券编码',
+                            `batch_no` varchar(64) NOT NULL DEFAULT '' COMMENT '导入批次号',
+                            `valid_from` datetime DEFAULT NULL COMMENT '有效期开始时间，为空表示不限',
+                            `valid_to` datetime DEFAULT NULL COMMENT '有效期结束时间，为空表示不限',
                             `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
                             `sys_updated` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '更新时间',
-                            `sys_status` smallint(5) unsigned NOT NULL DEFAULT '1' COMMENT '状态，1-正常，2-作废，3-已发放',
+                            `sys_status` smallint(5) unsigned NOT NULL DEFAULT '1' COMMENT '状态，1-正常，2-已发放，3-已作废，4-已过期，5-已收回',
                             PRIMARY KEY (`id`),
                             UNIQUE KEY `uk_code` (`code`),
-                            KEY `idx_prize_id` (`prize_id`)
+                            KEY `idx_prize_id` (`prize_id`),
+                            KEY `idx_prize_batch` (`prize_id`, `batch_no`),
+                            KEY `idx_status_valid_to` (`sys_status`, `valid_to`)
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='优惠券表';
 
 
+DROP TABLE IF EXISTS `t_coupon_reconcile`;
+CREATE TABLE `t_coupon_reconcile` (
+                                      `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+                                      `prize_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '奖品ID',
+                                      `source` varchar(32) NOT NULL DEFAULT '' COMMENT '触发方式，task定时任务，admin后台',
+                                      `dry_run` tinyint(1) NOT NULL DEFAULT '0' COMMENT '是否只对比不修正',
+                                      `db_num` int(10) NOT NULL DEFAULT '0' COMMENT '数据库中可以发放的优惠券数量',
+                                      `cache_num` int(10) NOT NULL DEFAULT '0' COMMENT '对账前缓存中的优惠券数量',
+                                      `added_num` int(10) NOT NULL DEFAULT '0' COMMENT '缓存中缺少的数量',
+                                      `removed_num` int(10) NOT NULL DEFAULT '0' COMMENT '缓存中多出的数量',
+                                      `added_codes` text COMMENT '加入缓存的编码，最多保存100个，json数组',
+                                      `removed_codes` text COMMENT '移出缓存的编码，最多保存100个，json数组',
+                                      `err_msg` varchar(255) NOT NULL DEFAULT '' COMMENT '修正失败的原因',
+                                      `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
+                                      PRIMARY KEY (`id`),
+                                      KEY `idx_prize_id` (`prize_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='优惠券缓存对账记录表';
+
+
 DROP TABLE IF EXISTS `t_result`;
 CREATE TABLE `t_result` (
                             `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+                            `activity_id` int(10) unsigned NOT NULL DEFAULT '1' COMMENT '活动ID，关联t_activity表',
                             `prize_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '奖品ID，关联lt_prize表',
                             `prize_name` varchar(255) NOT NULL DEFAULT '' COMMENT '奖品名称',
                             `prize_type` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '奖品类型，同lt_prize. gtype',
                             `user_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '用户ID',
                             `user_name` varchar(50) NOT NULL DEFAULT '' COMMENT '用户名',
-                            `prize_code` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '抽奖编号（4位的随机数）',
-                            `prize_data` varchar(255) NOT NULL DEFAULT '' COMMENT '获奖信息',
+                            `prize_code` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '抽奖编号，抽奖引擎编码空间内的随机数',
+                            `prize_data` varchar(1024) NOT NULL DEFAULT '' COMMENT '获奖信息，不同编码优惠券为发放的编码，相同编码优惠券为优惠券信息json',
+                            `request_id` varchar(64) NOT NULL DEFAULT '' COMMENT '抽奖请求ID，客户端重试时保持不变，用于防止重复发奖',
                             `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
                             `sys_ip` varchar(50) NOT NULL DEFAULT '' COMMENT '用户抽奖的IP',
-                            `sys_status` smallint(5) unsigned NOT NULL DEFAULT '1' COMMENT '状态，1-正常，2-删除，3-作弊',
+                            `sys_status` smallint(5) unsigned NOT NULL DEFAULT '0' COMMENT '状态，0-正常，1-删除，2-作弊',
                             PRIMARY KEY (`id`),
                             KEY `idx_user_id` (`user_id`),
-                            KEY `idx_prize_id` (`prize_id`)
+                            KEY `idx_prize_id` (`prize_id`),
+                            KEY `idx_activity_id` (`activity_id`),
+                            KEY `idx_user_request` (`user_id`,`request_id`),
+                            KEY `idx_user_created` (`user_id`,`sys_created`),
+                            KEY `idx_sys_ip` (`sys_ip`),
+                            KEY `idx_sys_created` (`sys_created`),
+                            KEY `idx_prize_type` (`prize_type`,`sys_status`)
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='抽奖记录表';
 
 
+DROP TABLE IF EXISTS `t_fulfilment_order`;
+CREATE TABLE `t_fulfilment_order` (
+                                      `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+                                      `result_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '中奖记录ID，关联t_result表',
+                                      `activity_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '活动ID',
+                                      `prize_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '奖品ID',
+                                      `prize_name` varchar(255) NOT NULL DEFAULT '' COMMENT '奖品名称',
+                                      `prize_type` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '奖品类型',
+                                      `user_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '用户ID',
+                                      `user_name` varchar(50) NOT NULL DEFAULT '' COMMENT '用户名',
+                                      `receiver` varchar(50) NOT NULL DEFAULT '' COMMENT '收件人',
+                                      `mobile` varchar(20) NOT NULL DEFAULT '' COMMENT '收件人电话',
+                                      `province` varchar(50) NOT NULL DEFAULT '' COMMENT '省',
+                                      `city` varchar(50) NOT NULL DEFAULT '' COMMENT '市',
+                                      `district` varchar(50) NOT NULL DEFAULT '' COMMENT '区县',
+                                      `address` varchar(255) NOT NULL DEFAULT '' COMMENT '详细地址',
+                                      `tracking_company` varchar(50) NOT NULL DEFAULT '' COMMENT '快递公司',
+                                      `tracking_no` varchar(64) NOT NULL DEFAULT '' COMMENT '快递单号',
+                                      `remark` varchar(255) NOT NULL DEFAULT '' COMMENT '备注，取消时为取消原因',
+                                      `shipped_time` datetime DEFAULT NULL COMMENT '发货时间',
+                                      `delivered_time` datetime DEFAULT NULL COMMENT '签收时间',
+                                      `sys_status` smallint(5) unsigned NOT NULL DEFAULT '1' COMMENT '状态，1待发货，2已发货，3已签收，4已取消',
+                                      `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
+                                      `sys_updated` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '修改时间',
+                                      PRIMARY KEY (`id`),
+                                      UNIQUE KEY `uk_result_id` (`result_id`),
+                                      KEY `idx_user_id` (`user_id`),
+                                      KEY `idx_status` (`sys_status`,`activity_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='实物奖品发货单表';
+
+
+DROP TABLE IF EXISTS `t_win_cap`;
+CREATE TABLE `t_win_cap` (
+                            `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+                            `activity_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '活动ID',
+                            `scope` smallint(5) unsigned NOT NULL DEFAULT '1' COMMENT '计数对象，1-用户，2-IP',
+                            `prize_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '限制的奖品ID，0-不限奖品',
+                            `prize_kind` smallint(5) unsigned NOT NULL DEFAULT '0' COMMENT '限制的奖品种类，0-全部，1-实物奖品，2-虚拟奖品',
+                            `period` smallint(5) unsigned NOT NULL DEFAULT '1' COMMENT '计数周期，1-每天，2-活动期间',
+                            `max_wins` int(11) NOT NULL DEFAULT '0' COMMENT '周期内最多中奖次数',
+                            `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
+                            `sys_updated` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '修改时间',
+                            PRIMARY KEY (`id`),
+                            KEY `idx_activity_id` (`activity_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='中奖次数上限规则表';
+
+
+DROP TABLE IF EXISTS `t_pity_counter`;
+CREATE TABLE `t_pity_counter` (
+                            `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+                            `activity_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '活动ID',
+                            `user_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '用户ID',
+                            `losses` bigint(20) NOT NULL DEFAULT '0' COMMENT '连续没有中奖的次数',
+                            `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
+                            `sys_updated` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '修改时间',
+                            PRIMARY KEY (`id`),
+                            UNIQUE KEY `uk_activity_user` (`activity_id`,`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='连续未中奖计数表';
+
+
+DROP TABLE IF EXISTS `t_wallet`;
+CREATE TABLE `t_wallet` (
+                            `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+                            `user_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '用户ID',
+                            `balance` bigint(20) NOT NULL DEFAULT '0' COMMENT '虚拟币余额',
+                            `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
+                            `sys_updated` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '修改时间',
+                            PRIMARY KEY (`id`),
+                            UNIQUE KEY `uk_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='虚拟币钱包表';
+
+
+DROP TABLE IF EXISTS `t_wallet_ledger`;
+CREATE TABLE `t_wallet_ledger` (
+                                   `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+                                   `tx_no` varchar(100) NOT NULL DEFAULT '' COMMENT '交易号，同一笔交易的分录相同，用于幂等',
+                                   `account` varchar(32) NOT NULL DEFAULT '' COMMENT '账户，user用户钱包，system_prize中奖发放，system_adjust后台调整',
+                                   `user_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '用户ID',
+                                   `amount` bigint(20) NOT NULL DEFAULT '0' COMMENT '金额，入账为正，出账为负',
+                                   `balance_after` bigint(20) NOT NULL DEFAULT '0' COMMENT '记账之后的用户余额，系统账户为0',
+                                   `biz_type` varchar(32) NOT NULL DEFAULT '' COMMENT '业务类型，prize中奖，prize_revoke作弊收回，adjust后台调整',
+                                   `result_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '关联的中奖记录ID',
+                                   `reason` varchar(255) NOT NULL DEFAULT '' COMMENT '原因',
+                                   `operator` varchar(50) NOT NULL DEFAULT '' COMMENT '操作人，后台调整时为管理员',
+                                   `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
+                                   PRIMARY KEY (`id`),
+                                   UNIQUE KEY `uk_tx_account` (`tx_no`,`account`),
+                                   KEY `idx_user_account` (`user_id`,`account`),
+                                   KEY `idx_result_id` (`result_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='虚拟币账本表';
+
+
 DROP TABLE IF EXISTS `t_black_user`;
 CREATE TABLE `t_black_user` (
                                 `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
@@ -71,18 +227,37 @@ CREATE TABLE `t_black_user` (
                                 `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
                                 `sys_updated` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '修改时间',
                                 `sys_ip` varchar(50) NOT NULL DEFAULT '' COMMENT 'IP地址',
+                                `reason` varchar(255) NOT NULL DEFAULT '' COMMENT '最近一次拉黑或者洗白的原因',
+                                `operator` varchar(50) NOT NULL DEFAULT '' COMMENT '最近一次拉黑或者洗白的管理员，空表示系统自动拉黑',
                                 PRIMARY KEY (`id`),
-                                KEY `idx_user_name` (`user_name`)
+                                UNIQUE KEY `uk_user_id` (`user_id`),
+                                KEY `idx_user_name` (`user_name`),
+                                KEY `idx_black_time` (`black_time`)
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='用户黑明单表';
 
+DROP TABLE IF EXISTS `t_black_user_log`;
+CREATE TABLE `t_black_user_log` (
+                                `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+                                `user_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '被操作的用户ID',
+                                `action` varchar(16) NOT NULL DEFAULT '' COMMENT '操作，ban-拉黑，lift-洗白',
+                                `black_time` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '操作之后的黑名单到期时间',
+                                `reason` varchar(255) NOT NULL DEFAULT '' COMMENT '原因',
+                                `operator` varchar(50) NOT NULL DEFAULT '' COMMENT '操作的管理员',
+                                `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
+                                PRIMARY KEY (`id`),
+                                KEY `idx_user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='后台拉黑和洗白用户的操作记录表';
+
 
 DROP TABLE IF EXISTS `t_black_ip`;
 CREATE TABLE `t_black_ip` (
                               `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
-                              `ip` varchar(50) NOT NULL DEFAULT '' COMMENT 'IP地址',
+                              `ip` varchar(50) NOT NULL DEFAULT '' COMMENT 'IP地址或者CIDR网段，支持IPv4和IPv6',
                               `black_time` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '黑名单限制到期时间',
                               `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
                               `sys_updated` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '修改时间',
+                              `reason` varchar(255) NOT NULL DEFAULT '' COMMENT '最近一次拉黑的原因',
+                              `operator` varchar(50) NOT NULL DEFAULT '' COMMENT '最近一次拉黑的管理员，空表示系统自动拉黑',
                               PRIMARY KEY (`id`),
                               UNIQUE KEY `uk_ip` (`ip`)
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 comment='ip黑明单表';
@@ -91,13 +266,14 @@ CREATE TABLE `t_black_ip` (
 DROP TABLE IF EXISTS `t_lottery_times`;
 CREATE TABLE `t_lottery_times` (
                                    `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+                                   `activity_id` int(10) unsigned NOT NULL DEFAULT '1' COMMENT '活动ID，关联t_activity表',
                                    `user_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '用户ID',
                                    `day` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '日期，如：20220625',
                                    `num` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '次数',
                                    `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
                                    `sys_updated` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '修改时间',
                                    PRIMARY KEY (`id`),
-                                   UNIQUE KEY `idx_user_id_day` (`user_id`,`day`)
+                                   UNIQUE KEY `idx_activity_user_day` (`activity_id`,`user_id`,`day`)
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 comment='用户每日抽奖次数表';
 
 
@@ -121,4 +297,54 @@ CREATE TABLE `t_user` (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='用户表';
 
 
+DROP TABLE IF EXISTS `t_user_role`;
+CREATE TABLE `t_user_role` (
+                               `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+                               `user_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '用户ID，关联t_user表',
+                               `role` varchar(50) NOT NULL DEFAULT '' COMMENT '角色，super_admin-超级管理员，operator-运营，auditor-审计',
+                               `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
+                               PRIMARY KEY (`id`),
+                               UNIQUE KEY `uk_user_role` (`user_id`,`role`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='管理后台用户角色表';
+
+-- 第一个超级管理员需要手动授权，注册用户之后执行：
+-- INSERT INTO `t_user_role` (`user_id`, `role`, `sys_created`) VALUES (<用户ID>, 'super_admin', NOW());
+
 
+DROP TABLE IF EXISTS `t_draw_seed`;
+CREATE TABLE `t_draw_seed` (
+                               `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+                               `commitment` char(64) NOT NULL DEFAULT '' COMMENT '种子的sha256，生成种子时公布',
+                               `seed` char(64) NOT NULL DEFAULT '' COMMENT '种子，轮换之后公开',
+                               `sys_status` smallint(5) unsigned NOT NULL DEFAULT '1' COMMENT '状态，1-使用中，2-已公开',
+                               `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
+                               `sys_updated` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '更新时间，公开之后为公开时间',
+                               PRIMARY KEY (`id`),
+                               KEY `idx_status` (`sys_status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='抽奖种子表';
+
+DROP TABLE IF EXISTS `t_draw_audit`;
+CREATE TABLE `t_draw_audit` (
+                                `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+                                `activity_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '活动ID',
+                                `user_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '用户ID',
+                                `request_id` varchar(64) NOT NULL DEFAULT '' COMMENT '抽奖请求ID，参与抽奖编码的计算',
+                                `api` varchar(10) NOT NULL DEFAULT '' COMMENT '抽奖接口版本',
+                                `seed_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '抽奖种子ID，0表示在抽奖之前被拒绝',
+                                `nonce` bigint(20) NOT NULL DEFAULT '0' COMMENT '种子下的抽奖序号',
+                                `code_space` bigint(20) NOT NULL DEFAULT '0' COMMENT '抽奖编码空间',
+                                `prize_code` bigint(20) NOT NULL DEFAULT '0' COMMENT '抽奖编码',
+                                `engine_version` varchar(32) NOT NULL DEFAULT '' COMMENT '抽奖时奖品列表的版本',
+                                `prize_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '抽中的奖品ID，0表示没有抽中',
+                                `result_code` int(10) NOT NULL DEFAULT '0' COMMENT '抽奖接口的返回码',
+                                `pity_losses` bigint(20) NOT NULL DEFAULT '0' COMMENT '抽奖之前连续没有中奖的次数',
+                                `pity_mode` varchar(16) NOT NULL DEFAULT '' COMMENT '保底规则对本次抽奖的调整，空表示没有调整',
+                                `risk_verdict` varchar(16) NOT NULL DEFAULT '' COMMENT '风控规则链的处理结果，allow-放行，degrade-不能中奖，deny-拒绝，空表示没有配置规则',
+                                `risk_rule` varchar(64) NOT NULL DEFAULT '' COMMENT '命中的风控规则',
+                                `sys_ip` varchar(50) NOT NULL DEFAULT '' COMMENT '用户抽奖的IP，服务端根据连接和可信代理获取',
+                                `body_ip` varchar(50) NOT NULL DEFAULT '' COMMENT '请求中填写的和服务端获取的不一致的IP，空表示一致或者没有填写',
+                                `sys_created` datetime NOT NULL DEFAULT '1000-01-01 00:00:00' COMMENT '创建时间',
+                                PRIMARY KEY (`id`),
+                                KEY `idx_user_request` (`user_id`,`request_id`),
+                                KEY `idx_seed_nonce` (`seed_id`,`nonce`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='抽奖审计表';